@@ -0,0 +1,138 @@
+package alt
+
+import (
+	"errors"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/whosonfirst"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+	"path/filepath"
+	"strings"
+)
+
+// DEFAULT_SOURCE is the source reported for default (not alternate) geometries
+
+const DEFAULT_SOURCE string = "default"
+
+const key_separator string = "-alt-"
+
+// AltFeature is a geojson.Feature for an alternate geometry whose Id method returns
+// its alternate geometry key rather than its WOF ID. The key is the same as the
+// feature's WOF filename, minus the extension - {ID}-alt-{LABEL} - so it doesn't
+// collide with the default geometry for the same ID in the index or the cache.
+// Results keep the plain WOF ID and the label is derived from their path.
+
+type AltFeature struct {
+	geojson.Feature
+	label string
+}
+
+func NewAltFeature(f geojson.Feature) (geojson.Feature, error) {
+
+	label := whosonfirst.AltLabel(f)
+
+	if label == "" {
+		return nil, errors.New("Missing src:alt_label property")
+	}
+
+	alt_f := AltFeature{
+		Feature: f,
+		label:   label,
+	}
+
+	return &alt_f, nil
+}
+
+func (f *AltFeature) Id() string {
+	return Key(f.Feature.Id(), f.label)
+}
+
+// Label returns the alternate geometry label (for example "quattroshapes" or
+// "naturalearth-display-terrestrial-zoom6") for 'f'.
+
+func (f *AltFeature) Label() string {
+	return f.label
+}
+
+// Key returns the key for the alternate geometry of 'str_id' labeled 'label'. If 'label'
+// is empty it returns 'str_id'.
+
+func Key(str_id string, label string) string {
+
+	if label == "" {
+		return str_id
+	}
+
+	return str_id + key_separator + label
+}
+
+// ParseKey returns the ID and the alternate geometry label for 'key', and whether or
+// not it is an alternate geometry key.
+
+func ParseKey(key string) (string, string, bool) {
+
+	idx := strings.Index(key, key_separator)
+
+	if idx == -1 {
+		return key, "", false
+	}
+
+	return key[0:idx], key[idx+len(key_separator):], true
+}
+
+// KeyForSPR returns the key that 's' is stored under in the index.
+
+func KeyForSPR(s spr.StandardPlacesResult) string {
+	return Key(s.Id(), Label(s))
+}
+
+// Label returns the alternate geometry label for 's', or an empty string if it is a
+// default geometry.
+
+func Label(s spr.StandardPlacesResult) string {
+
+	fname := filepath.Base(s.Path())
+	fname = strings.TrimSuffix(fname, filepath.Ext(fname))
+
+	_, label, is_alt := ParseKey(fname)
+
+	if !is_alt {
+		return ""
+	}
+
+	return label
+}
+
+// Source returns the alternate geometry label for 's', or DEFAULT_SOURCE if it is a
+// default geometry.
+
+func Source(s spr.StandardPlacesResult) string {
+
+	label := Label(s)
+
+	if label == "" {
+		return DEFAULT_SOURCE
+	}
+
+	return label
+}
+
+// URIArgs returns the uri.URIArgs for the alternate geometry labeled 'label'.
+
+func URIArgs(label string) *uri.URIArgs {
+
+	parts := strings.Split(label, "-")
+
+	function := ""
+	extras := make([]string, 0)
+
+	if len(parts) >= 2 {
+		function = parts[1]
+	}
+
+	if len(parts) >= 3 {
+		extras = parts[2:]
+	}
+
+	return uri.NewAlternateURIArgs(parts[0], function, extras...)
+}
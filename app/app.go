@@ -1,6 +1,7 @@
 package app
 
 import (
+	"errors"
 	"flag"
 	wof_index "github.com/whosonfirst/go-whosonfirst-index"
 	"github.com/whosonfirst/go-whosonfirst-log"
@@ -13,12 +14,13 @@ import (
 )
 
 type PIPApplication struct {
-	mode    string
-	Index   index.Index
-	Cache   cache.Cache
-	Extras  *database.SQLiteDatabase
-	Indexer *wof_index.Indexer
-	Logger  *log.WOFLogger
+	mode     string
+	snapshot string
+	Index    index.Index
+	Cache    cache.Cache
+	Extras   *database.SQLiteDatabase
+	Indexer  *wof_index.Indexer
+	Logger   *log.WOFLogger
 }
 
 func NewPIPApplication(fl *flag.FlagSet) (*PIPApplication, error) {
@@ -35,6 +37,8 @@ func NewPIPApplication(fl *flag.FlagSet) (*PIPApplication, error) {
 		return nil, err
 	}
 
+	warnApplicationCache(appcache, logger)
+
 	appindex, err := NewApplicationIndex(fl, appcache)
 
 	if err != nil {
@@ -54,14 +58,16 @@ func NewPIPApplication(fl *flag.FlagSet) (*PIPApplication, error) {
 	}
 
 	mode, _ := flags.StringVar(fl, "mode")
+	snapshot, _ := flags.StringVar(fl, "index-snapshot")
 
 	p := PIPApplication{
-		mode:    mode,
-		Cache:   appcache,
-		Index:   appindex,
-		Extras:  appextras,
-		Indexer: indexer,
-		Logger:  logger,
+		mode:     mode,
+		snapshot: snapshot,
+		Cache:    appcache,
+		Index:    appindex,
+		Extras:   appextras,
+		Indexer:  indexer,
+		Logger:   logger,
 	}
 
 	return &p, nil
@@ -79,36 +85,90 @@ func (p *PIPApplication) Close() error {
 	return nil
 }
 
+// LogCacheStats logs the size, hits, misses and evictions for the application's cache and, if it is
+// a tiered cache, for each of its tiers
+
+func (p *PIPApplication) LogCacheStats() {
+
+	c := p.Cache
+
+	p.Logger.Status("cache size: %d hits: %d misses: %d evictions: %d", c.Size(), c.Hits(), c.Misses(), c.Evictions())
+
+	tiered, ok := c.(*cache.TieredCache)
+
+	if !ok {
+		return
+	}
+
+	for i, t := range tiered.Tiers() {
+		p.Logger.Status("cache tier %d size: %d hits: %d misses: %d evictions: %d", i, t.Size(), t.Hits(), t.Misses(), t.Evictions())
+	}
+}
+
 func (p *PIPApplication) IndexPaths(paths []string) error {
 
 	if p.mode != "spatialite" {
 
-		go func() {
+		// the extras database is populated during indexing so if it's enabled
+		// we need to read the source documents regardless
+
+		if p.snapshot != "" && p.Extras == nil {
 
-			// TO DO: put this somewhere so that it can be triggered by signal(s)
-			// to reindex everything in bulk or incrementally
+			ok, err := p.LoadSnapshot(p.snapshot)
 
-			t1 := time.Now()
+			if err != nil {
+				p.Logger.Warning("failed to load snapshot '%s' because %s, indexing paths instead", p.snapshot, err)
+			}
 
-			err := p.Indexer.IndexPaths(paths)
+			if ok {
+				p.Logger.Status("finished loading snapshot")
+				return nil
+			}
+		}
+
+		go func() {
+
+			err := p.indexPaths(paths)
 
 			if err != nil {
 				p.Logger.Fatal("failed to index paths because %s", err)
 			}
+		}()
+	}
 
-			t2 := time.Since(t1)
+	return nil
+}
 
-			p.Logger.Status("finished indexing in %v", t2)
-			debug.FreeOSMemory()
-		}()
+// IndexPathsSync is like IndexPaths except that it always reads the source documents (any existing
+// snapshot is ignored) and doesn't return until indexing is complete. It is meant for building a new
+// index in the background while another one is still serving requests.
 
-		// set up some basic monitoring and feedback stuff
+func (p *PIPApplication) IndexPathsSync(paths []string) error {
 
-		go func() {
+	if p.mode == "spatialite" {
+		return errors.New("Spatialite databases are not indexed by the application")
+	}
+
+	return p.indexPaths(paths)
+}
 
-			c := time.Tick(1 * time.Second)
+func (p *PIPApplication) indexPaths(paths []string) error {
 
-			for _ = range c {
+	// set up some basic monitoring and feedback stuff
+
+	done_ch := make(chan bool)
+	defer close(done_ch)
+
+	go func() {
+
+		c := time.NewTicker(1 * time.Second)
+		defer c.Stop()
+
+		for {
+			select {
+			case <-done_ch:
+				return
+			case <-c.C:
 
 				if !p.Indexer.IsIndexing() {
 					continue
@@ -116,8 +176,30 @@ func (p *PIPApplication) IndexPaths(paths []string) error {
 
 				p.Logger.Status("indexing %d records indexed", p.Indexer.Indexed)
 			}
-		}()
+		}
+	}()
+
+	t1 := time.Now()
+
+	err := p.Indexer.IndexPaths(paths)
+
+	if err != nil {
+		return err
+	}
+
+	t2 := time.Since(t1)
+
+	p.Logger.Status("finished indexing in %v", t2)
+
+	if p.snapshot != "" {
+
+		err := p.WriteSnapshot(p.snapshot)
+
+		if err != nil {
+			p.Logger.Warning("failed to write snapshot '%s' because %s", p.snapshot, err)
+		}
 	}
 
+	debug.FreeOSMemory()
 	return nil
 }
@@ -3,8 +3,10 @@ package app
 import (
 	"errors"
 	"flag"
+	"github.com/whosonfirst/go-whosonfirst-log"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/flags"
+	"strings"
 )
 
 func NewApplicationCache(fl *flag.FlagSet) (cache.Cache, error) {
@@ -15,6 +17,33 @@ func NewApplicationCache(fl *flag.FlagSet) (cache.Cache, error) {
 		return nil, err
 	}
 
+	return newApplicationCache(fl, pip_cache)
+}
+
+// warnApplicationCache logs a warning if 'c' is an lru cache without a backing cache since an index
+// can't answer queries for items that have been evicted and there's nowhere to read them back from
+
+func warnApplicationCache(c cache.Cache, logger *log.WOFLogger) {
+
+	lru_cache, ok := c.(*cache.LRUCache)
+
+	if !ok || lru_cache.Options.Backing != nil {
+		return
+	}
+
+	opts := lru_cache.Options
+
+	if opts.MaxItems > 0 || opts.MaxBytes > 0 {
+		logger.Warning("-cache is lru but there is no -lru-backing cache so evicted items will be lost")
+	}
+}
+
+func newApplicationCache(fl *flag.FlagSet, pip_cache string) (cache.Cache, error) {
+
+	if strings.HasPrefix(pip_cache, "tiered:") {
+		return newTieredCache(fl, strings.TrimPrefix(pip_cache, "tiered:"))
+	}
+
 	switch pip_cache {
 
 	case "gocache":
@@ -35,30 +64,236 @@ func NewApplicationCache(fl *flag.FlagSet) (cache.Cache, error) {
 			return nil, err
 		}
 
-		return cache.NewFSCache(path)
+		items_path, err := flags.StringVar(fl, "fs-items-path")
 
-	case "sqlite":
+		if err != nil {
+			return nil, err
+		}
+
+		enc_opts, err := newItemEncodingOptions(fl)
 
-		db, err := NewSpatialiteDB(fl)
+		if err != nil {
+			return nil, err
+		}
+
+		opts, err := cache.DefaultFSCacheOptions()
 
 		if err != nil {
 			return nil, err
 		}
 
-		return cache.NewSQLiteCache(db)
+		opts.ItemsRoot = items_path
+		opts.Encoding = enc_opts
+
+		return cache.NewFSCacheWithOptions(path, opts)
+
+	case "sqlite":
+
+		return newSQLiteCache(fl)
 
 	case "spatialite":
 
-		db, err := NewSpatialiteDB(fl)
+		return newSQLiteCache(fl)
+
+	case "kv":
+
+		path, err := flags.StringVar(fl, "kv-path")
 
 		if err != nil {
 			return nil, err
 		}
 
-		return cache.NewSQLiteCache(db)
+		enc_opts, err := newItemEncodingOptions(fl)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts, err := cache.DefaultKVCacheOptions()
+
+		if err != nil {
+			return nil, err
+		}
+
+		sync_write, err := flags.BoolVar(fl, "kv-sync")
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Encoding = enc_opts
+		opts.Sync = sync_write
+
+		return cache.NewKVCacheWithOptions(path, opts)
+
+	case "lru":
+
+		return newLRUCache(fl, true)
 
 	default:
 		return nil, errors.New("Invalid cache layer")
 	}
 
 }
+
+func newSQLiteCache(fl *flag.FlagSet) (cache.Cache, error) {
+
+	db, err := NewSpatialiteDB(fl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	enc_opts, err := newItemEncodingOptions(fl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := cache.DefaultSQLiteCacheOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Encoding = enc_opts
+
+	return cache.NewSQLiteCacheWithOptions(db, opts)
+}
+
+// newItemEncodingOptions returns the options, from the -cache-compress and -cache-coords flags, for
+// the caches that write encoded items
+
+func newItemEncodingOptions(fl *flag.FlagSet) (*cache.ItemEncodingOptions, error) {
+
+	opts, err := cache.DefaultItemEncodingOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	compress, err := flags.BoolVar(fl, "cache-compress")
+
+	if err != nil {
+		return nil, err
+	}
+
+	coords, err := flags.StringVar(fl, "cache-coords")
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Compress = compress
+	opts.Coordinates = coords
+
+	return opts, nil
+}
+
+// newLRUCache returns a new LRUCache configured from the -lru-* flags. If 'with_backing' is false the
+// -lru-backing flag is ignored.
+
+func newLRUCache(fl *flag.FlagSet, with_backing bool) (cache.Cache, error) {
+
+	opts, err := cache.DefaultLRUCacheOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	max_items, err := flags.IntVar(fl, "lru-max-items")
+
+	if err != nil {
+		return nil, err
+	}
+
+	max_bytes, err := flags.IntVar(fl, "lru-max-bytes")
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts.MaxItems = max_items
+	opts.MaxBytes = int64(max_bytes)
+
+	if !with_backing {
+		return cache.NewLRUCache(opts)
+	}
+
+	backing, err := flags.StringVar(fl, "lru-backing")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if backing != "" {
+
+		if backing == "lru" || strings.HasPrefix(backing, "tiered:") {
+			return nil, errors.New("Invalid LRU backing cache")
+		}
+
+		backing_cache, err := newApplicationCache(fl, backing)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Backing = backing_cache
+	}
+
+	return cache.NewLRUCache(opts)
+}
+
+// newTieredCache returns a new TieredCache for a comma-separated list of cache names, for example
+// "lru,sqlite". The first tier must be lru since every item that is read is copied in to it and
+// it is the only cache that can be bounded. Any lru tiers ignore the -lru-backing flag since the
+// tiers below them already serve that purpose.
+
+func newTieredCache(fl *flag.FlagSet, str_tiers string) (cache.Cache, error) {
+
+	tiers := make([]cache.Cache, 0)
+
+	// close any tiers that have already been opened if we give up part of the way through
+
+	abort := func(err error) (cache.Cache, error) {
+
+		for _, t := range tiers {
+			t.Close()
+		}
+
+		return nil, err
+	}
+
+	for i, name := range strings.Split(str_tiers, ",") {
+
+		name = strings.TrimSpace(name)
+
+		var c cache.Cache
+		var err error
+
+		switch {
+		case i == 0 && name != "lru":
+			err = errors.New("The first tier of a tiered cache must be lru")
+		case name == "lru":
+			c, err = newLRUCache(fl, false)
+		case strings.HasPrefix(name, "tiered:"):
+			err = errors.New("Tiered caches can not be nested")
+		default:
+			c, err = newApplicationCache(fl, name)
+		}
+
+		if err != nil {
+			return abort(err)
+		}
+
+		tiers = append(tiers, c)
+	}
+
+	c, err := cache.NewTieredCache(tiers...)
+
+	if err != nil {
+		return abort(err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"bytes"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/flags"
+	"strings"
+	"testing"
+)
+
+func TestNewApplicationCacheTiered(t *testing.T) {
+
+	tests := []struct {
+		cache string
+		ok    bool
+	}{
+		{"tiered:lru,gocache", true},
+		{"tiered:lru, gocache", true},
+		{"tiered:gocache,gocache", false},
+		{"tiered:gocache,lru", false},
+		{"tiered:lru,tiered:lru,gocache", false},
+	}
+
+	for _, test := range tests {
+
+		fs, err := flags.CommonFlags()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fs.Set("cache", test.cache)
+
+		c, err := NewApplicationCache(fs)
+
+		if err == nil {
+			c.Close()
+		}
+
+		if (err == nil) != test.ok {
+			t.Errorf("Expected NewApplicationCache for '%s' to succeed: %t, got error '%v'", test.cache, test.ok, err)
+		}
+	}
+}
+
+func TestWarnApplicationCache(t *testing.T) {
+
+	tests := []struct {
+		cache   string
+		backing string
+		warn    bool
+	}{
+		{"lru", "", true},
+		{"lru", "gocache", false},
+		{"gocache", "", false},
+		{"tiered:lru,gocache", "", false},
+	}
+
+	for _, test := range tests {
+
+		fs, err := flags.CommonFlags()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fs.Set("cache", test.cache)
+		fs.Set("lru-backing", test.backing)
+
+		c, err := NewApplicationCache(fs)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+
+		logger := log.SimpleWOFLogger()
+		logger.AddLogger(&buf, "warning")
+
+		warnApplicationCache(c, logger)
+		c.Close()
+
+		warned := strings.Contains(buf.String(), "evicted items will be lost")
+
+		if warned != test.warn {
+			t.Errorf("Expected a warning for '%s' (backing '%s'): %t, got '%s'", test.cache, test.backing, test.warn, buf.String())
+		}
+	}
+}
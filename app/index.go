@@ -18,7 +18,42 @@ func NewApplicationIndex(fl *flag.FlagSet, appcache cache.Cache) (index.Index, e
 
 	switch pip_index {
 	case "rtree":
-		return index.NewRTreeIndex(appcache)
+
+		opts, err := index.DefaultRTreeIndexOptions()
+
+		if err != nil {
+			return nil, err
+		}
+
+		workers, err := flags.IntVar(fl, "rtree-workers")
+
+		if err != nil {
+			return nil, err
+		}
+
+		if workers > 0 {
+			opts.Workers = workers
+		}
+
+		prepare, err := flags.BoolVar(fl, "rtree-prepared-geometries")
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts.PrepareGeometries = prepare
+
+		segments, err := flags.BoolVar(fl, "rtree-path-segments")
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts.PathSegments = segments
+
+		return index.NewRTreeIndexWithOptions(appcache, opts)
+	case "cells":
+		return index.NewCellIndex(appcache)
 	case "spatialite":
 
 		db, err := NewSpatialiteDB(fl)
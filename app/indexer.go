@@ -11,6 +11,7 @@ import (
 	"github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/geometry"
 	"github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/whosonfirst"
 	wof_index "github.com/whosonfirst/go-whosonfirst-index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/flags"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/utils"
@@ -29,6 +30,7 @@ func NewApplicationIndexer(fl *flag.FlagSet, appindex index.Index, appextras *da
 
 	mode, _ := flags.StringVar(fl, "mode")
 	is_wof, _ := flags.BoolVar(fl, "is-wof")
+	index_alt, _ := flags.BoolVar(fl, "index-alt-files")
 
 	// something something something, just keep going if indexing
 	// a given record fails (20190919/thisisaaronland)
@@ -97,7 +99,39 @@ func NewApplicationIndexer(fl *flag.FlagSet, appindex index.Index, appextras *da
 
 		var f geojson.Feature
 
-		if is_wof {
+		is_alt := false
+
+		if is_wof && index_alt {
+
+			ok, err := utils.IsAltRecord(fh, ctx)
+
+			if err != nil {
+				return err
+			}
+
+			is_alt = ok
+		}
+
+		if is_alt {
+
+			// alternate geometries are indexed under their own key (see alt.AltFeature)
+			// and don't have any existential flags to check
+
+			tmp, err := feature.LoadWOFAltFeatureFromReader(fh)
+
+			if err != nil {
+				return err
+			}
+
+			alt_f, err := alt.NewAltFeature(tmp)
+
+			if err != nil {
+				return err
+			}
+
+			f = alt_f
+
+		} else if is_wof {
 
 			ok, err := utils.IsValidRecord(fh, ctx)
 
@@ -216,7 +250,10 @@ func NewApplicationIndexer(fl *flag.FlagSet, appindex index.Index, appextras *da
 		// an error signal - maybe we want to do that? maybe not...?
 		// (20171218/thisisaaronland)
 
-		if index_extras {
+		// extras are only ever looked up by ID so there's no point in storing alternate
+		// geometries
+
+		if index_extras && !is_alt {
 
 			wg.Add(1)
 
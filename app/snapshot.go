@@ -0,0 +1,100 @@
+package app
+
+import (
+	"errors"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LoadSnapshot restores the application index from the snapshot at 'path'. It returns false
+// (and no error) if there is no snapshot to load.
+
+func (p *PIPApplication) LoadSnapshot(path string) (bool, error) {
+
+	si, ok := p.Index.(index.SnapshotIndex)
+
+	if !ok {
+		return false, errors.New("Index does not support snapshots")
+	}
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	defer fh.Close()
+
+	t1 := time.Now()
+
+	err = si.ReadSnapshot(fh)
+
+	if err != nil {
+		return false, err
+	}
+
+	p.Logger.Status("time to load snapshot '%s' %v", path, time.Since(t1))
+	return true, nil
+}
+
+// WriteSnapshot writes a snapshot of the application index to 'path'. The snapshot is written to
+// a temporary file first and then moved in to place so that a failed write never clobbers a good
+// snapshot.
+
+func (p *PIPApplication) WriteSnapshot(path string) error {
+
+	si, ok := p.Index.(index.SnapshotIndex)
+
+	if !ok {
+		return errors.New("Index does not support snapshots")
+	}
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return err
+	}
+
+	fh, err := ioutil.TempFile(filepath.Dir(abs_path), ".snapshot")
+
+	if err != nil {
+		return err
+	}
+
+	tmp_path := fh.Name()
+
+	t1 := time.Now()
+
+	err = si.WriteSnapshot(fh)
+
+	if err != nil {
+		fh.Close()
+		os.Remove(tmp_path)
+		return err
+	}
+
+	err = fh.Close()
+
+	if err != nil {
+		os.Remove(tmp_path)
+		return err
+	}
+
+	err = os.Rename(tmp_path, abs_path)
+
+	if err != nil {
+		os.Remove(tmp_path)
+		return err
+	}
+
+	p.Logger.Status("time to write snapshot '%s' %v", abs_path, time.Since(t1))
+	return nil
+}
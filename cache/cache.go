@@ -1,15 +1,22 @@
 package cache
 
 import (
+	"context"
 	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
 	"github.com/whosonfirst/go-whosonfirst-geojson-v2/geometry"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
 	"github.com/whosonfirst/go-whosonfirst-spr"
+	"hash/fnv"
 )
 
 type Cache interface {
 	Get(string) (CacheItem, error)
 	Set(string, CacheItem) error
+	Delete(string) error
+	Has(string) (bool, error)
+	Keys(context.Context, KeysFunc) error
+	Iterate(context.Context, IterateFunc) error
 	Hits() int64
 	Misses() int64
 	Evictions() int64
@@ -17,6 +24,15 @@ type Cache interface {
 	Close() error
 }
 
+// KeysFunc is called by Cache.Keys for each key in a cache and IterateFunc is called by Cache.Iterate
+// for each key and its item. If either returns an error, or the context is cancelled, the iteration stops
+// and that error is returned by Keys or Iterate. Neither Keys nor Iterate count as hits or misses and keys are not returned in any
+// particular order.
+
+type KeysFunc func(context.Context, string) error
+
+type IterateFunc func(context.Context, string, CacheItem) error
+
 type CacheItem interface {
 	SPR() spr.StandardPlacesResult
 	Polygons() []geojson.Polygon
@@ -48,6 +64,13 @@ func NewFeatureCache(f geojson.Feature) (CacheItem, error) {
 		return nil, err
 	}
 
+	// polygons that cross the antimeridian are split in two (and polygons outside
+	// of -180 to 180 shifted back inside it) so that everything downstream of the
+	// cache - bounding boxes, point-in-polygon tests and so on - works without
+	// having to know about it; see geo/antimeridian.go for details
+
+	polys, _ = geo.NormalizePolygons(polys)
+
 	fc := FeatureCache{
 		FeatureSPR:      s,
 		FeaturePolygons: polys,
@@ -108,3 +131,14 @@ func (fc *FeatureCache) Polygons() []geojson.Polygon {
 
 	return fc.FeaturePolygons
 }
+
+// keyStripe returns which of 'count' stripes 'key' hashes to. Caches that serialize the writes for each
+// key use it to divide their keys between a fixed number of locks.
+
+func keyStripe(key string, count int) int {
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return int(h.Sum32() % uint32(count))
+}
@@ -0,0 +1,726 @@
+package cache
+
+// encoded cache items look like this:
+//
+// header:   byte version | byte flags
+// body:     byte spr type | spr | uvarint count, polygon...
+// polygon:  uvarint count (exterior ring + interior rings), ring...
+// ring:     uvarint count, coord...
+// coord:    (float64 x, float64 y) | (float32 x, float32 y) | (varint dx, varint dy)
+//
+// the body is compressed (using DEFLATE) if the ITEM_FLAG_COMPRESSED flag is set. coordinates are
+// float64s unless the ITEM_FLAG_FLOAT32 or ITEM_FLAG_DELTA flags are set. delta-encoded coordinates
+// are the difference from the previous vertex in the same ring (or from 0,0 for the first vertex)
+// multiplied by ITEM_DELTA_FACTOR and rounded, which is to say they are accurate to about a centimeter.
+//
+// the spr is encoded field by field for each of the SPR types that the go-whosonfirst-geojson-v2 package
+// produces, see writeSPR for details. strings are a uvarint length followed by that many bytes, lists
+// of IDs are a uvarint count followed by that many varints and all fixed-width numbers are little-endian
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/geometry"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+const ITEM_ENCODING_VERSION byte = 1
+
+const (
+	ITEM_FLAG_COMPRESSED byte = 1 << iota
+	ITEM_FLAG_FLOAT32
+	ITEM_FLAG_DELTA
+)
+
+const ITEM_DELTA_FACTOR float64 = 1e7
+
+const (
+	COORDINATES_FLOAT64 = "float64"
+	COORDINATES_FLOAT32 = "float32"
+	COORDINATES_DELTA   = "delta"
+)
+
+// the largest string that is read in one go, see ReadBytes
+
+const read_chunk int = 64 * 1024
+
+const (
+	item_spr_wof byte = iota + 1
+	item_spr_wof_alt
+	item_spr_geojson
+)
+
+// ItemEncodingOptions controls how EncodeCacheItem encodes a cache item. DecodeCacheItem doesn't need
+// to be told since every encoded item records how it was encoded.
+
+type ItemEncodingOptions struct {
+	// compress the encoded item
+	Compress bool
+	// one of COORDINATES_FLOAT64 (lossless), COORDINATES_FLOAT32 or COORDINATES_DELTA
+	Coordinates string
+}
+
+func DefaultItemEncodingOptions() (*ItemEncodingOptions, error) {
+
+	opts := ItemEncodingOptions{
+		Compress:    false,
+		Coordinates: COORDINATES_FLOAT64,
+	}
+
+	return &opts, nil
+}
+
+// Coordinates returns the list of valid ItemEncodingOptions.Coordinates values
+
+func Coordinates() []string {
+	return []string{COORDINATES_FLOAT64, COORDINATES_FLOAT32, COORDINATES_DELTA}
+}
+
+// EncodeCacheItem returns the binary encoding of 'item'
+
+func EncodeCacheItem(item CacheItem, opts *ItemEncodingOptions) ([]byte, error) {
+
+	flags := byte(0)
+
+	switch opts.Coordinates {
+	case COORDINATES_FLOAT64, "":
+		// pass
+	case COORDINATES_FLOAT32:
+		flags |= ITEM_FLAG_FLOAT32
+	case COORDINATES_DELTA:
+		flags |= ITEM_FLAG_DELTA
+	default:
+		return nil, fmt.Errorf("Invalid coordinates encoding '%s'", opts.Coordinates)
+	}
+
+	if opts.Compress {
+		flags |= ITEM_FLAG_COMPRESSED
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{ITEM_ENCODING_VERSION, flags})
+
+	var wr io.Writer
+	wr = &buf
+
+	var fl *flate.Writer
+
+	if opts.Compress {
+
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fl = w
+		wr = fl
+	}
+
+	iw := newItemWriter(wr, flags)
+
+	err := iw.writeSPR(item.SPR())
+
+	if err != nil {
+		return nil, err
+	}
+
+	polys := item.Polygons()
+	iw.writeUvarint(uint64(len(polys)))
+
+	for _, p := range polys {
+
+		interior := p.InteriorRings()
+
+		iw.writeUvarint(uint64(len(interior) + 1))
+		iw.writeRing(p.ExteriorRing())
+
+		for _, ring := range interior {
+			iw.writeRing(ring)
+		}
+	}
+
+	if iw.err != nil {
+		return nil, iw.err
+	}
+
+	if fl != nil {
+
+		err := fl.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodeCacheItem returns the cache item encoded in 'body' by EncodeCacheItem
+
+func DecodeCacheItem(body []byte) (CacheItem, error) {
+
+	if len(body) < 2 {
+		return nil, errors.New("Invalid cache item")
+	}
+
+	version := body[0]
+	flags := body[1]
+
+	if version != ITEM_ENCODING_VERSION {
+		return nil, fmt.Errorf("Unsupported cache item version %d", version)
+	}
+
+	if flags&ITEM_FLAG_FLOAT32 != 0 && flags&ITEM_FLAG_DELTA != 0 {
+		return nil, errors.New("Invalid cache item flags")
+	}
+
+	br := bytes.NewReader(body[2:])
+
+	var rd io.Reader
+	rd = br
+
+	if flags&ITEM_FLAG_COMPRESSED != 0 {
+
+		fl := flate.NewReader(rd)
+		defer fl.Close()
+
+		rd = fl
+	}
+
+	ir := newItemReader(rd, flags)
+
+	s, err := ir.readSPR()
+
+	if err != nil {
+		return nil, err
+	}
+
+	count_polys := ir.readUvarint()
+	polys := make([]geojson.Polygon, 0)
+
+	for i := uint64(0); i < count_polys && ir.err == nil; i++ {
+
+		count_rings := ir.readUvarint()
+
+		if ir.err == nil && count_rings == 0 {
+			ir.err = errors.New("Invalid polygon")
+			break
+		}
+
+		exterior := ir.readRing()
+		interior := make([]geom.Polygon, 0)
+
+		for j := uint64(1); j < count_rings && ir.err == nil; j++ {
+			interior = append(interior, ir.readRing())
+		}
+
+		p := geometry.Polygon{
+			Exterior: exterior,
+			Interior: interior,
+		}
+
+		polys = append(polys, p)
+	}
+
+	if ir.err != nil {
+		return nil, ir.err
+	}
+
+	// anything left over means the item is corrupt (or isn't actually a cache item) and reading
+	// to the end of a compressed item is the only way to know it wasn't truncated
+
+	n, err := io.Copy(ioutil.Discard, ir.rd)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// bytes.Reader is an io.ByteReader so the flate reader doesn't read past the end of the
+	// compressed data, which means anything after it is still in 'br'
+
+	if n > 0 || br.Len() > 0 {
+		return nil, errors.New("Invalid cache item, trailing data")
+	}
+
+	fc := FeatureCache{
+		FeatureSPR:      s,
+		FeaturePolygons: polys,
+	}
+
+	return &fc, nil
+}
+
+// itemWriter remembers the first error it encounters so that callers only need to check for
+// errors once
+
+type itemWriter struct {
+	wr    io.Writer
+	flags byte
+	buf   []byte
+	err   error
+}
+
+func newItemWriter(wr io.Writer, flags byte) *itemWriter {
+
+	iw := itemWriter{
+		wr:    wr,
+		flags: flags,
+		buf:   make([]byte, binary.MaxVarintLen64),
+	}
+
+	return &iw
+}
+
+func (iw *itemWriter) writeBytes(b []byte) {
+
+	if iw.err != nil {
+		return
+	}
+
+	_, iw.err = iw.wr.Write(b)
+}
+
+func (iw *itemWriter) writeUvarint(v uint64) {
+
+	n := binary.PutUvarint(iw.buf, v)
+	iw.writeBytes(iw.buf[0:n])
+}
+
+func (iw *itemWriter) writeVarint(v int64) {
+
+	n := binary.PutVarint(iw.buf, v)
+	iw.writeBytes(iw.buf[0:n])
+}
+
+func (iw *itemWriter) writeFloat64(v float64) {
+
+	binary.LittleEndian.PutUint64(iw.buf, math.Float64bits(v))
+	iw.writeBytes(iw.buf[0:8])
+}
+
+func (iw *itemWriter) writeFloat32(v float32) {
+
+	binary.LittleEndian.PutUint32(iw.buf, math.Float32bits(v))
+	iw.writeBytes(iw.buf[0:4])
+}
+
+func (iw *itemWriter) writeString(s string) {
+
+	iw.writeUvarint(uint64(len(s)))
+	iw.writeBytes([]byte(s))
+}
+
+func (iw *itemWriter) writeIds(ids []int64) {
+
+	iw.writeUvarint(uint64(len(ids)))
+
+	for _, id := range ids {
+		iw.writeVarint(id)
+	}
+}
+
+func (iw *itemWriter) writeRing(ring geom.Polygon) {
+
+	vertices := ring.Vertices()
+
+	iw.writeUvarint(uint64(len(vertices)))
+
+	prev_x := int64(0)
+	prev_y := int64(0)
+
+	for _, c := range vertices {
+
+		switch {
+		case iw.flags&ITEM_FLAG_FLOAT32 != 0:
+
+			iw.writeFloat32(float32(c.X))
+			iw.writeFloat32(float32(c.Y))
+
+		case iw.flags&ITEM_FLAG_DELTA != 0:
+
+			x := int64(math.Round(c.X * ITEM_DELTA_FACTOR))
+			y := int64(math.Round(c.Y * ITEM_DELTA_FACTOR))
+
+			iw.writeVarint(x - prev_x)
+			iw.writeVarint(y - prev_y)
+
+			prev_x = x
+			prev_y = y
+
+		default:
+
+			iw.writeFloat64(c.X)
+			iw.writeFloat64(c.Y)
+		}
+	}
+}
+
+// writeSPR writes each of the fields of 's', in the order they are declared, for the SPR types that
+// the go-whosonfirst-geojson-v2 package produces
+
+func (iw *itemWriter) writeSPR(s spr.StandardPlacesResult) error {
+
+	switch s := s.(type) {
+
+	case *feature.WOFStandardPlacesResult:
+
+		iw.writeBytes([]byte{item_spr_wof})
+
+		iw.writeString(s.EDTFInception)
+		iw.writeString(s.EDTFCessation)
+		iw.writeVarint(s.WOFId)
+		iw.writeVarint(s.WOFParentId)
+		iw.writeString(s.WOFName)
+		iw.writeString(s.WOFPlacetype)
+		iw.writeString(s.WOFCountry)
+		iw.writeString(s.WOFRepo)
+		iw.writeString(s.WOFPath)
+		iw.writeIds(s.WOFSupersededBy)
+		iw.writeIds(s.WOFSupersedes)
+		iw.writeIds(s.WOFBelongsTo)
+		iw.writeString(s.MZURI)
+		iw.writeFloat64(s.MZLatitude)
+		iw.writeFloat64(s.MZLongitude)
+		iw.writeFloat64(s.MZMinLatitude)
+		iw.writeFloat64(s.MZMinLongitude)
+		iw.writeFloat64(s.MZMaxLatitude)
+		iw.writeFloat64(s.MZMaxLongitude)
+		iw.writeVarint(s.MZIsCurrent)
+		iw.writeVarint(s.MZIsCeased)
+		iw.writeVarint(s.MZIsDeprecated)
+		iw.writeVarint(s.MZIsSuperseded)
+		iw.writeVarint(s.MZIsSuperseding)
+		iw.writeVarint(s.WOFLastModified)
+
+	case *feature.WOFAltStandardPlacesResult:
+
+		iw.writeBytes([]byte{item_spr_wof_alt})
+
+		iw.writeString(s.WOFId)
+		iw.writeString(s.WOFName)
+		iw.writeString(s.WOFPlacetype)
+		iw.writeFloat64(s.MZLatitude)
+		iw.writeFloat64(s.MZLongitude)
+		iw.writeFloat64(s.MZMinLatitude)
+		iw.writeFloat64(s.MZMinLongitude)
+		iw.writeFloat64(s.MZMaxLatitude)
+		iw.writeFloat64(s.MZMaxLongitude)
+		iw.writeString(s.WOFPath)
+		iw.writeString(s.WOFRepo)
+
+	case *feature.GeoJSONStandardPlacesResult:
+
+		iw.writeBytes([]byte{item_spr_geojson})
+
+		iw.writeString(s.SPRId)
+		iw.writeString(s.SPRName)
+		iw.writeString(s.SPRPlacetype)
+		iw.writeFloat64(s.SPRLatitude)
+		iw.writeFloat64(s.SPRLongitude)
+		iw.writeFloat64(s.SPRMinLatitude)
+		iw.writeFloat64(s.SPRMinLongitude)
+		iw.writeFloat64(s.SPRMaxLatitude)
+		iw.writeFloat64(s.SPRMaxLongitude)
+
+	default:
+		return fmt.Errorf("Unsupported SPR type %T", s)
+	}
+
+	return iw.err
+}
+
+// itemReader is the reading equivalent of itemWriter - once an error is encountered every
+// subsequent read returns a zero value
+
+type itemReader struct {
+	rd    *bufio.Reader
+	flags byte
+	buf   []byte
+	err   error
+}
+
+func newItemReader(rd io.Reader, flags byte) *itemReader {
+
+	ir := itemReader{
+		rd:    bufio.NewReader(rd),
+		flags: flags,
+		buf:   make([]byte, 8),
+	}
+
+	return &ir
+}
+
+func (ir *itemReader) readByte() byte {
+
+	if ir.err != nil {
+		return 0
+	}
+
+	var b byte
+	b, ir.err = ir.rd.ReadByte()
+
+	return b
+}
+
+func (ir *itemReader) readBytes(n int) []byte {
+
+	if ir.err != nil {
+		return nil
+	}
+
+	var b []byte
+	b, ir.err = ReadBytes(ir.rd, n)
+
+	return b
+}
+
+// ReadBytes reads 'n' bytes from 'rd'. It is meant for reading strings whose lengths come from the
+// input itself so rather than trusting them, and allocating however much memory a corrupt (or
+// truncated) input says to, anything larger than read_chunk is read a chunk at a time which means
+// it can never use much more memory than there is actually input.
+
+func ReadBytes(rd io.Reader, n int) ([]byte, error) {
+
+	if n <= read_chunk {
+
+		b := make([]byte, n)
+		_, err := io.ReadFull(rd, b)
+
+		return b, err
+	}
+
+	var buf bytes.Buffer
+
+	_, err := io.CopyN(&buf, rd, int64(n))
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return buf.Bytes(), err
+}
+
+func (ir *itemReader) readUvarint() uint64 {
+
+	if ir.err != nil {
+		return 0
+	}
+
+	var v uint64
+	v, ir.err = binary.ReadUvarint(ir.rd)
+
+	return v
+}
+
+func (ir *itemReader) readVarint() int64 {
+
+	if ir.err != nil {
+		return 0
+	}
+
+	var v int64
+	v, ir.err = binary.ReadVarint(ir.rd)
+
+	return v
+}
+
+func (ir *itemReader) readFloat64() float64 {
+
+	if ir.err != nil {
+		return 0.0
+	}
+
+	_, ir.err = io.ReadFull(ir.rd, ir.buf[0:8])
+	return math.Float64frombits(binary.LittleEndian.Uint64(ir.buf[0:8]))
+}
+
+func (ir *itemReader) readFloat32() float32 {
+
+	if ir.err != nil {
+		return 0.0
+	}
+
+	_, ir.err = io.ReadFull(ir.rd, ir.buf[0:4])
+	return math.Float32frombits(binary.LittleEndian.Uint32(ir.buf[0:4]))
+}
+
+// readCount reads a uvarint count of things that each take up at least one byte and makes sure
+// it isn't bigger than could possibly be right, to guard against allocating silly amounts of memory
+// for a corrupt item
+
+func (ir *itemReader) readCount() int {
+
+	n := ir.readUvarint()
+
+	if n > math.MaxInt32 {
+
+		if ir.err == nil {
+			ir.err = errors.New("Invalid length")
+		}
+
+		return 0
+	}
+
+	return int(n)
+}
+
+func (ir *itemReader) readString() string {
+
+	n := ir.readCount()
+
+	if n == 0 {
+		return ""
+	}
+
+	return string(ir.readBytes(n))
+}
+
+func (ir *itemReader) readIds() []int64 {
+
+	n := ir.readCount()
+	ids := make([]int64, 0)
+
+	for i := 0; i < n && ir.err == nil; i++ {
+		ids = append(ids, ir.readVarint())
+	}
+
+	return ids
+}
+
+func (ir *itemReader) readRing() geom.Polygon {
+
+	count := ir.readUvarint()
+	ring := geom.Polygon{}
+
+	x := int64(0)
+	y := int64(0)
+
+	for i := uint64(0); i < count && ir.err == nil; i++ {
+
+		var c geom.Coord
+
+		switch {
+		case ir.flags&ITEM_FLAG_FLOAT32 != 0:
+
+			c.X = float64(ir.readFloat32())
+			c.Y = float64(ir.readFloat32())
+
+		case ir.flags&ITEM_FLAG_DELTA != 0:
+
+			x += ir.readVarint()
+			y += ir.readVarint()
+
+			c.X = float64(x) / ITEM_DELTA_FACTOR
+			c.Y = float64(y) / ITEM_DELTA_FACTOR
+
+		default:
+
+			c.X = ir.readFloat64()
+			c.Y = ir.readFloat64()
+		}
+
+		ring.AddVertex(c)
+	}
+
+	return ring
+}
+
+func (ir *itemReader) readSPR() (spr.StandardPlacesResult, error) {
+
+	spr_type := ir.readByte()
+
+	if ir.err != nil {
+		return nil, ir.err
+	}
+
+	var s spr.StandardPlacesResult
+
+	switch spr_type {
+
+	case item_spr_wof:
+
+		wof_s := feature.WOFStandardPlacesResult{
+			EDTFInception:   ir.readString(),
+			EDTFCessation:   ir.readString(),
+			WOFId:           ir.readVarint(),
+			WOFParentId:     ir.readVarint(),
+			WOFName:         ir.readString(),
+			WOFPlacetype:    ir.readString(),
+			WOFCountry:      ir.readString(),
+			WOFRepo:         ir.readString(),
+			WOFPath:         ir.readString(),
+			WOFSupersededBy: ir.readIds(),
+			WOFSupersedes:   ir.readIds(),
+			WOFBelongsTo:    ir.readIds(),
+			MZURI:           ir.readString(),
+			MZLatitude:      ir.readFloat64(),
+			MZLongitude:     ir.readFloat64(),
+			MZMinLatitude:   ir.readFloat64(),
+			MZMinLongitude:  ir.readFloat64(),
+			MZMaxLatitude:   ir.readFloat64(),
+			MZMaxLongitude:  ir.readFloat64(),
+			MZIsCurrent:     ir.readVarint(),
+			MZIsCeased:      ir.readVarint(),
+			MZIsDeprecated:  ir.readVarint(),
+			MZIsSuperseded:  ir.readVarint(),
+			MZIsSuperseding: ir.readVarint(),
+			WOFLastModified: ir.readVarint(),
+		}
+
+		s = &wof_s
+
+	case item_spr_wof_alt:
+
+		alt_s := feature.WOFAltStandardPlacesResult{
+			WOFId:          ir.readString(),
+			WOFName:        ir.readString(),
+			WOFPlacetype:   ir.readString(),
+			MZLatitude:     ir.readFloat64(),
+			MZLongitude:    ir.readFloat64(),
+			MZMinLatitude:  ir.readFloat64(),
+			MZMinLongitude: ir.readFloat64(),
+			MZMaxLatitude:  ir.readFloat64(),
+			MZMaxLongitude: ir.readFloat64(),
+			WOFPath:        ir.readString(),
+			WOFRepo:        ir.readString(),
+		}
+
+		s = &alt_s
+
+	case item_spr_geojson:
+
+		geojson_s := feature.GeoJSONStandardPlacesResult{
+			SPRId:           ir.readString(),
+			SPRName:         ir.readString(),
+			SPRPlacetype:    ir.readString(),
+			SPRLatitude:     ir.readFloat64(),
+			SPRLongitude:    ir.readFloat64(),
+			SPRMinLatitude:  ir.readFloat64(),
+			SPRMinLongitude: ir.readFloat64(),
+			SPRMaxLatitude:  ir.readFloat64(),
+			SPRMaxLongitude: ir.readFloat64(),
+		}
+
+		s = &geojson_s
+
+	default:
+		return nil, fmt.Errorf("Unsupported SPR type %d", spr_type)
+	}
+
+	if ir.err != nil {
+		return nil, ir.err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,284 @@
+package cache_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"math"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+const test_wof_feature = `{"type":"Feature","properties":{"wof:id":85632793,"wof:parent_id":-1,"wof:name":"Ünïcode ☃","wof:placetype":"country","wof:repo":"whosonfirst-data","wof:country":"CA","geom:latitude":45.1,"geom:longitude":-73.2,"geom:bbox":"-74,44,-72,46","edtf:inception":"1867-07-01","edtf:cessation":"uuuu","wof:supersedes":[1,2],"wof:superseded_by":[],"wof:belongsto":[102191575,-5],"mz:is_current":1,"wof:lastmodified":1600000000},"geometry":{"type":"MultiPolygon","coordinates":[[[[-74,44],[-72,44],[-72,46],[-74,46],[-74,44]],[[-73.5,44.5],[-72.5,44.5],[-72.5,45.5],[-73.5,45.5],[-73.5,44.5]]],[[[10.123456789,20.987654321],[11,20],[11,21],[10.123456789,20.987654321]]]]}}`
+
+const test_alt_feature = `{"type":"Feature","properties":{"wof:id":101736545,"wof:repo":"x","src:alt_label":"quattroshapes","src:geom":"quattroshapes"},"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}`
+
+const test_geojson_feature = `{"type":"Feature","id":"abc","properties":{"name":"plain"},"geometry":{"type":"Polygon","coordinates":[[[179.5,-10],[-179.5,-10],[-179.5,10],[179.5,10],[179.5,-10]]]}}`
+
+// the largest difference, in degrees, between a coordinate and its decoded value for each of the
+// coordinate encodings
+
+var test_tolerances = map[string]float64{
+	cache.COORDINATES_FLOAT64: 0.0,
+	cache.COORDINATES_FLOAT32: 1e-5,
+	cache.COORDINATES_DELTA:   1e-7,
+}
+
+func testCacheItems(t testing.TB) map[string]cache.CacheItem {
+
+	wof_f, err := feature.LoadWOFFeatureFromReader(bytes.NewReader([]byte(test_wof_feature)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alt_f, err := feature.LoadWOFAltFeatureFromReader(bytes.NewReader([]byte(test_alt_feature)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	geojson_f, err := feature.LoadGeoJSONFeatureFromReader(bytes.NewReader([]byte(test_geojson_feature)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	features := map[string]geojson.Feature{
+		"wof":     wof_f,
+		"alt":     alt_f,
+		"geojson": geojson_f,
+	}
+
+	items := make(map[string]cache.CacheItem)
+
+	for label, f := range features {
+
+		i, err := cache.NewFeatureCache(f)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		items[label] = i
+	}
+
+	return items
+}
+
+func encodingOptions(t testing.TB, coords string, compress bool) *cache.ItemEncodingOptions {
+
+	opts, err := cache.DefaultItemEncodingOptions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Coordinates = coords
+	opts.Compress = compress
+
+	return opts
+}
+
+func compareRings(a geom.Polygon, b geom.Polygon, tolerance float64) error {
+
+	va := a.Vertices()
+	vb := b.Vertices()
+
+	if len(va) != len(vb) {
+		return fmt.Errorf("expected %d vertices, got %d", len(va), len(vb))
+	}
+
+	for i := range va {
+
+		if math.Abs(va[i].X-vb[i].X) > tolerance || math.Abs(va[i].Y-vb[i].Y) > tolerance {
+			return fmt.Errorf("vertex %d is %v, expected %v", i, vb[i], va[i])
+		}
+	}
+
+	return nil
+}
+
+func compareCacheItems(a cache.CacheItem, b cache.CacheItem, tolerance float64) error {
+
+	if !reflect.DeepEqual(a.SPR(), b.SPR()) {
+		return fmt.Errorf("SPR is %#v, expected %#v", b.SPR(), a.SPR())
+	}
+
+	pa := a.Polygons()
+	pb := b.Polygons()
+
+	if len(pa) != len(pb) {
+		return fmt.Errorf("expected %d polygons, got %d", len(pa), len(pb))
+	}
+
+	for i := range pa {
+
+		err := compareRings(pa[i].ExteriorRing(), pb[i].ExteriorRing(), tolerance)
+
+		if err != nil {
+			return fmt.Errorf("polygon %d exterior ring: %s", i, err)
+		}
+
+		ia := pa[i].InteriorRings()
+		ib := pb[i].InteriorRings()
+
+		if len(ia) != len(ib) {
+			return fmt.Errorf("polygon %d: expected %d interior rings, got %d", i, len(ia), len(ib))
+		}
+
+		for j := range ia {
+
+			err := compareRings(ia[j], ib[j], tolerance)
+
+			if err != nil {
+				return fmt.Errorf("polygon %d interior ring %d: %s", i, j, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestEncodeDecodeCacheItem(t *testing.T) {
+
+	for label, item := range testCacheItems(t) {
+
+		for _, coords := range cache.Coordinates() {
+
+			for _, compress := range []bool{false, true} {
+
+				name := fmt.Sprintf("%s/%s/compress=%t", label, coords, compress)
+
+				t.Run(name, func(t *testing.T) {
+
+					body, err := cache.EncodeCacheItem(item, encodingOptions(t, coords, compress))
+
+					if err != nil {
+						t.Fatalf("Failed to encode item, %s", err)
+					}
+
+					decoded, err := cache.DecodeCacheItem(body)
+
+					if err != nil {
+						t.Fatalf("Failed to decode item, %s", err)
+					}
+
+					err = compareCacheItems(item, decoded, test_tolerances[coords])
+
+					if err != nil {
+						t.Fatal(err)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestDecodeCacheItemTruncated(t *testing.T) {
+
+	for label, item := range testCacheItems(t) {
+
+		for _, coords := range cache.Coordinates() {
+
+			for _, compress := range []bool{false, true} {
+
+				body, err := cache.EncodeCacheItem(item, encodingOptions(t, coords, compress))
+
+				if err != nil {
+					t.Fatalf("Failed to encode item, %s", err)
+				}
+
+				for n := 0; n < len(body); n++ {
+
+					_, err := cache.DecodeCacheItem(body[0:n])
+
+					if err == nil {
+						t.Fatalf("%s/%s/compress=%t: decoding the first %d of %d bytes succeeded", label, coords, compress, n, len(body))
+					}
+				}
+
+				trailing := append(append([]byte{}, body...), 0)
+
+				_, err = cache.DecodeCacheItem(trailing)
+
+				if err == nil {
+					t.Fatalf("%s/%s/compress=%t: decoding an item with trailing data succeeded", label, coords, compress)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeCacheItemInvalid(t *testing.T) {
+
+	tests := map[string][]byte{
+		"empty":          []byte{},
+		"version":        []byte{cache.ITEM_ENCODING_VERSION + 1, 0, 1},
+		"flags":          []byte{cache.ITEM_ENCODING_VERSION, cache.ITEM_FLAG_FLOAT32 | cache.ITEM_FLAG_DELTA, 1},
+		"spr type":       []byte{cache.ITEM_ENCODING_VERSION, 0, 99},
+		"huge length":    []byte{cache.ITEM_ENCODING_VERSION, 0, 1, 0xff, 0xff, 0xff, 0xff, 0x07},
+		"length > int32": []byte{cache.ITEM_ENCODING_VERSION, 0, 1, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+	}
+
+	for label, body := range tests {
+
+		_, err := cache.DecodeCacheItem(body)
+
+		if err == nil {
+			t.Fatalf("%s: expected an error", label)
+		}
+	}
+}
+
+// a corrupt length shouldn't cause DecodeCacheItem to allocate (much) more memory than there is input
+
+func TestDecodeCacheItemOversizedLength(t *testing.T) {
+
+	length := []byte{0xff, 0xff, 0xff, 0xff, 0x07} // uvarint 0x7fffffff
+
+	var compressed bytes.Buffer
+
+	fl, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fl.Write([]byte{1})
+	fl.Write(length)
+	fl.Write(bytes.Repeat([]byte{'x'}, 1024))
+	fl.Close()
+
+	tests := map[string][]byte{
+		"uncompressed": append([]byte{cache.ITEM_ENCODING_VERSION, 0, 1}, length...),
+		"compressed":   append([]byte{cache.ITEM_ENCODING_VERSION, cache.ITEM_FLAG_COMPRESSED}, compressed.Bytes()...),
+	}
+
+	for label, body := range tests {
+
+		var before runtime.MemStats
+		var after runtime.MemStats
+
+		runtime.ReadMemStats(&before)
+
+		_, err := cache.DecodeCacheItem(body)
+
+		runtime.ReadMemStats(&after)
+
+		if err == nil {
+			t.Fatalf("%s: expected an error", label)
+		}
+
+		allocated := after.TotalAlloc - before.TotalAlloc
+
+		if allocated > 16*1024*1024 {
+			t.Fatalf("%s: decoding %d bytes allocated %d bytes", label, len(body), allocated)
+		}
+	}
+}
@@ -1,23 +1,41 @@
 package cache
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
 	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
 	"github.com/whosonfirst/go-whosonfirst-log"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
 	"github.com/whosonfirst/go-whosonfirst-uri"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 )
 
+// fs_no_repo is the repo index for keys whose items are in ItemsRoot but whose repo is unknown
+
+const fs_no_repo int = -1
+
+const fs_item_ext string = ".item"
+
+// FSCache is a cache.Cache that reads features from one or more Who's On First data repositories
+// on disk, remembering which repository each key was found in when it is Set. If the ItemsRoot option
+// is set items are also written there, using the binary encoding in encoding.go, and read back from
+// there rather than parsing the original GeoJSON files again.
+
 type FSCache struct {
 	Cache
 	Logger     *log.WOFLogger
+	Options    *FSCacheOptions
 	data_root  string
 	repo_index []string       // this is a list of repo names
-	repo_map   map[string]int // this is a list of WOF ID -> index of repo name in `repo_index`
+	repo_map   map[string]int // this is a list of WOF ID -> index of repo name in `repo_index` (or fs_no_repo)
 	mu         *sync.RWMutex
 	hits       int64
 	misses     int64
@@ -25,14 +43,57 @@ type FSCache struct {
 	keys       int64
 }
 
+type FSCacheOptions struct {
+	// the directory to write encoded items to, or "" to only read the original features
+	ItemsRoot string
+	// how items are encoded when they are written to ItemsRoot
+	Encoding *ItemEncodingOptions
+}
+
+func DefaultFSCacheOptions() (*FSCacheOptions, error) {
+
+	enc_opts, err := DefaultItemEncodingOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := FSCacheOptions{
+		ItemsRoot: "",
+		Encoding:  enc_opts,
+	}
+
+	return &opts, nil
+}
+
 func NewFSCache(data_root string) (Cache, error) {
 
+	opts, err := DefaultFSCacheOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFSCacheWithOptions(data_root, opts)
+}
+
+func NewFSCacheWithOptions(data_root string, opts *FSCacheOptions) (Cache, error) {
+
 	_, err := os.Stat(data_root)
 
 	if os.IsNotExist(err) {
 		return nil, err
 	}
 
+	if opts.ItemsRoot != "" {
+
+		err := os.MkdirAll(opts.ItemsRoot, 0755)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	logger := log.SimpleWOFLogger("source")
 
 	i := make([]string, 0)
@@ -42,6 +103,7 @@ func NewFSCache(data_root string) (Cache, error) {
 
 	c := FSCache{
 		Logger:     logger,
+		Options:    opts,
 		data_root:  data_root,
 		repo_map:   m,
 		repo_index: i,
@@ -52,6 +114,15 @@ func NewFSCache(data_root string) (Cache, error) {
 		keys:       int64(0),
 	}
 
+	if opts.ItemsRoot != "" {
+
+		err := c.loadItems()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &c, nil
 }
 
@@ -66,77 +137,188 @@ func (c *FSCache) Get(key string) (CacheItem, error) {
 
 	c.Logger.Info("GET %s", key)
 
-	c.mu.RLock()
+	fc, err := c.read(key)
 
-	idx, ok := c.repo_map[key]
-	repo := c.repo_index[idx]
+	if err != nil {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, err
+	}
 
-	c.mu.RUnlock()
+	atomic.AddInt64(&c.hits, 1)
+	return fc, nil
+}
 
-	if !ok {
-		return nil, errors.New("Unable to determine repo for ID")
+// Set remembers the repo that 'i' belongs to and, if the ItemsRoot option is set, writes it to disk.
+// Items written to ItemsRoot don't need a repo (or for it to exist in the data root) since they can
+// always be read back from there.
+
+func (c *FSCache) Set(key string, i CacheItem) error {
+
+	c.Logger.Info("SET %s", key)
+
+	if c.Options.ItemsRoot != "" {
+
+		err := c.setItem(key, i)
+
+		if err != nil {
+			return err
+		}
 	}
 
-	abs_path, err := c.str_id2abspath(key, repo)
+	s := i.SPR()
+	repo := s.Repo()
+
+	var err error
+
+	if repo == "" {
+		err = errors.New("Unable to determine wof:repo for feature")
+	} else {
+		_, err = c.str_id2abspath(key, repo)
+	}
 
 	if err != nil {
-		return nil, err
+
+		if c.Options.ItemsRoot == "" {
+			return err
+		}
+
+		repo = ""
 	}
 
-	f, err := feature.LoadWOFFeatureFromFile(abs_path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if err != nil {
-		atomic.AddInt64(&c.misses, 1)
-		return nil, err
+	idx := fs_no_repo
+
+	if repo != "" {
+
+		for i, name := range c.repo_index {
+			if name == repo {
+				idx = i
+				break
+			}
+		}
+
+		if idx == fs_no_repo {
+
+			c.repo_index = append(c.repo_index, repo)
+			idx = len(c.repo_index) - 1
+		}
 	}
 
-	fc, err := NewFeatureCache(f)
+	_, exists := c.repo_map[key]
 
-	if err != nil {
-		return nil, err
+	c.repo_map[key] = idx
+
+	if !exists {
+		atomic.AddInt64(&c.keys, 1)
 	}
 
-	atomic.AddInt64(&c.hits, 1)
-	return fc, nil
+	return nil
 }
 
-func (c *FSCache) Set(key string, i CacheItem) error {
+// Delete forgets the repo for 'key' and removes its item from ItemsRoot - the original features are
+// never removed from the filesystem
 
-	c.Logger.Info("SET %s", key)
+func (c *FSCache) Delete(key string) error {
 
-	s := i.SPR()
-	repo := s.Repo()
+	c.Logger.Info("DELETE %s", key)
 
-	if repo == "" {
-		return errors.New("Unable to determine wof:repo for feature")
+	c.mu.Lock()
+
+	_, exists := c.repo_map[key]
+
+	if exists {
+		delete(c.repo_map, key)
+		atomic.AddInt64(&c.keys, -1)
+	}
+
+	c.mu.Unlock()
+
+	if c.Options.ItemsRoot == "" {
+		return nil
 	}
 
-	_, err := c.str_id2abspath(key, repo)
+	item_path, err := c.itemPath(key)
 
 	if err != nil {
 		return err
 	}
 
-	c.mu.Lock()
+	err = os.Remove(item_path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Has reports whether 'key' has been Set (or was found in ItemsRoot when the cache was created). It
+// doesn't check that the feature can actually be read from disk, use Get for that.
 
-	idx := -1
+func (c *FSCache) Has(key string) (bool, error) {
 
-	for i, name := range c.repo_index {
-		if name == repo {
-			idx = i
-			break
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.repo_map[key]
+	return ok, nil
+}
+
+func (c *FSCache) Keys(ctx context.Context, cb KeysFunc) error {
+
+	for _, key := range c.keysList() {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return err
 		}
-	}
 
-	if idx == -1 {
+		err = cb(ctx, key)
 
-		c.repo_index = append(c.repo_index, repo)
-		idx = len(c.repo_index) - 1
+		if err != nil {
+			return err
+		}
 	}
 
-	c.repo_map[key] = idx
+	return nil
+}
+
+// Iterate reads each item from disk, the same way Get does, and stops with an error if any of them
+// can't be read
+
+func (c *FSCache) Iterate(ctx context.Context, cb IterateFunc) error {
+
+	for _, key := range c.keysList() {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return err
+		}
+
+		fc, err := c.read(key)
+
+		if err != nil {
+
+			// it was deleted after we started
+
+			if !c.has(key) {
+				continue
+			}
+
+			return fmt.Errorf("Failed to read %s, %s", key, err)
+		}
+
+		err = cb(ctx, key, fc)
+
+		if err != nil {
+			return err
+		}
+	}
 
-	c.mu.Unlock()
 	return nil
 }
 
@@ -174,13 +356,21 @@ func (c *FSCache) str_id2abspath(key string, repo string) (string, error) {
 		return "", err
 	}
 
-	wofid, err := strconv.ParseInt(key, 10, 64)
+	str_id, label, is_alt := alt.ParseKey(key)
+
+	wofid, err := strconv.ParseInt(str_id, 10, 64)
 
 	if err != nil {
 		return "", err
 	}
 
-	abs_path, err := uri.Id2AbsPath(data_path, wofid)
+	uri_args := uri.NewDefaultURIArgs()
+
+	if is_alt {
+		uri_args = alt.URIArgs(label)
+	}
+
+	abs_path, err := uri.Id2AbsPath(data_path, wofid, uri_args)
 
 	if os.IsNotExist(err) {
 		return "", err
@@ -194,3 +384,213 @@ func (c *FSCache) str_id2abspath(key string, repo string) (string, error) {
 
 	return abs_path, nil
 }
+
+// itemPath returns the path in ItemsRoot for 'key' which follows the same nested directory structure
+// as a WOF data repository, for example 101/736/545/101736545.item
+
+func (c *FSCache) itemPath(key string) (string, error) {
+
+	str_id, _, _ := alt.ParseKey(key)
+
+	wofid, err := strconv.ParseInt(str_id, 10, 64)
+
+	if err != nil {
+		return "", err
+	}
+
+	rel_path, err := uri.Id2Path(wofid)
+
+	if err != nil {
+		return "", err
+	}
+
+	fname := key + fs_item_ext
+
+	return filepath.Join(c.Options.ItemsRoot, rel_path, fname), nil
+}
+
+func (c *FSCache) getItem(key string) (CacheItem, error) {
+
+	item_path, err := c.itemPath(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadFile(item_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeCacheItem(body)
+}
+
+// setItem writes 'item' to a temporary file which is then renamed so that Get never sees a
+// partially written item
+
+func (c *FSCache) setItem(key string, item CacheItem) error {
+
+	body, err := EncodeCacheItem(item, c.Options.Encoding)
+
+	if err != nil {
+		return err
+	}
+
+	item_path, err := c.itemPath(key)
+
+	if err != nil {
+		return err
+	}
+
+	root := filepath.Dir(item_path)
+
+	err = os.MkdirAll(root, 0755)
+
+	if err != nil {
+		return err
+	}
+
+	fh, err := ioutil.TempFile(root, fs_item_ext)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fh.Write(body)
+
+	if err != nil {
+		fh.Close()
+		os.Remove(fh.Name())
+		return err
+	}
+
+	err = fh.Close()
+
+	if err != nil {
+		os.Remove(fh.Name())
+		return err
+	}
+
+	return os.Rename(fh.Name(), item_path)
+}
+
+func (c *FSCache) has(key string) bool {
+
+	ok, _ := c.Has(key)
+	return ok
+}
+
+// keysList returns a copy of all the keys in the cache so that Keys and Iterate don't hold
+// the lock while they call their callback functions
+
+func (c *FSCache) keysList() []string {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := make([]string, 0, len(c.repo_map))
+
+	for key := range c.repo_map {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// read returns the item for 'key' from ItemsRoot or, failing that, the original feature in its repo
+
+func (c *FSCache) read(key string) (CacheItem, error) {
+
+	c.mu.RLock()
+
+	idx, ok := c.repo_map[key]
+
+	var repo string
+
+	if ok && idx != fs_no_repo {
+		repo = c.repo_index[idx]
+	}
+
+	c.mu.RUnlock()
+
+	if !ok {
+		return nil, errors.New("CACHE MISS")
+	}
+
+	if c.Options.ItemsRoot != "" {
+
+		fc, err := c.getItem(key)
+
+		if err == nil {
+			return fc, nil
+		}
+
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if repo == "" {
+		return nil, errors.New("Unable to determine repo for ID")
+	}
+
+	abs_path, err := c.str_id2abspath(key, repo)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var f geojson.Feature
+
+	_, _, is_alt := alt.ParseKey(key)
+
+	if is_alt {
+		f, err = feature.LoadWOFAltFeatureFromFile(abs_path)
+	} else {
+		f, err = feature.LoadWOFFeatureFromFile(abs_path)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFeatureCache(f)
+}
+
+// loadItems adds the keys for all the items already in ItemsRoot (written by a previous FSCache
+// with the same ItemsRoot) to the cache
+
+func (c *FSCache) loadItems() error {
+
+	cb := func(path string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		fname := info.Name()
+
+		// skip directories and any temporary files left behind by setItem
+
+		if info.IsDir() || strings.HasPrefix(fname, ".") || !strings.HasSuffix(fname, fs_item_ext) {
+			return nil
+		}
+
+		key := strings.TrimSuffix(fname, fs_item_ext)
+
+		_, exists := c.repo_map[key]
+
+		if !exists {
+			c.repo_map[key] = fs_no_repo
+			atomic.AddInt64(&c.keys, 1)
+		}
+
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return filepath.Walk(c.Options.ItemsRoot, cb)
+}
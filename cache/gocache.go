@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	gocache "github.com/patrickmn/go-cache"
@@ -17,10 +18,11 @@ type GoCache struct {
 	hits      int64
 	misses    int64
 	evictions int64
-	keys      int64
 }
 
 type GoCacheOptions struct {
+	// CacheSize is not used - GoCache never evicts anything so if you need a cache
+	// with a size limit use LRUCache instead
 	CacheSize         int
 	CacheTrigger      int
 	DefaultExpiration time.Duration
@@ -63,7 +65,6 @@ func NewGoCache(opts *GoCacheOptions) (Cache, error) {
 		hits:      int64(0),
 		misses:    int64(0),
 		evictions: int64(0),
-		keys:      0,
 	}
 
 	return &lc, nil
@@ -120,13 +121,69 @@ func (c *GoCache) Set(key string, item CacheItem) error {
 	// c.Logger.Debug("SET %s %d points", key, points)
 
 	c.cache.Set(key, item, gocache.DefaultExpiration)
-	atomic.AddInt64(&c.keys, 1)
+	return nil
+}
+
+func (c *GoCache) Delete(key string) error {
+
+	c.cache.Delete(key)
+	return nil
+}
+
+func (c *GoCache) Has(key string) (bool, error) {
+
+	_, ok := c.cache.Get(key)
+	return ok, nil
+}
+
+// Keys and Iterate work on a copy of the items in the cache at the time they are called so the cache
+// can be modified while they are running
+
+func (c *GoCache) Keys(ctx context.Context, cb KeysFunc) error {
+
+	for key := range c.cache.Items() {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return err
+		}
+
+		err = cb(ctx, key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *GoCache) Iterate(ctx context.Context, cb IterateFunc) error {
+
+	for key, i := range c.cache.Items() {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return err
+		}
+
+		err = cb(ctx, key, i.Object.(CacheItem))
+
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// Size returns the number of items in the cache, as counted by go-cache itself, so that it can't
+// drift when items are replaced or deleted
+
 func (c *GoCache) Size() int64 {
-	return atomic.LoadInt64(&c.keys)
+	return int64(c.cache.ItemCount())
 }
 
 func (c *GoCache) Hits() int64 {
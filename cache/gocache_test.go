@@ -0,0 +1,83 @@
+package cache_test
+
+import (
+	"sync"
+	"testing"
+)
+
+// Size should only count distinct keys however many times they are set, replaced or deleted,
+// including when that happens concurrently
+
+func TestGoCacheSize(t *testing.T) {
+
+	items := testCacheItems(t)
+	c := newTestGoCache(t)
+
+	keys := []string{"a", "b", "c", "d"}
+
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < 8; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+
+				for _, key := range keys {
+
+					err := c.Set(key, items["wof"])
+
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					err = c.Delete(key)
+
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					err = c.Set(key, items["alt"])
+
+					if err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if c.Size() != int64(len(keys)) {
+		t.Fatalf("Expected %d items, got %d", len(keys), c.Size())
+	}
+
+	for _, key := range keys {
+
+		err := c.Delete(key)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// deleting something that isn't there is a no-op
+
+	err := c.Delete("a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Size() != 0 {
+		t.Fatalf("Expected an empty cache, got %d items", c.Size())
+	}
+}
@@ -0,0 +1,1031 @@
+package cache
+
+// kv caches are a directory containing an append-only log of records and an index of the offset of
+// the most recent record for each key in that log. they look like this:
+//
+// log:      "WOFPIPKV" (magic) | uint32 version | uint64 generation | record...
+// record:   uint32 checksum | byte op | uint32 key length | uint32 body length | key | body
+// index:    "WOFPIPKI" (magic) | uint32 version | uint64 generation | int64 log size | uvarint count, entry... | uint32 checksum
+// entry:    string key | uvarint offset | uvarint length
+//
+// the body of a record is a cache item encoded by EncodeCacheItem, or empty if the record deletes its
+// key. record checksums are the CRC-32 (IEEE) of everything in the record after the checksum and the
+// index checksum is the CRC-32 of everything before it. strings are a uvarint length followed by that
+// many bytes and all fixed-width numbers are little-endian.
+//
+// the index is only written when the cache is closed and records the size of the log at that time. when
+// the cache is opened again any records after that are read from the log itself and if the index is
+// missing, or its generation doesn't match the log's, the whole log is read. a record that is truncated
+// or doesn't match its checksum (because the process died while writing it) marks the end of the log
+// and it, and anything after it, is discarded.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const KV_LOG_MAGIC string = "WOFPIPKV"
+
+const KV_INDEX_MAGIC string = "WOFPIPKI"
+
+const KV_VERSION uint32 = 1
+
+const kv_log_fname string = "items.log"
+
+const kv_index_fname string = "items.idx"
+
+// the size of the log header and of each record header
+
+const kv_log_header_size int64 = 20
+const kv_record_header_size int64 = 13
+
+const (
+	kv_op_set byte = iota + 1
+	kv_op_delete
+)
+
+// the log is compacted when it is opened if it is at least kv_compact_min bytes and less than half
+// of it is live records
+
+const kv_compact_min int64 = 1 << 20
+
+// KVCache is a cache.Cache that stores encoded cache items in an append-only log on disk with an
+// in-memory index of where each item is, so that a Get is a single read from a file that is already
+// open followed by DecodeCacheItem. Set and Delete append a record to the log so replacing or deleting
+// items leaves the old records behind as garbage until the log is compacted (see Compact).
+//
+// The log is locked (with flock, where it is available) while the cache is open so a directory can only
+// be used by one KVCache at a time, in this process or any other, and opening a directory that is
+// already in use fails rather than waiting. A KVCache needs to be closed before its directory can be
+// opened again.
+
+type KVCache struct {
+	Cache
+	Logger    *log.WOFLogger
+	Options   *KVCacheOptions
+	store     *kvStore
+	closed    int32
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+type KVCacheOptions struct {
+	// how items are encoded when they are written to the log
+	Encoding *ItemEncodingOptions
+	// sync the log to disk after every write, which is slower but means nothing is lost if the machine
+	// (rather than just the process) crashes
+	Sync bool
+}
+
+// kvStore is the log, and the index of the records in it, for a KVCache directory
+
+type kvStore struct {
+	Logger     *log.WOFLogger
+	root       string
+	log        *os.File
+	generation uint64
+	end        int64 // the offset of the end of the last good record in the log
+	entries    map[string]kvEntry
+	live       int64 // the total length of the records in entries
+	mu         *sync.RWMutex
+}
+
+type kvEntry struct {
+	offset int64
+	length int64
+}
+
+func DefaultKVCacheOptions() (*KVCacheOptions, error) {
+
+	enc_opts, err := DefaultItemEncodingOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := KVCacheOptions{
+		Encoding: enc_opts,
+		Sync:     false,
+	}
+
+	return &opts, nil
+}
+
+func NewKVCache(root string) (Cache, error) {
+
+	opts, err := DefaultKVCacheOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKVCacheWithOptions(root, opts)
+}
+
+// NewKVCacheWithOptions opens the kv cache in the directory 'root', creating it if necessary.
+
+func NewKVCacheWithOptions(root string, opts *KVCacheOptions) (Cache, error) {
+
+	if root == "" {
+		return nil, errors.New("Missing kv cache path")
+	}
+
+	abs_root, err := filepath.Abs(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.SimpleWOFLogger("kv")
+
+	store, err := openKVStore(abs_root, logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := KVCache{
+		Logger:    logger,
+		Options:   opts,
+		store:     store,
+		closed:    int32(0),
+		hits:      int64(0),
+		misses:    int64(0),
+		evictions: int64(0),
+	}
+
+	return &c, nil
+}
+
+// Close writes the index and closes (and unlocks) the log
+
+func (c *KVCache) Close() error {
+
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+
+	return c.store.close()
+}
+
+func (c *KVCache) Get(key string) (CacheItem, error) {
+
+	c.Logger.Info("GET %s", key)
+
+	s := c.store
+
+	s.mu.RLock()
+
+	e, ok := s.entries[key]
+
+	if !ok {
+		s.mu.RUnlock()
+
+		atomic.AddInt64(&c.misses, 1)
+		return nil, errors.New("CACHE MISS")
+	}
+
+	body, err := s.readBody(key, e)
+
+	s.mu.RUnlock()
+
+	if err != nil {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, err
+	}
+
+	fc, err := DecodeCacheItem(body)
+
+	if err != nil {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, err
+	}
+
+	atomic.AddInt64(&c.hits, 1)
+	return fc, nil
+}
+
+func (c *KVCache) Set(key string, item CacheItem) error {
+
+	c.Logger.Info("SET %s", key)
+
+	body, err := EncodeCacheItem(item, c.Options.Encoding)
+
+	if err != nil {
+		return err
+	}
+
+	s := c.store
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.append(kv_op_set, key, body, c.Options.Sync)
+
+	if err != nil {
+		return err
+	}
+
+	old, ok := s.entries[key]
+
+	if ok {
+		s.live -= old.length
+	}
+
+	s.entries[key] = e
+	s.live += e.length
+
+	return nil
+}
+
+func (c *KVCache) Delete(key string) error {
+
+	c.Logger.Info("DELETE %s", key)
+
+	s := c.store
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.entries[key]
+
+	if !ok {
+		return nil
+	}
+
+	_, err := s.append(kv_op_delete, key, nil, c.Options.Sync)
+
+	if err != nil {
+		return err
+	}
+
+	delete(s.entries, key)
+	s.live -= old.length
+
+	return nil
+}
+
+func (c *KVCache) Has(key string) (bool, error) {
+
+	s := c.store
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.entries[key]
+	return ok, nil
+}
+
+func (c *KVCache) Keys(ctx context.Context, cb KeysFunc) error {
+
+	for _, key := range c.store.keysList() {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return err
+		}
+
+		err = cb(ctx, key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *KVCache) Iterate(ctx context.Context, cb IterateFunc) error {
+
+	s := c.store
+
+	for _, key := range s.keysList() {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return err
+		}
+
+		s.mu.RLock()
+
+		e, ok := s.entries[key]
+
+		var body []byte
+
+		if ok {
+			body, err = s.readBody(key, e)
+		}
+
+		s.mu.RUnlock()
+
+		// it was deleted after we started
+
+		if !ok {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		fc, err := DecodeCacheItem(body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to decode %s, %s", key, err)
+		}
+
+		err = cb(ctx, key, fc)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *KVCache) Size() int64 {
+
+	s := c.store
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.entries))
+}
+
+func (c *KVCache) Hits() int64 {
+	return atomic.LoadInt64(&c.hits)
+}
+
+func (c *KVCache) Misses() int64 {
+	return atomic.LoadInt64(&c.misses)
+}
+
+func (c *KVCache) Evictions() int64 {
+	return atomic.LoadInt64(&c.evictions)
+}
+
+// Compact rewrites the log so that it only contains the most recent record for each key. This happens
+// automatically when a log is opened if it is mostly garbage.
+
+func (c *KVCache) Compact() error {
+
+	s := c.store
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+// openKVStore opens (or creates) the log in 'root' and compacts it if it is mostly garbage
+
+func openKVStore(root string, logger *log.WOFLogger) (*kvStore, error) {
+
+	err := os.MkdirAll(root, 0755)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := kvStore{
+		Logger:  logger,
+		root:    root,
+		entries: make(map[string]kvEntry),
+		live:    int64(0),
+		mu:      new(sync.RWMutex),
+	}
+
+	err = s.open()
+
+	if err != nil {
+		return nil, err
+	}
+
+	garbage := s.end - kv_log_header_size - s.live
+
+	if s.end >= kv_compact_min && garbage > s.live {
+
+		err := s.compact()
+
+		if err != nil {
+			s.log.Close()
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
+
+// close writes the index and closes the log
+
+func (s *kvStore) close() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.writeIndex()
+
+	if err != nil {
+		s.log.Close()
+		s.log = nil
+		return err
+	}
+
+	err = s.log.Close()
+	s.log = nil
+
+	return err
+}
+
+// compact rewrites the log so that it only contains the most recent record for each key. The new
+// log is written alongside the old one and then moved in to place so a failed compaction leaves the
+// log as it was. It is assumed that the caller is holding the lock.
+
+func (s *kvStore) compact() error {
+
+	t1 := time.Now()
+	old_size := s.end
+
+	tmp_path := filepath.Join(s.root, kv_log_fname+".compact")
+
+	fh, err := os.OpenFile(tmp_path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	abort := func(err error) error {
+		fh.Close()
+		os.Remove(tmp_path)
+		return err
+	}
+
+	// the new log is locked before it is moved in to place so that the directory is never
+	// left unlocked
+
+	err = lockKVLog(fh)
+
+	if err != nil {
+		return abort(err)
+	}
+
+	generation := newKVGeneration()
+
+	wr := bufio.NewWriter(fh)
+
+	_, err = wr.Write(kvLogHeader(generation))
+
+	if err != nil {
+		return abort(err)
+	}
+
+	offset := kv_log_header_size
+	entries := make(map[string]kvEntry)
+
+	for key, e := range s.entries {
+
+		record := make([]byte, e.length)
+
+		_, err := s.log.ReadAt(record, e.offset)
+
+		if err != nil {
+			return abort(err)
+		}
+
+		_, err = wr.Write(record)
+
+		if err != nil {
+			return abort(err)
+		}
+
+		entries[key] = kvEntry{offset: offset, length: e.length}
+		offset += e.length
+	}
+
+	err = wr.Flush()
+
+	if err != nil {
+		return abort(err)
+	}
+
+	err = fh.Sync()
+
+	if err != nil {
+		return abort(err)
+	}
+
+	// the old index is removed first so that if we die after the rename the new log is read
+	// from scratch rather than with an index that doesn't match it (although the generations
+	// wouldn't match either)
+
+	err = os.Remove(s.indexPath())
+
+	if err != nil && !os.IsNotExist(err) {
+		return abort(err)
+	}
+
+	err = os.Rename(tmp_path, s.logPath())
+
+	if err != nil {
+		return abort(err)
+	}
+
+	s.log.Close()
+
+	s.log = fh
+	s.generation = generation
+	s.end = offset
+	s.entries = entries
+
+	s.Logger.Status("Compacted %s from %d to %d bytes in %v", s.logPath(), old_size, offset, time.Since(t1))
+	return nil
+}
+
+func (s *kvStore) logPath() string {
+	return filepath.Join(s.root, kv_log_fname)
+}
+
+func (s *kvStore) indexPath() string {
+	return filepath.Join(s.root, kv_index_fname)
+}
+
+func (s *kvStore) keysList() []string {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.entries))
+
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// open opens (or creates) the log and populates the index from the index file and/or the log itself
+
+func (s *kvStore) open() error {
+
+	fh, err := os.OpenFile(s.logPath(), os.O_RDWR|os.O_CREATE, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	err = lockKVLog(fh)
+
+	if err != nil {
+		fh.Close()
+		return err
+	}
+
+	info, err := fh.Stat()
+
+	if err != nil {
+		fh.Close()
+		return err
+	}
+
+	// if the log was compacted by whoever was holding the lock while we were waiting for it
+	// then the file we've locked has since been replaced
+
+	path_info, err := os.Stat(s.logPath())
+
+	if err != nil || !os.SameFile(info, path_info) {
+		fh.Close()
+		return fmt.Errorf("%s is already in use", s.logPath())
+	}
+
+	if info.Size() == 0 {
+
+		generation := newKVGeneration()
+
+		_, err := fh.WriteAt(kvLogHeader(generation), 0)
+
+		if err != nil {
+			fh.Close()
+			return err
+		}
+
+		s.log = fh
+		s.generation = generation
+		s.end = kv_log_header_size
+
+		// an index left over from a log that has since been removed is never any use
+
+		os.Remove(s.indexPath())
+		return nil
+	}
+
+	header := make([]byte, kv_log_header_size)
+
+	_, err = fh.ReadAt(header, 0)
+
+	if err != nil || string(header[0:8]) != KV_LOG_MAGIC {
+		fh.Close()
+		return fmt.Errorf("%s is not a kv cache log", s.logPath())
+	}
+
+	version := binary.LittleEndian.Uint32(header[8:12])
+
+	if version != KV_VERSION {
+		fh.Close()
+		return fmt.Errorf("Unsupported kv cache version %d", version)
+	}
+
+	s.log = fh
+	s.generation = binary.LittleEndian.Uint64(header[12:20])
+
+	offset, err := s.readIndex(info.Size())
+
+	if err != nil {
+		s.Logger.Warning("Failed to read %s, reading the whole log instead, because %s", s.indexPath(), err)
+
+		s.entries = make(map[string]kvEntry)
+		s.live = 0
+
+		offset = kv_log_header_size
+	}
+
+	err = s.scan(offset, info.Size())
+
+	if err != nil {
+		fh.Close()
+		return err
+	}
+
+	return nil
+}
+
+// scan reads the records in the log from 'offset' to 'size' and adds them to the index, truncating
+// the log at the first bad record
+
+func (s *kvStore) scan(offset int64, size int64) error {
+
+	rd := bufio.NewReader(io.NewSectionReader(s.log, offset, size-offset))
+	header := make([]byte, kv_record_header_size)
+
+	for offset < size {
+
+		_, err := io.ReadFull(rd, header)
+
+		if err != nil {
+			break
+		}
+
+		checksum := binary.LittleEndian.Uint32(header[0:4])
+		op := header[4]
+		key_len := int64(binary.LittleEndian.Uint32(header[5:9]))
+		body_len := int64(binary.LittleEndian.Uint32(header[9:13]))
+
+		length := kv_record_header_size + key_len + body_len
+
+		if offset+length > size {
+			break
+		}
+
+		data := make([]byte, key_len+body_len)
+
+		_, err = io.ReadFull(rd, data)
+
+		if err != nil {
+			break
+		}
+
+		h := crc32.NewIEEE()
+		h.Write(header[4:])
+		h.Write(data)
+
+		if h.Sum32() != checksum {
+			break
+		}
+
+		key := string(data[0:key_len])
+
+		old, ok := s.entries[key]
+
+		if ok {
+			s.live -= old.length
+			delete(s.entries, key)
+		}
+
+		switch op {
+		case kv_op_set:
+			s.entries[key] = kvEntry{offset: offset, length: length}
+			s.live += length
+		case kv_op_delete:
+			// pass
+		default:
+			return fmt.Errorf("Invalid kv cache record at offset %d", offset)
+		}
+
+		offset += length
+	}
+
+	if offset < size {
+
+		s.Logger.Warning("Discarding %d bytes of incomplete or corrupt records at the end of %s", size-offset, s.logPath())
+
+		err := s.log.Truncate(offset)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	s.end = offset
+	return nil
+}
+
+// append writes a new record to the end of the log - it is assumed that the caller is holding the lock
+
+func (s *kvStore) append(op byte, key string, body []byte, sync_write bool) (kvEntry, error) {
+
+	if s.log == nil {
+		return kvEntry{}, errors.New("kv cache is closed")
+	}
+
+	if int64(len(key)) > math.MaxUint32 || int64(len(body)) > math.MaxUint32 {
+		return kvEntry{}, errors.New("Record is too big")
+	}
+
+	length := kv_record_header_size + int64(len(key)) + int64(len(body))
+	record := make([]byte, length)
+
+	record[4] = op
+	binary.LittleEndian.PutUint32(record[5:9], uint32(len(key)))
+	binary.LittleEndian.PutUint32(record[9:13], uint32(len(body)))
+
+	copy(record[kv_record_header_size:], key)
+	copy(record[kv_record_header_size+int64(len(key)):], body)
+
+	binary.LittleEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(record[4:]))
+
+	_, err := s.log.WriteAt(record, s.end)
+
+	if err != nil {
+
+		// don't leave a partial record behind for the next one to be appended after
+
+		s.log.Truncate(s.end)
+		return kvEntry{}, err
+	}
+
+	if sync_write {
+
+		err := s.log.Sync()
+
+		if err != nil {
+			return kvEntry{}, err
+		}
+	}
+
+	e := kvEntry{
+		offset: s.end,
+		length: length,
+	}
+
+	s.end += length
+	return e, nil
+}
+
+// readBody returns the body of the record at 'e' - it is assumed that the caller is holding (at least)
+// the read lock
+
+func (s *kvStore) readBody(key string, e kvEntry) ([]byte, error) {
+
+	if s.log == nil {
+		return nil, errors.New("kv cache is closed")
+	}
+
+	record := make([]byte, e.length)
+
+	_, err := s.log.ReadAt(record, e.offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(record[4:]) != binary.LittleEndian.Uint32(record[0:4]) {
+		return nil, fmt.Errorf("Invalid checksum for %s", key)
+	}
+
+	key_len := int64(binary.LittleEndian.Uint32(record[5:9]))
+
+	return record[kv_record_header_size+key_len:], nil
+}
+
+// readIndex populates the index from the index file and returns the offset in the log to continue
+// reading records from. It is an error if the index doesn't belong to the log or is corrupt.
+
+func (s *kvStore) readIndex(log_size int64) (int64, error) {
+
+	body, err := ioutil.ReadFile(s.indexPath())
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(body) < 32 {
+		return 0, errors.New("Invalid index")
+	}
+
+	checksum := binary.LittleEndian.Uint32(body[len(body)-4:])
+	body = body[0 : len(body)-4]
+
+	if crc32.ChecksumIEEE(body) != checksum {
+		return 0, errors.New("Invalid index checksum")
+	}
+
+	if string(body[0:8]) != KV_INDEX_MAGIC {
+		return 0, errors.New("Invalid index")
+	}
+
+	if binary.LittleEndian.Uint32(body[8:12]) != KV_VERSION {
+		return 0, errors.New("Unsupported index version")
+	}
+
+	if binary.LittleEndian.Uint64(body[12:20]) != s.generation {
+		return 0, errors.New("Index is for a different log")
+	}
+
+	offset := int64(binary.LittleEndian.Uint64(body[20:28]))
+
+	if offset < kv_log_header_size || offset > log_size {
+		return 0, errors.New("Index is for a different log")
+	}
+
+	rd := bytes.NewReader(body[28:])
+
+	count, err := binary.ReadUvarint(rd)
+
+	if err != nil {
+		return 0, err
+	}
+
+	entries := make(map[string]kvEntry)
+	live := int64(0)
+
+	for i := uint64(0); i < count; i++ {
+
+		key_len, err := binary.ReadUvarint(rd)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if key_len > uint64(rd.Len()) {
+			return 0, errors.New("Invalid index")
+		}
+
+		key := make([]byte, key_len)
+
+		_, err = io.ReadFull(rd, key)
+
+		if err != nil {
+			return 0, err
+		}
+
+		e_offset, err := binary.ReadUvarint(rd)
+
+		if err != nil {
+			return 0, err
+		}
+
+		e_length, err := binary.ReadUvarint(rd)
+
+		if err != nil {
+			return 0, err
+		}
+
+		e := kvEntry{
+			offset: int64(e_offset),
+			length: int64(e_length),
+		}
+
+		if e.offset < kv_log_header_size || e.offset+e.length > offset {
+			return 0, errors.New("Invalid index")
+		}
+
+		entries[string(key)] = e
+		live += e.length
+	}
+
+	s.entries = entries
+	s.live = live
+
+	return offset, nil
+}
+
+// writeIndex writes the index to a temporary file which is then moved in to place - it is assumed that
+// the caller is holding the lock
+
+func (s *kvStore) writeIndex() error {
+
+	var buf bytes.Buffer
+
+	b := make([]byte, binary.MaxVarintLen64)
+
+	buf.WriteString(KV_INDEX_MAGIC)
+
+	binary.LittleEndian.PutUint32(b, KV_VERSION)
+	buf.Write(b[0:4])
+
+	binary.LittleEndian.PutUint64(b, s.generation)
+	buf.Write(b[0:8])
+
+	binary.LittleEndian.PutUint64(b, uint64(s.end))
+	buf.Write(b[0:8])
+
+	n := binary.PutUvarint(b, uint64(len(s.entries)))
+	buf.Write(b[0:n])
+
+	for key, e := range s.entries {
+
+		n = binary.PutUvarint(b, uint64(len(key)))
+		buf.Write(b[0:n])
+		buf.WriteString(key)
+
+		n = binary.PutUvarint(b, uint64(e.offset))
+		buf.Write(b[0:n])
+
+		n = binary.PutUvarint(b, uint64(e.length))
+		buf.Write(b[0:n])
+	}
+
+	binary.LittleEndian.PutUint32(b, crc32.ChecksumIEEE(buf.Bytes()))
+	buf.Write(b[0:4])
+
+	// the index is only worth anything if the log it points in to is actually on disk
+
+	err := s.log.Sync()
+
+	if err != nil {
+		return err
+	}
+
+	fh, err := ioutil.TempFile(s.root, kv_index_fname)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fh.Write(buf.Bytes())
+
+	if err == nil {
+		err = fh.Chmod(0644)
+	}
+
+	if err != nil {
+		fh.Close()
+		os.Remove(fh.Name())
+		return err
+	}
+
+	err = fh.Close()
+
+	if err != nil {
+		os.Remove(fh.Name())
+		return err
+	}
+
+	return os.Rename(fh.Name(), s.indexPath())
+}
+
+func kvLogHeader(generation uint64) []byte {
+
+	header := make([]byte, kv_log_header_size)
+
+	copy(header[0:8], KV_LOG_MAGIC)
+	binary.LittleEndian.PutUint32(header[8:12], KV_VERSION)
+	binary.LittleEndian.PutUint64(header[12:20], generation)
+
+	return header
+}
+
+// newKVGeneration returns a number that is (for all practical purposes) different every time a new
+// log is started so that an index can't be mistaken for the index of a different log
+
+func newKVGeneration() uint64 {
+	return uint64(time.Now().UnixNano())
+}
@@ -0,0 +1,24 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package cache
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockKVLog takes an exclusive lock on 'fh' so that a kv cache directory is only used by one
+// KVCache at a time. It fails, rather than waiting, if the log is already locked.
+
+func lockKVLog(fh *os.File) error {
+
+	err := syscall.Flock(int(fh.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+
+	if err == syscall.EWOULDBLOCK {
+		return fmt.Errorf("%s is already in use", fh.Name())
+	}
+
+	return err
+}
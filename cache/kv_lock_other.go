@@ -0,0 +1,15 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package cache
+
+import (
+	"os"
+)
+
+// lockKVLog does nothing on platforms without flock so it is up to you to make sure that a kv
+// cache directory is only used by one KVCache at a time.
+
+func lockKVLog(fh *os.File) error {
+	return nil
+}
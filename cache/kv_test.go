@@ -0,0 +1,732 @@
+package cache_test
+
+import (
+	"bytes"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"github.com/whosonfirst/go-whosonfirst-sqlite/database"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// the names of the files in a kv cache directory, see kv.go
+
+const test_kv_log string = "items.log"
+const test_kv_index string = "items.idx"
+
+// the size of the header at the start of a kv cache log
+
+const test_kv_log_header int64 = 20
+
+// the size of the fixed-width part of the header at the start of a kv cache index
+
+const test_kv_index_header int = 28
+
+func newTestKVCache(t testing.TB, root string) cache.Cache {
+
+	c, err := cache.NewKVCache(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func closeTestCache(t testing.TB, c cache.Cache) {
+
+	err := c.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fileSize(t testing.TB, path string) int64 {
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info.Size()
+}
+
+// copyFile copies 'src' to 'dst', truncated to 'size' bytes if 'size' is not -1, and does nothing if
+// 'src' doesn't exist
+
+func copyFile(t testing.TB, src string, dst string, size int64) {
+
+	body, err := ioutil.ReadFile(src)
+
+	if os.IsNotExist(err) {
+		return
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != -1 {
+		body = body[0:size]
+	}
+
+	err = ioutil.WriteFile(dst, body, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// cacheKeys returns the keys in 'c', sorted, as a comma-separated string
+
+func cacheKeys(t testing.TB, c cache.Cache) string {
+
+	keys := make([]string, 0)
+
+	for i := 1; i <= 10; i++ {
+
+		key := strconv.Itoa(i)
+
+		ok, err := c.Has(key)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !ok {
+			continue
+		}
+
+		_, err = c.Get(key)
+
+		if err != nil {
+			t.Fatalf("Failed to get %s, %s", key, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// writeTestKVLog writes 'count' items, with the keys 1 to 'count', to a new kv cache in 'root' and
+// closes it. It returns the size of the log after each item was written.
+
+func writeTestKVLog(t *testing.T, root string, count int) []int64 {
+
+	item := testCacheItems(t)["wof"]
+
+	c := newTestKVCache(t, root)
+
+	log_path := filepath.Join(root, test_kv_log)
+	ends := make([]int64, 0)
+
+	for i := 1; i <= count; i++ {
+
+		err := c.Set(strconv.Itoa(i), item)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ends = append(ends, fileSize(t, log_path))
+	}
+
+	closeTestCache(t, c)
+	return ends
+}
+
+func TestKVCacheReopen(t *testing.T) {
+
+	items := testCacheItems(t)
+	root := t.TempDir()
+
+	c := newTestKVCache(t, root)
+
+	for i, label := range []string{"wof", "alt", "geojson"} {
+
+		err := c.Set(strconv.Itoa(i+1), items[label])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := c.Delete("2")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeTestCache(t, c)
+
+	c = newTestKVCache(t, root)
+	defer closeTestCache(t, c)
+
+	keys := cacheKeys(t, c)
+
+	if keys != "1,3" {
+		t.Fatalf("Expected keys 1,3 after reopening, got %s", keys)
+	}
+
+	item, err := c.Get("3")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.SPR().Id() != items["geojson"].SPR().Id() {
+		t.Fatalf("Expected %s, got %s", items["geojson"].SPR().Id(), item.SPR().Id())
+	}
+}
+
+// a process that dies doesn't write the index so the records written since the last time the cache
+// was closed are read back from the log itself
+
+func TestKVCacheCrashRecovery(t *testing.T) {
+
+	item := testCacheItems(t)["wof"]
+
+	root := t.TempDir()
+	crash_root := t.TempDir()
+
+	writeTestKVLog(t, root, 3)
+
+	c := newTestKVCache(t, root)
+
+	err := c.Delete("1")
+
+	if err == nil {
+		err = c.Set("4", item)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// this is what is on disk if we die now: the log with the new records
+	// and the index from the last time the cache was closed
+
+	for _, fname := range []string{test_kv_log, test_kv_index} {
+		copyFile(t, filepath.Join(root, fname), filepath.Join(crash_root, fname), -1)
+	}
+
+	closeTestCache(t, c)
+
+	_, err = os.Stat(filepath.Join(crash_root, test_kv_index))
+
+	if err != nil {
+		t.Fatalf("Expected an old index to recover from, %s", err)
+	}
+
+	recovered := newTestKVCache(t, crash_root)
+	defer closeTestCache(t, recovered)
+
+	keys := cacheKeys(t, recovered)
+
+	if keys != "2,3,4" {
+		t.Fatalf("Expected keys 2,3,4 after recovering, got %s", keys)
+	}
+}
+
+// a log that ends part way through a record (because the process died while writing it) is truncated
+// after the last complete record, wherever that is, and the records before it are kept
+
+func TestKVCacheTruncatedLog(t *testing.T) {
+
+	item := testCacheItems(t)["wof"]
+
+	root := t.TempDir()
+	ends := writeTestKVLog(t, root, 3)
+
+	log_path := filepath.Join(root, test_kv_log)
+	size := fileSize(t, log_path)
+
+	for n := int64(1); n < size; n++ {
+
+		truncated_root := filepath.Join(t.TempDir(), "kv")
+
+		err := os.MkdirAll(truncated_root, 0755)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// there is no index, so the whole log has to be read
+
+		truncated_path := filepath.Join(truncated_root, test_kv_log)
+		copyFile(t, log_path, truncated_path, n)
+
+		c, err := cache.NewKVCache(truncated_root)
+
+		if n < test_kv_log_header {
+
+			if err == nil {
+				c.Close()
+				t.Fatalf("Expected an error opening a log with a truncated header (%d bytes)", n)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("Failed to open a log truncated to %d bytes, %s", n, err)
+		}
+
+		expected_end := test_kv_log_header
+		expected_keys := make([]string, 0)
+
+		for i, end := range ends {
+
+			if end <= n {
+				expected_end = end
+				expected_keys = append(expected_keys, strconv.Itoa(i+1))
+			}
+		}
+
+		keys := cacheKeys(t, c)
+
+		if keys != strings.Join(expected_keys, ",") {
+			t.Fatalf("Expected keys %s from a log truncated to %d bytes, got %s", strings.Join(expected_keys, ","), n, keys)
+		}
+
+		if fileSize(t, truncated_path) != expected_end {
+			t.Fatalf("Expected a log truncated to %d bytes to be cut back to %d bytes, got %d", n, expected_end, fileSize(t, truncated_path))
+		}
+
+		// and new records are written after the last good one
+
+		err = c.Set("10", item)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		closeTestCache(t, c)
+
+		c = newTestKVCache(t, truncated_root)
+
+		ok, _ := c.Has("10")
+
+		if !ok {
+			t.Fatalf("Expected a record written after truncating to %d bytes to survive reopening", n)
+		}
+
+		closeTestCache(t, c)
+	}
+}
+
+// a record that doesn't match its checksum marks the end of the log if the log has to be read but
+// is reported by Get if the record is in the index
+
+func TestKVCacheCorruptRecord(t *testing.T) {
+
+	root := t.TempDir()
+	ends := writeTestKVLog(t, root, 3)
+
+	log_path := filepath.Join(root, test_kv_log)
+
+	body, err := ioutil.ReadFile(log_path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a bit in the middle of the body of the second record
+
+	body[(ends[0]+ends[1])/2] ^= 0x01
+
+	err = ioutil.WriteFile(log_path, body, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestKVCache(t, root)
+
+	_, err = c.Get("2")
+
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("Expected a checksum error reading a corrupt record, got %v", err)
+	}
+
+	_, err = c.Get("3")
+
+	if err != nil {
+		t.Fatalf("Expected the record after a corrupt record to be readable, %s", err)
+	}
+
+	closeTestCache(t, c)
+
+	// without an index the log is read from the start and everything from the
+	// corrupt record onwards is discarded
+
+	err = os.Remove(filepath.Join(root, test_kv_index))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = newTestKVCache(t, root)
+	defer closeTestCache(t, c)
+
+	keys := cacheKeys(t, c)
+
+	if keys != "1" {
+		t.Fatalf("Expected keys 1 after discarding a corrupt record, got %s", keys)
+	}
+
+	if fileSize(t, log_path) != ends[0] {
+		t.Fatalf("Expected the log to be truncated to %d bytes, got %d", ends[0], fileSize(t, log_path))
+	}
+}
+
+// an index is only used with the log it was written for, and if it is corrupt, and otherwise the
+// whole log is read
+
+func TestKVCacheIndexGeneration(t *testing.T) {
+
+	root := t.TempDir()
+	other_root := t.TempDir()
+
+	writeTestKVLog(t, root, 3)
+
+	// a different log, whose records are in different places
+
+	other := newTestKVCache(t, other_root)
+
+	err := other.Set("9", testCacheItems(t)["alt"])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeTestCache(t, other)
+
+	index_path := filepath.Join(root, test_kv_index)
+
+	// the index for the other log
+
+	copyFile(t, filepath.Join(other_root, test_kv_index), index_path, -1)
+
+	c := newTestKVCache(t, root)
+
+	keys := cacheKeys(t, c)
+
+	if keys != "1,2,3" {
+		t.Fatalf("Expected keys 1,2,3 with the index for a different log, got %s", keys)
+	}
+
+	closeTestCache(t, c)
+
+	// the log's own index, which was written when it was closed, with key 1 renamed to 7
+	// but its checksum left as it was
+
+	body, err := ioutil.ReadFile(index_path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := bytes.Index(body[test_kv_index_header:], []byte{0x01, '1'})
+
+	if i == -1 {
+		t.Fatal("Failed to find key 1 in the index")
+	}
+
+	body[test_kv_index_header+i+1] = '7'
+
+	err = ioutil.WriteFile(index_path, body, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = newTestKVCache(t, root)
+	defer closeTestCache(t, c)
+
+	keys = cacheKeys(t, c)
+
+	if keys != "1,2,3" {
+		t.Fatalf("Expected keys 1,2,3 with a corrupt index, got %s", keys)
+	}
+}
+
+// a log that is mostly garbage is compacted when it is opened, or when Compact is called
+
+func TestKVCacheCompact(t *testing.T) {
+
+	items := testCacheItems(t)
+	root := t.TempDir()
+
+	log_path := filepath.Join(root, test_kv_log)
+
+	c := newTestKVCache(t, root)
+
+	err := c.Set("1", items["wof"])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for fileSize(t, log_path) < 2*1024*1024 {
+
+		err := c.Set("2", items["alt"])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = c.Delete("1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Set("3", items["geojson"])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// compacting the log of an open cache
+
+	err = c.(*cache.KVCache).Compact()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compacted := fileSize(t, log_path)
+
+	if compacted > 64*1024 {
+		t.Fatalf("Expected the log to be compacted, it is %d bytes", compacted)
+	}
+
+	keys := cacheKeys(t, c)
+
+	if keys != "2,3" {
+		t.Fatalf("Expected keys 2,3 after compacting, got %s", keys)
+	}
+
+	err = c.Set("4", items["wof"])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeTestCache(t, c)
+
+	c = newTestKVCache(t, root)
+
+	keys = cacheKeys(t, c)
+
+	if keys != "2,3,4" {
+		t.Fatalf("Expected keys 2,3,4 after compacting and reopening, got %s", keys)
+	}
+
+	// and now the log is garbage again it is compacted when it is opened
+
+	for fileSize(t, log_path) < 2*1024*1024 {
+
+		err := c.Set("2", items["alt"])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	closeTestCache(t, c)
+
+	c = newTestKVCache(t, root)
+	defer closeTestCache(t, c)
+
+	if fileSize(t, log_path) > 64*1024 {
+		t.Fatalf("Expected the log to be compacted when it was opened, it is %d bytes", fileSize(t, log_path))
+	}
+
+	keys = cacheKeys(t, c)
+
+	if keys != "2,3,4" {
+		t.Fatalf("Expected keys 2,3,4 after compacting on open, got %s", keys)
+	}
+
+	_, err = os.Stat(log_path + ".compact")
+
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected the temporary compaction log to be gone, %v", err)
+	}
+}
+
+// a directory can only be used by one KVCache at a time, including after its log has been compacted
+
+func TestKVCacheLocked(t *testing.T) {
+
+	items := testCacheItems(t)
+	root := t.TempDir()
+
+	c := newTestKVCache(t, root)
+
+	err := c.Set("1", items["wof"])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cache.NewKVCache(root)
+
+	if err == nil {
+		t.Fatal("Expected opening a directory that is already in use to fail")
+	}
+
+	err = c.(*cache.KVCache).Compact()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cache.NewKVCache(root)
+
+	if err == nil {
+		t.Fatal("Expected opening a directory that is already in use to fail after compacting")
+	}
+
+	closeTestCache(t, c)
+
+	c = newTestKVCache(t, root)
+	defer closeTestCache(t, c)
+
+	keys := cacheKeys(t, c)
+
+	if keys != "1" {
+		t.Fatalf("Expected key 1 after reopening, got %s", keys)
+	}
+}
+
+// newBenchmarkCache opens the persistent cache called 'name' in the directory 'root'
+
+func newBenchmarkCache(b *testing.B, name string, root string) cache.Cache {
+
+	var c cache.Cache
+	var err error
+
+	switch name {
+
+	case "kv":
+
+		c, err = cache.NewKVCache(root)
+
+	case "sqlite":
+
+		var db *database.SQLiteDatabase
+		db, err = database.NewDB(filepath.Join(root, "cache.db"))
+
+		if err == nil {
+			c, err = cache.NewSQLiteCache(db)
+		}
+
+	case "fs":
+
+		var opts *cache.FSCacheOptions
+		opts, err = cache.DefaultFSCacheOptions()
+
+		if err == nil {
+			opts.ItemsRoot = filepath.Join(root, "items")
+			c, err = cache.NewFSCacheWithOptions(root, opts)
+		}
+
+	default:
+		b.Fatalf("Invalid cache '%s'", name)
+	}
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return c
+}
+
+// the number of distinct keys the benchmarks write to, and read from
+
+const benchmark_keys int = 1000
+
+func benchmarkCacheSet(b *testing.B, name string) {
+
+	item := testCacheItems(b)["wof"]
+
+	c := newBenchmarkCache(b, name, b.TempDir())
+	defer c.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		err := c.Set(strconv.Itoa(1+i%benchmark_keys), item)
+
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkCacheGet(b *testing.B, name string) {
+
+	item := testCacheItems(b)["wof"]
+
+	c := newBenchmarkCache(b, name, b.TempDir())
+	defer c.Close()
+
+	for i := 1; i <= benchmark_keys; i++ {
+
+		err := c.Set(strconv.Itoa(i), item)
+
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		_, err := c.Get(strconv.Itoa(1 + i%benchmark_keys))
+
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkKVCacheSet(b *testing.B) {
+	benchmarkCacheSet(b, "kv")
+}
+
+func BenchmarkKVCacheGet(b *testing.B) {
+	benchmarkCacheGet(b, "kv")
+}
+
+func BenchmarkFSCacheSet(b *testing.B) {
+	benchmarkCacheSet(b, "fs")
+}
+
+func BenchmarkFSCacheGet(b *testing.B) {
+	benchmarkCacheGet(b, "fs")
+}
+
+func BenchmarkSQLiteCacheSet(b *testing.B) {
+	benchmarkCacheSet(b, "sqlite")
+}
+
+func BenchmarkSQLiteCacheGet(b *testing.B) {
+	benchmarkCacheGet(b, "sqlite")
+}
@@ -0,0 +1,483 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"sync"
+	"sync/atomic"
+)
+
+// the (rough) number of bytes each cache item, and each vertex of its polygons, takes up in memory -
+// these are only used to estimate the size of an item for the LRUCacheOptions.MaxBytes limit
+
+const lru_item_overhead int64 = 512
+const lru_vertex_size int64 = 16
+
+// LRUCache is a cache.Cache that holds at most MaxItems items (and/or MaxBytes bytes, as estimated
+// from the size of each item's polygons and SPR) in memory, evicting the least recently used items
+// to make room for new ones. Items are also written to (and, once evicted, read back from) a backing
+// cache like FSCache or SQLiteCache so that nothing is ever lost. Without a backing cache evicted items
+// are simply gone which is probably not what you want if the cache is being used by an index.
+//
+// Hits, Misses and Evictions describe the in-memory cache only: a Get for an item that has been evicted
+// counts as a miss even if it is read back from the backing cache.
+
+type LRUCache struct {
+	Cache
+	Logger    *log.WOFLogger
+	Options   *LRUCacheOptions
+	items     map[string]*list.Element
+	order     *list.List          // most recently used items first
+	reads     map[string]*lruRead // keys that are being read back from the backing cache, see Get
+	bytes     int64
+	mu        *sync.Mutex
+	writes    []*sync.Mutex // keys are hashed in to stripes so that writes to the same key happen one at a time, see Set
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// LRU_STRIPES is the number of stripes an LRUCache divides its keys in to when writing them
+
+const LRU_STRIPES int = 64
+
+type LRUCacheOptions struct {
+	// the maximum number of items to keep in memory, or 0 for no limit
+	MaxItems int
+	// the maximum (estimated) number of bytes to keep in memory, or 0 for no limit
+	MaxBytes int64
+	// the cache to write items through to and read evicted items back from, or nil
+	Backing Cache
+}
+
+type lruEntry struct {
+	key  string
+	item CacheItem
+	size int64
+}
+
+// lruRead tracks the Gets that are reading a key back from the backing cache so that they can tell
+// whether it was Set or Deleted in the meantime
+
+type lruRead struct {
+	readers int
+	stale   bool
+}
+
+func (o *LRUCacheOptions) String() string {
+	return fmt.Sprintf("max items %d max bytes %d", o.MaxItems, o.MaxBytes)
+}
+
+func DefaultLRUCacheOptions() (*LRUCacheOptions, error) {
+
+	opts := LRUCacheOptions{
+		MaxItems: 10000,
+		MaxBytes: 0,
+		Backing:  nil,
+	}
+
+	return &opts, nil
+}
+
+func NewLRUCache(opts *LRUCacheOptions) (Cache, error) {
+
+	if opts.MaxItems < 0 || opts.MaxBytes < 0 {
+		return nil, errors.New("Invalid LRU cache limits")
+	}
+
+	logger := log.SimpleWOFLogger("lru")
+
+	writes := make([]*sync.Mutex, LRU_STRIPES)
+
+	for i := range writes {
+		writes[i] = new(sync.Mutex)
+	}
+
+	c := LRUCache{
+		Logger:    logger,
+		Options:   opts,
+		items:     make(map[string]*list.Element),
+		order:     list.New(),
+		reads:     make(map[string]*lruRead),
+		bytes:     int64(0),
+		mu:        new(sync.Mutex),
+		writes:    writes,
+		hits:      int64(0),
+		misses:    int64(0),
+		evictions: int64(0),
+	}
+
+	return &c, nil
+}
+
+func (c *LRUCache) Close() error {
+
+	if c.Options.Backing == nil {
+		return nil
+	}
+
+	return c.Options.Backing.Close()
+}
+
+func (c *LRUCache) Get(key string) (CacheItem, error) {
+
+	c.Logger.Info("GET %s", key)
+
+	c.mu.Lock()
+
+	el, ok := c.items[key]
+
+	if ok {
+		c.order.MoveToFront(el)
+		c.mu.Unlock()
+
+		atomic.AddInt64(&c.hits, 1)
+		return el.Value.(*lruEntry).item, nil
+	}
+
+	if c.Options.Backing == nil {
+		c.mu.Unlock()
+
+		atomic.AddInt64(&c.misses, 1)
+		return nil, errors.New("CACHE MISS")
+	}
+
+	// register the read before letting go of the lock so that a Set or Delete
+	// that happens while we are reading the backing cache will mark it as stale
+
+	r, ok := c.reads[key]
+
+	if !ok {
+		r = new(lruRead)
+		c.reads[key] = r
+	}
+
+	r.readers += 1
+
+	c.mu.Unlock()
+
+	atomic.AddInt64(&c.misses, 1)
+
+	item, err := c.Options.Backing.Get(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r.readers -= 1
+
+	if r.readers == 0 {
+		delete(c.reads, key)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// don't resurrect an item that was Deleted, or clobber a newer item that was
+	// Set, while we were reading this one
+
+	if !r.stale {
+		c.insert(key, item, false)
+	}
+
+	return item, nil
+}
+
+// Set writes 'item' to the backing cache and then adds it to the in-memory cache, evicting other items
+// if necessary. Writes (Sets and Deletes) to the same key, or rather to keys that hash to the same stripe,
+// happen one at a time so that the in-memory cache always ends up with the same item as the backing cache.
+
+func (c *LRUCache) Set(key string, item CacheItem) error {
+
+	c.Logger.Info("SET %s", key)
+
+	mu := c.writes[keyStripe(key, len(c.writes))]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if c.Options.Backing != nil {
+
+		err := c.Options.Backing.Set(key, item)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	c.add(key, item, true)
+	return nil
+}
+
+// Delete removes 'key' from the backing cache and then from the in-memory cache. It is done in that
+// order so that a Get can't read the item back from the backing cache after it has been removed from
+// memory.
+
+func (c *LRUCache) Delete(key string) error {
+
+	c.Logger.Info("DELETE %s", key)
+
+	mu := c.writes[keyStripe(key, len(c.writes))]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if c.Options.Backing != nil {
+
+		err := c.Options.Backing.Delete(key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+
+	if ok {
+		c.remove(el)
+	}
+
+	c.invalidate(key)
+	return nil
+}
+
+// Has reports whether 'key' is in the in-memory cache or the backing cache. Unlike Get it doesn't
+// change the order in which items are evicted.
+
+func (c *LRUCache) Has(key string) (bool, error) {
+
+	c.mu.Lock()
+	_, ok := c.items[key]
+	c.mu.Unlock()
+
+	if ok || c.Options.Backing == nil {
+		return ok, nil
+	}
+
+	return c.Options.Backing.Has(key)
+}
+
+// Keys and Iterate use the backing cache, since it has every item, if there is one and otherwise
+// a copy of the in-memory cache
+
+func (c *LRUCache) Keys(ctx context.Context, cb KeysFunc) error {
+
+	if c.Options.Backing != nil {
+		return c.Options.Backing.Keys(ctx, cb)
+	}
+
+	for _, e := range c.entries() {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return err
+		}
+
+		err = cb(ctx, e.key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *LRUCache) Iterate(ctx context.Context, cb IterateFunc) error {
+
+	if c.Options.Backing != nil {
+		return c.Options.Backing.Iterate(ctx, cb)
+	}
+
+	for _, e := range c.entries() {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return err
+		}
+
+		err = cb(ctx, e.key, e.item)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Size returns the number of items in the in-memory cache
+
+func (c *LRUCache) Size() int64 {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return int64(len(c.items))
+}
+
+// Bytes returns the estimated size, in bytes, of the items in the in-memory cache
+
+func (c *LRUCache) Bytes() int64 {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytes
+}
+
+func (c *LRUCache) Hits() int64 {
+	return atomic.LoadInt64(&c.hits)
+}
+
+func (c *LRUCache) Misses() int64 {
+	return atomic.LoadInt64(&c.misses)
+}
+
+func (c *LRUCache) Evictions() int64 {
+	return atomic.LoadInt64(&c.evictions)
+}
+
+// add adds 'item' to the in-memory cache, replacing any existing item for 'key' if 'replace' is true
+
+func (c *LRUCache) add(key string, item CacheItem, replace bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if replace {
+		c.invalidate(key)
+	}
+
+	c.insert(key, item, replace)
+}
+
+// insert adds 'item' to the in-memory cache, replacing any existing item for 'key' if 'replace' is true,
+// and then evicts the least recently used items until the cache is back within its limits. Items that
+// are too big to ever fit are not added. It is assumed that the caller is holding the lock.
+
+func (c *LRUCache) insert(key string, item CacheItem, replace bool) {
+
+	size := estimateItemSize(item)
+
+	el, ok := c.items[key]
+
+	if ok {
+
+		if !replace {
+			return
+		}
+
+		c.remove(el)
+	}
+
+	if c.Options.MaxBytes > 0 && size > c.Options.MaxBytes {
+		c.Logger.Debug("SKIP %s because it is too big (%d bytes)", key, size)
+		return
+	}
+
+	e := lruEntry{
+		key:  key,
+		item: item,
+		size: size,
+	}
+
+	c.items[key] = c.order.PushFront(&e)
+	c.bytes += size
+
+	for c.isFull() {
+
+		oldest := c.order.Back()
+
+		c.Logger.Debug("EVICT %s", oldest.Value.(*lruEntry).key)
+
+		c.remove(oldest)
+		atomic.AddInt64(&c.evictions, 1)
+	}
+}
+
+// invalidate marks any reads of 'key' from the backing cache that are in progress as stale - it is
+// assumed that the caller is holding the lock
+
+func (c *LRUCache) invalidate(key string) {
+
+	r, ok := c.reads[key]
+
+	if ok {
+		r.stale = true
+	}
+}
+
+// remove deletes 'el' from the in-memory cache - it is assumed that the caller is holding the lock
+
+func (c *LRUCache) remove(el *list.Element) {
+
+	e := el.Value.(*lruEntry)
+
+	c.order.Remove(el)
+	delete(c.items, e.key)
+
+	c.bytes -= e.size
+}
+
+// entries returns a copy of the entries in the in-memory cache, most recently used first
+
+func (c *LRUCache) entries() []*lruEntry {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]*lruEntry, 0, c.order.Len())
+
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		entries = append(entries, el.Value.(*lruEntry))
+	}
+
+	return entries
+}
+
+func (c *LRUCache) isFull() bool {
+
+	if c.Options.MaxItems > 0 && len(c.items) > c.Options.MaxItems {
+		return true
+	}
+
+	if c.Options.MaxBytes > 0 && c.bytes > c.Options.MaxBytes {
+		return true
+	}
+
+	return false
+}
+
+// estimateItemSize returns a rough estimate of the number of bytes 'item' takes up in memory
+
+func estimateItemSize(item CacheItem) int64 {
+
+	size := lru_item_overhead
+
+	s := item.SPR()
+
+	for _, str := range []string{s.Id(), s.ParentId(), s.Name(), s.Placetype(), s.Country(), s.Repo(), s.Path(), s.URI()} {
+		size += int64(len(str))
+	}
+
+	for _, p := range item.Polygons() {
+
+		ext := p.ExteriorRing()
+		size += int64(ext.Length()) * lru_vertex_size
+
+		for _, ring := range p.InteriorRings() {
+			size += int64(ring.Length()) * lru_vertex_size
+		}
+	}
+
+	return size
+}
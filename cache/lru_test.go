@@ -0,0 +1,261 @@
+package cache_test
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestLRUCache(t *testing.T, max_items int, backing cache.Cache) cache.Cache {
+
+	opts, err := cache.DefaultLRUCacheOptions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.MaxItems = max_items
+	opts.Backing = backing
+
+	c, err := cache.NewLRUCache(opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestLRUCacheEviction(t *testing.T) {
+
+	items := testCacheItems(t)
+
+	backing := newTestGoCache(t)
+	c := newTestLRUCache(t, 2, backing)
+
+	for _, key := range []string{"wof", "alt", "geojson"} {
+
+		err := c.Set(key, items[key])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c.Size() != 2 {
+		t.Fatalf("Expected 2 items in memory, got %d", c.Size())
+	}
+
+	if c.Evictions() != 1 {
+		t.Fatalf("Expected 1 eviction, got %d", c.Evictions())
+	}
+
+	// the evicted item is read back from the backing cache
+
+	item, err := c.Get("wof")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.SPR().Id() != items["wof"].SPR().Id() {
+		t.Fatalf("Expected %s, got %s", items["wof"].SPR().Id(), item.SPR().Id())
+	}
+
+	if c.Misses() != 1 {
+		t.Fatalf("Expected 1 miss, got %d", c.Misses())
+	}
+}
+
+// an item read back from the backing cache mustn't be added to memory if it was deleted while
+// it was being read
+
+func TestLRUCacheGetDuringDelete(t *testing.T) {
+
+	item := testCacheItems(t)["wof"]
+
+	read := make(chan bool)
+	release := make(chan bool)
+
+	backing := &hookCache{
+		Cache: newTestGoCache(t),
+		afterGet: func(key string) {
+			close(read)
+			<-release
+		},
+	}
+
+	err := backing.Cache.Set("a", item)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestLRUCache(t, 10, backing)
+
+	get_done := make(chan bool)
+
+	go func() {
+		c.Get("a")
+		close(get_done)
+	}()
+
+	wait(t, "the item to be read", read)
+
+	err = c.Delete("a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	wait(t, "the read to finish", get_done)
+
+	ok, err := c.Has("a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok {
+		t.Fatal("Expected a deleted item to stay deleted")
+	}
+
+	if c.Size() != 0 {
+		t.Fatalf("Expected an empty cache, got %d items", c.Size())
+	}
+}
+
+// an item read back from the backing cache mustn't replace a newer item that was set while it
+// was being read
+
+func TestLRUCacheGetDuringSet(t *testing.T) {
+
+	items := testCacheItems(t)
+
+	old_item := items["wof"]
+	new_item := items["alt"]
+
+	read := make(chan bool)
+	release := make(chan bool)
+
+	backing := &hookCache{
+		Cache: newTestGoCache(t),
+		afterGet: func(key string) {
+			close(read)
+			<-release
+		},
+	}
+
+	err := backing.Cache.Set("a", old_item)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestLRUCache(t, 10, backing)
+
+	get_done := make(chan bool)
+
+	go func() {
+		c.Get("a")
+		close(get_done)
+	}()
+
+	wait(t, "the old item to be read", read)
+
+	err = c.Set("a", new_item)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	wait(t, "the read to finish", get_done)
+
+	// this should be a hit, since the new item is in memory, so the backing cache is not read again
+
+	item, err := c.Get("a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if item.SPR().Id() != new_item.SPR().Id() {
+		t.Fatalf("Expected %s, got %s", new_item.SPR().Id(), item.SPR().Id())
+	}
+}
+
+// two Sets for the same key should leave the same item in memory as in the backing cache, whichever
+// order they finish in
+
+func TestLRUCacheConcurrentSets(t *testing.T) {
+
+	items := testCacheItems(t)
+
+	first_item := items["wof"]
+	second_item := items["alt"]
+
+	written := make(chan bool)
+	release := make(chan bool)
+
+	sets := int32(0)
+
+	backing := &hookCache{
+		Cache: newTestGoCache(t),
+		afterSet: func(key string) {
+
+			if atomic.AddInt32(&sets, 1) == 1 {
+				close(written)
+				<-release
+			}
+		},
+	}
+
+	c := newTestLRUCache(t, 10, backing)
+
+	first_done := make(chan bool)
+
+	go func() {
+		c.Set("a", first_item)
+		close(first_done)
+	}()
+
+	wait(t, "the first item to be written to the backing cache", written)
+
+	second_done := make(chan bool)
+
+	go func() {
+		c.Set("a", second_item)
+		close(second_done)
+	}()
+
+	// the second Set should wait for the first one so give it a moment to (wrongly) finish
+
+	select {
+	case <-second_done:
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	wait(t, "the first write to finish", first_done)
+	wait(t, "the second write to finish", second_done)
+
+	in_memory, err := c.Get("a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in_backing, err := backing.Cache.Get("a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if in_memory.SPR().Id() != in_backing.SPR().Id() {
+		t.Fatalf("Expected %s in memory (the same as the backing cache), got %s", in_backing.SPR().Id(), in_memory.SPR().Id())
+	}
+}
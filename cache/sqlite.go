@@ -3,28 +3,88 @@ package cache
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
 	"github.com/whosonfirst/go-whosonfirst-log"
-	"github.com/whosonfirst/go-whosonfirst-pip-v2"
-	"github.com/whosonfirst/go-whosonfirst-spr"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
 	"github.com/whosonfirst/go-whosonfirst-sqlite-features/tables"
 	"github.com/whosonfirst/go-whosonfirst-sqlite/database"
 	"sync/atomic"
+	"time"
 )
 
+const sqlite_items_schema string = `CREATE TABLE IF NOT EXISTS cache_items (
+	id TEXT NOT NULL PRIMARY KEY,
+	body BLOB,
+	lastmodified INTEGER
+)`
+
+// sqlite_live_items selects the keys in the cache_items table that haven't been deleted. A row with
+// a NULL body is a tombstone: it hides the row in the geojson table with the same key, which is never
+// changed, after the item has been deleted (see Delete).
+
+const sqlite_live_items string = `SELECT id FROM cache_items WHERE body IS NOT NULL`
+
+// sqlite_live_geojson selects the keys in the geojson table that aren't also in the cache_items
+// table, either because they have been written back to it or because they have been deleted
+
+const sqlite_live_geojson string = `SELECT id FROM (SELECT ` + sqlite_geojson_key + ` FROM geojson) WHERE id NOT IN (SELECT id FROM cache_items)`
+
+// sqlite_geojson_key is the key that each row in the geojson table is read by Get as: its ID for default
+// geometries and {ID}-alt-{LABEL} for alternate geometries (see alt.Key). IDs are cast to text because
+// they are integers in databases produced by go-whosonfirst-sqlite-features and would otherwise never
+// be equal to the same key in the cache_items table.
+
+const sqlite_geojson_key string = `(CASE WHEN alt_label IS NULL OR alt_label = '' THEN CAST(id AS TEXT) ELSE id || '-alt-' || alt_label END) AS id`
+
+// SQLiteCache is a cache.Cache backed by a SQLite database. Items are written to the cache_items table,
+// using the binary encoding in encoding.go, but can also be read from the geojson table in databases
+// produced by the go-whosonfirst-sqlite-features package. The geojson table is only ever read from.
+
 type SQLiteCache struct {
 	Cache
 	Logger    *log.WOFLogger
+	Options   *SQLiteCacheOptions
 	database  *database.SQLiteDatabase
 	hits      int64
 	misses    int64
 	evictions int64
 }
 
+type SQLiteCacheOptions struct {
+	// how items are encoded when they are written to the cache_items table
+	Encoding *ItemEncodingOptions
+}
+
+func DefaultSQLiteCacheOptions() (*SQLiteCacheOptions, error) {
+
+	enc_opts, err := DefaultItemEncodingOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := SQLiteCacheOptions{
+		Encoding: enc_opts,
+	}
+
+	return &opts, nil
+}
+
 func NewSQLiteCache(db *database.SQLiteDatabase) (Cache, error) {
 
+	opts, err := DefaultSQLiteCacheOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSQLiteCacheWithOptions(db, opts)
+}
+
+func NewSQLiteCacheWithOptions(db *database.SQLiteDatabase, opts *SQLiteCacheOptions) (Cache, error) {
+
 	logger := log.SimpleWOFLogger("sqlite")
 
 	ctx := context.Background()
@@ -35,8 +95,21 @@ func NewSQLiteCache(db *database.SQLiteDatabase) (Cache, error) {
 		return nil, err
 	}
 
+	conn, err := db.Conn()
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Exec(sqlite_items_schema)
+
+	if err != nil {
+		return nil, err
+	}
+
 	lc := SQLiteCache{
 		Logger:    logger,
+		Options:   opts,
 		database:  db,
 		hits:      int64(0),
 		misses:    int64(0),
@@ -50,6 +123,9 @@ func (c *SQLiteCache) Close() error {
 	return c.database.Close()
 }
 
+// Get returns the item for 'key' from the cache_items table or, failing that (and unless it has been
+// deleted), the geojson table
+
 func (c *SQLiteCache) Get(key string) (CacheItem, error) {
 
 	db := c.database
@@ -60,8 +136,35 @@ func (c *SQLiteCache) Get(key string) (CacheItem, error) {
 		return nil, err
 	}
 
-	q := "SELECT body FROM geojson WHERE id = ?"
-	row := conn.QueryRow(q, key)
+	row := conn.QueryRow("SELECT body FROM cache_items WHERE id = ?", key)
+
+	var enc_body []byte
+	err = row.Scan(&enc_body)
+
+	switch {
+	case err == sql.ErrNoRows:
+		// pass
+	case err != nil:
+		return nil, err
+	case enc_body == nil:
+		atomic.AddInt64(&c.misses, 1)
+		return nil, errors.New("CACHE MISS")
+	default:
+
+		fc, err := DecodeCacheItem(enc_body)
+
+		if err != nil {
+			return nil, err
+		}
+
+		atomic.AddInt64(&c.hits, 1)
+		return fc, nil
+	}
+
+	where, args := sqliteWhere(key)
+
+	q := "SELECT body FROM geojson WHERE " + where
+	row = conn.QueryRow(q, args...)
 
 	var body string
 	err = row.Scan(&body)
@@ -92,20 +195,18 @@ func (c *SQLiteCache) Get(key string) (CacheItem, error) {
 	return fc, nil
 }
 
+// Set writes 'item' to the cache_items table. The geojson table is left alone since, if there is one,
+// it belongs to whatever produced the database (and is what the extras code reads from).
+
 func (c *SQLiteCache) Set(key string, item CacheItem) error {
 
-	// PLEASE RECONCILE THIS CODE WITH
-	// go-whosonfirst-sqlite-features/tables/geojson.go
+	body, err := EncodeCacheItem(item, c.Options.Encoding)
 
-	// what that means in practical terms is we need to write
-	// something that takes a cache item and implements all of
-	// the geojson.Feature interface and write now we're just
-	// making something that sort of looks like it...
-	// (20180224/thisisaaronland)
+	if err != nil {
+		return err
+	}
 
-	// this is more complicated that it seems (or should be) so
-	// see notes in cmd/wof-pip-server.go in the 'if *enable_extras'
-	// section (20180228/thisisaaronland)
+	lastmod := item.SPR().LastModified()
 
 	db := c.database
 
@@ -115,26 +216,45 @@ func (c *SQLiteCache) Set(key string, item CacheItem) error {
 		return err
 	}
 
-	s := item.SPR()
-	g := item.Geometry()
+	tx, err := conn.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	q := "INSERT OR REPLACE INTO cache_items (id, body, lastmodified) VALUES (?, ?, ?)"
 
-	str_id := s.Id()
-	lastmod := s.LastModified()
+	stmt, err := tx.Prepare(q)
 
-	type Feature struct {
-		Geometry   pip.GeoJSONGeometry      `json:"geometry"`
-		Properties spr.StandardPlacesResult `json:"properties"`
+	if err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	f := Feature{
-		Geometry:   g,
-		Properties: s,
+	defer stmt.Close()
+
+	_, err = stmt.Exec(key, body, lastmod)
+
+	if err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	body, err := json.Marshal(f)
+	return tx.Commit()
+}
+
+// Delete removes 'key' from the cache_items table. If there is also a row for 'key' in the geojson table,
+// which is left alone for the same reasons as in Set, a tombstone is written in its place so that it
+// isn't returned by Get, Has, Keys or Iterate any more. Setting 'key' again replaces the tombstone.
+
+func (c *SQLiteCache) Delete(key string) error {
+
+	db := c.database
+
+	conn, err := db.Conn()
 
 	if err != nil {
-		return nil
+		return err
 	}
 
 	tx, err := conn.Begin()
@@ -143,27 +263,201 @@ func (c *SQLiteCache) Set(key string, item CacheItem) error {
 		return err
 	}
 
-	q := "INSERT OR REPLACE INTO geojson (id, body, lastmodified) VALUES (?, ?, ?)"
-
-	stmt, err := tx.Prepare(q)
+	_, err = tx.Exec("DELETE FROM cache_items WHERE id = ?", key)
 
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	defer stmt.Close()
+	where, args := sqliteWhere(key)
 
-	str_body := string(body)
+	row := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM geojson WHERE "+where+")", args...)
 
-	_, err = stmt.Exec(str_id, str_body, lastmod)
+	var exists bool
+	err = row.Scan(&exists)
 
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
+	if exists {
+
+		_, err = tx.Exec("INSERT INTO cache_items (id, body, lastmodified) VALUES (?, NULL, ?)", key, time.Now().Unix())
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
 
+// Has reports whether there is a row for 'key' in either the cache_items or the geojson table, that
+// hasn't been deleted
+
+func (c *SQLiteCache) Has(key string) (bool, error) {
+
+	db := c.database
+
+	conn, err := db.Conn()
+
+	if err != nil {
+		return false, err
+	}
+
+	where, args := sqliteWhere(key)
+
+	q := "SELECT EXISTS(SELECT 1 FROM cache_items WHERE id = ? AND body IS NOT NULL) OR (NOT EXISTS(SELECT 1 FROM cache_items WHERE id = ?) AND EXISTS(SELECT 1 FROM geojson WHERE " + where + "))"
+
+	args = append([]interface{}{key, key}, args...)
+	row := conn.QueryRow(q, args...)
+
+	var exists bool
+	err = row.Scan(&exists)
+
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (c *SQLiteCache) Keys(ctx context.Context, cb KeysFunc) error {
+
+	db := c.database
+
+	conn, err := db.Conn()
+
+	if err != nil {
+		return err
+	}
+
+	q := sqlite_live_items + " UNION " + sqlite_live_geojson
+
+	rows, err := conn.QueryContext(ctx, q)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var key string
+		err := rows.Scan(&key)
+
+		if err != nil {
+			return err
+		}
+
+		err = cb(ctx, key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Iterate returns all the items in the cache_items table followed by any rows in the geojson table
+// that aren't also in the cache_items table (including as a tombstone)
+
+func (c *SQLiteCache) Iterate(ctx context.Context, cb IterateFunc) error {
+
+	db := c.database
+
+	conn, err := db.Conn()
+
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT id, body FROM cache_items WHERE body IS NOT NULL")
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var key string
+		var body []byte
+
+		err := rows.Scan(&key, &body)
+
+		if err != nil {
+			return err
+		}
+
+		fc, err := DecodeCacheItem(body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to decode %s, %s", key, err)
+		}
+
+		err = cb(ctx, key, fc)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return err
+	}
+
+	q := "SELECT id, body FROM (SELECT " + sqlite_geojson_key + ", body FROM geojson) WHERE id NOT IN (SELECT id FROM cache_items)"
+
+	geojson_rows, err := conn.QueryContext(ctx, q)
+
+	if err != nil {
+		return err
+	}
+
+	defer geojson_rows.Close()
+
+	for geojson_rows.Next() {
+
+		var key string
+		var body string
+
+		err := geojson_rows.Scan(&key, &body)
+
+		if err != nil {
+			return err
+		}
+
+		f, err := feature.LoadFeature([]byte(body))
+
+		if err != nil {
+			return fmt.Errorf("Failed to load %s, %s", key, err)
+		}
+
+		fc, err := NewFeatureCache(f)
+
+		if err != nil {
+			return err
+		}
+
+		err = cb(ctx, key, fc)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return geojson_rows.Err()
+}
+
 func (c *SQLiteCache) Size() int64 {
 
 	db := c.database
@@ -174,7 +468,10 @@ func (c *SQLiteCache) Size() int64 {
 		return -1
 	}
 
-	q := "SELECT COUNT(id) FROM geojson"
+	// items that are in both tables (because they were read from the geojson table and
+	// then written back by an index) are only counted once and deleted items aren't counted
+
+	q := "SELECT COUNT(id) FROM (" + sqlite_live_items + " UNION " + sqlite_live_geojson + ")"
 	row := conn.QueryRow(q)
 
 	var count int64
@@ -199,3 +496,18 @@ func (c *SQLiteCache) Misses() int64 {
 func (c *SQLiteCache) Evictions() int64 {
 	return atomic.LoadInt64(&c.evictions)
 }
+
+// sqliteWhere returns the WHERE clause, and its arguments, for the row(s) in the geojson table for 'key'.
+// Alternate geometries written by Set are stored under their alternate geometry key but the ones in
+// databases produced by the go-whosonfirst-sqlite-features package are stored by ID and label.
+
+func sqliteWhere(key string) (string, []interface{}) {
+
+	str_id, label, is_alt := alt.ParseKey(key)
+
+	if is_alt {
+		return "id = ? OR (id = ? AND alt_label = ?)", []interface{}{key, str_id, label}
+	}
+
+	return "id = ? AND (alt_label IS NULL OR alt_label = '')", []interface{}{key}
+}
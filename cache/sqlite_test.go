@@ -0,0 +1,178 @@
+package cache_test
+
+import (
+	"context"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"github.com/whosonfirst/go-whosonfirst-sqlite/database"
+	"path/filepath"
+	"testing"
+)
+
+// newTestSQLiteCache returns a SQLite cache, and its database, with a row for 'id' in the geojson
+// table the way go-whosonfirst-sqlite-features would write it
+
+func newTestSQLiteCache(t *testing.T, id int64, body string) (cache.Cache, *database.SQLiteDatabase) {
+
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cache.NewSQLiteCache(db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := db.Conn()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = conn.Exec("INSERT INTO geojson (id, body, source, is_alt, alt_label, lastmodified) VALUES (?, ?, ?, ?, ?, ?)", id, body, "test", false, "", 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, db
+}
+
+func geojsonRows(t *testing.T, db *database.SQLiteDatabase) []string {
+
+	conn, err := db.Conn()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := conn.Query("SELECT body FROM geojson ORDER BY id")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	bodies := make([]string, 0)
+
+	for rows.Next() {
+
+		var body string
+		err := rows.Scan(&body)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bodies = append(bodies, body)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bodies
+}
+
+// deleting an item hides it, but doesn't remove it from the geojson table, which belongs to whatever
+// produced the database
+
+func TestSQLiteCacheDeleteKeepsGeoJSON(t *testing.T) {
+
+	c, db := newTestSQLiteCache(t, 85632793, test_wof_feature)
+	defer c.Close()
+
+	key := "85632793"
+
+	ok, err := c.Has(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatal("Expected the cache to have the row in the geojson table")
+	}
+
+	item, err := c.Get(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Set(key, item)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Delete(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := geojsonRows(t, db)
+
+	if len(rows) != 1 || rows[0] != test_wof_feature {
+		t.Fatalf("Expected the geojson table to be unchanged, got %v", rows)
+	}
+
+	ok, err = c.Has(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok {
+		t.Fatal("Expected a deleted item not to be in the cache")
+	}
+
+	_, err = c.Get(key)
+
+	if err == nil {
+		t.Fatal("Expected a deleted item to be a cache miss")
+	}
+
+	if c.Size() != 0 {
+		t.Fatalf("Expected an empty cache, got %d items", c.Size())
+	}
+
+	if cacheKeys(t, c) != "" {
+		t.Fatalf("Expected no keys, got %s", cacheKeys(t, c))
+	}
+
+	count := 0
+
+	err = c.Iterate(context.Background(), func(ctx context.Context, key string, item cache.CacheItem) error {
+		count += 1
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Fatalf("Expected to iterate over no items, got %d", count)
+	}
+
+	// and setting it again replaces the tombstone
+
+	err = c.Set(key, item)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, _ = c.Has(key)
+
+	if !ok || c.Size() != 1 {
+		t.Fatalf("Expected an item that was set again to be in the cache (size %d)", c.Size())
+	}
+}
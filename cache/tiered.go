@@ -0,0 +1,263 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"sync"
+	"sync/atomic"
+)
+
+// TieredCache is a cache.Cache that chains a list of caches together, typically a bounded in-memory
+// cache (like LRUCache) over a persistent one (like SQLiteCache). Items are read from the first tier
+// that has them and then copied in to all the tiers above it (read-through) and are written to, or
+// deleted from, every tier (write-through).
+//
+// Hits and Misses count the items that were, or weren't, found in any tier and Evictions is the total
+// for all the tiers. Use the Tiers method to get the statistics for each tier.
+
+type TieredCache struct {
+	Cache
+	Logger  *log.WOFLogger
+	tiers   []Cache
+	stripes []*tieredStripe // keys are hashed in to stripes so that writes to different keys don't wait for each other
+	hits    int64
+	misses  int64
+}
+
+// tieredStripe serializes the writes for the keys that hash to it and counts them, see Get
+
+type tieredStripe struct {
+	mu     *sync.Mutex
+	writes int64 // the number of times Set or Delete has finished for a key in this stripe
+}
+
+// TIERED_STRIPES is the number of stripes a TieredCache divides its keys in to
+
+const TIERED_STRIPES int = 64
+
+func NewTieredCache(tiers ...Cache) (Cache, error) {
+
+	if len(tiers) < 2 {
+		return nil, errors.New("A tiered cache needs at least two tiers")
+	}
+
+	logger := log.SimpleWOFLogger("tiered")
+
+	stripes := make([]*tieredStripe, TIERED_STRIPES)
+
+	for i := range stripes {
+
+		stripes[i] = &tieredStripe{
+			mu:     new(sync.Mutex),
+			writes: int64(0),
+		}
+	}
+
+	c := TieredCache{
+		Logger:  logger,
+		tiers:   tiers,
+		stripes: stripes,
+		hits:    int64(0),
+		misses:  int64(0),
+	}
+
+	return &c, nil
+}
+
+// stripe returns the stripe for 'key'
+
+func (c *TieredCache) stripe(key string) *tieredStripe {
+
+	return c.stripes[keyStripe(key, len(c.stripes))]
+}
+
+// Tiers returns the caches that make up 'c', in order
+
+func (c *TieredCache) Tiers() []Cache {
+	return c.tiers
+}
+
+func (c *TieredCache) Close() error {
+
+	var first_err error
+
+	for _, t := range c.tiers {
+
+		err := t.Close()
+
+		if err != nil && first_err == nil {
+			first_err = err
+		}
+	}
+
+	return first_err
+}
+
+func (c *TieredCache) Get(key string) (CacheItem, error) {
+
+	c.Logger.Info("GET %s", key)
+
+	// this is the number of writes to the key's stripe that have finished before we
+	// start looking, see fill
+
+	s := c.stripe(key)
+	writes := atomic.LoadInt64(&s.writes)
+
+	var err error
+
+	for i, t := range c.tiers {
+
+		var item CacheItem
+		item, err = t.Get(key)
+
+		if err != nil {
+			continue
+		}
+
+		atomic.AddInt64(&c.hits, 1)
+
+		if i > 0 {
+			c.fill(key, item, i, s, writes)
+		}
+
+		return item, nil
+	}
+
+	atomic.AddInt64(&c.misses, 1)
+	return nil, err
+}
+
+// Set writes 'item' to every tier starting with the last (persistent) one so that the tiers above
+// it never have an item that it doesn't. Only writes to keys in the same stripe wait for each other.
+
+func (c *TieredCache) Set(key string, item CacheItem) error {
+
+	c.Logger.Info("SET %s", key)
+
+	s := c.stripe(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the count is only bumped once every tier has been written to (or we have
+	// given up) so that a Get that started before then never fills the tiers
+	// above with what it read, see fill
+
+	defer atomic.AddInt64(&s.writes, 1)
+
+	for i := len(c.tiers) - 1; i >= 0; i-- {
+
+		err := c.tiers[i].Set(key, item)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes 'key' from every tier starting with the first one
+
+func (c *TieredCache) Delete(key string) error {
+
+	c.Logger.Info("DELETE %s", key)
+
+	s := c.stripe(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	defer atomic.AddInt64(&s.writes, 1)
+
+	for _, t := range c.tiers {
+
+		err := t.Delete(key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fill copies 'item', which was found in tier 'tier', to all the tiers above it unless anything in the
+// key's stripe has been written since 'writes' in which case 'item' may already be out of date and the
+// tiers above will be filled on the next Get instead. It holds the stripe's lock so that a write can't
+// start half way through.
+
+func (c *TieredCache) fill(key string, item CacheItem, tier int, s *tieredStripe, writes int64) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if atomic.LoadInt64(&s.writes) != writes {
+		return
+	}
+
+	for i := tier - 1; i >= 0; i-- {
+
+		err := c.tiers[i].Set(key, item)
+
+		if err != nil {
+			c.Logger.Warning("Failed to copy %s to tier %d, because %s", key, i, err)
+		}
+	}
+}
+
+// Has reports whether any of the tiers have 'key'
+
+func (c *TieredCache) Has(key string) (bool, error) {
+
+	for _, t := range c.tiers {
+
+		ok, err := t.Has(key)
+
+		if err != nil {
+			return false, err
+		}
+
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Keys and Iterate use the last tier since it has every item
+
+func (c *TieredCache) Keys(ctx context.Context, cb KeysFunc) error {
+	return c.tiers[len(c.tiers)-1].Keys(ctx, cb)
+}
+
+func (c *TieredCache) Iterate(ctx context.Context, cb IterateFunc) error {
+	return c.tiers[len(c.tiers)-1].Iterate(ctx, cb)
+}
+
+// Size returns the size of the last tier since it has every item
+
+func (c *TieredCache) Size() int64 {
+	return c.tiers[len(c.tiers)-1].Size()
+}
+
+func (c *TieredCache) Hits() int64 {
+	return atomic.LoadInt64(&c.hits)
+}
+
+func (c *TieredCache) Misses() int64 {
+	return atomic.LoadInt64(&c.misses)
+}
+
+func (c *TieredCache) Evictions() int64 {
+
+	evictions := int64(0)
+
+	for _, t := range c.tiers {
+		evictions += t.Evictions()
+	}
+
+	return evictions
+}
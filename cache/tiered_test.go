@@ -0,0 +1,327 @@
+package cache_test
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"hash/fnv"
+	"testing"
+	"time"
+)
+
+// hookCache wraps a cache.Cache so that tests can stop Get (after it has read an item) or Set
+// (before or after it has written one) half way through
+
+type hookCache struct {
+	cache.Cache
+	afterGet  func(string)
+	beforeSet func(string)
+	afterSet  func(string)
+}
+
+func (c *hookCache) Get(key string) (cache.CacheItem, error) {
+
+	item, err := c.Cache.Get(key)
+
+	if c.afterGet != nil {
+		c.afterGet(key)
+	}
+
+	return item, err
+}
+
+func (c *hookCache) Set(key string, item cache.CacheItem) error {
+
+	if c.beforeSet != nil {
+		c.beforeSet(key)
+	}
+
+	err := c.Cache.Set(key, item)
+
+	if c.afterSet != nil {
+		c.afterSet(key)
+	}
+
+	return err
+}
+
+func newTestGoCache(t *testing.T) cache.Cache {
+
+	opts, err := cache.DefaultGoCacheOptions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cache.NewGoCache(opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func newTestTieredCache(t *testing.T, top cache.Cache, bottom cache.Cache) cache.Cache {
+
+	c, err := cache.NewTieredCache(top, bottom)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// wait fails 't' if 'done' isn't closed within a few seconds
+
+func wait(t *testing.T, label string, done chan bool) {
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %s", label)
+	}
+}
+
+// sameStripe reports whether 'a' and 'b' hash to the same stripe in a TieredCache
+
+func sameStripe(a string, b string) bool {
+
+	stripe := func(key string) uint32 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return h.Sum32() % uint32(cache.TIERED_STRIPES)
+	}
+
+	return stripe(a) == stripe(b)
+}
+
+func TestTieredCacheReadThrough(t *testing.T) {
+
+	item := testCacheItems(t)["wof"]
+
+	top := newTestGoCache(t)
+	bottom := newTestGoCache(t)
+
+	c := newTestTieredCache(t, top, bottom)
+
+	err := bottom.Set("a", item)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Get("a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, _ := top.Has("a")
+
+	if !ok {
+		t.Fatal("Expected the item to be copied to the top tier")
+	}
+
+	err = c.Delete("a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tier := range []cache.Cache{top, bottom} {
+
+		ok, _ := tier.Has("a")
+
+		if ok {
+			t.Fatalf("Expected the item to be deleted from tier %d", i)
+		}
+	}
+
+	if c.Hits() != 1 {
+		t.Fatalf("Expected 1 hit, got %d", c.Hits())
+	}
+}
+
+// a slow write to one key shouldn't hold up reads and writes for other keys
+
+func TestTieredCacheWriteDoesNotBlockOtherKeys(t *testing.T) {
+
+	item := testCacheItems(t)["wof"]
+
+	blocked := make(chan bool)
+	release := make(chan bool)
+
+	bottom := &hookCache{
+		Cache: newTestGoCache(t),
+		beforeSet: func(key string) {
+
+			if key == "a" {
+				close(blocked)
+				<-release
+			}
+		},
+	}
+
+	c := newTestTieredCache(t, newTestGoCache(t), bottom)
+
+	set_a := make(chan bool)
+
+	go func() {
+		c.Set("a", item)
+		close(set_a)
+	}()
+
+	wait(t, "the write to 'a' to start", blocked)
+
+	key := "b"
+
+	for sameStripe("a", key) {
+		key = string(key[0] + 1)
+	}
+
+	done := make(chan bool)
+
+	go func() {
+
+		err := c.Set(key, item)
+
+		if err == nil {
+			_, err = c.Get(key)
+		}
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		close(done)
+	}()
+
+	wait(t, "a write to '"+key+"' while 'a' is being written", done)
+
+	close(release)
+	wait(t, "the write to 'a' to finish", set_a)
+}
+
+// an item read from a lower tier before a Set finishes mustn't replace the new item in the tiers above
+
+func TestTieredCacheFillDuringSet(t *testing.T) {
+
+	items := testCacheItems(t)
+
+	old_item := items["wof"]
+	new_item := items["alt"]
+
+	top := newTestGoCache(t)
+
+	set_blocked := make(chan bool)
+	set_release := make(chan bool)
+
+	read := make(chan bool)
+
+	bottom := &hookCache{
+		Cache: newTestGoCache(t),
+		beforeSet: func(key string) {
+			close(set_blocked)
+			<-set_release
+		},
+		afterGet: func(key string) {
+			close(read)
+		},
+	}
+
+	err := bottom.Cache.Set("a", old_item)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestTieredCache(t, top, bottom)
+
+	set_done := make(chan bool)
+
+	go func() {
+		c.Set("a", new_item)
+		close(set_done)
+	}()
+
+	wait(t, "the write to start", set_blocked)
+
+	get_done := make(chan bool)
+
+	go func() {
+		c.Get("a")
+		close(get_done)
+	}()
+
+	wait(t, "the old item to be read", read)
+
+	close(set_release)
+
+	wait(t, "the write to finish", set_done)
+	wait(t, "the read to finish", get_done)
+
+	cached, err := top.Get("a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cached.SPR().Id() != new_item.SPR().Id() {
+		t.Fatalf("Expected the top tier to have %s, got %s", new_item.SPR().Id(), cached.SPR().Id())
+	}
+}
+
+// an item read from a lower tier mustn't be copied to the tiers above after it has been deleted
+
+func TestTieredCacheFillAfterDelete(t *testing.T) {
+
+	item := testCacheItems(t)["wof"]
+
+	top := newTestGoCache(t)
+
+	read := make(chan bool)
+	release := make(chan bool)
+
+	bottom := &hookCache{
+		Cache: newTestGoCache(t),
+		afterGet: func(key string) {
+			close(read)
+			<-release
+		},
+	}
+
+	err := bottom.Cache.Set("a", item)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestTieredCache(t, top, bottom)
+
+	get_done := make(chan bool)
+
+	go func() {
+		c.Get("a")
+		close(get_done)
+	}()
+
+	wait(t, "the item to be read", read)
+
+	err = c.Delete("a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	wait(t, "the read to finish", get_done)
+
+	for i, tier := range []cache.Cache{top, bottom.Cache} {
+
+		ok, _ := tier.Has("a")
+
+		if ok {
+			t.Fatalf("Expected tier %d not to have a deleted item", i)
+		}
+	}
+}
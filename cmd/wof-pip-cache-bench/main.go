@@ -0,0 +1,431 @@
+package main
+
+// wof-pip-cache-bench writes the same set of items to each of the persistent caches (kv, sqlite and fs)
+// and then reads them back in the same (random) order, reporting how long each operation took on
+// average and how much disk space each cache used. the items are either the features in the paths
+// passed on the command line or, if there aren't any, a synthetic set of polygons.
+
+import (
+	"context"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	wof_index "github.com/whosonfirst/go-whosonfirst-index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/conformance"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/flags"
+	"github.com/whosonfirst/go-whosonfirst-sqlite/database"
+	"github.com/whosonfirst/warning"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type item struct {
+	key  string
+	item cache.CacheItem
+}
+
+type result struct {
+	name    string
+	set     time.Duration
+	reopen  time.Duration
+	get     time.Duration
+	misses  int
+	disk    int64
+	entries int64
+}
+
+// newCacheFunc returns a function that opens (or reopens) the cache called 'name' in the directory 'root'
+
+func newCacheFunc(name string, root string, enc_opts *cache.ItemEncodingOptions) (func() (cache.Cache, error), error) {
+
+	switch name {
+
+	case "kv":
+
+		return func() (cache.Cache, error) {
+
+			opts, err := cache.DefaultKVCacheOptions()
+
+			if err != nil {
+				return nil, err
+			}
+
+			opts.Encoding = enc_opts
+
+			return cache.NewKVCacheWithOptions(root, opts)
+		}, nil
+
+	case "sqlite":
+
+		err := os.MkdirAll(root, 0755)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return func() (cache.Cache, error) {
+
+			db, err := database.NewDB(filepath.Join(root, "cache.db"))
+
+			if err != nil {
+				return nil, err
+			}
+
+			opts, err := cache.DefaultSQLiteCacheOptions()
+
+			if err != nil {
+				return nil, err
+			}
+
+			opts.Encoding = enc_opts
+
+			return cache.NewSQLiteCacheWithOptions(db, opts)
+		}, nil
+
+	case "fs":
+
+		err := os.MkdirAll(root, 0755)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return func() (cache.Cache, error) {
+
+			opts, err := cache.DefaultFSCacheOptions()
+
+			if err != nil {
+				return nil, err
+			}
+
+			opts.ItemsRoot = filepath.Join(root, "items")
+			opts.Encoding = enc_opts
+
+			return cache.NewFSCacheWithOptions(root, opts)
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("Invalid cache '%s'", name)
+	}
+}
+
+// loadItems returns the features in 'paths', read using the go-whosonfirst-index 'mode', as cache items
+
+func loadItems(mode string, paths []string) ([]*item, error) {
+
+	items := make([]*item, 0)
+	mu := new(sync.Mutex)
+
+	cb := func(ctx context.Context, fh io.Reader, args ...interface{}) error {
+
+		f, err := feature.LoadWOFFeatureFromReader(fh)
+
+		if err != nil && !warning.IsWarning(err) {
+			return err
+		}
+
+		i, err := cache.NewFeatureCache(f)
+
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		items = append(items, &item{key: f.Id(), item: i})
+		mu.Unlock()
+
+		return nil
+	}
+
+	idx, err := wof_index.NewIndexer(mode, cb)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = idx.IndexPaths(paths)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// syntheticItems returns 'count' cache items whose geometries are (roughly) circular polygons with
+// 'vertices' vertices scattered around the world
+
+func syntheticItems(count int, vertices int, rnd *rand.Rand) ([]*item, error) {
+
+	items := make([]*item, count)
+
+	for n := 0; n < count; n++ {
+
+		lon := rnd.Float64()*340.0 - 170.0
+		lat := rnd.Float64()*160.0 - 80.0
+		radius := 0.01 + rnd.Float64()*2.0
+
+		ring := make([][]float64, vertices+1)
+
+		for v := 0; v < vertices; v++ {
+
+			a := 2.0 * math.Pi * float64(v) / float64(vertices)
+			r := radius * (0.8 + rnd.Float64()*0.2)
+
+			ring[v] = []float64{lon + r*math.Cos(a), lat + r*math.Sin(a)}
+		}
+
+		ring[vertices] = ring[0]
+
+		fx := conformance.Fixture{
+			Id:        int64(n + 1),
+			Name:      fmt.Sprintf("Synthetic %d", n+1),
+			Placetype: "locality",
+			Ring:      ring,
+		}
+
+		f, err := fx.NewFeature()
+
+		if err != nil {
+			return nil, err
+		}
+
+		i, err := cache.NewFeatureCache(f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		items[n] = &item{key: f.Id(), item: i}
+	}
+
+	return items, nil
+}
+
+func diskUsage(root string) (int64, error) {
+
+	size := int64(0)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return size, err
+}
+
+// run writes 'items' to a new cache, closes and reopens it and then reads 'order' back from it
+
+func run(name string, new_cache func() (cache.Cache, error), root string, items []*item, order []int) (*result, error) {
+
+	c, err := new_cache()
+
+	if err != nil {
+		return nil, err
+	}
+
+	t1 := time.Now()
+
+	for _, i := range items {
+
+		err := c.Set(i.key, i.item)
+
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("Failed to set %s, %s", i.key, err)
+		}
+	}
+
+	set := time.Since(t1)
+
+	err = c.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	t2 := time.Now()
+
+	c, err = new_cache()
+
+	if err != nil {
+		return nil, err
+	}
+
+	reopen := time.Since(t2)
+
+	defer c.Close()
+
+	misses := 0
+
+	t3 := time.Now()
+
+	for _, idx := range order {
+
+		_, err := c.Get(items[idx].key)
+
+		if err != nil {
+			misses += 1
+		}
+	}
+
+	get := time.Since(t3)
+
+	disk, err := diskUsage(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := result{
+		name:    name,
+		set:     set,
+		reopen:  reopen,
+		get:     get,
+		misses:  misses,
+		disk:    disk,
+		entries: c.Size(),
+	}
+
+	return &r, nil
+}
+
+func perOp(d time.Duration, count int) string {
+
+	if count == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d", d.Nanoseconds()/int64(count))
+}
+
+func main() {
+
+	fs := flags.NewFlagSet("bench")
+
+	mode := fs.String("mode", "repo", "The go-whosonfirst-index mode used to read the paths passed on the command line.")
+	caches := fs.String("caches", "kv,sqlite,fs", "A comma-separated list of the caches to benchmark. Valid options are: fs, kv, sqlite.")
+	synthetic := fs.Int("synthetic", 10000, "The number of synthetic items to benchmark if no paths are passed on the command line.")
+	vertices := fs.Int("vertices", 200, "The number of vertices in each synthetic item's polygon.")
+	gets := fs.Int("gets", 0, "The number of (random) items to read back from each cache. If 0 it's the same as the number of items.")
+	seed := fs.Int64("seed", 1, "The seed for the random numbers used to create synthetic items and to pick the items to read back.")
+	compress := fs.Bool("cache-compress", false, "Compress the items written to each cache.")
+	coords := fs.String("cache-coords", cache.COORDINATES_FLOAT64, "How to encode the coordinates of the items written to each cache. Valid options are: float64, float32, delta.")
+	root := fs.String("root", "", "The directory to create the caches in. If empty a temporary directory is used and removed when the benchmarks are done.")
+
+	flags.Parse(fs)
+
+	rnd := rand.New(rand.NewSource(*seed))
+
+	var items []*item
+	var err error
+
+	paths := fs.Args()
+
+	if len(paths) > 0 {
+		items, err = loadItems(*mode, paths)
+	} else {
+		items, err = syntheticItems(*synthetic, *vertices, rnd)
+	}
+
+	if err != nil {
+		log.Fatal("Failed to load items, because ", err)
+	}
+
+	if len(items) == 0 {
+		log.Fatal("Nothing to benchmark")
+	}
+
+	count := *gets
+
+	if count == 0 {
+		count = len(items)
+	}
+
+	order := make([]int, count)
+
+	for n := 0; n < count; n++ {
+		order[n] = rnd.Intn(len(items))
+	}
+
+	enc_opts, err := cache.DefaultItemEncodingOptions()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	enc_opts.Compress = *compress
+	enc_opts.Coordinates = *coords
+
+	bench_root := *root
+
+	if bench_root == "" {
+
+		tmp, err := ioutil.TempDir("", "wof-pip-cache-bench")
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer os.RemoveAll(tmp)
+		bench_root = tmp
+	}
+
+	log.Printf("benchmarking %d items, %d reads, compress is %t coords are %s\n", len(items), count, *compress, *coords)
+
+	results := make([]*result, 0)
+
+	for _, name := range strings.Split(*caches, ",") {
+
+		name = strings.TrimSpace(name)
+		cache_root := filepath.Join(bench_root, name)
+
+		new_cache, err := newCacheFunc(name, cache_root, enc_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		r, err := run(name, new_cache, cache_root, items, order)
+
+		if err != nil {
+			log.Fatal(fmt.Sprintf("Failed to benchmark %s cache, because %s", name, err))
+		}
+
+		results = append(results, r)
+	}
+
+	wr := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Fprintln(wr, "cache\titems\tset ns/op\treopen ms\tget ns/op\tmisses\tdisk bytes\tbytes/item\t")
+
+	for _, r := range results {
+
+		bytes_per_item := int64(0)
+
+		if r.entries > 0 {
+			bytes_per_item = r.disk / r.entries
+		}
+
+		fmt.Fprintf(wr, "%s\t%d\t%s\t%d\t%s\t%d\t%d\t%d\t\n", r.name, r.entries, perOp(r.set, len(items)), r.reopen.Milliseconds(), perOp(r.get, count), r.misses, r.disk, bytes_per_item)
+	}
+
+	wr.Flush()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/app"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/conformance"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/flags"
+	log "log"
+	"os"
+)
+
+func main() {
+
+	fl, err := flags.CommonFlags()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	flags.Parse(fl)
+
+	err = flags.ValidateCommonFlags(fl)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pip_index, _ := flags.StringVar(fl, "index")
+	pip_cache, _ := flags.StringVar(fl, "cache")
+
+	appcache, err := app.NewApplicationCache(fl)
+
+	if err != nil {
+		log.Fatal("Failed to create cache, because ", err)
+	}
+
+	defer appcache.Close()
+
+	appindex, err := app.NewApplicationIndex(fl, appcache)
+
+	if err != nil {
+		log.Fatal("Failed to create index, because ", err)
+	}
+
+	defer appindex.Close()
+
+	failures, err := conformance.Run(context.Background(), appindex)
+
+	if err != nil {
+		log.Fatal("Failed to run conformance tests, because ", err)
+	}
+
+	for _, f := range failures {
+		fmt.Printf("FAIL %s\n", f)
+	}
+
+	if len(failures) > 0 {
+		os.Exit(1)
+	}
+
+	fmt.Printf("PASS index is %s cache is %s\n", pip_index, pip_cache)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/whosonfirst/go-http-mapzenjs"
 	"github.com/whosonfirst/go-http-rewrite"
@@ -10,8 +12,11 @@ import (
 	"log"
 	gohttp "net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -57,6 +62,27 @@ func main() {
 		pip.Logger.Fatal("Failed to index paths, because %s", err)
 	}
 
+	mux, err := newServeMux(fs, pip)
+
+	if err != nil {
+		pip.Logger.Fatal("Failed to set up handlers, because %s", err)
+	}
+
+	// requests are handed off to the current index "generation" which can be rebuilt in the
+	// background, by sending the server a SIGHUP signal or POST-ing to the /reindex endpoint,
+	// and then swapped in without interrupting any requests that are still being served by
+	// the previous generation
+
+	swap_handler := http.NewSwappableHandler(mux, pip.Close)
+
+	reindex_mu := new(sync.Mutex)
+	reindexing := false
+
+	// the application for the generation that new requests are handed off to, which is
+	// where the cache stats are read from
+
+	current := pip
+
 	go func() {
 
 		tick := time.Tick(1 * time.Minute)
@@ -65,10 +91,156 @@ func main() {
 			var ms runtime.MemStats
 			runtime.ReadMemStats(&ms)
 			pip.Logger.Status("memstats system: %8d inuse: %8d released: %8d objects: %6d", ms.HeapSys, ms.HeapInuse, ms.HeapReleased, ms.HeapObjects)
+
+			reindex_mu.Lock()
+			p := current
+			reindex_mu.Unlock()
+
+			p.LogCacheStats()
 		}
 	}()
 
-	// set up the HTTP endpoint
+	in_memory, err := flags.IsInMemoryCache(fs)
+
+	if err != nil {
+		pip.Logger.Fatal("failed to determine cache type because %s", err)
+	}
+
+	reindex := func() error {
+
+		if mode == "spatialite" {
+			return errors.New("Reindexing is not supported in spatialite mode")
+		}
+
+		if !in_memory {
+			return errors.New("Reindexing is only supported for in-memory caches")
+		}
+
+		reindex_mu.Lock()
+		defer reindex_mu.Unlock()
+
+		if reindexing {
+			return errors.New("Already reindexing")
+		}
+
+		// the next generation and its handlers are created before returning so that if
+		// either can't be created the error is reported to the caller and the current
+		// generation carries on serving requests
+
+		next, err := app.NewPIPApplication(fs)
+
+		if err != nil {
+			pip.Logger.Error("failed to create new PIP application for reindexing because %s", err)
+			return err
+		}
+
+		next_mux, err := newServeMux(fs, next)
+
+		if err != nil {
+			pip.Logger.Error("failed to set up handlers for reindexing because %s", err)
+			next.Close()
+			return err
+		}
+
+		reindexing = true
+
+		go func() {
+
+			defer func() {
+				reindex_mu.Lock()
+				reindexing = false
+				reindex_mu.Unlock()
+			}()
+
+			pip.Logger.Status("reindexing paths")
+
+			err := next.IndexPathsSync(fs.Args())
+
+			if err != nil {
+				pip.Logger.Error("failed to reindex paths because %s", err)
+				next.Close()
+				return
+			}
+
+			reindex_mu.Lock()
+			generation := swap_handler.Swap(next_mux, next.Close)
+			current = next
+			reindex_mu.Unlock()
+
+			pip.Logger.Status("finished reindexing, requests are now served by index generation %d", generation)
+		}()
+
+		return nil
+	}
+
+	hup_ch := make(chan os.Signal, 1)
+	signal.Notify(hup_ch, syscall.SIGHUP)
+
+	go func() {
+
+		for _ = range hup_ch {
+
+			err := reindex()
+
+			if err != nil {
+				pip.Logger.Warning("failed to start reindexing because %s", err)
+			}
+		}
+	}()
+
+	var handler gohttp.Handler
+	handler = swap_handler
+
+	// the context passed to index queries (by way of req.Context()) is cancelled
+	// when the timeout is reached so long-running queries stop early rather than
+	// chugging along on behalf of a client that's already received an error
+
+	request_timeout, _ := flags.IntVar(fs, "request-timeout")
+
+	if request_timeout > 0 {
+
+		timeout := time.Duration(request_timeout) * time.Second
+		handler = gohttp.TimeoutHandler(handler, timeout, "request timed out")
+	}
+
+	enable_reindex, _ := flags.BoolVar(fs, "enable-reindex")
+
+	if enable_reindex {
+
+		pip.Logger.Debug("setting up reindex handler")
+
+		reindex_handler, err := http.ReindexHandler(reindex)
+
+		if err != nil {
+			pip.Logger.Fatal("failed to create reindex handler because %s", err)
+		}
+
+		admin_mux := gohttp.NewServeMux()
+		admin_mux.Handle("/reindex", reindex_handler)
+		admin_mux.Handle("/", handler)
+
+		handler = admin_mux
+	}
+
+	host, _ := flags.StringVar(fs, "host")
+	port, _ := flags.IntVar(fs, "port")
+
+	endpoint := fmt.Sprintf("%s:%d", host, port)
+	pip.Logger.Status("listening for requests on %s", endpoint)
+
+	err = gohttp.ListenAndServe(endpoint, handler)
+
+	if err != nil {
+		pip.Logger.Fatal("failed to start server because %s", err)
+	}
+
+	os.Exit(0)
+}
+
+// newServeMux returns a new ServeMux with all the handlers enabled by 'fs' bound to the
+// index (and indexer) of 'pip' or an error if any of the handlers can't be created
+
+func newServeMux(fs *flag.FlagSet, pip *app.PIPApplication) (*gohttp.ServeMux, error) {
 
 	pip.Logger.Debug("setting up intersects handler")
 
@@ -80,13 +252,13 @@ func main() {
 	intersects_handler, err := http.IntersectsHandler(pip.Index, pip.Indexer, pip.Extras, intersects_opts)
 
 	if err != nil {
-		pip.Logger.Fatal("failed to create PIP handler because %s", err)
+		return nil, fmt.Errorf("Failed to create PIP handler, because %s", err)
 	}
 
 	ping_handler, err := http.PingHandler()
 
 	if err != nil {
-		pip.Logger.Fatal("failed to create Ping handler because %s", err)
+		return nil, fmt.Errorf("Failed to create Ping handler, because %s", err)
 	}
 
 	mux := gohttp.NewServeMux()
@@ -97,6 +269,9 @@ func main() {
 	enable_www, _ := flags.BoolVar(fs, "enable-www")
 	enable_candidates, _ := flags.BoolVar(fs, "enable-candidates")
 	enable_polylines, _ := flags.BoolVar(fs, "enable-polylines")
+	enable_bbox, _ := flags.BoolVar(fs, "enable-bbox")
+	enable_geometry, _ := flags.BoolVar(fs, "enable-geometry")
+	enable_nearby, _ := flags.BoolVar(fs, "enable-nearby")
 
 	if enable_candidates {
 
@@ -105,7 +280,7 @@ func main() {
 		candidateshandler, err := http.CandidatesHandler(pip.Index, pip.Indexer)
 
 		if err != nil {
-			pip.Logger.Fatal("failed to create Spatial handler because %s", err)
+			return nil, fmt.Errorf("Failed to create Spatial handler, because %s", err)
 		}
 
 		mux.Handle("/candidates", candidateshandler)
@@ -124,12 +299,63 @@ func main() {
 		poly_handler, err := http.PolylineHandler(pip.Index, pip.Indexer, poly_opts)
 
 		if err != nil {
-			pip.Logger.Fatal("failed to create polyline handler because %s", err)
+			return nil, fmt.Errorf("Failed to create polyline handler, because %s", err)
 		}
 
 		mux.Handle("/polyline", poly_handler)
 	}
 
+	if enable_bbox {
+
+		pip.Logger.Debug("setting up bounding box handler")
+
+		bbox_opts := http.NewDefaultBoundingBoxHandlerOptions()
+		bbox_opts.EnableGeoJSON = enable_geojson
+
+		bbox_handler, err := http.BoundingBoxHandler(pip.Index, pip.Indexer, bbox_opts)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create bounding box handler, because %s", err)
+		}
+
+		mux.Handle("/bbox", bbox_handler)
+	}
+
+	if enable_geometry {
+
+		pip.Logger.Debug("setting up geometry handler")
+
+		geometry_opts := http.NewDefaultGeometryHandlerOptions()
+		geometry_opts.EnableGeoJSON = enable_geojson
+
+		geometry_handler, err := http.GeometryHandler(pip.Index, pip.Indexer, geometry_opts)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create geometry handler, because %s", err)
+		}
+
+		mux.Handle("/geometry", geometry_handler)
+	}
+
+	if enable_nearby {
+
+		pip.Logger.Debug("setting up nearby handler")
+
+		max_radius, _ := flags.Float64Var(fs, "nearby-max-radius")
+
+		nearby_opts := http.NewDefaultNearbyHandlerOptions()
+		nearby_opts.EnableGeoJSON = enable_geojson
+		nearby_opts.MaxRadius = max_radius
+
+		nearby_handler, err := http.NearbyHandler(pip.Index, pip.Indexer, nearby_opts)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create nearby handler, because %s", err)
+		}
+
+		mux.Handle("/nearby", nearby_handler)
+	}
+
 	if enable_www {
 
 		www_path, _ := flags.StringVar(fs, "www-path")
@@ -140,7 +366,7 @@ func main() {
 		www_handler, err := http.BundledWWWHandler()
 
 		if err != nil {
-			pip.Logger.Fatal("failed to create (bundled) www handler because %s", err)
+			return nil, fmt.Errorf("Failed to create (bundled) www handler, because %s", err)
 		}
 
 		// squirt an API key in to document.body in HTML pages
@@ -151,7 +377,7 @@ func main() {
 		mapzenjs_handler, err := mapzenjs.MapzenJSHandler(www_handler, mapzenjs_opts)
 
 		if err != nil {
-			pip.Logger.Fatal("failed to create (bundled) mapzenjs handler because %s", err)
+			return nil, fmt.Errorf("Failed to create (bundled) mapzenjs handler, because %s", err)
 		}
 
 		// all the HTML-y bits expect everything to be hanging off of '/' but the default
@@ -171,13 +397,13 @@ func main() {
 		rewrite_handler, err := rewrite.RewriteHandler(rules, mapzenjs_handler)
 
 		if err != nil {
-			pip.Logger.Fatal("failed to create rewrite handler because %s", err)
+			return nil, fmt.Errorf("Failed to create rewrite handler, because %s", err)
 		}
 
 		mapzenjs_assets_handler, err := mapzenjs.MapzenJSAssetsHandler()
 
 		if err != nil {
-			pip.Logger.Fatal("failed to create mapzenjs_assets handler because %s", err)
+			return nil, fmt.Errorf("Failed to create mapzenjs_assets handler, because %s", err)
 		}
 
 		mux.Handle("/javascript/mapzen.min.js", mapzenjs_assets_handler)
@@ -195,17 +421,5 @@ func main() {
 		mux.Handle(www_path, rewrite_handler)
 	}
 
-	host, _ := flags.StringVar(fs, "host")
-	port, _ := flags.IntVar(fs, "port")
-
-	endpoint := fmt.Sprintf("%s:%d", host, port)
-	pip.Logger.Status("listening for requests on %s", endpoint)
-
-	err = gohttp.ListenAndServe(endpoint, mux)
-
-	if err != nil {
-		pip.Logger.Fatal("failed to start server because %s", err)
-	}
-
-	os.Exit(0)
+	return mux, nil
 }
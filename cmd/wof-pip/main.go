@@ -8,7 +8,9 @@ import (
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/app"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/flags"
-	"github.com/whosonfirst/go-whosonfirst-pip-v2/utils"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/polyline"
+	"io/ioutil"
 	log "log"
 	"os"
 	"strconv"
@@ -79,10 +81,16 @@ func main() {
 
 		switch parts[0] {
 
+		case "bbox":
+			command = parts[0]
 		case "candidates":
 			command = parts[0]
+		case "nearby":
+			command = parts[0]
 		case "pip":
 			command = parts[0]
+		case "path":
+			command = parts[0]
 		case "polyline":
 			command = parts[0]
 		default:
@@ -141,24 +149,110 @@ func main() {
 				results = candidates
 			}
 
+		} else if command == "nearby" {
+
+			if len(parts) != 4 {
+				pip.Logger.Warning("Invalid radius query, expected: nearby LAT LON METERS")
+				continue
+			}
+
+			coords := make([]float64, 3)
+			ok := true
+
+			for i, str_c := range parts[1:] {
+
+				c, err := strconv.ParseFloat(strings.Trim(str_c, " "), 64)
+
+				if err != nil {
+					pip.Logger.Warning("Invalid radius query, %s", err)
+					ok = false
+					break
+				}
+
+				coords[i] = c
+			}
+
+			if !ok {
+				continue
+			}
+
+			c, err := geojson_utils.NewCoordinateFromLatLons(coords[0], coords[1])
+
+			if err != nil {
+				pip.Logger.Warning("Invalid latitude, longitude, %s", err)
+				continue
+			}
+
+			intersects, err := appindex.GetIntersectsByRadius(c, coords[2], f)
+
+			if err != nil {
+				pip.Logger.Warning("Unable to get intersects, because %s", err)
+				continue
+			}
+
+			results = intersects
+
+		} else if command == "bbox" {
+
+			if len(parts) != 5 {
+				pip.Logger.Warning("Invalid bounding box, expected: bbox MINLAT MINLON MAXLAT MAXLON")
+				continue
+			}
+
+			coords := make([]float64, 4)
+			ok := true
+
+			for i, str_c := range parts[1:] {
+
+				c, err := strconv.ParseFloat(strings.Trim(str_c, " "), 64)
+
+				if err != nil {
+					pip.Logger.Warning("Invalid bounding box coordinate, %s", err)
+					ok = false
+					break
+				}
+
+				coords[i] = c
+			}
+
+			if !ok {
+				continue
+			}
+
+			bbox, err := geojson_utils.NewRectFromLatLons(coords[0], coords[1], coords[2], coords[3])
+
+			if err != nil {
+				pip.Logger.Warning("Invalid bounding box, %s", err)
+				continue
+			}
+
+			intersects, err := appindex.GetIntersectsByBoundingBox(bbox, f)
+
+			if err != nil {
+				pip.Logger.Warning("Unable to get intersects, because %s", err)
+				continue
+			}
+
+			results = intersects
+
 		} else if command == "polyline" {
 
 			poly := parts[1]
-			factor := 1.0e5
+			precision := polyline.DEFAULT_PRECISION
 
 			if len(parts) > 2 {
 
-				f, err := utils.StringPrecisionToFactor(parts[2])
+				p, err := strconv.Atoi(parts[2])
 
 				if err != nil {
 					pip.Logger.Warning("Unable to parse precision because %s", err)
 					continue
 				}
 
-				factor = f
+				precision = p
 			}
 
-			path, err := utils.DecodePolyline(poly, factor)
+			path, err := polyline.Decode(poly, precision)
 
 			if err != nil {
 				pip.Logger.Warning("Unable to decode polyline because %s", err)
@@ -174,6 +268,39 @@ func main() {
 
 			results = intersects
 
+		} else if command == "path" {
+
+			// path {FILE} where FILE is a GeoJSON LineString or MultiLineString,
+			// a GPX document or a WKT LINESTRING or MULTILINESTRING
+
+			if len(parts) < 2 {
+				pip.Logger.Warning("Missing path")
+				continue
+			}
+
+			body, err := ioutil.ReadFile(parts[1])
+
+			if err != nil {
+				pip.Logger.Warning("Unable to read path because %s", err)
+				continue
+			}
+
+			path, err := geo.NewPathFromBytes(body, "")
+
+			if err != nil {
+				pip.Logger.Warning("Unable to parse path because %s", err)
+				continue
+			}
+
+			intersects, err := appindex.GetIntersectsByPath(*path, f)
+
+			if err != nil {
+				pip.Logger.Warning("Unable to get intersects, because %s", err)
+				continue
+			}
+
+			results = intersects
+
 		} else {
 			pip.Logger.Warning("Invalid command")
 			continue
@@ -189,5 +316,6 @@ func main() {
 		fmt.Println(string(body))
 	}
 
+	pip.Close()
 	os.Exit(0)
 }
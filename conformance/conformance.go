@@ -0,0 +1,373 @@
+// Package conformance runs the same set of fixtures against any index.Index implementation
+// so that the different engines can be checked for consistent results. The index passed to
+// Run should be empty and created with its default options.
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"math"
+	"strings"
+)
+
+const (
+	PER_VERTEX = "per-vertex"
+	AGGREGATE  = "aggregate"
+	COORD      = "coord"
+	BBOX       = "bbox"
+)
+
+// Fixture is a square place with its south-west corner at MinX, MinY or, if Ring is not empty,
+// a place whose geometry is a polygon with that (exterior) ring.
+
+type Fixture struct {
+	Id        int64
+	Name      string
+	Placetype string
+	MinX      float64
+	MinY      float64
+	Size      float64
+	Ring      [][]float64 // [lon, lat] pairs
+}
+
+// CoordCase is a coordinate and the IDs of the places that should contain it, in order.
+
+type CoordCase struct {
+	Name     string
+	Coord    []float64 // [lon, lat]
+	Expected []string
+}
+
+// BBoxCase is a bounding box and the IDs of the places that should intersect it, in order. A
+// bounding box whose minimum longitude is greater than its maximum longitude crosses the antimeridian.
+
+type BBoxCase struct {
+	Name     string
+	BBox     []float64 // [minlon, minlat, maxlon, maxlat]
+	Expected []string
+}
+
+// PathCase is a path and the IDs of the places that should be returned for it, in order,
+// for each of the path query semantics (see index/path.go).
+
+type PathCase struct {
+	Name      string
+	Path      [][]float64 // [lon, lat] pairs
+	PerVertex [][]string
+	Aggregate []string
+}
+
+// Failure describes a case whose results didn't match the expected results.
+
+type Failure struct {
+	Case      string
+	Semantics string
+	Expected  string
+	Actual    string
+}
+
+func (f *Failure) String() string {
+	return fmt.Sprintf("%s (%s) expected %s but got %s", f.Case, f.Semantics, f.Expected, f.Actual)
+}
+
+func Fixtures() []*Fixture {
+
+	return []*Fixture{
+		&Fixture{Id: 1001, Name: "Big", Placetype: "region", MinX: 0.0, MinY: 0.0, Size: 10.0},
+		&Fixture{Id: 1002, Name: "West", Placetype: "locality", MinX: 1.0, MinY: 1.0, Size: 2.0},
+		&Fixture{Id: 1003, Name: "East", Placetype: "locality", MinX: 7.0, MinY: 7.0, Size: 2.0},
+		&Fixture{Id: 1004, Name: "Sliver", Placetype: "neighbourhood", MinX: 4.9, MinY: 4.9, Size: 0.2},
+		// a single ring that crosses the antimeridian, the way some Pacific
+		// islands are drawn
+		&Fixture{Id: 2001, Name: "Pacific", Placetype: "region", Ring: [][]float64{
+			{178.0, -18.0}, {-179.0, -18.0}, {-179.0, -16.0}, {178.0, -16.0}, {178.0, -18.0},
+		}},
+		// a ring that goes all the way round the north pole without ever
+		// touching it
+		&Fixture{Id: 2002, Name: "Arctic", Placetype: "region", Ring: [][]float64{
+			{0.0, 80.0}, {90.0, 80.0}, {180.0, 80.0}, {-90.0, 80.0}, {0.0, 80.0},
+		}},
+		// a ring that spans every longitude and is closed along the south
+		// pole, the way RFC 7946 says to draw it
+		&Fixture{Id: 2003, Name: "Antarctic", Placetype: "region", Ring: [][]float64{
+			{-180.0, -90.0}, {-180.0, -60.0}, {180.0, -60.0}, {180.0, -90.0}, {-180.0, -90.0},
+		}},
+	}
+}
+
+func CoordCases() []*CoordCase {
+
+	return []*CoordCase{
+		&CoordCase{Name: "west of the antimeridian", Coord: []float64{179.5, -17.0}, Expected: []string{"2001"}},
+		&CoordCase{Name: "east of the antimeridian", Coord: []float64{-179.5, -17.0}, Expected: []string{"2001"}},
+		&CoordCase{Name: "longitude greater than 180", Coord: []float64{180.5, -17.0}, Expected: []string{"2001"}},
+		&CoordCase{Name: "longitude less than -180", Coord: []float64{-180.5, -17.0}, Expected: []string{"2001"}},
+		&CoordCase{Name: "inside the antimeridian bounding box", Coord: []float64{5.0, -17.0}, Expected: []string{}},
+		&CoordCase{Name: "arctic", Coord: []float64{45.0, 85.0}, Expected: []string{"2002"}},
+		&CoordCase{Name: "arctic across the antimeridian", Coord: []float64{-179.5, 89.5}, Expected: []string{"2002"}},
+		&CoordCase{Name: "south of the arctic", Coord: []float64{45.0, 75.0}, Expected: []string{}},
+		&CoordCase{Name: "antarctic", Coord: []float64{-100.0, -70.0}, Expected: []string{"2003"}},
+		&CoordCase{Name: "antarctic near the pole", Coord: []float64{179.5, -89.5}, Expected: []string{"2003"}},
+		&CoordCase{Name: "north of the antarctic", Coord: []float64{-100.0, -50.0}, Expected: []string{}},
+	}
+}
+
+func BBoxCases() []*BBoxCase {
+
+	return []*BBoxCase{
+		&BBoxCase{Name: "bbox", BBox: []float64{1.5, 1.5, 2.5, 2.5}, Expected: []string{"1001", "1002"}},
+		&BBoxCase{Name: "bbox across the antimeridian", BBox: []float64{179.0, -17.5, -179.5, -16.5}, Expected: []string{"2001"}},
+		&BBoxCase{Name: "bbox across the antimeridian, west side only", BBox: []float64{177.0, -17.5, -170.0, -16.5}, Expected: []string{"2001"}},
+		&BBoxCase{Name: "bbox across the antimeridian, outside", BBox: []float64{170.0, 5.0, -170.0, 6.0}, Expected: []string{}},
+		&BBoxCase{Name: "bbox across the antimeridian, arctic", BBox: []float64{170.0, 82.0, -170.0, 84.0}, Expected: []string{"2002"}},
+		&BBoxCase{Name: "bbox across the antimeridian, everything", BBox: []float64{10.0, -80.0, 9.0, 85.0}, Expected: []string{"1001", "2001", "2002", "2003", "1002", "1003", "1004"}},
+		&BBoxCase{Name: "bbox not across the antimeridian", BBox: []float64{-179.5, -17.5, 179.5, -16.5}, Expected: []string{"2001"}},
+	}
+}
+
+func PathCases() []*PathCase {
+
+	return []*PathCase{
+		&PathCase{
+			Name:      "single vertex",
+			Path:      [][]float64{{2.0, 2.0}},
+			PerVertex: [][]string{{"1001", "1002"}},
+			Aggregate: []string{"1001", "1002"},
+		},
+		&PathCase{
+			Name:      "between vertices",
+			Path:      [][]float64{{2.0, 2.0}, {8.0, 8.0}},
+			PerVertex: [][]string{{"1001", "1002"}, {"1001", "1003"}},
+			Aggregate: []string{"1001", "1002", "1003", "1004"},
+		},
+		&PathCase{
+			Name:      "no vertices inside",
+			Path:      [][]float64{{-1.0, 5.0}, {11.0, 5.0}},
+			PerVertex: [][]string{{}, {}},
+			Aggregate: []string{"1001", "1004"},
+		},
+		&PathCase{
+			Name:      "outside",
+			Path:      [][]float64{{20.0, 20.0}, {30.0, 30.0}, {20.0, 30.0}},
+			PerVertex: [][]string{{}, {}, {}},
+			Aggregate: []string{},
+		},
+		&PathCase{
+			Name:      "across the antimeridian",
+			Path:      [][]float64{{177.0, -17.0}, {-178.0, -17.0}},
+			PerVertex: [][]string{{}, {}},
+			Aggregate: []string{"2001"},
+		},
+		&PathCase{
+			Name:      "the short way round",
+			Path:      [][]float64{{170.0, 5.0}, {-170.0, 5.0}},
+			PerVertex: [][]string{{}, {}},
+			Aggregate: []string{},
+		},
+		&PathCase{
+			Name:      "across the arctic",
+			Path:      [][]float64{{179.0, 70.0}, {-179.0, 85.0}},
+			PerVertex: [][]string{{}, {"2002"}},
+			Aggregate: []string{"2002"},
+		},
+	}
+}
+
+// NewFeature returns the fixture as a geojson.Feature.
+
+func (fx *Fixture) NewFeature() (geojson.Feature, error) {
+
+	ring := fx.Ring
+
+	if len(ring) == 0 {
+
+		min_x := fx.MinX
+		min_y := fx.MinY
+		max_x := fx.MinX + fx.Size
+		max_y := fx.MinY + fx.Size
+
+		ring = [][]float64{{min_x, min_y}, {max_x, min_y}, {max_x, max_y}, {min_x, max_y}, {min_x, min_y}}
+	}
+
+	// the bounding box and centroid are naive (they ignore the antimeridian) which is
+	// what they would be in most source data anyway
+
+	min_x, min_y, max_x, max_y := ring[0][0], ring[0][1], ring[0][0], ring[0][1]
+	points := make([]string, len(ring))
+
+	for i, pt := range ring {
+
+		min_x = math.Min(min_x, pt[0])
+		min_y = math.Min(min_y, pt[1])
+		max_x = math.Max(max_x, pt[0])
+		max_y = math.Max(max_y, pt[1])
+
+		points[i] = fmt.Sprintf("[%0.6f,%0.6f]", pt[0], pt[1])
+	}
+
+	body := fmt.Sprintf(`{"type":"Feature","properties":{"wof:id":%d,"wof:name":"%s","wof:placetype":"%s","wof:repo":"conformance","wof:country":"XX","geom:latitude":%0.6f,"geom:longitude":%0.6f,"geom:bbox":"%0.6f,%0.6f,%0.6f,%0.6f","edtf:inception":"","edtf:cessation":""},"geometry":{"type":"Polygon","coordinates":[[%s]]}}`,
+		fx.Id, fx.Name, fx.Placetype, (min_y+max_y)/2.0, (min_x+max_x)/2.0, min_x, min_y, max_x, max_y, strings.Join(points, ","))
+
+	return feature.LoadFeature([]byte(body))
+}
+
+// Run indexes the fixtures in 'idx', runs each of the coordinate, bounding box and path cases against it and
+// returns the cases whose results didn't match. An error is only returned if a query itself fails.
+
+func Run(ctx context.Context, idx index.Index) ([]*Failure, error) {
+
+	for _, fx := range Fixtures() {
+
+		f, err := fx.NewFeature()
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = idx.IndexFeature(f)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	filters, err := filter.NewSPRFilter()
+
+	if err != nil {
+		return nil, err
+	}
+
+	failures := make([]*Failure, 0)
+
+	for _, c := range CoordCases() {
+
+		coord := geom.Coord{X: c.Coord[0], Y: c.Coord[1]}
+
+		rs, err := idx.GetIntersectsByCoordContext(ctx, coord, filters)
+
+		if err != nil {
+			return nil, err
+		}
+
+		str_expected := formatIds(c.Expected)
+		str_actual := formatIds(resultIds(rs))
+
+		if str_expected != str_actual {
+
+			failures = append(failures, &Failure{
+				Case:      c.Name,
+				Semantics: COORD,
+				Expected:  str_expected,
+				Actual:    str_actual,
+			})
+		}
+	}
+
+	for _, c := range BBoxCases() {
+
+		bbox := geom.Rect{
+			Min: geom.Coord{X: c.BBox[0], Y: c.BBox[1]},
+			Max: geom.Coord{X: c.BBox[2], Y: c.BBox[3]},
+		}
+
+		rs, err := idx.GetIntersectsByBoundingBoxContext(ctx, bbox, filters)
+
+		if err != nil {
+			return nil, err
+		}
+
+		str_expected := formatIds(c.Expected)
+		str_actual := formatIds(resultIds(rs))
+
+		if str_expected != str_actual {
+
+			failures = append(failures, &Failure{
+				Case:      c.Name,
+				Semantics: BBOX,
+				Expected:  str_expected,
+				Actual:    str_actual,
+			})
+		}
+	}
+
+	for _, c := range PathCases() {
+
+		path := geom.Path{}
+
+		for _, pt := range c.Path {
+			path.AddVertex(geom.Coord{X: pt[0], Y: pt[1]})
+		}
+
+		per_vertex, err := idx.GetIntersectsByPathContext(ctx, path, filters)
+
+		if err != nil {
+			return nil, err
+		}
+
+		expected := make([]string, len(c.PerVertex))
+		actual := make([]string, len(per_vertex))
+
+		for i, ids := range c.PerVertex {
+			expected[i] = formatIds(ids)
+		}
+
+		for i, rs := range per_vertex {
+			actual[i] = formatIds(resultIds(rs))
+		}
+
+		str_expected := strings.Join(expected, " ")
+		str_actual := strings.Join(actual, " ")
+
+		if str_expected != str_actual {
+
+			failures = append(failures, &Failure{
+				Case:      c.Name,
+				Semantics: PER_VERTEX,
+				Expected:  str_expected,
+				Actual:    str_actual,
+			})
+		}
+
+		aggregate, err := idx.GetIntersectsByPathAggregateContext(ctx, path, filters)
+
+		if err != nil {
+			return nil, err
+		}
+
+		str_expected = formatIds(c.Aggregate)
+		str_actual = formatIds(resultIds(aggregate))
+
+		if str_expected != str_actual {
+
+			failures = append(failures, &Failure{
+				Case:      c.Name,
+				Semantics: AGGREGATE,
+				Expected:  str_expected,
+				Actual:    str_actual,
+			})
+		}
+	}
+
+	return failures, nil
+}
+
+func resultIds(rs spr.StandardPlacesResults) []string {
+
+	ids := make([]string, 0)
+
+	for _, s := range rs.Results() {
+		ids = append(ids, s.Id())
+	}
+
+	return ids
+}
+
+func formatIds(ids []string) string {
+	return "[" + strings.Join(ids, ",") + "]"
+}
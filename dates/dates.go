@@ -0,0 +1,613 @@
+package dates
+
+// this is not a complete EDTF parser - it is just enough of one to determine the earliest
+// and latest days that an EDTF string may refer to, which is all that is needed to answer
+// questions like "which places existed on 1995-06-01?" - qualifiers (uncertain, approximate)
+// are ignored, unspecified digits ("19XX") are treated as the full range of possible values
+// and unknown or open ends of a range are unbounded
+//
+// https://www.loc.gov/standards/datetime/
+
+import (
+	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Range is the span of days between Lower and Upper, inclusive. A nil Lower or Upper
+// means that end of the range is unbounded.
+
+type Range struct {
+	Lower *time.Time
+	Upper *time.Time
+}
+
+// seasons maps EDTF season (and quarter, quadrimester and semester) "months" to the first
+// month they start in and how many months they last
+
+var seasons = map[int][2]int{
+	21: {3, 3},  // spring
+	22: {6, 3},  // summer
+	23: {9, 3},  // autumn
+	24: {12, 3}, // winter
+	25: {3, 3},  // spring (northern hemisphere)
+	26: {6, 3},  // summer (northern hemisphere)
+	27: {9, 3},  // autumn (northern hemisphere)
+	28: {12, 3}, // winter (northern hemisphere)
+	29: {9, 3},  // spring (southern hemisphere)
+	30: {12, 3}, // summer (southern hemisphere)
+	31: {3, 3},  // autumn (southern hemisphere)
+	32: {6, 3},  // winter (southern hemisphere)
+	33: {1, 3},  // quarter 1
+	34: {4, 3},  // quarter 2
+	35: {7, 3},  // quarter 3
+	36: {10, 3}, // quarter 4
+	37: {1, 4},  // quadrimester 1
+	38: {5, 4},  // quadrimester 2
+	39: {9, 4},  // quadrimester 3
+	40: {1, 6},  // semestral 1
+	41: {7, 6},  // semestral 2
+}
+
+// parsed is a cache of the EDTF strings in SPRs, since the same strings are parsed over
+// and over again by FilterSPR. it is only used by RangeForSPR so that it can't be grown
+// (without limit) by things like query parameters
+
+var parsed = new(sync.Map)
+
+type parsedRange struct {
+	r   *Range
+	err error
+}
+
+// NewRange returns the Range between the earliest date that 'inception' may refer to
+// and the latest date that 'cessation' may refer to.
+
+func NewRange(inception string, cessation string) (*Range, error) {
+
+	i, err := ParseEDTF(inception)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := ParseEDTF(cessation)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := Range{
+		Lower: i.Lower,
+		Upper: c.Upper,
+	}
+
+	return &r, nil
+}
+
+// RangeForSPR returns the Range between the inception and cessation dates of 's'. Places
+// without EDTF dates (for example alternate geometries or plain GeoJSON features) have an
+// unbounded range.
+
+func RangeForSPR(s spr.StandardPlacesResult) (*Range, error) {
+
+	wof_s, ok := s.(*feature.WOFStandardPlacesResult)
+
+	if !ok {
+		return &Range{}, nil
+	}
+
+	i, err := parseCachedEDTF(wof_s.EDTFInception)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := parseCachedEDTF(wof_s.EDTFCessation)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := Range{
+		Lower: i.Lower,
+		Upper: c.Upper,
+	}
+
+	return &r, nil
+}
+
+// ParseEDTF returns the Range between the earliest and latest days that 'edtf_str' may
+// refer to. Unknown ("") and open ("..") dates return an unbounded Range.
+
+func ParseEDTF(edtf_str string) (*Range, error) {
+	return parseEDTF(edtf_str)
+}
+
+// parseCachedEDTF is ParseEDTF for strings that are stored in the cache of parsed strings
+
+func parseCachedEDTF(edtf_str string) (*Range, error) {
+
+	v, ok := parsed.Load(edtf_str)
+
+	if ok {
+		p := v.(*parsedRange)
+		return p.r, p.err
+	}
+
+	r, err := parseEDTF(edtf_str)
+
+	parsed.Store(edtf_str, &parsedRange{r, err})
+	return r, err
+}
+
+// Overlaps returns true if any part of 'r' overlaps 'other'.
+
+func (r *Range) Overlaps(other *Range) bool {
+
+	if r.Lower != nil && other.Upper != nil && r.Lower.After(*other.Upper) {
+		return false
+	}
+
+	if r.Upper != nil && other.Lower != nil && r.Upper.Before(*other.Lower) {
+		return false
+	}
+
+	return true
+}
+
+// IsBounded returns true if neither end of 'r' is unbounded.
+
+func (r *Range) IsBounded() bool {
+	return r.Lower != nil && r.Upper != nil
+}
+
+func (r *Range) String() string {
+
+	lower := ".."
+	upper := ".."
+
+	if r.Lower != nil {
+		lower = r.Lower.Format("2006-01-02")
+	}
+
+	if r.Upper != nil {
+		upper = r.Upper.Format("2006-01-02")
+	}
+
+	return fmt.Sprintf("%s/%s", lower, upper)
+}
+
+func parseEDTF(edtf_str string) (*Range, error) {
+
+	edtf_str = strings.TrimSpace(edtf_str)
+
+	switch edtf_str {
+	case "", "..", "open", "uuuu", "unknown":
+		return &Range{}, nil
+	}
+
+	// sets: one of ([...]) or all of ({...}) a list of dates or ranges of dates
+
+	if strings.HasPrefix(edtf_str, "[") || strings.HasPrefix(edtf_str, "{") {
+
+		closing := "]"
+
+		if strings.HasPrefix(edtf_str, "{") {
+			closing = "}"
+		}
+
+		if len(edtf_str) < 2 || !strings.HasSuffix(edtf_str, closing) {
+			return nil, invalidEDTF(edtf_str)
+		}
+
+		return parseSet(edtf_str[1 : len(edtf_str)-1])
+	}
+
+	// intervals
+
+	if strings.Contains(edtf_str, "/") {
+
+		parts := strings.Split(edtf_str, "/")
+
+		if len(parts) != 2 {
+			return nil, invalidEDTF(edtf_str)
+		}
+
+		start, err := parseEDTF(parts[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := parseEDTF(parts[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		r := Range{
+			Lower: start.Lower,
+			Upper: end.Upper,
+		}
+
+		return &r, nil
+	}
+
+	return parseDate(edtf_str)
+}
+
+func parseSet(str_set string) (*Range, error) {
+
+	r := Range{}
+
+	for i, member := range strings.Split(str_set, ",") {
+
+		var m *Range
+
+		// ranges of dates are separated by ".." but so are (one sided) open ranges
+
+		parts := strings.Split(strings.TrimSpace(member), "..")
+
+		switch len(parts) {
+		case 1:
+
+			d, err := parseDate(parts[0])
+
+			if err != nil {
+				return nil, err
+			}
+
+			m = d
+
+		case 2:
+
+			start, err := parseEDTF(parts[0])
+
+			if err != nil {
+				return nil, err
+			}
+
+			end, err := parseEDTF(parts[1])
+
+			if err != nil {
+				return nil, err
+			}
+
+			m = &Range{
+				Lower: start.Lower,
+				Upper: end.Upper,
+			}
+
+		default:
+			return nil, invalidEDTF(str_set)
+		}
+
+		if i == 0 {
+			r = *m
+			continue
+		}
+
+		if r.Lower != nil && (m.Lower == nil || m.Lower.Before(*r.Lower)) {
+			r.Lower = m.Lower
+		}
+
+		if r.Upper != nil && (m.Upper == nil || m.Upper.After(*r.Upper)) {
+			r.Upper = m.Upper
+		}
+	}
+
+	return &r, nil
+}
+
+// parseDate parses a single (possibly qualified or partially unspecified) date
+
+func parseDate(edtf_str string) (*Range, error) {
+
+	str_date := edtf_str
+
+	// times don't make any difference to the range of days a date refers to
+
+	idx := strings.Index(str_date, "T")
+
+	if idx != -1 {
+		str_date = str_date[0:idx]
+	}
+
+	// qualifiers can be applied to the whole date or to any of its parts
+
+	str_date = strings.NewReplacer("?", "", "~", "", "%", "").Replace(str_date)
+
+	if str_date == "" {
+		return nil, invalidEDTF(edtf_str)
+	}
+
+	var year_lower int
+	var year_upper int
+	var err error
+
+	month_lower := 1
+	month_upper := 12
+
+	day_lower := 1
+	day_upper := 31
+
+	var str_year string
+	var str_month string
+	var str_day string
+
+	if strings.HasPrefix(str_date, "Y") {
+
+		// years with more than four digits (which can't have a month or a day)
+
+		year_lower, year_upper, err = parseLongYear(str_date[1:])
+
+		if err != nil {
+			return nil, invalidEDTF(edtf_str)
+		}
+
+	} else {
+
+		negative := strings.HasPrefix(str_date, "-")
+
+		if negative {
+			str_date = str_date[1:]
+		}
+
+		parts := strings.Split(str_date, "-")
+
+		if len(parts) > 3 {
+			return nil, invalidEDTF(edtf_str)
+		}
+
+		str_year = parts[0]
+
+		if len(parts) >= 2 {
+			str_month = parts[1]
+		}
+
+		if len(parts) == 3 {
+			str_day = parts[2]
+		}
+
+		// significant digits, for example "1950S2"
+
+		significant := -1
+
+		idx := strings.Index(str_year, "S")
+
+		if idx != -1 {
+
+			s, err := strconv.Atoi(str_year[idx+1:])
+
+			if err != nil {
+				return nil, invalidEDTF(edtf_str)
+			}
+
+			significant = s
+			str_year = str_year[0:idx]
+		}
+
+		if len(str_year) != 4 {
+			return nil, invalidEDTF(edtf_str)
+		}
+
+		if significant > 0 && significant < len(str_year) {
+			str_year = str_year[0:significant] + strings.Repeat("X", len(str_year)-significant)
+		}
+
+		year_lower, year_upper, err = parseDigits(str_year, 0, 9999)
+
+		if err != nil {
+			return nil, invalidEDTF(edtf_str)
+		}
+
+		if negative {
+			year_lower, year_upper = -year_upper, -year_lower
+		}
+	}
+
+	if str_month != "" {
+
+		if len(str_month) != 2 {
+			return nil, invalidEDTF(edtf_str)
+		}
+
+		m, err := strconv.Atoi(str_month)
+
+		if err == nil && m >= 21 {
+
+			season, ok := seasons[m]
+
+			if !ok || str_day != "" {
+				return nil, invalidEDTF(edtf_str)
+			}
+
+			// seasons that end in the following year (like winter) just
+			// have months after 12 which time.Date will normalize
+
+			month_lower = season[0]
+			month_upper = season[0] + season[1] - 1
+
+		} else {
+
+			month_lower, month_upper, err = parseDigits(str_month, 1, 12)
+
+			if err != nil {
+				return nil, invalidEDTF(edtf_str)
+			}
+		}
+	}
+
+	if str_day != "" {
+
+		if len(str_day) != 2 {
+			return nil, invalidEDTF(edtf_str)
+		}
+
+		day_lower, day_upper, err = parseDigits(str_day, 1, 31)
+
+		if err != nil {
+			return nil, invalidEDTF(edtf_str)
+		}
+	}
+
+	lower := time.Date(year_lower, time.Month(month_lower), day_lower, 0, 0, 0, 0, time.UTC)
+
+	// the last day of the upper month, unless it is a specific day that exists in that
+	// month, is the day before the first day of the following month
+
+	last_day := time.Date(year_upper, time.Month(month_upper+1), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	upper := time.Date(year_upper, time.Month(month_upper), day_upper, 0, 0, 0, 0, time.UTC)
+
+	if upper.After(last_day) {
+		upper = last_day
+	}
+
+	if upper.Before(lower) {
+		return nil, invalidEDTF(edtf_str)
+	}
+
+	r := Range{
+		Lower: &lower,
+		Upper: &upper,
+	}
+
+	return &r, nil
+}
+
+// parseDigits returns the smallest and largest values (clamped to 'min' and 'max') that
+// 'str_digits', which may contain unspecified ("X") digits, may be
+
+func parseDigits(str_digits string, min int, max int) (int, int, error) {
+
+	str_lower := strings.Replace(str_digits, "X", "0", -1)
+	str_upper := strings.Replace(str_digits, "X", "9", -1)
+
+	lower, err := strconv.Atoi(str_lower)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	upper, err := strconv.Atoi(str_upper)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if lower < min {
+		lower = min
+	}
+
+	if upper > max {
+		upper = max
+	}
+
+	if lower > max || upper < min {
+		return 0, 0, errors.New("Value out of range")
+	}
+
+	return lower, upper, nil
+}
+
+// parseLongYear parses the part of a year after the "Y" prefix, for example "170000002",
+// "-170000002" or "17E7"
+
+func parseLongYear(str_year string) (int, int, error) {
+
+	significant := -1
+
+	idx := strings.Index(str_year, "S")
+
+	if idx != -1 {
+
+		s, err := strconv.Atoi(str_year[idx+1:])
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		significant = s
+		str_year = str_year[0:idx]
+	}
+
+	year := 0
+
+	idx = strings.Index(str_year, "E")
+
+	if idx != -1 {
+
+		base, err := strconv.Atoi(str_year[0:idx])
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		exp, err := strconv.Atoi(str_year[idx+1:])
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		year = base * int(math.Pow10(exp))
+
+	} else {
+
+		y, err := strconv.Atoi(str_year)
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		year = y
+	}
+
+	if significant <= 0 {
+		return year, year, nil
+	}
+
+	// only the first 'significant' digits are known
+
+	digits := strconv.Itoa(year)
+	negative := year < 0
+
+	if negative {
+		digits = digits[1:]
+	}
+
+	if significant >= len(digits) {
+		return year, year, nil
+	}
+
+	unknown := len(digits) - significant
+	factor := int(math.Pow10(unknown))
+
+	lower := (abs(year) / factor) * factor
+	upper := lower + factor - 1
+
+	if negative {
+		return -upper, -lower, nil
+	}
+
+	return lower, upper, nil
+}
+
+func abs(i int) int {
+
+	if i < 0 {
+		return -i
+	}
+
+	return i
+}
+
+func invalidEDTF(edtf_str string) error {
+	return fmt.Errorf("Invalid or unsupported EDTF string '%s'", edtf_str)
+}
@@ -0,0 +1,263 @@
+package dates
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"testing"
+	"time"
+)
+
+func day(y int, m int, d int) *time.Time {
+	t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+	return &t
+}
+
+func sameDay(a *time.Time, b *time.Time) bool {
+
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return a.Equal(*b)
+}
+
+func rangeString(t *time.Time) string {
+
+	if t == nil {
+		return ".."
+	}
+
+	return t.String()
+}
+
+func TestParseEDTF(t *testing.T) {
+
+	tests := []struct {
+		edtf  string
+		lower *time.Time
+		upper *time.Time
+	}{
+		// unknown and open
+		{"", nil, nil},
+		{"..", nil, nil},
+		{"open", nil, nil},
+		{"uuuu", nil, nil},
+		{"unknown", nil, nil},
+		// dates
+		{"1995-06-01", day(1995, 6, 1), day(1995, 6, 1)},
+		{"1995-06", day(1995, 6, 1), day(1995, 6, 30)},
+		{"1996-02", day(1996, 2, 1), day(1996, 2, 29)},
+		{"1995", day(1995, 1, 1), day(1995, 12, 31)},
+		{"2001-02-03T09:30:01Z", day(2001, 2, 3), day(2001, 2, 3)},
+		{"-0100", day(-100, 1, 1), day(-100, 12, 31)},
+		// qualifiers
+		{"1984?", day(1984, 1, 1), day(1984, 12, 31)},
+		{"2004-06~", day(2004, 6, 1), day(2004, 6, 30)},
+		{"2004-06-11%", day(2004, 6, 11), day(2004, 6, 11)},
+		{"?2004-06-~11", day(2004, 6, 11), day(2004, 6, 11)},
+		// unspecified digits
+		{"19XX", day(1900, 1, 1), day(1999, 12, 31)},
+		{"201X", day(2010, 1, 1), day(2019, 12, 31)},
+		{"1985-XX", day(1985, 1, 1), day(1985, 12, 31)},
+		{"1985-1X", day(1985, 10, 1), day(1985, 12, 31)},
+		{"1985-04-XX", day(1985, 4, 1), day(1985, 4, 30)},
+		{"1985-XX-XX", day(1985, 1, 1), day(1985, 12, 31)},
+		// seasons, quarters, quadrimesters and semesters
+		{"2001-21", day(2001, 3, 1), day(2001, 5, 31)},
+		{"2001-24", day(2001, 12, 1), day(2002, 2, 28)},
+		{"2001-33", day(2001, 1, 1), day(2001, 3, 31)},
+		{"2001-39", day(2001, 9, 1), day(2001, 12, 31)},
+		{"2001-41", day(2001, 7, 1), day(2001, 12, 31)},
+		// significant digits
+		{"1950S2", day(1900, 1, 1), day(1999, 12, 31)},
+		// long years
+		{"Y170000002", day(170000002, 1, 1), day(170000002, 12, 31)},
+		{"Y-170000002", day(-170000002, 1, 1), day(-170000002, 12, 31)},
+		{"Y17E7", day(170000000, 1, 1), day(170000000, 12, 31)},
+		{"Y171010000S3", day(171000000, 1, 1), day(171999999, 12, 31)},
+		// intervals
+		{"1964/2008", day(1964, 1, 1), day(2008, 12, 31)},
+		{"2004-06/2006-08", day(2004, 6, 1), day(2006, 8, 31)},
+		{"2004-02-01/2005-02-08", day(2004, 2, 1), day(2005, 2, 8)},
+		{"1985-04-12/..", day(1985, 4, 12), nil},
+		{"../1985-04-12", nil, day(1985, 4, 12)},
+		{"1985-04-12/", day(1985, 4, 12), nil},
+		{"/1985-04-12", nil, day(1985, 4, 12)},
+		{"1984~/2004-06", day(1984, 1, 1), day(2004, 6, 30)},
+		// sets
+		{"[1667,1668,1670..1672]", day(1667, 1, 1), day(1672, 12, 31)},
+		{"[..1760-12-03]", nil, day(1760, 12, 3)},
+		{"[1760-01,1760-02,1760-12..]", day(1760, 1, 1), nil},
+		{"{1960,1961-12}", day(1960, 1, 1), day(1961, 12, 31)},
+		{"{1667,1668, 1670..1672}", day(1667, 1, 1), day(1672, 12, 31)},
+	}
+
+	for _, test := range tests {
+
+		r, err := ParseEDTF(test.edtf)
+
+		if err != nil {
+			t.Errorf("Failed to parse '%s', %s", test.edtf, err)
+			continue
+		}
+
+		if !sameDay(r.Lower, test.lower) || !sameDay(r.Upper, test.upper) {
+			t.Errorf("'%s' is %s - %s, expected %s - %s", test.edtf, rangeString(r.Lower), rangeString(r.Upper), rangeString(test.lower), rangeString(test.upper))
+		}
+	}
+}
+
+func TestParseEDTFInvalid(t *testing.T) {
+
+	tests := []string{
+		"abcd",
+		"95",
+		"19950",
+		"1995-6",
+		"1995-13",
+		"1995-00",
+		"1995-06-1",
+		"1995-06-32",
+		"2001-02-30",
+		"2001-42",
+		"2001-21-01",
+		"1995-06-01-01",
+		"1964/2008/2010",
+		"1964/abcd",
+		"[",
+		"[2001",
+		"{2001",
+		"[20011",
+		"{19951",
+		"[2001}",
+		"{2001]",
+		"[2001,abcd]",
+		"[2001..2002..2003]",
+		"Yabc",
+		"1950Sx",
+		"?",
+	}
+
+	for _, edtf_str := range tests {
+
+		r, err := ParseEDTF(edtf_str)
+
+		if err == nil {
+			t.Errorf("Expected '%s' to be invalid but it parsed as %s", edtf_str, r)
+		}
+	}
+}
+
+// ParseEDTF is passed things like query parameters so it shouldn't remember what it has parsed
+
+func TestParseEDTFNotCached(t *testing.T) {
+
+	edtf_str := "1066-10-14"
+
+	_, err := ParseEDTF(edtf_str)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok := parsed.Load(edtf_str)
+
+	if ok {
+		t.Fatalf("ParseEDTF cached '%s'", edtf_str)
+	}
+}
+
+func TestRangeForSPR(t *testing.T) {
+
+	tests := []struct {
+		inception string
+		cessation string
+		lower     *time.Time
+		upper     *time.Time
+	}{
+		{"1867-07-01", "uuuu", day(1867, 7, 1), nil},
+		{"", "", nil, nil},
+		{"1995~", "2004-06-XX", day(1995, 1, 1), day(2004, 6, 30)},
+		{"1964/1965", "2008/2010", day(1964, 1, 1), day(2010, 12, 31)},
+		{"..", "[2001,2003]", nil, day(2003, 12, 31)},
+	}
+
+	for _, test := range tests {
+
+		s := feature.WOFStandardPlacesResult{
+			EDTFInception: test.inception,
+			EDTFCessation: test.cessation,
+		}
+
+		r, err := RangeForSPR(&s)
+
+		if err != nil {
+			t.Errorf("Failed to derive range for '%s' - '%s', %s", test.inception, test.cessation, err)
+			continue
+		}
+
+		if !sameDay(r.Lower, test.lower) || !sameDay(r.Upper, test.upper) {
+			t.Errorf("'%s' - '%s' is %s - %s, expected %s - %s", test.inception, test.cessation, rangeString(r.Lower), rangeString(r.Upper), rangeString(test.lower), rangeString(test.upper))
+		}
+	}
+
+	invalid := feature.WOFStandardPlacesResult{
+		EDTFInception: "[2001",
+		EDTFCessation: "",
+	}
+
+	_, err := RangeForSPR(&invalid)
+
+	if err == nil {
+		t.Errorf("Expected an error for an invalid inception date")
+	}
+
+	// places without EDTF dates are unbounded
+
+	g := feature.GeoJSONStandardPlacesResult{}
+
+	r, err := RangeForSPR(&g)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Lower != nil || r.Upper != nil {
+		t.Errorf("Expected an unbounded range for a GeoJSON SPR, got %s", r)
+	}
+}
+
+func TestRangeOverlaps(t *testing.T) {
+
+	tests := []struct {
+		a        string
+		b        string
+		overlaps bool
+	}{
+		{"1964/2008", "1995-06-01", true},
+		{"1964/2008", "2008-12-31", true},
+		{"1964/2008", "2009-01-01", false},
+		{"1964/2008", "1963-12-31", false},
+		{"../1964", "1900", true},
+		{"1964/..", "1900", false},
+		{"", "1900", true},
+	}
+
+	for _, test := range tests {
+
+		a, err := ParseEDTF(test.a)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := ParseEDTF(test.b)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a.Overlaps(b) != test.overlaps {
+			t.Errorf("Expected '%s' overlaps '%s' to be %t", test.a, test.b, test.overlaps)
+		}
+	}
+}
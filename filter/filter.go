@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/whosonfirst/go-whosonfirst-flags"
 	"github.com/whosonfirst/go-whosonfirst-flags/placetypes"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/dates"
 	"github.com/whosonfirst/go-whosonfirst-spr"
 	"log"
 )
@@ -16,12 +18,34 @@ type Filter interface {
 	IsCeased(flags.ExistentialFlag) bool
 	IsSuperseded(flags.ExistentialFlag) bool
 	IsSuperseding(flags.ExistentialFlag) bool
+	HasSource(string) bool
+	CoversDate(*dates.Range) bool
 }
 
+// FilterSPR returns an error if 's' doesn't pass 'filters'. Alternate geometries don't have a placetype,
+// existential flags or dates of their own so they should be tested with FilterAltSPR instead.
+
 func FilterSPR(filters Filter, s spr.StandardPlacesResult) error {
+	return FilterAltSPR(filters, s, s)
+}
+
+// FilterAltSPR returns an error if the alternate geometry 's' doesn't pass 'filters'. Its source is
+// tested but everything else is tested using 'default_spr', the default geometry for the same place.
+
+func FilterAltSPR(filters Filter, s spr.StandardPlacesResult, default_spr spr.StandardPlacesResult) error {
 
 	var ok bool
 
+	ok = filters.HasSource(alt.Source(s))
+
+	if !ok {
+		return errors.New("Failed 'source' test")
+	}
+
+	// everything else is a property of the place rather than of the geometry
+
+	s = default_spr
+
 	pf, err := placetypes.NewPlacetypeFlag(s.Placetype())
 
 	if err != nil {
@@ -66,5 +90,19 @@ func FilterSPR(filters Filter, s spr.StandardPlacesResult) error {
 		return errors.New("Failed 'is superseding' test")
 	}
 
+	// a place whose dates can't be parsed can't be tested so it is treated as
+	// though its dates are unknown, which is to say unbounded
+
+	r, err := dates.RangeForSPR(s)
+
+	if err == nil {
+
+		ok = filters.CoversDate(r)
+
+		if !ok {
+			return errors.New("Failed 'date' test")
+		}
+	}
+
 	return nil
 }
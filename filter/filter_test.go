@@ -0,0 +1,98 @@
+package filter_test
+
+import (
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"net/url"
+	"testing"
+)
+
+func testSPR(t *testing.T, body string) spr.StandardPlacesResult {
+
+	f, err := feature.LoadFeature([]byte(body))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := f.SPR()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func testDefaultSPR(t *testing.T, id int, placetype string, is_current int) spr.StandardPlacesResult {
+
+	body := fmt.Sprintf(`{"type":"Feature","properties":{"wof:id":%d,"wof:name":"test","wof:placetype":"%s","wof:repo":"x","mz:is_current":%d,"geom:latitude":0.5,"geom:longitude":0.5,"geom:bbox":"0,0,1,1"},"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}`, id, placetype, is_current)
+	return testSPR(t, body)
+}
+
+func testAltSPR(t *testing.T, id int, label string) spr.StandardPlacesResult {
+
+	body := fmt.Sprintf(`{"type":"Feature","properties":{"wof:id":%d,"wof:repo":"x","src:alt_label":"%s","src:geom":"%s"},"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}}`, id, label, label)
+	return testSPR(t, body)
+}
+
+// alternate geometries are tested using the placetype and existential flags of their default geometry
+
+func TestFilterAltSPR(t *testing.T) {
+
+	locality := testDefaultSPR(t, 1, "locality", 1)
+	region := testDefaultSPR(t, 2, "region", 0)
+
+	locality_alt := testAltSPR(t, 1, "quattroshapes")
+	region_alt := testAltSPR(t, 2, "quattroshapes")
+
+	tests := []struct {
+		query    string
+		alt      spr.StandardPlacesResult
+		default_ spr.StandardPlacesResult
+		expected bool
+	}{
+		{"alt=true", locality_alt, locality, true},
+		{"alt=true", region_alt, region, true},
+		{"alt=true&placetype=locality", locality_alt, locality, true},
+		{"alt=true&placetype=locality", region_alt, region, false},
+		{"alt=true&is_current=1", locality_alt, locality, true},
+		{"alt=true&is_current=1", region_alt, region, false},
+		{"alt=true&placetype=region&is_current=1", region_alt, region, false},
+		{"source=quattroshapes&placetype=region", region_alt, region, true},
+		{"source=naturalearth&placetype=region", region_alt, region, false},
+		{"placetype=locality", locality_alt, locality, false},
+	}
+
+	for _, test := range tests {
+
+		query, err := url.ParseQuery(test.query)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := filter.NewSPRFilterFromQuery(query)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = filter.FilterAltSPR(f, test.alt, test.default_)
+
+		if (err == nil) != test.expected {
+			t.Errorf("Expected the alternate geometry for %s (%s) to pass '%s' to be %t, got %v", test.default_.Id(), test.default_.Placetype(), test.query, test.expected, err)
+		}
+
+		// and default geometries are only tested against themselves
+
+		err = filter.FilterSPR(f, test.default_)
+		err_alt := filter.FilterAltSPR(f, test.default_, test.default_)
+
+		if (err == nil) != (err_alt == nil) {
+			t.Errorf("Expected FilterSPR and FilterAltSPR to agree about %s for '%s'", test.default_.Id(), test.query)
+		}
+	}
+}
@@ -18,6 +18,9 @@ func NewSPRFilterFromQuery(query url.Values) (Filter, error) {
 	inputs.IsCeased = query["is_ceased"]
 	inputs.IsSuperseded = query["is_superseded"]
 	inputs.IsSuperseding = query["is_superseding"]
+	inputs.Alt = query["alt"]
+	inputs.Sources = query["source"]
+	inputs.Date = query["date"]
 
 	return NewSPRFilterFromInputs(inputs)
 }
@@ -1,9 +1,12 @@
 package filter
 
 import (
+	"errors"
 	"github.com/whosonfirst/go-whosonfirst-flags"
 	"github.com/whosonfirst/go-whosonfirst-flags/existential"
 	"github.com/whosonfirst/go-whosonfirst-flags/placetypes"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/dates"
 	_ "log"
 	"strconv"
 	"strings"
@@ -16,6 +19,9 @@ type SPRInputs struct {
 	IsDeprecated  []string
 	IsSuperseded  []string
 	IsSuperseding []string
+	Alt           []string
+	Sources       []string
+	Date          []string
 }
 
 type SPRFilter struct {
@@ -26,6 +32,12 @@ type SPRFilter struct {
 	Ceased      []flags.ExistentialFlag
 	Superseded  []flags.ExistentialFlag
 	Superseding []flags.ExistentialFlag
+	// the geometry sources (alternate geometry labels, or alt.DEFAULT_SOURCE) to include
+	Sources []string
+	// include every geometry source
+	AllSources bool
+	// the (EDTF) date that places need to have existed on, or nil for any date
+	Date *dates.Range
 }
 
 func (f *SPRFilter) HasPlacetypes(fl flags.PlacetypeFlag) bool {
@@ -100,6 +112,38 @@ func (f *SPRFilter) IsSuperseding(fl flags.ExistentialFlag) bool {
 	return false
 }
 
+// HasSource returns true if 'source' is one of the filter's sources. Sources match
+// an alternate geometry label exactly or by its leading parts, so "naturalearth"
+// matches "naturalearth-display-terrestrial-zoom6".
+
+func (f *SPRFilter) HasSource(source string) bool {
+
+	if f.AllSources {
+		return true
+	}
+
+	for _, s := range f.Sources {
+
+		if s == source || strings.HasPrefix(source, s+"-") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CoversDate returns true if the filter doesn't have a date or if 'r', the range between
+// a place's inception and cessation dates, overlaps it.
+
+func (f *SPRFilter) CoversDate(r *dates.Range) bool {
+
+	if f.Date == nil {
+		return true
+	}
+
+	return r.Overlaps(f.Date)
+}
+
 func NewSPRInputs() (*SPRInputs, error) {
 
 	i := SPRInputs{
@@ -109,6 +153,9 @@ func NewSPRInputs() (*SPRInputs, error) {
 		IsCeased:      make([]string, 0),
 		IsSuperseded:  make([]string, 0),
 		IsSuperseding: make([]string, 0),
+		Alt:           make([]string, 0),
+		Sources:       make([]string, 0),
+		Date:          make([]string, 0),
 	}
 
 	return &i, nil
@@ -129,6 +176,8 @@ func NewSPRFilter() (*SPRFilter, error) {
 		Ceased:      col_ex,
 		Superseded:  col_ex,
 		Superseding: col_ex,
+		Sources:     []string{alt.DEFAULT_SOURCE},
+		AllSources:  false,
 	}
 
 	return &f, nil
@@ -208,6 +257,34 @@ func NewSPRFilterFromInputs(inputs *SPRInputs) (Filter, error) {
 		f.Superseding = possible
 	}
 
+	if len(inputs.Alt) != 0 {
+
+		all, err := altFlag(inputs.Alt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		f.AllSources = all
+	}
+
+	if len(inputs.Sources) != 0 {
+
+		f.Sources = sources(inputs.Sources)
+		f.AllSources = false
+	}
+
+	if len(inputs.Date) != 0 {
+
+		r, err := dateRange(inputs.Date)
+
+		if err != nil {
+			return nil, err
+		}
+
+		f.Date = r
+	}
+
 	return f, nil
 }
 
@@ -262,3 +339,61 @@ func existentialFlags(inputs []string) ([]flags.ExistentialFlag, error) {
 
 	return possible, nil
 }
+
+func altFlag(inputs []string) (bool, error) {
+
+	all := false
+
+	for _, str_b := range inputs {
+
+		b, err := strconv.ParseBool(strings.Trim(str_b, " "))
+
+		if err != nil {
+			return false, errors.New("Invalid 'alt' parameter")
+		}
+
+		all = b
+	}
+
+	return all, nil
+}
+
+func sources(inputs []string) []string {
+
+	possible := make([]string, 0)
+
+	for _, test := range inputs {
+
+		for _, src := range strings.Split(test, ",") {
+
+			src = strings.Trim(src, " ")
+
+			if src == "" {
+				continue
+			}
+
+			possible = append(possible, src)
+		}
+	}
+
+	return possible
+}
+
+func dateRange(inputs []string) (*dates.Range, error) {
+
+	if len(inputs) > 1 {
+		return nil, errors.New("Multiple 'date' parameters")
+	}
+
+	r, err := dates.ParseEDTF(inputs[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !r.IsBounded() {
+		return nil, errors.New("Invalid 'date' parameter")
+	}
+
+	return r, nil
+}
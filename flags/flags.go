@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/whosonfirst/go-whosonfirst-index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
 	"log"
 	"os"
 	"sort"
@@ -80,13 +81,92 @@ func ValidateCommonFlags(fs *flag.FlagSet) error {
 		return err
 	}
 
+	index_snapshot, err := StringVar(fs, "index-snapshot")
+
+	if err != nil {
+		return err
+	}
+
+	if index_snapshot != "" && pip_index != "rtree" {
+		return errors.New("-index-snapshot is only supported by '-index rtree'")
+	}
+
+	if pip_cache == "lru" {
+
+		lru_backing, err := StringVar(fs, "lru-backing")
+
+		if err != nil {
+			return err
+		}
+
+		if lru_backing == "lru" {
+			return errors.New("-lru-backing can not be 'lru'")
+		}
+
+		lru_max_items, err := IntVar(fs, "lru-max-items")
+
+		if err != nil {
+			return err
+		}
+
+		lru_max_bytes, err := IntVar(fs, "lru-max-bytes")
+
+		if err != nil {
+			return err
+		}
+
+		if lru_max_items < 0 || lru_max_bytes < 0 {
+			return errors.New("-lru-max-items and -lru-max-bytes can not be negative numbers")
+		}
+	}
+
+	cache_coords, err := StringVar(fs, "cache-coords")
+
+	if err != nil {
+		return err
+	}
+
+	valid_coords := false
+
+	for _, c := range cache.Coordinates() {
+
+		if c == cache_coords {
+			valid_coords = true
+			break
+		}
+	}
+
+	if !valid_coords {
+		return fmt.Errorf("Invalid -cache-coords '%s'", cache_coords)
+	}
+
+	rtree_workers, err := IntVar(fs, "rtree-workers")
+
+	if err != nil {
+		return err
+	}
+
+	if rtree_workers < 0 {
+		return errors.New("-rtree-workers can not be a negative number")
+	}
+
 	if mode == "spatialite" {
 
 		if pip_index != "spatialite" {
 			return errors.New("-mode is spatialite but -index is not")
 		}
 
-		if pip_cache != "sqlite" && pip_cache != "spatialite" {
+		// a tiered cache is fine as long as its last (persistent) tier is the
+		// database itself
+
+		last_tier := pip_cache
+
+		if strings.HasPrefix(pip_cache, "tiered:") {
+			tiers := strings.Split(strings.TrimPrefix(pip_cache, "tiered:"), ",")
+			last_tier = strings.TrimSpace(tiers[len(tiers)-1])
+		}
+
+		if last_tier != "sqlite" && last_tier != "spatialite" {
 			return errors.New("-mode is spatialite but -cache is neither 'sqlite' or 'spatialite'")
 		}
 
@@ -193,6 +273,25 @@ func ValidateWWWFlags(fs *flag.FlagSet) error {
 		}
 	}
 
+	enable_reindex, err := BoolVar(fs, "enable-reindex")
+
+	if err != nil {
+		return err
+	}
+
+	if enable_reindex {
+
+		in_memory, err := IsInMemoryCache(fs)
+
+		if err != nil {
+			return err
+		}
+
+		if !in_memory {
+			return errors.New("-enable-reindex requires an in-memory -cache (gocache, or lru without a persistent -lru-backing cache)")
+		}
+	}
+
 	deprecated_bool := map[string]string{
 		"allow-geojson": "enable-geojson",
 		"candidates":    "enable-candidates",
@@ -221,6 +320,52 @@ func ValidateWWWFlags(fs *flag.FlagSet) error {
 	return nil
 }
 
+// IsInMemoryCache returns true if every cache described by the -cache (and -lru-backing) flags keeps
+// its items in memory. Reindexing builds a new index and cache alongside the current ones so it is
+// only supported for in-memory caches since persistent caches would be shared by both of them.
+
+func IsInMemoryCache(fs *flag.FlagSet) (bool, error) {
+
+	pip_cache, err := StringVar(fs, "cache")
+
+	if err != nil {
+		return false, err
+	}
+
+	// lru tiers ignore the -lru-backing flag
+
+	if strings.HasPrefix(pip_cache, "tiered:") {
+
+		for _, name := range strings.Split(strings.TrimPrefix(pip_cache, "tiered:"), ",") {
+
+			switch strings.TrimSpace(name) {
+			case "gocache", "lru":
+				continue
+			default:
+				return false, nil
+			}
+		}
+
+		return true, nil
+	}
+
+	switch pip_cache {
+	case "gocache":
+		return true, nil
+	case "lru":
+
+		lru_backing, err := StringVar(fs, "lru-backing")
+
+		if err != nil {
+			return false, err
+		}
+
+		return lru_backing == "" || lru_backing == "gocache", nil
+	default:
+		return false, nil
+	}
+}
+
 func CheckDeprecatedFlags(fs *flag.FlagSet, deprecated map[string]string, target string, strict bool) error {
 
 	for old, new := range deprecated {
@@ -317,6 +462,17 @@ func IntVar(fl *flag.FlagSet, k string) (int, error) {
 	return i.(int), nil
 }
 
+func Float64Var(fl *flag.FlagSet, k string) (float64, error) {
+
+	i, err := Lookup(fl, k)
+
+	if err != nil {
+		return 0.0, err
+	}
+
+	return i.(float64), nil
+}
+
 func BoolVar(fl *flag.FlagSet, k string) (bool, error) {
 
 	i, err := Lookup(fl, k)
@@ -343,8 +499,8 @@ func CommonFlags() (*flag.FlagSet, error) {
 
 	fs := NewFlagSet("common")
 
-	fs.String("index", "rtree", "Valid options are: rtree, spatialite.")
-	fs.String("cache", "gocache", "Valid options are: gocache, fs, spatialite, sqlite. Note that the spatalite option is just a convenience to mirror the '-index spatialite' option.")
+	fs.String("index", "rtree", "Valid options are: cells, rtree, spatialite.")
+	fs.String("cache", "gocache", "Valid options are: gocache, fs, kv, lru, spatialite, sqlite, or 'tiered:' followed by a comma-separated list of those options (for example 'tiered:lru,sqlite'). Note that the spatalite option is just a convenience to mirror the '-index spatialite' option.")
 
 	modes := index.Modes()
 	modes = append(modes, "spatialite")
@@ -358,8 +514,21 @@ func CommonFlags() (*flag.FlagSet, error) {
 
 	fs.String("spatialite-dsn", "", "A valid SQLite DSN for the '-cache spatialite/sqlite' or '-index spatialite' option. As of this writing for the '-index' and '-cache' options share the same '-spatailite' DSN.")
 	fs.String("fs-path", "", "The root directory to look for features if '-cache fs'.")
+	fs.String("fs-items-path", "", "The root directory to write encoded cache items to, and read them back from, if '-cache fs'. If empty features are always read from '-fs-path'.")
+	fs.Bool("cache-compress", false, "Compress the items written by '-cache fs' (if '-fs-items-path' is set), '-cache kv' and '-cache sqlite/spatialite'.")
+	fs.String("cache-coords", cache.COORDINATES_FLOAT64, "How to encode the coordinates of the items written by '-cache fs' (if '-fs-items-path' is set), '-cache kv' and '-cache sqlite/spatialite'. Valid options are: float64, float32, delta. Note that float32 and delta are lossy: float32 coordinates are accurate to about a meter and delta coordinates to about a centimeter.")
+	fs.String("kv-path", "", "The directory to store items in if '-cache kv'. It is created if it doesn't exist and items that are already there are kept.")
+	fs.Bool("kv-sync", false, "Sync the '-cache kv' log to disk after every write. This is slower but means no items are lost if the machine crashes.")
+	fs.Int("lru-max-items", 10000, "The maximum number of items to keep in memory if '-cache lru'. If 0 there is no limit.")
+	fs.Int("lru-max-bytes", 0, "The maximum (estimated) number of bytes to keep in memory if '-cache lru'. If 0 there is no limit.")
+	fs.String("lru-backing", "", "The cache to write items to, and read evicted items back from, if '-cache lru'. Valid options are: fs, gocache, kv, spatialite, sqlite. If empty evicted items are lost.")
+	fs.Bool("rtree-prepared-geometries", true, "Store an index of the edges of large polygons for faster point-in-polygon tests with '-index rtree'. This uses more memory. (Pass a value of '0' or 'false' to disable it.)")
+	fs.Bool("rtree-path-segments", true, "Test each segment of a path, rather than just its vertices, against the edges of candidate polygons in '-index rtree' aggregate path queries. This finds places that a path crosses between two vertices but is slower. Per-vertex path queries always test just the vertices. (Pass a value of '0' or 'false' to disable it.)")
+	fs.Int("rtree-workers", 0, "The maximum number of goroutines a single '-index rtree' query will use to test candidate records. If 0 the number of CPUs is used.")
+	fs.String("index-snapshot", "", "The path to a snapshot of the '-index rtree' index. If the file exists it is loaded instead of indexing the paths passed to the application, otherwise a new snapshot is written to that path once indexing is complete.")
 
 	fs.Bool("is-wof", true, "Input data is WOF-flavoured GeoJSON. (Pass a value of '0' or 'false' if you need to index non-WOF documents.")
+	fs.Bool("index-alt-files", false, "Index WOF alternate geometry files ({ID}-alt-{LABEL}.geojson) as well as default geometries. Alternate geometries are only returned by queries that ask for them with the 'alt' or 'source' parameters.")
 
 	// this is invoked/used in app/indexer.go but for the life of me I can't
 	// figure out how to make the code in flags/exclude.go implement the
@@ -398,9 +567,15 @@ func AppendWWWFlags(fs *flag.FlagSet) error {
 	fs.Bool("enable-geojson", false, "Allow users to request GeoJSON FeatureCollection formatted responses.")
 	fs.Bool("enable-candidates", false, "Enable the /candidates endpoint to return candidate bounding boxes (as GeoJSON) for requests.")
 	fs.Bool("enable-polylines", false, "Enable the /polylines endpoint to return hierarchies intersecting a path.")
+	fs.Bool("enable-bbox", false, "Enable the /bbox endpoint to return places intersecting a bounding box.")
+	fs.Bool("enable-geometry", false, "Enable the /geometry endpoint to return places intersecting a GeoJSON geometry POST-ed to the server.")
+	fs.Bool("enable-nearby", false, "Enable the /nearby endpoint to return places within a radius of a point.")
+	fs.Bool("enable-reindex", false, "Enable the /reindex endpoint to rebuild the index in the background when it receives a POST request. This requires an in-memory -cache (gocache, or lru without a persistent -lru-backing cache).")
 	fs.Bool("enable-www", false, "Enable the interactive /debug endpoint to query points and display results.")
 
+	fs.Int("request-timeout", 0, "The maximum number of seconds a request may take before it is cancelled. A value of 0 means there is no timeout.")
 	fs.Int("polylines-max-coords", 100, "The maximum number of points a (/polylines) path may contain before it is automatically paginated.")
+	fs.Float64("nearby-max-radius", 50000.0, "The maximum radius, in meters, that may be passed to the /nearby endpoint.")
 	fs.String("www-path", "/debug", "The URL path for the interactive debug endpoint.")
 	fs.String("www-api-key", "xxxxxx", "A valid Nextzen Map Tiles API key (https://developers.nextzen.org).")
 
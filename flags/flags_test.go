@@ -0,0 +1,50 @@
+package flags
+
+import (
+	"testing"
+)
+
+func TestIsInMemoryCache(t *testing.T) {
+
+	tests := []struct {
+		cache     string
+		backing   string
+		in_memory bool
+	}{
+		{"gocache", "", true},
+		{"lru", "", true},
+		{"lru", "gocache", true},
+		{"lru", "kv", false},
+		{"lru", "sqlite", false},
+		{"fs", "", false},
+		{"kv", "", false},
+		{"sqlite", "", false},
+		{"spatialite", "", false},
+		{"tiered:lru,gocache", "", true},
+		{"tiered:lru,gocache", "sqlite", true},
+		{"tiered:lru,kv", "", false},
+		{"tiered:lru, sqlite", "", false},
+	}
+
+	for _, test := range tests {
+
+		fs, err := CommonFlags()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fs.Set("cache", test.cache)
+		fs.Set("lru-backing", test.backing)
+
+		in_memory, err := IsInMemoryCache(fs)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if in_memory != test.in_memory {
+			t.Errorf("Expected IsInMemoryCache for '%s' (backing '%s') to be %t", test.cache, test.backing, test.in_memory)
+		}
+	}
+}
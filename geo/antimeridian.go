@@ -0,0 +1,389 @@
+package geo
+
+import (
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/geometry"
+	"math"
+)
+
+// NormalizeCoord returns 'c' with its longitude wrapped in to the range -180 to 180 so that, for
+// example, a longitude of 190 becomes -170.
+
+func NormalizeCoord(c geom.Coord) geom.Coord {
+
+	if c.X >= -180.0 && c.X <= 180.0 {
+		return c
+	}
+
+	x := math.Mod(c.X+180.0, 360.0)
+
+	if x < 0.0 {
+		x += 360.0
+	}
+
+	return geom.Coord{X: x - 180.0, Y: c.Y}
+}
+
+// SplitSegment returns the segment a-b as a list of segments none of which cross the antimeridian.
+// A segment whose longitudes are more than 180 degrees apart is assumed to take the short way round,
+// across the antimeridian, and is split in two where it crosses it. Both ends are normalized (see
+// NormalizeCoord) first.
+
+func SplitSegment(a geom.Coord, b geom.Coord) [][2]geom.Coord {
+
+	a = NormalizeCoord(a)
+	b = NormalizeCoord(b)
+
+	dx := b.X - a.X
+
+	if math.Abs(dx) <= 180.0 {
+		return [][2]geom.Coord{{a, b}}
+	}
+
+	// the longitude of the antimeridian on the same side as 'a' and the
+	// longitude of 'b' unwrapped so that it is on the other side of it
+
+	edge := 180.0
+	bx := b.X + 360.0
+
+	if dx > 0.0 {
+		edge = -180.0
+		bx = b.X - 360.0
+	}
+
+	t := (edge - a.X) / (bx - a.X)
+	y := a.Y + (b.Y-a.Y)*t
+
+	return [][2]geom.Coord{
+		{a, geom.Coord{X: edge, Y: y}},
+		{geom.Coord{X: -edge, Y: y}, b},
+	}
+}
+
+// SplitRect returns 'r' as a list of bounding boxes none of which cross the antimeridian. A bounding
+// box whose minimum longitude is greater than its maximum longitude is assumed to cross it (the same as
+// a GeoJSON bbox) and is split in two, one on either side of it.
+
+func SplitRect(r geom.Rect) []geom.Rect {
+
+	if r.Min.X <= r.Max.X {
+		return []geom.Rect{r}
+	}
+
+	west := geom.Rect{
+		Min: r.Min,
+		Max: geom.Coord{X: 180.0, Y: r.Max.Y},
+	}
+
+	east := geom.Rect{
+		Min: geom.Coord{X: -180.0, Y: r.Min.Y},
+		Max: r.Max,
+	}
+
+	return []geom.Rect{west, east}
+}
+
+// NormalizePolygons returns 'polys' with any polygons that cross the antimeridian (that is, they have an
+// edge whose longitudes are more than 180 degrees apart) split in to two polygons, one on each side of it,
+// and any polygons with longitudes outside the range -180 to 180 shifted back in to that range. Polygons
+// that go all the way round one of the poles are closed along the pole. The second return value is true
+// if any of the polygons were changed.
+
+func NormalizePolygons(polys []geojson.Polygon) ([]geojson.Polygon, bool) {
+
+	normalized := make([]geojson.Polygon, 0)
+	changed := false
+
+	for _, p := range polys {
+
+		if !needsNormalizing(p) {
+			normalized = append(normalized, p)
+			continue
+		}
+
+		normalized = append(normalized, normalizePolygon(p)...)
+		changed = true
+	}
+
+	return normalized, changed
+}
+
+func needsNormalizing(p geojson.Polygon) bool {
+
+	for _, ring := range Rings(p) {
+
+		vertices := ring.Vertices()
+		count := len(vertices)
+
+		for i := 0; i < count; i++ {
+
+			a := vertices[i]
+			b := vertices[(i+1)%count]
+
+			if a.X < -180.0 || a.X > 180.0 {
+				return true
+			}
+
+			// edges along a pole (for example the southern edge of Antarctica
+			// which runs from 180 to -180 at -90) or from one side of the map to
+			// the other (the way RFC 7946 says to draw polygons that span every
+			// longitude) don't cross the antimeridian
+
+			if math.Abs(a.Y) == 90.0 && a.Y == b.Y {
+				continue
+			}
+
+			if math.Abs(a.X) == 180.0 && math.Abs(b.X) == 180.0 {
+				continue
+			}
+
+			if math.Abs(b.X-a.X) > 180.0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func normalizePolygon(p geojson.Polygon) []geojson.Polygon {
+
+	ext_ring := p.ExteriorRing()
+	exterior := unwrapRing(ext_ring.Vertices())
+
+	// shift the exterior ring so that its western edge is in the range -180 to 180 and
+	// then shift each interior ring so that it is within 180 degrees of the exterior ring
+
+	min_x, max_x := rangeX(exterior)
+	shift := -360.0 * math.Floor((min_x+180.0)/360.0)
+
+	exterior = shiftRing(exterior, shift)
+	min_x += shift
+	max_x += shift
+
+	center_x := (min_x + max_x) / 2.0
+
+	interior := make([][]geom.Coord, 0)
+
+	for _, ring := range p.InteriorRings() {
+
+		vertices := unwrapRing(ring.Vertices())
+
+		i_min, i_max := rangeX(vertices)
+		i_center := (i_min + i_max) / 2.0
+
+		interior = append(interior, shiftRing(vertices, -360.0*math.Round((i_center-center_x)/360.0)))
+	}
+
+	if max_x <= 180.0 {
+		return []geojson.Polygon{newClippedPolygon(exterior, interior)}
+	}
+
+	polys := make([]geojson.Polygon, 0)
+
+	for _, west := range []bool{true, false} {
+
+		ext := clipRing(exterior, 180.0, west)
+
+		// skip anything that only touches the antimeridian
+
+		if len(ext) < 3 {
+			continue
+		}
+
+		ext_min, ext_max := rangeX(ext)
+
+		if ext_min == ext_max {
+			continue
+		}
+
+		holes := make([][]geom.Coord, 0)
+
+		for _, ring := range interior {
+
+			clipped := clipRing(ring, 180.0, west)
+
+			if len(clipped) >= 3 {
+				holes = append(holes, clipped)
+			}
+		}
+
+		if !west {
+
+			ext = shiftRing(ext, -360.0)
+
+			for i, ring := range holes {
+				holes[i] = shiftRing(ring, -360.0)
+			}
+		}
+
+		polys = append(polys, newClippedPolygon(ext, holes))
+	}
+
+	return polys
+}
+
+// unwrapRing returns the vertices of a ring with their longitudes shifted, by multiples of 360 degrees,
+// so that no edge is more than 180 degrees wide. If the unwrapped ring doesn't close (because it goes all
+// the way round a pole) it is closed along that pole.
+
+func unwrapRing(vertices []geom.Coord) []geom.Coord {
+
+	count := len(vertices)
+
+	if count == 0 {
+		return vertices
+	}
+
+	unwrapped := make([]geom.Coord, count)
+	unwrapped[0] = vertices[0]
+
+	sum_y := vertices[0].Y
+
+	for i := 1; i < count; i++ {
+
+		prev := unwrapped[i-1]
+		c := vertices[i]
+
+		dx := c.X - vertices[i-1].X
+		dx -= 360.0 * math.Round(dx/360.0)
+
+		unwrapped[i] = geom.Coord{X: prev.X + dx, Y: c.Y}
+		sum_y += c.Y
+	}
+
+	// the ring may or may not repeat its first vertex at the end
+
+	first := vertices[0]
+	last := unwrapped[count-1]
+
+	dx := first.X - vertices[count-1].X
+	dx -= 360.0 * math.Round(dx/360.0)
+
+	winding := (last.X + dx) - first.X
+
+	if math.Abs(winding) < 180.0 {
+		return unwrapped
+	}
+
+	pole := 90.0
+
+	if sum_y < 0.0 {
+		pole = -90.0
+	}
+
+	close_x := first.X + winding
+
+	if last.X != close_x || last.Y != first.Y {
+		unwrapped = append(unwrapped, geom.Coord{X: close_x, Y: first.Y})
+	}
+
+	unwrapped = append(unwrapped, geom.Coord{X: close_x, Y: pole})
+	unwrapped = append(unwrapped, geom.Coord{X: first.X, Y: pole})
+
+	return unwrapped
+}
+
+// clipRing returns the part of a ring to the west (or east) of the longitude 'x' using the
+// Sutherland-Hodgman algorithm
+
+func clipRing(vertices []geom.Coord, x float64, west bool) []geom.Coord {
+
+	inside := func(c geom.Coord) bool {
+
+		if west {
+			return c.X <= x
+		}
+
+		return c.X >= x
+	}
+
+	crossing := func(a geom.Coord, b geom.Coord) geom.Coord {
+		t := (x - a.X) / (b.X - a.X)
+		return geom.Coord{X: x, Y: a.Y + (b.Y-a.Y)*t}
+	}
+
+	clipped := make([]geom.Coord, 0)
+	count := len(vertices)
+
+	add := func(c geom.Coord) {
+
+		last := len(clipped) - 1
+
+		if last >= 0 && clipped[last] == c {
+			return
+		}
+
+		clipped = append(clipped, c)
+	}
+
+	for i := 0; i < count; i++ {
+
+		c := vertices[i]
+		prev := vertices[(i+count-1)%count]
+
+		if inside(c) {
+
+			if !inside(prev) {
+				add(crossing(prev, c))
+			}
+
+			add(c)
+
+		} else if inside(prev) {
+			add(crossing(prev, c))
+		}
+	}
+
+	return clipped
+}
+
+func shiftRing(vertices []geom.Coord, dx float64) []geom.Coord {
+
+	shifted := make([]geom.Coord, len(vertices))
+
+	for i, c := range vertices {
+		shifted[i] = geom.Coord{X: c.X + dx, Y: c.Y}
+	}
+
+	return shifted
+}
+
+func rangeX(vertices []geom.Coord) (float64, float64) {
+
+	min_x := math.Inf(1)
+	max_x := math.Inf(-1)
+
+	for _, c := range vertices {
+		min_x = math.Min(min_x, c.X)
+		max_x = math.Max(max_x, c.X)
+	}
+
+	return min_x, max_x
+}
+
+func newClippedPolygon(exterior []geom.Coord, interior [][]geom.Coord) geojson.Polygon {
+
+	newRing := func(vertices []geom.Coord) geom.Polygon {
+
+		ring := geom.Polygon{}
+
+		for _, c := range vertices {
+			ring.AddVertex(c)
+		}
+
+		return ring
+	}
+
+	holes := make([]geom.Polygon, 0)
+
+	for _, vertices := range interior {
+		holes = append(holes, newRing(vertices))
+	}
+
+	return geometry.Polygon{
+		Exterior: newRing(exterior),
+		Interior: holes,
+	}
+}
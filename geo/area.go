@@ -0,0 +1,371 @@
+package geo
+
+import (
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"math"
+	"sort"
+)
+
+// EARTH_RADIUS is the mean radius of the earth, in meters
+
+const EARTH_RADIUS float64 = 6371008.8
+
+// COLLINEAR_TOLERANCE is how far (in degrees) a point can be from a line and still be considered
+// to be on it when comparing the edges of two polygons
+
+const COLLINEAR_TOLERANCE float64 = 1e-9
+
+// Overlap describes how much of a (feature's) set of polygons is covered by a query geometry
+
+type Overlap struct {
+	Area    float64 // in square meters
+	Percent float64 // the overlapping area as a percentage of the total area of the feature's polygons
+}
+
+// PolygonsOverlap calculates the area shared by 'polys' and 'g'. Areas are calculated in
+// planar (degree) space and then scaled to square meters using an equirectangular projection
+// centered on the overlapping polygons which is more than good enough for polygons that aren't
+// the size of continents. Line geometries have no area and always return an empty Overlap.
+
+func PolygonsOverlap(polys []geojson.Polygon, g *Geometry) Overlap {
+
+	var shared float64
+	var total float64
+
+	bounds := geom.NilRect()
+
+	for _, p := range polys {
+
+		total += math.Abs(PolygonArea(p))
+
+		for _, other := range g.Polygons {
+
+			a := intersectionArea(p, other)
+
+			if a <= 0.0 {
+				continue
+			}
+
+			shared += a
+
+			ext := p.ExteriorRing()
+			other_ext := other.ExteriorRing()
+
+			bounds.ExpandToContainRect(geom.RectsIntersection(*ext.Bounds(), *other_ext.Bounds()))
+		}
+	}
+
+	o := Overlap{}
+
+	if shared <= 0.0 {
+		return o
+	}
+
+	o.Area = SquareDegreesToMeters(shared, bounds.Center().Y)
+
+	if total > 0.0 {
+		o.Percent = math.Min(100.0, (shared/total)*100.0)
+	}
+
+	return o
+}
+
+// PolygonArea returns the planar area of a polygon, less its interior rings, in square degrees
+
+func PolygonArea(p geojson.Polygon) float64 {
+
+	area := math.Abs(ringArea(p.ExteriorRing().Path))
+
+	for _, int := range p.InteriorRings() {
+		area -= math.Abs(ringArea(int.Path))
+	}
+
+	return area
+}
+
+// SquareDegreesToMeters converts an area in square degrees to square meters at a given latitude
+
+func SquareDegreesToMeters(area float64, latitude float64) float64 {
+
+	m := EARTH_RADIUS * math.Pi / 180.0
+	return area * m * m * math.Cos(latitude*math.Pi/180.0)
+}
+
+// BoundingBoxArea returns the area, in square meters, of the bounding box 'min_x', 'min_y', 'max_x', 'max_y'
+// on a sphere. This is exact (for a sphere) rather than an approximation since the sides of a bounding box
+// follow lines of latitude and longitude. A bounding box whose minimum longitude is greater than its maximum
+// longitude crosses the antimeridian.
+
+func BoundingBoxArea(min_x float64, min_y float64, max_x float64, max_y float64) float64 {
+
+	width := max_x - min_x
+
+	if width < 0.0 {
+		width += 360.0
+	}
+
+	rad := math.Pi / 180.0
+	return EARTH_RADIUS * EARTH_RADIUS * (width * rad) * math.Abs(math.Sin(max_y*rad)-math.Sin(min_y*rad))
+}
+
+func ringArea(path geom.Path) float64 {
+
+	vertices := path.Vertices()
+	count := len(vertices)
+
+	var area float64
+
+	for i := 0; i < count; i++ {
+		a := vertices[i]
+		b := vertices[(i+1)%count]
+		area += (a.X * b.Y) - (b.X * a.Y)
+	}
+
+	return area / 2.0
+}
+
+// intersectionArea calculates the area of the intersection of two polygons by integrating around
+// its boundary, which is made up of the parts of each polygon's boundary that fall inside the other
+// polygon (Green's theorem). Exterior rings are walked counter-clockwise and interior rings clockwise
+// so that holes are subtracted. This works for arbitrary (non-convex, holey) simple polygons without
+// having to build the actual intersection geometry. Parts of the two boundaries that exactly coincide
+// (for example two neighbourhoods that share an edge) are counted once if they run in the same
+// direction, since they are on the boundary of the intersection, and not at all otherwise.
+
+func intersectionArea(a geojson.Polygon, b geojson.Polygon) float64 {
+
+	a_ext := a.ExteriorRing()
+	b_ext := b.ExteriorRing()
+
+	if !geom.RectsIntersect(*a_ext.Bounds(), *b_ext.Bounds()) {
+		return 0.0
+	}
+
+	area := boundaryIntegral(a, b, true) + boundaryIntegral(b, a, false)
+	return math.Max(0.0, area)
+}
+
+// boundaryIntegral sums the parts of the boundary of 'a' that are inside 'b'. If 'shared' is true the
+// parts that coincide with the boundary of 'b', running in the same direction, are included too.
+
+func boundaryIntegral(a geojson.Polygon, b geojson.Polygon, shared bool) float64 {
+
+	var sum float64
+
+	b_ext := b.ExteriorRing()
+	b_bounds := b_ext.Bounds()
+
+	b_rings := make([][]geom.Coord, 0)
+
+	for idx, ring := range Rings(b) {
+		b_rings = append(b_rings, orientedVertices(ring.Path, idx == 0))
+	}
+
+	for idx, ring := range Rings(a) {
+
+		vertices := orientedVertices(ring.Path, idx == 0)
+		count := len(vertices)
+
+		for i := 0; i < count; i++ {
+
+			p1 := vertices[i]
+			p2 := vertices[(i+1)%count]
+
+			if p1.EqualsCoord(p2) {
+				continue
+			}
+
+			edge_bounds := geom.Rect{
+				Min: geom.Coord{X: minf(p1.X, p2.X), Y: minf(p1.Y, p2.Y)},
+				Max: geom.Coord{X: maxf(p1.X, p2.X), Y: maxf(p1.Y, p2.Y)},
+			}
+
+			if !geom.RectsIntersect(edge_bounds, *b_bounds) {
+				continue
+			}
+
+			params := []float64{0.0, 1.0}
+
+			for _, other_vertices := range b_rings {
+
+				other_count := len(other_vertices)
+
+				for j := 0; j < other_count; j++ {
+
+					q1 := other_vertices[j]
+					q2 := other_vertices[(j+1)%other_count]
+
+					t, ok := segmentIntersectionParameter(p1, p2, q1, q2)
+
+					if ok {
+						params = append(params, t)
+						continue
+					}
+
+					params = append(params, collinearParameters(p1, p2, q1, q2)...)
+				}
+			}
+
+			sort.Float64s(params)
+
+			for k := 0; k < len(params)-1; k++ {
+
+				t1 := params[k]
+				t2 := params[k+1]
+
+				if t2-t1 <= 1e-12 {
+					continue
+				}
+
+				s1 := interpolate(p1, p2, t1)
+				s2 := interpolate(p1, p2, t2)
+
+				mid := interpolate(p1, p2, (t1+t2)/2.0)
+
+				on_boundary, same_direction := ringsEdgeAt(b_rings, mid, p2.Minus(p1))
+
+				if on_boundary {
+
+					if !shared || !same_direction {
+						continue
+					}
+
+				} else if !PolygonContainsCoord(b, mid) {
+					continue
+				}
+
+				sum += ((s1.X * s2.Y) - (s2.X * s1.Y)) / 2.0
+			}
+		}
+	}
+
+	return sum
+}
+
+// orientedVertices returns the vertices of a ring counter-clockwise if 'ccw' is true
+// or clockwise if it is false
+
+func orientedVertices(path geom.Path, ccw bool) []geom.Coord {
+
+	vertices := path.Vertices()
+	is_ccw := ringArea(path) > 0.0
+
+	if is_ccw == ccw {
+		return vertices
+	}
+
+	count := len(vertices)
+	reversed := make([]geom.Coord, count)
+
+	for i, c := range vertices {
+		reversed[count-1-i] = c
+	}
+
+	return reversed
+}
+
+// segmentIntersectionParameter returns the position (0 - 1) along p1-p2 where it crosses q1-q2
+
+func segmentIntersectionParameter(p1 geom.Coord, p2 geom.Coord, q1 geom.Coord, q2 geom.Coord) (float64, bool) {
+
+	r := p2.Minus(p1)
+	s := q2.Minus(q1)
+
+	denom := geom.CrossProduct(r, s)
+
+	if denom == 0.0 {
+		return 0.0, false
+	}
+
+	qp := q1.Minus(p1)
+
+	t := geom.CrossProduct(qp, s) / denom
+	u := geom.CrossProduct(qp, r) / denom
+
+	if t < 0.0 || t > 1.0 || u < 0.0 || u > 1.0 {
+		return 0.0, false
+	}
+
+	return t, true
+}
+
+// collinearParameters returns the positions (0 - 1) along p1-p2 of the ends of q1-q2 if the two segments
+// lie on the same line, which is where the part of them that coincides starts and stops
+
+func collinearParameters(p1 geom.Coord, p2 geom.Coord, q1 geom.Coord, q2 geom.Coord) []float64 {
+
+	params := make([]float64, 0)
+
+	r := p2.Minus(p1)
+	length := r.Magnitude()
+
+	if length == 0.0 {
+		return params
+	}
+
+	for _, q := range []geom.Coord{q1, q2} {
+
+		qp := q.Minus(p1)
+
+		if math.Abs(geom.CrossProduct(r, qp))/length > COLLINEAR_TOLERANCE {
+			return make([]float64, 0)
+		}
+
+		t := geom.DotProduct(r, qp) / (length * length)
+
+		if t > 0.0 && t < 1.0 {
+			params = append(params, t)
+		}
+	}
+
+	return params
+}
+
+// ringsEdgeAt reports whether 'c' lies on one of the edges of 'rings' and, if it does, whether that
+// edge runs in the same direction as 'direction'
+
+func ringsEdgeAt(rings [][]geom.Coord, c geom.Coord, direction geom.Coord) (bool, bool) {
+
+	for _, vertices := range rings {
+
+		count := len(vertices)
+
+		for j := 0; j < count; j++ {
+
+			q1 := vertices[j]
+			q2 := vertices[(j+1)%count]
+
+			s := q2.Minus(q1)
+			length := s.Magnitude()
+
+			if length == 0.0 {
+				continue
+			}
+
+			cq := c.Minus(q1)
+
+			if math.Abs(geom.CrossProduct(s, cq))/length > COLLINEAR_TOLERANCE {
+				continue
+			}
+
+			t := geom.DotProduct(s, cq) / (length * length)
+
+			if t < 0.0 || t > 1.0 {
+				continue
+			}
+
+			return true, geom.DotProduct(s, direction) > 0.0
+		}
+	}
+
+	return false, false
+}
+
+func interpolate(a geom.Coord, b geom.Coord, t float64) geom.Coord {
+
+	return geom.Coord{
+		X: a.X + (b.X-a.X)*t,
+		Y: a.Y + (b.Y-a.Y)*t,
+	}
+}
@@ -0,0 +1,79 @@
+package geo
+
+import (
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+)
+
+// PolygonsContainsCoord reports whether any of 'polys' contains 'c'. Like geom.Polygon.ContainsCoord it
+// casts a vertical ray from 'c' (so it gives the same answers as PreparedPolygon, whose edges are grouped
+// in to vertical bands) but an edge is only counted if 'c' is within its half-open X range, so a point
+// directly above or below a vertex is never counted twice or not at all. Points that are on a boundary
+// may be reported as being either inside or outside. This is the only point-in-polygon test that should
+// be used so that every index, and every query, gives the same answer for the same point.
+
+func PolygonsContainsCoord(polys []geojson.Polygon, c geom.Coord) bool {
+
+	for _, p := range polys {
+
+		if PolygonContainsCoord(p, c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PolygonContainsCoord is PolygonsContainsCoord for a single polygon and should be used in place of
+// the polygon's own ContainsCoord method.
+
+func PolygonContainsCoord(p geojson.Polygon, c geom.Coord) bool {
+
+	if !ringContainsCoord(p.ExteriorRing(), c) {
+		return false
+	}
+
+	for _, ring := range p.InteriorRings() {
+
+		if ringContainsCoord(ring, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func ringContainsCoord(ring geom.Polygon, c geom.Coord) bool {
+
+	vertices := ring.Vertices()
+	count := len(vertices)
+
+	inside := false
+
+	for i, j := 0, count-1; i < count; j, i = i, i+1 {
+
+		if edgeCrossesRay(vertices[i], vertices[j], c) {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// edgeCrossesRay reports whether the edge a-b crosses a vertical ray cast upwards from 'c'. The ends
+// are put in order first so that an edge gives the same answer whichever way round it is passed.
+
+func edgeCrossesRay(a geom.Coord, b geom.Coord, c geom.Coord) bool {
+
+	if (a.X > c.X) == (b.X > c.X) {
+		return false
+	}
+
+	if a.X > b.X {
+		a, b = b, a
+	}
+
+	y := a.Y + (c.X-a.X)*(b.Y-a.Y)/(b.X-a.X)
+
+	return c.Y < y
+}
@@ -0,0 +1,123 @@
+package geo
+
+import (
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"sort"
+)
+
+// crossing_epsilon is how close (as a fraction of a segment) two crossings need to be to be treated as the same one
+
+const crossing_epsilon float64 = 1e-12
+
+// PathInterval is a stretch of a path that is inside a set of polygons. Distances are measured, in
+// meters, along the path from its first vertex.
+
+type PathInterval struct {
+	Entry         geom.Coord
+	Exit          geom.Coord
+	EntryDistance float64
+	ExitDistance  float64
+}
+
+// PolygonsPathIntervals returns the stretches of 'path' that are inside any of 'polys', in the order
+// they are entered. Each segment of the path is split at every point where it crosses the edge of a
+// polygon and the midpoint of each piece is tested to decide whether that piece is inside or outside.
+// Consecutive pieces that are inside are joined in to a single interval.
+
+func PolygonsPathIntervals(polys []geojson.Polygon, path geom.Path) []*PathInterval {
+
+	intervals := make([]*PathInterval, 0)
+	vertices := path.Vertices()
+
+	if len(vertices) == 1 {
+
+		c := vertices[0]
+
+		if PolygonsContainsCoord(polys, c) {
+
+			i := PathInterval{
+				Entry: c,
+				Exit:  c,
+			}
+
+			intervals = append(intervals, &i)
+		}
+
+		return intervals
+	}
+
+	var current *PathInterval
+	distance := 0.0
+
+	for i := 0; i < len(vertices)-1; i++ {
+
+		a := vertices[i]
+		b := vertices[i+1]
+
+		length := HaversineDistance(a, b)
+
+		params := []float64{0.0, 1.0}
+
+		for _, p := range polys {
+
+			for _, ring := range Rings(p) {
+
+				edges := ring.Vertices()
+				count := len(edges)
+
+				for j := 0; j < count; j++ {
+
+					t, ok := segmentIntersectionParameter(a, b, edges[j], edges[(j+1)%count])
+
+					if ok {
+						params = append(params, t)
+					}
+				}
+			}
+		}
+
+		sort.Float64s(params)
+
+		for j := 0; j < len(params)-1; j++ {
+
+			t0 := params[j]
+			t1 := params[j+1]
+
+			if t1-t0 < crossing_epsilon {
+				continue
+			}
+
+			mid := interpolate(a, b, (t0+t1)/2.0)
+
+			if !PolygonsContainsCoord(polys, mid) {
+
+				if current != nil {
+					intervals = append(intervals, current)
+					current = nil
+				}
+
+				continue
+			}
+
+			if current == nil {
+
+				current = &PathInterval{
+					Entry:         interpolate(a, b, t0),
+					EntryDistance: distance + (length * t0),
+				}
+			}
+
+			current.Exit = interpolate(a, b, t1)
+			current.ExitDistance = distance + (length * t1)
+		}
+
+		distance += length
+	}
+
+	if current != nil {
+		intervals = append(intervals, current)
+	}
+
+	return intervals
+}
@@ -0,0 +1,194 @@
+package geo
+
+import (
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"math"
+)
+
+// MAX_DISTANCE is (roughly) half the circumference of the earth, in meters, which is as far
+// apart as any two points can be
+
+const MAX_DISTANCE float64 = math.Pi * EARTH_RADIUS
+
+// HaversineDistance returns the great circle distance between two coordinates, in meters
+
+func HaversineDistance(a geom.Coord, b geom.Coord) float64 {
+
+	lat1 := radians(a.Y)
+	lat2 := radians(b.Y)
+
+	dlat := radians(b.Y - a.Y)
+	dlon := radians(b.X - a.X)
+
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	h = math.Min(1.0, h)
+
+	return 2 * EARTH_RADIUS * math.Asin(math.Sqrt(h))
+}
+
+// PathLength returns the length of 'path' in meters.
+
+func PathLength(path geom.Path) float64 {
+
+	vertices := path.Vertices()
+	length := 0.0
+
+	for i := 0; i < len(vertices)-1; i++ {
+		length += HaversineDistance(vertices[i], vertices[i+1])
+	}
+
+	return length
+}
+
+// DistanceToSegment returns the distance, in meters, between 'c' and the closest point on the
+// segment a-b. The closest point is found using an equirectangular projection centered on 'c'
+// and the distance to it is then measured along a great circle.
+
+func DistanceToSegment(c geom.Coord, a geom.Coord, b geom.Coord) float64 {
+
+	// make sure the segment is on the same side of the antimeridian as 'c'
+	// and that it doesn't cross it on the way
+
+	a.X = unwrapLongitude(a.X, c.X)
+	b.X = unwrapLongitude(b.X, a.X)
+
+	scale := math.Cos(radians(c.Y))
+
+	ax := (a.X - c.X) * scale
+	ay := a.Y - c.Y
+
+	bx := (b.X - c.X) * scale
+	by := b.Y - c.Y
+
+	dx := bx - ax
+	dy := by - ay
+
+	t := 0.0
+	length := dx*dx + dy*dy
+
+	if length > 0.0 {
+		t = -(ax*dx + ay*dy) / length
+		t = math.Max(0.0, math.Min(1.0, t))
+	}
+
+	closest := interpolate(a, b, t)
+	return HaversineDistance(c, closest)
+}
+
+// DistanceToPolygons returns the distance, in meters, between 'c' and the nearest edge of any
+// of 'polys' or 0.0 if 'c' is contained by one of them
+
+func DistanceToPolygons(polys []geojson.Polygon, c geom.Coord) float64 {
+
+	d := math.Inf(1)
+
+	for _, p := range polys {
+
+		if PolygonContainsCoord(p, c) {
+			return 0.0
+		}
+
+		for _, ring := range Rings(p) {
+
+			vertices := ring.Vertices()
+			count := len(vertices)
+
+			for i := 0; i < count; i++ {
+
+				a := vertices[i]
+				b := vertices[(i+1)%count]
+
+				d = math.Min(d, DistanceToSegment(c, a, b))
+			}
+		}
+	}
+
+	return d
+}
+
+// BoundsForRadius returns a bounding box which contains every point within 'meters'
+// of 'c', clamped to the valid range of latitudes and longitudes
+
+func BoundsForRadius(c geom.Coord, meters float64) geom.Rect {
+
+	dlat := degrees(meters / EARTH_RADIUS)
+
+	minlat := c.Y - dlat
+	maxlat := c.Y + dlat
+
+	minlon := -180.0
+	maxlon := 180.0
+
+	// if the circle reaches either pole then every longitude is in range
+
+	if minlat > -90.0 && maxlat < 90.0 {
+
+		dlon := degrees(math.Asin(math.Min(1.0, math.Sin(meters/EARTH_RADIUS)/math.Cos(radians(c.Y)))))
+
+		if meters/EARTH_RADIUS < math.Pi/2.0 && dlon < 180.0 {
+			minlon = math.Max(-180.0, c.X-dlon)
+			maxlon = math.Min(180.0, c.X+dlon)
+		}
+	}
+
+	r := geom.Rect{
+		Min: geom.Coord{X: minlon, Y: math.Max(-90.0, minlat)},
+		Max: geom.Coord{X: maxlon, Y: math.Min(90.0, maxlat)},
+	}
+
+	return r
+}
+
+// BoundsListForRadius returns the bounding box for a circle of 'meters' around 'c', the same as
+// BoundsForRadius, along with a second bounding box for the part of the circle on the other side of
+// the antimeridian if it reaches across it.
+
+func BoundsListForRadius(c geom.Coord, meters float64) []geom.Rect {
+
+	r := BoundsForRadius(c, meters)
+	bounds := []geom.Rect{r}
+
+	if r.Min.X == -180.0 && r.Max.X == 180.0 {
+		return bounds
+	}
+
+	dlon := math.Max(c.X-r.Min.X, r.Max.X-c.X)
+
+	if c.X-dlon < -180.0 {
+
+		other := geom.Rect{
+			Min: geom.Coord{X: c.X - dlon + 360.0, Y: r.Min.Y},
+			Max: geom.Coord{X: 180.0, Y: r.Max.Y},
+		}
+
+		bounds = append(bounds, other)
+	}
+
+	if c.X+dlon > 180.0 {
+
+		other := geom.Rect{
+			Min: geom.Coord{X: -180.0, Y: r.Min.Y},
+			Max: geom.Coord{X: c.X + dlon - 360.0, Y: r.Max.Y},
+		}
+
+		bounds = append(bounds, other)
+	}
+
+	return bounds
+}
+
+// unwrapLongitude returns 'x' shifted, by multiples of 360 degrees, so that it is within 180
+// degrees of 'ref'
+
+func unwrapLongitude(x float64, ref float64) float64 {
+	return x - 360.0*math.Round((x-ref)/360.0)
+}
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180.0
+}
+
+func degrees(r float64) float64 {
+	return r * 180.0 / math.Pi
+}
@@ -0,0 +1,283 @@
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/geometry"
+	"strings"
+)
+
+// Geometry is a query geometry (as opposed to the geometry of an indexed feature) which is
+// either a set of polygons or a set of lines, derived from a GeoJSON Polygon, MultiPolygon,
+// LineString or MultiLineString geometry
+
+type Geometry struct {
+	Type     string
+	Polygons []geojson.Polygon
+	Lines    []geom.Path
+}
+
+type geojsonGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type geojsonFeature struct {
+	Type     string          `json:"type"`
+	Geometry geojsonGeometry `json:"geometry"`
+}
+
+// NewGeometryFromGeoJSON parses 'body' which may be either a GeoJSON geometry or a GeoJSON
+// Feature, in which case its geometry property is used
+
+func NewGeometryFromGeoJSON(body []byte) (*Geometry, error) {
+
+	var stub geojsonGeometry
+
+	err := json.Unmarshal(body, &stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if stub.Type == "Feature" {
+
+		var f geojsonFeature
+
+		err := json.Unmarshal(body, &f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		stub = f.Geometry
+	}
+
+	if len(stub.Coordinates) == 0 {
+		return nil, errors.New("Missing coordinates")
+	}
+
+	g := Geometry{
+		Type:     stub.Type,
+		Polygons: make([]geojson.Polygon, 0),
+		Lines:    make([]geom.Path, 0),
+	}
+
+	switch stub.Type {
+
+	case "Polygon":
+
+		var coords [][][]float64
+
+		err := json.Unmarshal(stub.Coordinates, &coords)
+
+		if err != nil {
+			return nil, err
+		}
+
+		poly, err := newPolygon(coords)
+
+		if err != nil {
+			return nil, err
+		}
+
+		g.Polygons = append(g.Polygons, poly)
+
+	case "MultiPolygon":
+
+		var coords [][][][]float64
+
+		err := json.Unmarshal(stub.Coordinates, &coords)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rings := range coords {
+
+			poly, err := newPolygon(rings)
+
+			if err != nil {
+				return nil, err
+			}
+
+			g.Polygons = append(g.Polygons, poly)
+		}
+
+	case "LineString":
+
+		var coords [][]float64
+
+		err := json.Unmarshal(stub.Coordinates, &coords)
+
+		if err != nil {
+			return nil, err
+		}
+
+		path, err := newPath(coords)
+
+		if err != nil {
+			return nil, err
+		}
+
+		g.Lines = append(g.Lines, path)
+
+	case "MultiLineString":
+
+		var coords [][][]float64
+
+		err := json.Unmarshal(stub.Coordinates, &coords)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range coords {
+
+			path, err := newPath(line)
+
+			if err != nil {
+				return nil, err
+			}
+
+			g.Lines = append(g.Lines, path)
+		}
+
+	default:
+		msg := fmt.Sprintf("Unsupported geometry type '%s'", stub.Type)
+		return nil, errors.New(msg)
+	}
+
+	return &g, nil
+}
+
+func (g *Geometry) Bounds() geom.Rect {
+
+	bounds := geom.NilRect()
+
+	for _, p := range g.Polygons {
+		ext := p.ExteriorRing()
+		bounds.ExpandToContainRect(*ext.Bounds())
+	}
+
+	for _, l := range g.Lines {
+		bounds.ExpandToContainRect(*l.Bounds())
+	}
+
+	return bounds
+}
+
+// WKT returns the geometry as a Well Known Text string suitable for passing to
+// (Spatialite) functions like GeomFromText
+
+func (g *Geometry) WKT() string {
+
+	if len(g.Polygons) > 0 {
+
+		polys := make([]string, len(g.Polygons))
+
+		for i, p := range g.Polygons {
+
+			rings := []string{wktRing(p.ExteriorRing().Path)}
+
+			for _, int := range p.InteriorRings() {
+				rings = append(rings, wktRing(int.Path))
+			}
+
+			polys[i] = fmt.Sprintf("(%s)", strings.Join(rings, ","))
+		}
+
+		return fmt.Sprintf("MULTIPOLYGON(%s)", strings.Join(polys, ","))
+	}
+
+	lines := make([]string, len(g.Lines))
+
+	for i, l := range g.Lines {
+		lines[i] = wktRing(l)
+	}
+
+	return fmt.Sprintf("MULTILINESTRING(%s)", strings.Join(lines, ","))
+}
+
+func wktRing(path geom.Path) string {
+
+	vertices := path.Vertices()
+	points := make([]string, len(vertices))
+
+	for i, c := range vertices {
+		points[i] = fmt.Sprintf("%0.6f %0.6f", c.X, c.Y)
+	}
+
+	return fmt.Sprintf("(%s)", strings.Join(points, ","))
+}
+
+func newPath(coords [][]float64) (geom.Path, error) {
+
+	path := geom.Path{}
+
+	if len(coords) < 2 {
+		return path, errors.New("A line must have at least two positions")
+	}
+
+	for _, pt := range coords {
+
+		if len(pt) < 2 {
+			return path, errors.New("Invalid position")
+		}
+
+		path.AddVertex(geom.Coord{X: pt[0], Y: pt[1]})
+	}
+
+	return path, nil
+}
+
+func newRing(coords [][]float64) (geom.Polygon, error) {
+
+	if len(coords) < 4 {
+		return geom.Polygon{}, errors.New("A linear ring must have at least four positions")
+	}
+
+	path, err := newPath(coords)
+
+	if err != nil {
+		return geom.Polygon{}, err
+	}
+
+	return geom.Polygon{Path: path}, nil
+}
+
+func newPolygon(rings [][][]float64) (geojson.Polygon, error) {
+
+	if len(rings) == 0 {
+		return nil, errors.New("A polygon must have at least one ring")
+	}
+
+	exterior, err := newRing(rings[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	interior := make([]geom.Polygon, 0)
+
+	for _, coords := range rings[1:] {
+
+		ring, err := newRing(coords)
+
+		if err != nil {
+			return nil, err
+		}
+
+		interior = append(interior, ring)
+	}
+
+	poly := geometry.Polygon{
+		Exterior: exterior,
+		Interior: interior,
+	}
+
+	return poly, nil
+}
@@ -0,0 +1,319 @@
+package geo
+
+import (
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+)
+
+func PolygonsIntersectsRect(polys []geojson.Polygon, r geom.Rect) (bool, error) {
+
+	for _, p := range polys {
+
+		if PolygonIntersectsRect(p, r) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func PolygonIntersectsRect(p geojson.Polygon, r geom.Rect) bool {
+
+	ext := p.ExteriorRing()
+
+	// the cheap tests first: is any part of the polygon's exterior ring
+	// inside the rect or is any corner of the rect inside the polygon
+
+	for _, c := range ext.Vertices() {
+
+		if r.ContainsCoord(c) {
+			return true
+		}
+	}
+
+	corners := RectVertices(r)
+
+	for _, c := range corners {
+
+		if PolygonContainsCoord(p, c) {
+			return true
+		}
+	}
+
+	// now check every edge of every ring against the sides of the rect
+	// which will catch the case where a polygon crosses a rect without
+	// having any vertices inside of it
+
+	rings := []geom.Polygon{ext}
+	rings = append(rings, p.InteriorRings()...)
+
+	for _, ring := range rings {
+
+		vertices := ring.Vertices()
+		count := len(vertices)
+
+		for i := 0; i < count; i++ {
+
+			a := vertices[i]
+			b := vertices[(i+1)%count]
+
+			for j := 0; j < 4; j++ {
+
+				if SegmentsIntersect(a, b, corners[j], corners[(j+1)%4]) {
+					return true
+				}
+			}
+		}
+	}
+
+	// the rect may still sit entirely inside an interior ring (a hole)
+	// without touching any of the polygon's edges in which case this is
+	// the correct answer
+
+	return false
+}
+
+func RectVertices(r geom.Rect) []geom.Coord {
+
+	sw := geom.Coord{X: r.Min.X, Y: r.Min.Y}
+	nw := geom.Coord{X: r.Min.X, Y: r.Max.Y}
+	ne := geom.Coord{X: r.Max.X, Y: r.Max.Y}
+	se := geom.Coord{X: r.Max.X, Y: r.Min.Y}
+
+	return []geom.Coord{sw, nw, ne, se}
+}
+
+// SegmentIntersectsRect reports whether the segment a-b touches or crosses the (closed) rect 'r'
+
+func SegmentIntersectsRect(a geom.Coord, b geom.Coord, r geom.Rect) bool {
+
+	if minf(a.X, b.X) > r.Max.X || maxf(a.X, b.X) < r.Min.X || minf(a.Y, b.Y) > r.Max.Y || maxf(a.Y, b.Y) < r.Min.Y {
+		return false
+	}
+
+	if r.ContainsCoord(a) || r.ContainsCoord(b) {
+		return true
+	}
+
+	corners := RectVertices(r)
+
+	for j := 0; j < 4; j++ {
+
+		if SegmentsIntersect(a, b, corners[j], corners[(j+1)%4]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SegmentsIntersect reports whether the segments a1-a2 and b1-b2 touch or cross
+
+func SegmentsIntersect(a1 geom.Coord, a2 geom.Coord, b1 geom.Coord, b2 geom.Coord) bool {
+
+	d1 := orientation(b1, b2, a1)
+	d2 := orientation(b1, b2, a2)
+	d3 := orientation(a1, a2, b1)
+	d4 := orientation(a1, a2, b2)
+
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+
+	if d1 == 0 && onSegment(b1, b2, a1) {
+		return true
+	}
+
+	if d2 == 0 && onSegment(b1, b2, a2) {
+		return true
+	}
+
+	if d3 == 0 && onSegment(a1, a2, b1) {
+		return true
+	}
+
+	if d4 == 0 && onSegment(a1, a2, b2) {
+		return true
+	}
+
+	return false
+}
+
+func orientation(a geom.Coord, b geom.Coord, c geom.Coord) float64 {
+	return geom.CrossProduct(b.Minus(a), c.Minus(a))
+}
+
+func onSegment(a geom.Coord, b geom.Coord, c geom.Coord) bool {
+
+	return c.X >= minf(a.X, b.X) && c.X <= maxf(a.X, b.X) && c.Y >= minf(a.Y, b.Y) && c.Y <= maxf(a.Y, b.Y)
+}
+
+func minf(a float64, b float64) float64 {
+
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func maxf(a float64, b float64) float64 {
+
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+func PolygonsIntersectsGeometry(polys []geojson.Polygon, g *Geometry) (bool, error) {
+
+	for _, p := range polys {
+
+		for _, other := range g.Polygons {
+
+			if PolygonIntersectsPolygon(p, other) {
+				return true, nil
+			}
+		}
+
+		for _, path := range g.Lines {
+
+			if PolygonIntersectsPath(p, path) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func PolygonIntersectsPolygon(a geojson.Polygon, b geojson.Polygon) bool {
+
+	a_ext := a.ExteriorRing()
+	b_ext := b.ExteriorRing()
+
+	if !geom.RectsIntersect(*a_ext.Bounds(), *b_ext.Bounds()) {
+		return false
+	}
+
+	// either polygon may contain the other outright...
+
+	for _, c := range a_ext.Vertices() {
+
+		if PolygonContainsCoord(b, c) {
+			return true
+		}
+	}
+
+	for _, c := range b_ext.Vertices() {
+
+		if PolygonContainsCoord(a, c) {
+			return true
+		}
+	}
+
+	// ...or their boundaries cross
+
+	for _, ring := range Rings(b) {
+
+		if ringsCross(Rings(a), ring.Path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PolygonsIntersectsSegment reports whether the segment a-b touches or crosses any of 'polys'
+
+func PolygonsIntersectsSegment(polys []geojson.Polygon, a geom.Coord, b geom.Coord) bool {
+
+	for _, p := range polys {
+
+		if PolygonIntersectsSegment(p, a, b) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func PolygonIntersectsSegment(p geojson.Polygon, a geom.Coord, b geom.Coord) bool {
+
+	path := geom.Path{}
+	path.AddVertex(a)
+	path.AddVertex(b)
+
+	return PolygonIntersectsPath(p, path)
+}
+
+func PolygonIntersectsPath(p geojson.Polygon, path geom.Path) bool {
+
+	ext := p.ExteriorRing()
+
+	if !geom.RectsIntersect(*ext.Bounds(), *path.Bounds()) {
+		return false
+	}
+
+	for _, c := range path.Vertices() {
+
+		if PolygonContainsCoord(p, c) {
+			return true
+		}
+	}
+
+	return ringsCross(Rings(p), path)
+}
+
+// Rings returns the exterior ring of a polygon followed by any interior rings
+
+func Rings(p geojson.Polygon) []geom.Polygon {
+
+	rings := []geom.Polygon{p.ExteriorRing()}
+	rings = append(rings, p.InteriorRings()...)
+
+	return rings
+}
+
+// ringsCross reports whether any segment in 'path' intersects any edge of 'rings'
+
+func ringsCross(rings []geom.Polygon, path geom.Path) bool {
+
+	path_bounds := path.Bounds()
+	vertices := path.Vertices()
+
+	for _, ring := range rings {
+
+		if !geom.RectsIntersect(*ring.Bounds(), *path_bounds) {
+			continue
+		}
+
+		edges := ring.Vertices()
+		count := len(edges)
+
+		for i := 0; i < count; i++ {
+
+			a := edges[i]
+			b := edges[(i+1)%count]
+
+			edge_bounds := geom.Rect{
+				Min: geom.Coord{X: minf(a.X, b.X), Y: minf(a.Y, b.Y)},
+				Max: geom.Coord{X: maxf(a.X, b.X), Y: maxf(a.Y, b.Y)},
+			}
+
+			if !geom.RectsIntersect(edge_bounds, *path_bounds) {
+				continue
+			}
+
+			for j := 0; j < len(vertices)-1; j++ {
+
+				if SegmentsIntersect(a, b, vertices[j], vertices[j+1]) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
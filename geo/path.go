@@ -0,0 +1,316 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/skelterjohn/geom"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	PATH_FORMAT_GEOJSON = "geojson"
+	PATH_FORMAT_GPX     = "gpx"
+	PATH_FORMAT_WKT     = "wkt"
+)
+
+type gpxPoint struct {
+	Latitude  float64 `xml:"lat,attr"`
+	Longitude float64 `xml:"lon,attr"`
+}
+
+type gpxDocument struct {
+	XMLName xml.Name `xml:"gpx"`
+	Tracks  []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+// PathFormats returns the list of formats that NewPathFromBytes understands
+
+func PathFormats() []string {
+	return []string{PATH_FORMAT_GEOJSON, PATH_FORMAT_GPX, PATH_FORMAT_WKT}
+}
+
+// NewPathFromBytes parses 'body' as a path in 'format' (one of the PATH_FORMAT_ constants). If 'format'
+// is empty it is guessed from the first character of 'body': '{' for GeoJSON, '<' for GPX and anything
+// else for WKT.
+
+func NewPathFromBytes(body []byte, format string) (*geom.Path, error) {
+
+	if format == "" {
+
+		trimmed := bytes.TrimSpace(body)
+
+		if len(trimmed) == 0 {
+			return nil, errors.New("Empty path")
+		}
+
+		switch trimmed[0] {
+		case '{':
+			format = PATH_FORMAT_GEOJSON
+		case '<':
+			format = PATH_FORMAT_GPX
+		default:
+			format = PATH_FORMAT_WKT
+		}
+	}
+
+	switch format {
+	case PATH_FORMAT_GEOJSON:
+		return NewPathFromGeoJSON(body)
+	case PATH_FORMAT_GPX:
+		return NewPathFromGPX(body)
+	case PATH_FORMAT_WKT:
+		return NewPathFromWKT(string(body))
+	default:
+		msg := fmt.Sprintf("Unsupported path format '%s'", format)
+		return nil, errors.New(msg)
+	}
+}
+
+// NewPathFromGeoJSON parses a GeoJSON LineString or MultiLineString geometry (or a Feature with one of
+// those geometries). The lines in a MultiLineString are joined, in order, in to a single path.
+
+func NewPathFromGeoJSON(body []byte) (*geom.Path, error) {
+
+	g, err := NewGeometryFromGeoJSON(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(g.Lines) == 0 {
+		msg := fmt.Sprintf("Unsupported geometry type '%s'", g.Type)
+		return nil, errors.New(msg)
+	}
+
+	return joinPaths(g.Lines)
+}
+
+// NewPathFromGPX parses the track points (or, if there aren't any, the route points) of a GPX
+// document. All the tracks and track segments are joined, in order, in to a single path.
+
+func NewPathFromGPX(body []byte) (*geom.Path, error) {
+
+	var doc gpxDocument
+
+	err := xml.Unmarshal(body, &doc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]geom.Path, 0)
+
+	for _, trk := range doc.Tracks {
+
+		for _, seg := range trk.Segments {
+			lines = append(lines, gpxPath(seg.Points))
+		}
+	}
+
+	if len(lines) == 0 {
+
+		for _, rte := range doc.Routes {
+			lines = append(lines, gpxPath(rte.Points))
+		}
+	}
+
+	return joinPaths(lines)
+}
+
+// NewPathFromWKT parses a WKT (or EWKT) LINESTRING or MULTILINESTRING. Any Z or M values are ignored
+// and the lines in a MULTILINESTRING are joined, in order, in to a single path.
+
+func NewPathFromWKT(wkt string) (*geom.Path, error) {
+
+	wkt = strings.TrimSpace(wkt)
+
+	// EWKT, for example SRID=4326;LINESTRING(...)
+
+	if strings.HasPrefix(strings.ToUpper(wkt), "SRID=") {
+
+		idx := strings.Index(wkt, ";")
+
+		if idx == -1 {
+			return nil, errors.New("Invalid WKT")
+		}
+
+		wkt = strings.TrimSpace(wkt[idx+1:])
+	}
+
+	idx := strings.Index(wkt, "(")
+
+	if idx == -1 {
+		return nil, errors.New("Invalid WKT")
+	}
+
+	// strip any dimension qualifiers, for example LINESTRING Z or LINESTRINGZM
+
+	tag := strings.ToUpper(strings.TrimSpace(wkt[0:idx]))
+	tag = strings.TrimSpace(strings.TrimRight(tag, "ZM "))
+
+	body := strings.TrimSpace(wkt[idx:])
+
+	lines := make([]geom.Path, 0)
+
+	switch tag {
+
+	case "LINESTRING":
+
+		coords, err := wktCoords(body)
+
+		if err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, coords)
+
+	case "MULTILINESTRING":
+
+		if !strings.HasPrefix(body, "(") || !strings.HasSuffix(body, ")") {
+			return nil, errors.New("Invalid WKT")
+		}
+
+		for _, part := range wktParts(body[1 : len(body)-1]) {
+
+			coords, err := wktCoords(part)
+
+			if err != nil {
+				return nil, err
+			}
+
+			lines = append(lines, coords)
+		}
+
+	default:
+		msg := fmt.Sprintf("Unsupported geometry type '%s'", tag)
+		return nil, errors.New(msg)
+	}
+
+	return joinPaths(lines)
+}
+
+// wktCoords parses a parenthesized list of coordinates, for example (1 2, 3 4)
+
+func wktCoords(str string) (geom.Path, error) {
+
+	path := geom.Path{}
+
+	str = strings.TrimSpace(str)
+
+	if !strings.HasPrefix(str, "(") || !strings.HasSuffix(str, ")") {
+		return path, errors.New("Invalid WKT")
+	}
+
+	for _, pt := range strings.Split(str[1:len(str)-1], ",") {
+
+		values := strings.Fields(pt)
+
+		if len(values) < 2 || len(values) > 4 {
+			return path, errors.New("Invalid WKT position")
+		}
+
+		x, err := strconv.ParseFloat(values[0], 64)
+
+		if err != nil {
+			return path, err
+		}
+
+		y, err := strconv.ParseFloat(values[1], 64)
+
+		if err != nil {
+			return path, err
+		}
+
+		path.AddVertex(geom.Coord{X: x, Y: y})
+	}
+
+	return path, nil
+}
+
+// wktParts splits a list of parenthesized lists, for example (1 2, 3 4), (5 6, 7 8), in to its
+// parts
+
+func wktParts(str string) []string {
+
+	parts := make([]string, 0)
+
+	depth := 0
+	start := 0
+
+	for i, r := range str {
+
+		switch r {
+		case '(':
+			depth += 1
+		case ')':
+			depth -= 1
+		case ',':
+
+			if depth == 0 {
+				parts = append(parts, str[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	parts = append(parts, str[start:])
+	return parts
+}
+
+func gpxPath(points []gpxPoint) geom.Path {
+
+	path := geom.Path{}
+
+	for _, pt := range points {
+		path.AddVertex(geom.Coord{X: pt.Longitude, Y: pt.Latitude})
+	}
+
+	return path
+}
+
+// joinPaths joins 'lines', in order, in to a single path and checks that every vertex is a valid
+// longitude and latitude
+
+func joinPaths(lines []geom.Path) (*geom.Path, error) {
+
+	path := geom.Path{}
+
+	for _, l := range lines {
+
+		for _, c := range l.Vertices() {
+
+			if !isValidCoord(c) {
+				msg := fmt.Sprintf("Invalid position %f, %f", c.X, c.Y)
+				return nil, errors.New(msg)
+			}
+
+			path.AddVertex(c)
+		}
+	}
+
+	if path.Length() == 0 {
+		return nil, errors.New("Path has no positions")
+	}
+
+	return &path, nil
+}
+
+func isValidCoord(c geom.Coord) bool {
+
+	if math.IsNaN(c.X) || math.IsNaN(c.Y) {
+		return false
+	}
+
+	return c.X >= -180.0 && c.X <= 180.0 && c.Y >= -90.0 && c.Y <= 90.0
+}
@@ -0,0 +1,290 @@
+package geo
+
+import (
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"math"
+)
+
+// PREPARED_EDGES_PER_BAND is the (average) number of edges in each of the bands a prepared ring is
+// divided in to
+
+const PREPARED_EDGES_PER_BAND int = 8
+
+// PreparedPolygon wraps a geojson.Polygon with an index of the edges of each of its rings so that
+// point-in-polygon tests only need to look at a handful of edges rather than all of them.
+//
+// The rings are divided in to vertical bands and every edge is assigned to each band its X range
+// overlaps. The containment test (see PolygonsContainsCoord) casts a vertical ray from the point
+// being tested and counts the edges it crosses so only the edges in the band containing that point
+// can ever be crossed. Each of those edges is tested exactly the same way as the unprepared version
+// does so the results are the same, just faster.
+
+type PreparedPolygon struct {
+	polygon  geojson.Polygon
+	exterior *preparedRing
+	interior []*preparedRing
+}
+
+type preparedRing struct {
+	min_x float64
+	max_x float64
+	width float64 // the width of each band
+	bands [][]*geom.Segment
+}
+
+// NewPreparedPolygon prepares 'p' for repeated point-in-polygon tests.
+
+func NewPreparedPolygon(p geojson.Polygon) *PreparedPolygon {
+
+	interior := make([]*preparedRing, 0)
+
+	for _, ring := range p.InteriorRings() {
+		interior = append(interior, newPreparedRing(ring))
+	}
+
+	pr := PreparedPolygon{
+		polygon:  p,
+		exterior: newPreparedRing(p.ExteriorRing()),
+		interior: interior,
+	}
+
+	return &pr
+}
+
+// PreparePolygons returns a PreparedPolygon for each of 'polys'. Only polygons with at least 'min_vertices'
+// vertices (across all of their rings) are actually prepared, smaller ones are wrapped as-is since they are
+// already fast enough to test and aren't worth the extra memory. If none of 'polys' are large enough it
+// returns nil.
+
+func PreparePolygons(polys []geojson.Polygon, min_vertices int) []*PreparedPolygon {
+
+	prepared := make([]*PreparedPolygon, len(polys))
+	count_prepared := 0
+
+	for i, p := range polys {
+
+		if countVertices(p) < min_vertices {
+			prepared[i] = &PreparedPolygon{polygon: p}
+			continue
+		}
+
+		prepared[i] = NewPreparedPolygon(p)
+		count_prepared += 1
+	}
+
+	if count_prepared == 0 {
+		return nil
+	}
+
+	return prepared
+}
+
+// PreparedPolygonsContainsCoord is the prepared equivalent of PolygonsContainsCoord.
+
+func PreparedPolygonsContainsCoord(polys []*PreparedPolygon, c geom.Coord) bool {
+
+	for _, p := range polys {
+
+		if p.ContainsCoord(c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PreparedPolygonsIntersectsSegment is the prepared equivalent of PolygonsIntersectsSegment.
+
+func PreparedPolygonsIntersectsSegment(polys []*PreparedPolygon, a geom.Coord, b geom.Coord) bool {
+
+	for _, p := range polys {
+
+		if p.IntersectsSegment(a, b) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *PreparedPolygon) Polygon() geojson.Polygon {
+	return p.polygon
+}
+
+func (p *PreparedPolygon) ContainsCoord(c geom.Coord) bool {
+
+	if p.exterior == nil {
+		return PolygonContainsCoord(p.polygon, c)
+	}
+
+	if !p.exterior.containsCoord(c) {
+		return false
+	}
+
+	for _, ring := range p.interior {
+
+		if ring.containsCoord(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IntersectsSegment reports whether the segment a-b touches or crosses the polygon, which is
+// to say that either end of the segment is inside the polygon or the segment crosses one of its
+// edges. Only the edges in the bands that the segment's X range overlaps are tested.
+
+func (p *PreparedPolygon) IntersectsSegment(a geom.Coord, b geom.Coord) bool {
+
+	if p.exterior == nil {
+		return PolygonIntersectsSegment(p.polygon, a, b)
+	}
+
+	if p.ContainsCoord(a) || p.ContainsCoord(b) {
+		return true
+	}
+
+	if p.exterior.crossesSegment(a, b) {
+		return true
+	}
+
+	for _, ring := range p.interior {
+
+		if ring.crossesSegment(a, b) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newPreparedRing(ring geom.Polygon) *preparedRing {
+
+	count := ring.Length()
+
+	min_x := math.Inf(1)
+	max_x := math.Inf(-1)
+
+	for _, v := range ring.Vertices() {
+		min_x = math.Min(min_x, v.X)
+		max_x = math.Max(max_x, v.X)
+	}
+
+	count_bands := count / PREPARED_EDGES_PER_BAND
+
+	if count_bands < 1 {
+		count_bands = 1
+	}
+
+	width := (max_x - min_x) / float64(count_bands)
+
+	if width <= 0.0 || math.IsNaN(width) {
+		count_bands = 1
+	}
+
+	pr := preparedRing{
+		min_x: min_x,
+		max_x: max_x,
+		width: width,
+		bands: make([][]*geom.Segment, count_bands),
+	}
+
+	for i := 0; i < count; i++ {
+
+		s := ring.Segment(i)
+
+		first := pr.band(math.Min(s.A.X, s.B.X))
+		last := pr.band(math.Max(s.A.X, s.B.X))
+
+		for b := first; b <= last; b++ {
+			pr.bands[b] = append(pr.bands[b], s)
+		}
+	}
+
+	return &pr
+}
+
+// band returns the index of the band that 'x' falls in, clamped to the bands that exist
+
+func (pr *preparedRing) band(x float64) int {
+
+	count := len(pr.bands)
+
+	if count == 1 {
+		return 0
+	}
+
+	b := int((x - pr.min_x) / pr.width)
+
+	if b < 0 {
+		return 0
+	}
+
+	if b >= count {
+		return count - 1
+	}
+
+	return b
+}
+
+func (pr *preparedRing) containsCoord(c geom.Coord) bool {
+
+	if c.X < pr.min_x || c.X > pr.max_x {
+		return false
+	}
+
+	// this is the same test as PolygonsContainsCoord but only for the
+	// edges whose X range overlaps the band that 'c' is in
+
+	inside := false
+
+	for _, s := range pr.bands[pr.band(c.X)] {
+
+		if edgeCrossesRay(s.A, s.B, c) {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// crossesSegment reports whether the segment a-b touches or crosses any of the ring's edges
+
+func (pr *preparedRing) crossesSegment(a geom.Coord, b geom.Coord) bool {
+
+	min_x := math.Min(a.X, b.X)
+	max_x := math.Max(a.X, b.X)
+
+	if max_x < pr.min_x || min_x > pr.max_x {
+		return false
+	}
+
+	first := pr.band(min_x)
+	last := pr.band(max_x)
+
+	for i := first; i <= last; i++ {
+
+		for _, s := range pr.bands[i] {
+
+			if SegmentsIntersect(a, b, s.A, s.B) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func countVertices(p geojson.Polygon) int {
+
+	ext := p.ExteriorRing()
+	count := ext.Length()
+
+	for _, ring := range p.InteriorRings() {
+		count += ring.Length()
+	}
+
+	return count
+}
@@ -10,6 +10,7 @@ require (
 	github.com/skelterjohn/geom v0.0.0-20180103142417-96f3e8a219c5
 	github.com/tidwall/gjson v1.14.0
 	github.com/tidwall/sjson v1.2.4
+	github.com/twpayne/go-geom v1.4.1
 	github.com/whosonfirst/go-http-mapzenjs v0.0.0-20190722193037-4ed59e932ab4
 	github.com/whosonfirst/go-http-rewrite v0.0.0-20170922163152-18b1c50538dd
 	github.com/whosonfirst/go-whosonfirst-flags v0.4.3
@@ -0,0 +1,161 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	geojson_utils "github.com/whosonfirst/go-whosonfirst-geojson-v2/utils"
+	wof_index "github.com/whosonfirst/go-whosonfirst-index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/utils"
+	gohttp "net/http"
+	"strconv"
+	"strings"
+)
+
+type BoundingBoxHandlerOptions struct {
+	EnableGeoJSON bool
+}
+
+func NewDefaultBoundingBoxHandlerOptions() *BoundingBoxHandlerOptions {
+
+	opts := BoundingBoxHandlerOptions{
+		EnableGeoJSON: false,
+	}
+
+	return &opts
+}
+
+func BoundingBoxHandler(i index.Index, idx *wof_index.Indexer, opts *BoundingBoxHandlerOptions) (gohttp.Handler, error) {
+
+	fn := func(rsp gohttp.ResponseWriter, req *gohttp.Request) {
+
+		if idx.IsIndexing() {
+			gohttp.Error(rsp, "indexing records", gohttp.StatusServiceUnavailable)
+			return
+		}
+
+		query := req.URL.Query()
+
+		str_bbox := query.Get("bbox")
+		str_format := query.Get("format")
+
+		if str_format == "geojson" && !opts.EnableGeoJSON {
+			gohttp.Error(rsp, "Invalid format", gohttp.StatusBadRequest)
+			return
+		}
+
+		if str_bbox == "" {
+			gohttp.Error(rsp, "Missing 'bbox' parameter", gohttp.StatusBadRequest)
+			return
+		}
+
+		// bbox is expected to be minx,miny,maxx,maxy (or swlon,swlat,nelon,nelat)
+		// which is the same ordering as a GeoJSON bbox property
+
+		parts := strings.Split(str_bbox, ",")
+
+		if len(parts) != 4 {
+			gohttp.Error(rsp, "Invalid 'bbox' parameter", gohttp.StatusBadRequest)
+			return
+		}
+
+		coords := make([]float64, 4)
+
+		for j, str_c := range parts {
+
+			c, err := strconv.ParseFloat(strings.Trim(str_c, " "), 64)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+				return
+			}
+
+			coords[j] = c
+		}
+
+		minlon := coords[0]
+		minlat := coords[1]
+		maxlon := coords[2]
+		maxlat := coords[3]
+
+		err := validateBoundingBox(minlat, minlon, maxlat, maxlon)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+
+		bbox, err := geojson_utils.NewRectFromLatLons(minlat, minlon, maxlat, maxlon)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+
+		filters, err := filter.NewSPRFilterFromQuery(query)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+
+		results, err := i.GetIntersectsByBoundingBoxContext(req.Context(), bbox, filters)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), queryStatus(err))
+			return
+		}
+
+		var final interface{}
+		final = results
+
+		if str_format == "geojson" {
+
+			collection, err := utils.ResultsToFeatureCollection(results, i)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
+				return
+			}
+
+			final = collection
+		}
+
+		js, err := json.Marshal(final)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
+			return
+		}
+
+		rsp.Header().Set("Content-Type", "application/json")
+		rsp.Header().Set("Access-Control-Allow-Origin", "*")
+
+		rsp.Write(js)
+	}
+
+	h := gohttp.HandlerFunc(fn)
+	return h, nil
+}
+
+func validateBoundingBox(minlat float64, minlon float64, maxlat float64, maxlon float64) error {
+
+	if minlat < -90.0 || maxlat > 90.0 {
+		return errors.New("Invalid latitude")
+	}
+
+	if minlon < -180.0 || maxlon > 180.0 {
+		return errors.New("Invalid longitude")
+	}
+
+	if minlat > maxlat {
+		return errors.New("Minimum latitude is greater than maximum latitude")
+	}
+
+	// a minimum longitude greater than the maximum longitude is a bounding box
+	// that crosses the antimeridian (for example 170,-20,-170,-10) which the
+	// indices split in two
+
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"github.com/whosonfirst/go-whosonfirst-geojson-v2/utils"
 	wof "github.com/whosonfirst/go-whosonfirst-index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
 	pip "github.com/whosonfirst/go-whosonfirst-pip-v2/index"
 	_ "log"
 	gohttp "net/http"
@@ -55,13 +57,55 @@ func CandidatesHandler(i pip.Index, idx *wof.Indexer) (gohttp.Handler, error) {
 			return
 		}
 
-		candidates, err := i.GetCandidatesByCoord(coord)
+		filters, err := filter.NewSPRFilterFromQuery(query)
 
 		if err != nil {
-			gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
+			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+
+		candidates, err := i.GetCandidatesByCoordContext(req.Context(), coord)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), queryStatus(err))
 			return
 		}
 
+		// candidates are bounding boxes rather than places so the geometry
+		// source is the only filter that can be applied to them
+
+		features := candidates.Features[:0]
+
+		for _, f := range candidates.Features {
+
+			props, ok := f.Properties.(map[string]interface{})
+
+			if !ok {
+				features = append(features, f)
+				continue
+			}
+
+			key, _ := props["id"].(string)
+			str_id, label, is_alt := alt.ParseKey(key)
+
+			source := alt.DEFAULT_SOURCE
+
+			if is_alt {
+				source = label
+			}
+
+			if !filters.HasSource(source) {
+				continue
+			}
+
+			props["id"] = str_id
+			props["pip:source"] = source
+
+			features = append(features, f)
+		}
+
+		candidates.Features = features
+
 		enc, err := json.Marshal(candidates)
 
 		if err != nil {
@@ -0,0 +1,20 @@
+package http
+
+import (
+	"context"
+	"errors"
+	gohttp "net/http"
+)
+
+// queryStatus returns the HTTP status code to use for an error returned by an index query;
+// queries that were cancelled or ran out of time (see the -request-timeout flag) are reported
+// as 503 errors rather than 500 errors
+
+func queryStatus(err error) int {
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return gohttp.StatusServiceUnavailable
+	}
+
+	return gohttp.StatusInternalServerError
+}
@@ -0,0 +1,131 @@
+package http
+
+import (
+	"encoding/json"
+	wof_index "github.com/whosonfirst/go-whosonfirst-index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/utils"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"io/ioutil"
+	gohttp "net/http"
+)
+
+type GeometryHandlerOptions struct {
+	EnableGeoJSON bool
+	MaxBytes      int64
+}
+
+func NewDefaultGeometryHandlerOptions() *GeometryHandlerOptions {
+
+	opts := GeometryHandlerOptions{
+		EnableGeoJSON: false,
+		MaxBytes:      1024 * 1024,
+	}
+
+	return &opts
+}
+
+// GeometryHandler returns the places intersecting a GeoJSON Polygon, MultiPolygon, LineString
+// or MultiLineString geometry (or a Feature with one of those geometries) passed as the body
+// of a POST request
+
+func GeometryHandler(i index.Index, idx *wof_index.Indexer, opts *GeometryHandlerOptions) (gohttp.Handler, error) {
+
+	fn := func(rsp gohttp.ResponseWriter, req *gohttp.Request) {
+
+		if req.Method != gohttp.MethodPost {
+			gohttp.Error(rsp, "Method not allowed", gohttp.StatusMethodNotAllowed)
+			return
+		}
+
+		if idx.IsIndexing() {
+			gohttp.Error(rsp, "indexing records", gohttp.StatusServiceUnavailable)
+			return
+		}
+
+		query := req.URL.Query()
+
+		str_format := query.Get("format")
+		str_overlap := query.Get("overlap")
+
+		if str_format == "geojson" && !opts.EnableGeoJSON {
+			gohttp.Error(rsp, "Invalid format", gohttp.StatusBadRequest)
+			return
+		}
+
+		fh := gohttp.MaxBytesReader(rsp, req.Body, opts.MaxBytes)
+		defer fh.Close()
+
+		body, err := ioutil.ReadAll(fh)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+
+		g, err := geo.NewGeometryFromGeoJSON(body)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+
+		filters, err := filter.NewSPRFilterFromQuery(query)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+
+		var results spr.StandardPlacesResults
+
+		results, err = i.GetIntersectsByGeometryContext(req.Context(), g, filters)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), queryStatus(err))
+			return
+		}
+
+		if str_overlap != "" {
+
+			results, err = index.AppendOverlaps(i, results, g)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
+				return
+			}
+		}
+
+		var final interface{}
+		final = results
+
+		if str_format == "geojson" {
+
+			collection, err := utils.ResultsToFeatureCollection(results, i)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
+				return
+			}
+
+			final = collection
+		}
+
+		js, err := json.Marshal(final)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
+			return
+		}
+
+		rsp.Header().Set("Content-Type", "application/json")
+		rsp.Header().Set("Access-Control-Allow-Origin", "*")
+
+		rsp.Write(js)
+	}
+
+	h := gohttp.HandlerFunc(fn)
+	return h, nil
+}
@@ -16,12 +16,18 @@ import (
 
 type IntersectsHandlerOptions struct {
 	EnableGeoJSON bool
+	// the maximum number of results that may be requested with the 'nearest' parameter
+	MaxNearest int
+	// the default (and maximum) distance, in meters, to look for nearest results
+	MaxNearestDistance float64
 }
 
 func NewDefaultIntersectsHandlerOptions() *IntersectsHandlerOptions {
 
 	opts := IntersectsHandlerOptions{
-		EnableGeoJSON: false,
+		EnableGeoJSON:      false,
+		MaxNearest:         100,
+		MaxNearestDistance: 10000.0,
 	}
 
 	return &opts
@@ -41,6 +47,9 @@ func IntersectsHandler(i index.Index, idx *wof_index.Indexer, extras_db *databas
 		str_lat := query.Get("latitude")
 		str_lon := query.Get("longitude")
 		str_format := query.Get("format")
+		str_nearest := query.Get("nearest")
+		str_nearest_distance := query.Get("nearest_distance")
+		str_sort := query.Get("sort")
 
 		v1 := query.Get("v1")
 
@@ -80,6 +89,52 @@ func IntersectsHandler(i index.Index, idx *wof_index.Indexer, extras_db *databas
 			return
 		}
 
+		// if there are no intersecting places and the 'nearest' parameter is present return the
+		// nearest N places instead, for example coordinates just offshore or in the gaps between
+		// polygons
+
+		nearest := 0
+		nearest_distance := opts.MaxNearestDistance
+
+		if str_nearest != "" {
+
+			k, err := strconv.Atoi(str_nearest)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+				return
+			}
+
+			if k < 1 || k > opts.MaxNearest {
+				gohttp.Error(rsp, "Invalid 'nearest' parameter", gohttp.StatusBadRequest)
+				return
+			}
+
+			nearest = k
+		}
+
+		if str_nearest_distance != "" {
+
+			d, err := strconv.ParseFloat(str_nearest_distance, 64)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+				return
+			}
+
+			if d <= 0.0 || d > opts.MaxNearestDistance {
+				gohttp.Error(rsp, "Invalid 'nearest_distance' parameter", gohttp.StatusBadRequest)
+				return
+			}
+
+			nearest_distance = d
+		}
+
+		if str_sort != "" && !isValidSortOrder(str_sort) {
+			gohttp.Error(rsp, "Invalid 'sort' parameter", gohttp.StatusBadRequest)
+			return
+		}
+
 		filters, err := filter.NewSPRFilterFromQuery(query)
 
 		if err != nil {
@@ -87,13 +142,38 @@ func IntersectsHandler(i index.Index, idx *wof_index.Indexer, extras_db *databas
 			return
 		}
 
-		results, err := i.GetIntersectsByCoord(coord, filters)
+		results, err := i.GetIntersectsByCoordContext(req.Context(), coord, filters)
 
 		if err != nil {
-			gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
+			gohttp.Error(rsp, err.Error(), queryStatus(err))
 			return
 		}
 
+		if nearest > 0 && len(results.Results()) == 0 {
+
+			results, err = i.GetNearestByCoordContext(req.Context(), coord, nearest, nearest_distance, filters)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), queryStatus(err))
+				return
+			}
+		}
+
+		// results are ordered by placetype (and then ID) by default, or by distance if
+		// they are nearest results, unless another order has been asked for
+
+		if str_sort != "" {
+
+			results, err = index.SortResults(results, str_sort)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
+				return
+			}
+		}
+
+		results = index.AppendSources(results)
+
 		var final interface{}
 		final = results
 
@@ -160,3 +240,15 @@ func IntersectsHandler(i index.Index, idx *wof_index.Indexer, extras_db *databas
 	h := gohttp.HandlerFunc(fn)
 	return h, nil
 }
+
+func isValidSortOrder(order string) bool {
+
+	for _, o := range index.SortOrders() {
+
+		if o == order {
+			return true
+		}
+	}
+
+	return false
+}
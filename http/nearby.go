@@ -0,0 +1,143 @@
+package http
+
+import (
+	"encoding/json"
+	geojson_utils "github.com/whosonfirst/go-whosonfirst-geojson-v2/utils"
+	wof_index "github.com/whosonfirst/go-whosonfirst-index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/utils"
+	gohttp "net/http"
+	"strconv"
+)
+
+type NearbyHandlerOptions struct {
+	EnableGeoJSON bool
+	// the maximum radius, in meters, that may be requested
+	MaxRadius float64
+}
+
+func NewDefaultNearbyHandlerOptions() *NearbyHandlerOptions {
+
+	opts := NearbyHandlerOptions{
+		EnableGeoJSON: false,
+		MaxRadius:     50000.0,
+	}
+
+	return &opts
+}
+
+func NearbyHandler(i index.Index, idx *wof_index.Indexer, opts *NearbyHandlerOptions) (gohttp.Handler, error) {
+
+	fn := func(rsp gohttp.ResponseWriter, req *gohttp.Request) {
+
+		if idx.IsIndexing() {
+			gohttp.Error(rsp, "indexing records", gohttp.StatusServiceUnavailable)
+			return
+		}
+
+		query := req.URL.Query()
+
+		str_lat := query.Get("latitude")
+		str_lon := query.Get("longitude")
+		str_radius := query.Get("radius")
+		str_format := query.Get("format")
+
+		if str_format == "geojson" && !opts.EnableGeoJSON {
+			gohttp.Error(rsp, "Invalid format", gohttp.StatusBadRequest)
+			return
+		}
+
+		if str_lat == "" {
+			gohttp.Error(rsp, "Missing 'latitude' parameter", gohttp.StatusBadRequest)
+			return
+		}
+
+		if str_lon == "" {
+			gohttp.Error(rsp, "Missing 'longitude' parameter", gohttp.StatusBadRequest)
+			return
+		}
+
+		if str_radius == "" {
+			gohttp.Error(rsp, "Missing 'radius' parameter", gohttp.StatusBadRequest)
+			return
+		}
+
+		lat, err := strconv.ParseFloat(str_lat, 64)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+
+		lon, err := strconv.ParseFloat(str_lon, 64)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+
+		radius, err := strconv.ParseFloat(str_radius, 64)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+
+		if radius <= 0.0 || radius > opts.MaxRadius {
+			gohttp.Error(rsp, "Invalid 'radius' parameter", gohttp.StatusBadRequest)
+			return
+		}
+
+		coord, err := geojson_utils.NewCoordinateFromLatLons(lat, lon)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+
+		filters, err := filter.NewSPRFilterFromQuery(query)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+			return
+		}
+
+		results, err := i.GetIntersectsByRadiusContext(req.Context(), coord, radius, filters)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), queryStatus(err))
+			return
+		}
+
+		var final interface{}
+		final = results
+
+		if str_format == "geojson" {
+
+			collection, err := utils.ResultsToFeatureCollection(results, i)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
+				return
+			}
+
+			final = collection
+		}
+
+		js, err := json.Marshal(final)
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
+			return
+		}
+
+		rsp.Header().Set("Content-Type", "application/json")
+		rsp.Header().Set("Access-Control-Allow-Origin", "*")
+
+		rsp.Write(js)
+	}
+
+	h := gohttp.HandlerFunc(fn)
+	return h, nil
+}
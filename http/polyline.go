@@ -5,10 +5,14 @@ import (
 	"github.com/skelterjohn/geom"
 	"github.com/whosonfirst/go-whosonfirst-index"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
 	pip_index "github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/polyline"
 	pip_utils "github.com/whosonfirst/go-whosonfirst-pip-v2/utils"
 	"github.com/whosonfirst/go-whosonfirst-spr"
+	"io/ioutil"
 	_ "log"
 	"math"
 	gohttp "net/http"
@@ -23,12 +27,23 @@ type PolylineResults struct {
 	// spr.StandardPlacesResults `json:",omitempty"`
 	Rows       [][]spr.StandardPlacesResult `json:"places"`
 	Pagination pip.Pagination               `json:"pagination,omitempty"`
+	Polyline   string                       `json:"polyline,omitempty"`
 }
 
 type PolylineResultsUnique struct {
 	// spr.StandardPlacesResults `json:",omitempty"`
 	Rows       [][]spr.StandardPlacesResult `json:"places"`
 	Pagination pip.Pagination               `json:"pagination,omitempty"`
+	Polyline   string                       `json:"polyline,omitempty"`
+}
+
+// PolylineResultsCrossings are the places a path enters and leaves, grouped by placetype (see
+// index.GetCrossingsByPath)
+
+type PolylineResultsCrossings struct {
+	*pip_index.Crossings
+	Pagination pip.Pagination `json:"pagination,omitempty"`
+	Polyline   string         `json:"polyline,omitempty"`
 }
 
 // see above inre `pip` and `spr` and things left to figure out...
@@ -40,6 +55,8 @@ type PolylineResultsUnique struct {
 type PolylineHandlerOptions struct {
 	EnableGeoJSON bool
 	MaxCoords     int
+	// the maximum size, in bytes, of a path passed as the body of a POST request
+	MaxBytes int64
 }
 
 func NewDefaultPolylineHandlerOptions() *PolylineHandlerOptions {
@@ -47,11 +64,16 @@ func NewDefaultPolylineHandlerOptions() *PolylineHandlerOptions {
 	opts := PolylineHandlerOptions{
 		EnableGeoJSON: false,
 		MaxCoords:     100,
+		MaxBytes:      1024 * 1024,
 	}
 
 	return &opts
 }
 
+// PolylineHandler returns the places intersecting a path. The path is either an encoded polyline passed
+// in the 'polyline' query parameter or, for POST requests, a GeoJSON LineString or MultiLineString, a GPX
+// document or a WKT LINESTRING or MULTILINESTRING passed as the body of the request (see geo.NewPathFromBytes)
+
 func PolylineHandler(i pip_index.Index, idx *index.Indexer, opts *PolylineHandlerOptions) (gohttp.Handler, error) {
 
 	fn := func(rsp gohttp.ResponseWriter, req *gohttp.Request) {
@@ -66,6 +88,7 @@ func PolylineHandler(i pip_index.Index, idx *index.Indexer, opts *PolylineHandle
 		str_polyline := query.Get("polyline")
 		str_precision := query.Get("precision")
 		str_unique := query.Get("unique")
+		str_encode := query.Get("encode")
 		str_format := query.Get("format")
 
 		str_page := query.Get("page")
@@ -77,7 +100,12 @@ func PolylineHandler(i pip_index.Index, idx *index.Indexer, opts *PolylineHandle
 		total_count := 0
 		page_count := 1
 
-		if str_polyline == "" {
+		trailing := false
+		offset := 0.0 // the distance, in meters, from the start of the path to the start of the page
+
+		is_post := req.Method == gohttp.MethodPost
+
+		if str_polyline == "" && !is_post {
 			gohttp.Error(rsp, "Missing 'polyline' parameter", gohttp.StatusBadRequest)
 			return
 		}
@@ -127,29 +155,64 @@ func PolylineHandler(i pip_index.Index, idx *index.Indexer, opts *PolylineHandle
 		}
 
 		unique := false
-		poly_factor := 1.0e5
+		precision := polyline.DEFAULT_PRECISION
 
 		if str_precision != "" {
 
-			f, err := pip_utils.StringPrecisionToFactor(str_precision)
+			p, err := strconv.Atoi(str_precision)
+
+			if err != nil {
+				gohttp.Error(rsp, "Invalid precision value", gohttp.StatusBadRequest)
+				return
+			}
+
+			_, err = polyline.Factor(p)
 
 			if err != nil {
 				gohttp.Error(rsp, "Invalid precision value", gohttp.StatusBadRequest)
 				return
 			}
 
-			poly_factor = f
+			precision = p
 		}
 
 		if str_unique != "" {
 			unique = true
 		}
 
-		path, err := pip_utils.DecodePolyline(str_polyline, poly_factor)
+		var path *geom.Path
 
-		if err != nil {
-			gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
-			return
+		if is_post {
+
+			fh := gohttp.MaxBytesReader(rsp, req.Body, opts.MaxBytes)
+			defer fh.Close()
+
+			body, err := ioutil.ReadAll(fh)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+				return
+			}
+
+			p, err := geo.NewPathFromBytes(body, query.Get("input"))
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+				return
+			}
+
+			path = p
+
+		} else {
+
+			p, err := polyline.Decode(str_polyline, precision)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), gohttp.StatusBadRequest)
+				return
+			}
+
+			path = p
 		}
 
 		total_count = path.Length()
@@ -164,7 +227,7 @@ func PolylineHandler(i pip_index.Index, idx *index.Indexer, opts *PolylineHandle
 			// log.Println("SLICE", first, last)
 
 			if last > total_count {
-				last = total_count - 1
+				last = total_count
 			}
 
 			total_count_fl := float64(total_count)
@@ -182,13 +245,60 @@ func PolylineHandler(i pip_index.Index, idx *index.Indexer, opts *PolylineHandle
 
 			slice := geom.Path{}
 
+			if first > 0 {
+
+				previous := geom.Path{}
+
+				for _, c := range vertices[0 : first+1] {
+					previous.AddVertex(c)
+				}
+
+				offset = geo.PathLength(previous)
+			}
+
 			for _, c := range vertices[first:last] {
 				slice.AddVertex(c)
 			}
 
+			// include the first vertex of the next page so that the segment between
+			// the two pages is tested too - the results for it are dropped below
+
+			if last < total_count {
+				slice.AddVertex(vertices[last])
+				trailing = true
+			}
+
 			path = &slice
 		}
 
+		// the (page of the) path as an encoded polyline, for example so that a path
+		// that was POST-ed as GPX can be passed to later GET requests
+
+		encoded := ""
+
+		if str_encode != "" {
+
+			page_path := geom.Path{}
+
+			for k, c := range path.Vertices() {
+
+				if trailing && k == path.Length()-1 {
+					break
+				}
+
+				page_path.AddVertex(c)
+			}
+
+			e, err := polyline.Encode(page_path, precision)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
+				return
+			}
+
+			encoded = e
+		}
+
 		pagination := pip.Pagination{
 			TotalCount: total_count,
 			Page:       page,
@@ -203,71 +313,60 @@ func PolylineHandler(i pip_index.Index, idx *index.Indexer, opts *PolylineHandle
 			return
 		}
 
-		results, err := i.GetIntersectsByPath(*path, filters)
-
-		if err != nil {
-			gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
-			return
-		}
-
-		p_rows := make([][]spr.StandardPlacesResult, 0)
+		var final interface{}
 
-		for _, rs := range results {
+		if str_format == "crossings" {
 
-			rows := make([]spr.StandardPlacesResult, 0)
+			crossings, err := pip_index.GetCrossingsByPath(req.Context(), i, *path, filters)
 
-			for _, r := range rs.Results() {
-				rows = append(rows, r)
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), queryStatus(err))
+				return
 			}
 
-			p_rows = append(p_rows, rows)
-		}
+			crossings.Offset(offset)
 
-		p_results := PolylineResults{
-			Rows:       p_rows,
-			Pagination: pagination,
-		}
-
-		var final interface{}
-		final = p_results
+			for _, rows := range crossings.Placetypes {
 
-		if unique {
-
-			rows := make([]spr.StandardPlacesResult, 0)
-			seen := make(map[string]bool)
+				for _, cr := range rows {
+					r := pip_index.NewExtendedPlacesResult(cr.Place)
+					r.Properties["pip:source"] = alt.Source(cr.Place)
+					cr.Place = r
+				}
+			}
 
-			for _, rs := range results {
+			final = &PolylineResultsCrossings{
+				Crossings:  crossings,
+				Pagination: pagination,
+				Polyline:   encoded,
+			}
 
-				for _, r := range rs.Results() {
+		} else if unique {
 
-					id := r.Id()
+			// a single list of all the places the path touches or crosses
 
-					_, ok := seen[id]
+			results, err := i.GetIntersectsByPathAggregateContext(req.Context(), *path, filters)
 
-					if ok {
-						continue
-					}
-
-					rows = append(rows, r)
-					seen[id] = true
-				}
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), queryStatus(err))
+				return
 			}
 
-			p_rows := [][]spr.StandardPlacesResult{rows}
+			results = pip_index.AppendSources(results)
+
+			p_rows := [][]spr.StandardPlacesResult{results.Results()}
 
 			unq := PolylineResultsUnique{
 				Rows:       p_rows,
 				Pagination: pagination,
+				Polyline:   encoded,
 			}
 
 			final = &unq
-		}
-
-		if str_format == "geojson" {
 
-			if unique {
+			if str_format == "geojson" {
 
-				collection, err := pip_utils.ResultsToFeatureCollection(final.(spr.StandardPlacesResults), i)
+				collection, err := pip_utils.ResultsToFeatureCollection(results, i)
 
 				if err != nil {
 					gohttp.Error(rsp, err.Error(), gohttp.StatusInternalServerError)
@@ -276,8 +375,40 @@ func PolylineHandler(i pip_index.Index, idx *index.Indexer, opts *PolylineHandle
 
 				collection.Pagination = pagination
 				final = collection
+			}
+
+		} else {
+
+			// one list of places for each vertex in the path
+
+			results, err := i.GetIntersectsByPathContext(req.Context(), *path, filters)
+
+			if err != nil {
+				gohttp.Error(rsp, err.Error(), queryStatus(err))
+				return
+			}
+
+			if trailing && len(results) > 0 {
+				results = results[0 : len(results)-1]
+			}
+
+			p_rows := make([][]spr.StandardPlacesResult, 0)
+
+			for k, rs := range results {
+
+				results[k] = pip_index.AppendSources(rs)
+				p_rows = append(p_rows, results[k].Results())
+			}
+
+			p_results := PolylineResults{
+				Rows:       p_rows,
+				Pagination: pagination,
+				Polyline:   encoded,
+			}
+
+			final = p_results
 
-			} else {
+			if str_format == "geojson" {
 
 				collections := make([]*pip.GeoJSONFeatureCollection, 0)
 
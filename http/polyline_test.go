@@ -0,0 +1,382 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/conformance"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	pip_index "github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/polyline"
+	"io"
+	"math"
+	gohttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testPolylinePath runs east along latitude 5 across the conformance fixtures: it starts and
+// ends inside 1001 and the segment between the second and third vertices crosses 1004, so with
+// two vertices per page that segment is the one between the first and second pages
+
+func testPolylinePath() geom.Path {
+
+	path := geom.Path{}
+
+	for _, x := range []float64{4.0, 4.5, 5.5, 6.0, 6.5} {
+		path.AddVertex(geom.Coord{X: x, Y: 5.0})
+	}
+
+	return path
+}
+
+type testPolylineResults struct {
+	Rows       [][]map[string]interface{} `json:"places"`
+	Pagination struct {
+		TotalCount int `json:"total_count"`
+		Page       int `json:"page"`
+		PerPage    int `json:"per_page"`
+		PageCount  int `json:"page_count"`
+	} `json:"pagination"`
+	Polyline string `json:"polyline"`
+}
+
+func newTestPolylineHandler(t *testing.T) gohttp.Handler {
+
+	c_opts, err := cache.DefaultGoCacheOptions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cache.NewGoCache(c_opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := pip_index.NewRTreeIndex(c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fx := range conformance.Fixtures() {
+
+		f, err := fx.NewFeature()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = i.IndexFeature(f)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := PolylineHandler(i, &index.Indexer{}, NewDefaultPolylineHandlerOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+// polylineRequest sends a request with the query string 'query' (and, if it isn't nil, the body 'body'
+// as a POST request) to 'h' and decodes the response in to 'rsp'
+
+func polylineRequest(t *testing.T, h gohttp.Handler, query string, body io.Reader, rsp interface{}) {
+
+	method := gohttp.MethodGet
+
+	if body != nil {
+		method = gohttp.MethodPost
+	}
+
+	req := httptest.NewRequest(method, "/polyline?"+query, body)
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != gohttp.StatusOK {
+		t.Fatalf("Expected 200 for %s %s, got %d (%s)", method, query, rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+
+	err := json.Unmarshal(rec.Body.Bytes(), rsp)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encodeTestPolyline(t *testing.T, path geom.Path) string {
+
+	str_polyline, err := polyline.Encode(path, polyline.DEFAULT_PRECISION)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return url.QueryEscape(str_polyline)
+}
+
+// rowIds returns the sorted, comma-separated IDs of each row of places
+
+func rowIds(rows [][]map[string]interface{}) []string {
+
+	ids := make([]string, len(rows))
+
+	for i, places := range rows {
+
+		row := make([]string, len(places))
+
+		for j, pl := range places {
+			row[j] = fmt.Sprintf("%v", pl["wof:id"])
+		}
+
+		sort.Strings(row)
+		ids[i] = strings.Join(row, ",")
+	}
+
+	return ids
+}
+
+// each page is tested with the first vertex of the next page so that the segment between the two
+// pages isn't missed, but only the vertices on the page itself are returned
+
+func TestPolylineHandlerPages(t *testing.T) {
+
+	h := newTestPolylineHandler(t)
+	str_polyline := encodeTestPolyline(t, testPolylinePath())
+
+	tests := []struct {
+		page     int
+		vertices string
+		unique   string
+	}{
+		{1, "1001 1001", "1001,1004"},
+		{2, "1001 1001", "1001"},
+		// the last page has no next page so there is no trailing vertex
+		{3, "1001", "1001"},
+	}
+
+	for _, test := range tests {
+
+		query := fmt.Sprintf("polyline=%s&per_page=2&page=%d", str_polyline, test.page)
+
+		var rsp testPolylineResults
+		polylineRequest(t, h, query, nil, &rsp)
+
+		if rsp.Pagination.TotalCount != 5 || rsp.Pagination.PageCount != 3 || rsp.Pagination.Page != test.page {
+			t.Errorf("Unexpected pagination for page %d, %+v", test.page, rsp.Pagination)
+		}
+
+		vertices := strings.Join(rowIds(rsp.Rows), " ")
+
+		if vertices != test.vertices {
+			t.Errorf("Expected [%s] for the vertices on page %d, got [%s]", test.vertices, test.page, vertices)
+		}
+
+		var unique testPolylineResults
+		polylineRequest(t, h, query+"&unique=1", nil, &unique)
+
+		str_unique := strings.Join(rowIds(unique.Rows), " ")
+
+		if str_unique != test.unique {
+			t.Errorf("Expected [%s] for the unique places on page %d, got [%s]", test.unique, test.page, str_unique)
+		}
+	}
+}
+
+type testCrossingPoint struct {
+	Longitude float64 `json:"longitude"`
+	Distance  float64 `json:"distance"`
+}
+
+type testCrossingsResults struct {
+	Placetypes map[string][]struct {
+		Place map[string]interface{} `json:"place"`
+		Entry testCrossingPoint      `json:"entry"`
+		Exit  testCrossingPoint      `json:"exit"`
+	} `json:"placetypes"`
+}
+
+// crossings are measured from the start of the whole path, not the page, and the segment to
+// the next page is included so that the exit from one page is the entry to the next
+
+func TestPolylineHandlerCrossingsPages(t *testing.T) {
+
+	h := newTestPolylineHandler(t)
+
+	path := testPolylinePath()
+	str_polyline := encodeTestPolyline(t, path)
+
+	// the length of a path through 'vertices'
+
+	distanceTo := func(vertices ...geom.Coord) float64 {
+
+		p := geom.Path{}
+
+		for _, c := range vertices {
+			p.AddVertex(c)
+		}
+
+		return geo.PathLength(p)
+	}
+
+	v := path.Vertices()
+
+	tests := []struct {
+		page      int
+		placetype string
+		id        string
+		entry     testCrossingPoint
+		exit      testCrossingPoint
+	}{
+		{1, "region", "1001", testCrossingPoint{4.0, 0.0}, testCrossingPoint{5.5, distanceTo(v[0:3]...)}},
+		{1, "neighbourhood", "1004", testCrossingPoint{4.9, distanceTo(v[0], geom.Coord{X: 4.9, Y: 5.0})}, testCrossingPoint{5.1, distanceTo(v[0], geom.Coord{X: 5.1, Y: 5.0})}},
+		{2, "region", "1001", testCrossingPoint{5.5, distanceTo(v[0:3]...)}, testCrossingPoint{6.5, distanceTo(v...)}},
+	}
+
+	pages := make(map[int]*testCrossingsResults)
+
+	for _, test := range tests {
+
+		rsp, ok := pages[test.page]
+
+		if !ok {
+
+			query := fmt.Sprintf("polyline=%s&per_page=2&page=%d&format=crossings", str_polyline, test.page)
+
+			rsp = &testCrossingsResults{}
+			polylineRequest(t, h, query, nil, rsp)
+
+			pages[test.page] = rsp
+		}
+
+		crossings := rsp.Placetypes[test.placetype]
+
+		if len(crossings) != 1 || fmt.Sprintf("%v", crossings[0].Place["wof:id"]) != test.id {
+			t.Errorf("Expected a single crossing of %s on page %d, got %v", test.id, test.page, crossings)
+			continue
+		}
+
+		for label, points := range map[string][2]testCrossingPoint{"entry": {test.entry, crossings[0].Entry}, "exit": {test.exit, crossings[0].Exit}} {
+
+			expected := points[0]
+			actual := points[1]
+
+			if math.Abs(expected.Longitude-actual.Longitude) > 1e-6 || math.Abs(expected.Distance-actual.Distance) > 1.0 {
+				t.Errorf("Expected the %s of %s on page %d to be %+v, got %+v", label, test.id, test.page, expected, actual)
+			}
+		}
+	}
+
+	if len(pages[2].Placetypes["neighbourhood"]) != 0 {
+		t.Errorf("Expected no neighbourhoods on page 2, got %v", pages[2].Placetypes["neighbourhood"])
+	}
+}
+
+// a path POST-ed in any format should be paginated exactly the same way as the same path passed as
+// an encoded polyline
+
+func TestPolylineHandlerPostPages(t *testing.T) {
+
+	h := newTestPolylineHandler(t)
+
+	path := testPolylinePath()
+	str_polyline := encodeTestPolyline(t, path)
+
+	geojson_coords := make([]string, 0)
+	gpx_points := make([]string, 0)
+	wkt_coords := make([]string, 0)
+
+	for _, c := range path.Vertices() {
+		geojson_coords = append(geojson_coords, fmt.Sprintf("[%f,%f]", c.X, c.Y))
+		gpx_points = append(gpx_points, fmt.Sprintf(`<trkpt lat="%f" lon="%f"></trkpt>`, c.Y, c.X))
+		wkt_coords = append(wkt_coords, fmt.Sprintf("%f %f", c.X, c.Y))
+	}
+
+	bodies := map[string]string{
+		"geojson": fmt.Sprintf(`{"type":"LineString","coordinates":[%s]}`, strings.Join(geojson_coords, ",")),
+		"gpx":     fmt.Sprintf(`<gpx><trk><trkseg>%s</trkseg></trk></gpx>`, strings.Join(gpx_points, "")),
+		"wkt":     fmt.Sprintf(`LINESTRING(%s)`, strings.Join(wkt_coords, ",")),
+	}
+
+	for page := 1; page <= 3; page++ {
+
+		for _, unique := range []string{"", "&unique=1"} {
+
+			query := fmt.Sprintf("per_page=2&page=%d%s", page, unique)
+
+			var expected testPolylineResults
+			polylineRequest(t, h, "polyline="+str_polyline+"&"+query, nil, &expected)
+
+			str_expected := strings.Join(rowIds(expected.Rows), " ")
+
+			for format, body := range bodies {
+
+				var actual testPolylineResults
+				polylineRequest(t, h, query, strings.NewReader(body), &actual)
+
+				str_actual := strings.Join(rowIds(actual.Rows), " ")
+
+				if str_actual != str_expected {
+					t.Errorf("Expected [%s] for %s POST-ed as %s, got [%s]", str_expected, query, format, str_actual)
+				}
+
+				if actual.Pagination != expected.Pagination {
+					t.Errorf("Expected pagination %+v for %s POST-ed as %s, got %+v", expected.Pagination, query, format, actual.Pagination)
+				}
+			}
+		}
+	}
+}
+
+// the 'encode' parameter returns the vertices on the page, without the first vertex of the next page
+
+func TestPolylineHandlerEncodePages(t *testing.T) {
+
+	h := newTestPolylineHandler(t)
+
+	path := testPolylinePath()
+	vertices := path.Vertices()
+
+	body := "LINESTRING(4.0 5.0,4.5 5.0,5.5 5.0,6.0 5.0,6.5 5.0)"
+
+	tests := []struct {
+		page     int
+		vertices []geom.Coord
+	}{
+		{1, vertices[0:2]},
+		{2, vertices[2:4]},
+		{3, vertices[4:5]},
+	}
+
+	for _, test := range tests {
+
+		var rsp testPolylineResults
+		polylineRequest(t, h, fmt.Sprintf("per_page=2&page=%d&encode=1", test.page), strings.NewReader(body), &rsp)
+
+		decoded, err := polyline.Decode(rsp.Polyline, polyline.DEFAULT_PRECISION)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := fmt.Sprintf("%v", decoded.Vertices())
+		expected := fmt.Sprintf("%v", test.vertices)
+
+		if actual != expected {
+			t.Errorf("Expected the polyline for page %d to be %s, got %s", test.page, expected, actual)
+		}
+	}
+}
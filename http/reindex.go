@@ -0,0 +1,35 @@
+package http
+
+import (
+	gohttp "net/http"
+)
+
+// ReindexHandler starts rebuilding the index (by calling 'reindex') in response to a POST request.
+// The 'reindex' function is expected to return immediately and to return an error if the index can
+// not be rebuilt, for example because it is already being rebuilt.
+
+func ReindexHandler(reindex func() error) (gohttp.Handler, error) {
+
+	fn := func(rsp gohttp.ResponseWriter, req *gohttp.Request) {
+
+		if req.Method != gohttp.MethodPost {
+			gohttp.Error(rsp, "Method not allowed", gohttp.StatusMethodNotAllowed)
+			return
+		}
+
+		err := reindex()
+
+		if err != nil {
+			gohttp.Error(rsp, err.Error(), gohttp.StatusConflict)
+			return
+		}
+
+		rsp.Header().Set("Content-Type", "text/plain")
+		rsp.WriteHeader(gohttp.StatusAccepted)
+
+		rsp.Write([]byte("REINDEXING"))
+	}
+
+	h := gohttp.HandlerFunc(fn)
+	return h, nil
+}
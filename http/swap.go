@@ -0,0 +1,111 @@
+package http
+
+import (
+	gohttp "net/http"
+	"strconv"
+	"sync"
+)
+
+// the name of the HTTP header that reports the index generation that served a request
+
+const GENERATION_HEADER string = "X-WOF-PIP-Generation"
+
+type generation struct {
+	id      int64
+	handler gohttp.Handler
+	close   func() error
+	pending *sync.WaitGroup
+}
+
+// SwappableHandler hands requests off to the handler for the current index "generation" and
+// allows a new generation (typically the same handlers bound to a freshly built index) to be
+// swapped in without interrupting requests that are already being served.
+
+type SwappableHandler struct {
+	gohttp.Handler
+	mu      *sync.RWMutex
+	current *generation
+}
+
+// NewSwappableHandler returns a new SwappableHandler whose first generation is 'h'. The
+// (optional) 'close' function is called once 'h' has been swapped out and every request it
+// was serving has completed.
+
+func NewSwappableHandler(h gohttp.Handler, close func() error) *SwappableHandler {
+
+	g := generation{
+		id:      1,
+		handler: h,
+		close:   close,
+		pending: new(sync.WaitGroup),
+	}
+
+	s := SwappableHandler{
+		mu:      new(sync.RWMutex),
+		current: &g,
+	}
+
+	return &s
+}
+
+// Generation returns the ID of the current generation. IDs start at 1 and are incremented
+// each time a new handler is swapped in.
+
+func (s *SwappableHandler) Generation() int64 {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.current.id
+}
+
+// Swap makes 'h' the current generation and returns its ID. Requests that are already being
+// served by the previous generation are allowed to finish after which its 'close' function is
+// called (in the background).
+
+func (s *SwappableHandler) Swap(h gohttp.Handler, close func() error) int64 {
+
+	s.mu.Lock()
+
+	previous := s.current
+
+	g := generation{
+		id:      previous.id + 1,
+		handler: h,
+		close:   close,
+		pending: new(sync.WaitGroup),
+	}
+
+	s.current = &g
+
+	s.mu.Unlock()
+
+	// no new requests can be added to 'previous.pending' once it is no
+	// longer the current generation so it's safe to wait on it now
+
+	go func() {
+
+		previous.pending.Wait()
+
+		if previous.close != nil {
+			previous.close()
+		}
+	}()
+
+	return g.id
+}
+
+func (s *SwappableHandler) ServeHTTP(rsp gohttp.ResponseWriter, req *gohttp.Request) {
+
+	s.mu.RLock()
+
+	g := s.current
+	g.pending.Add(1)
+
+	s.mu.RUnlock()
+
+	defer g.pending.Done()
+
+	rsp.Header().Set(GENERATION_HEADER, strconv.FormatInt(g.id, 10))
+	g.handler.ServeHTTP(rsp, req)
+}
@@ -0,0 +1,73 @@
+package index_test
+
+import (
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/conformance"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// antimeridianFixtures are a square on either side of the antimeridian and one at Greenwich, which a
+// bounding box that crosses the antimeridian but is treated as running from its maximum to its
+// minimum longitude would (wrongly) contain
+
+func antimeridianFixtures() []*conformance.Fixture {
+
+	return []*conformance.Fixture{
+		&conformance.Fixture{Id: 4001, Name: "West", Placetype: "locality", MinX: 179.0, MinY: 0.0, Size: 0.5},
+		&conformance.Fixture{Id: 4002, Name: "East", Placetype: "locality", MinX: -179.5, MinY: 0.0, Size: 0.5},
+		&conformance.Fixture{Id: 4003, Name: "Greenwich", Placetype: "locality", MinX: 0.0, MinY: 0.0, Size: 0.5},
+	}
+}
+
+func TestGetIntersectsByBoundingBoxAntimeridian(t *testing.T) {
+
+	tests := []struct {
+		bbox     geom.Rect
+		expected string
+	}{
+		// across the antimeridian, touching both sides
+		{geom.Rect{Min: geom.Coord{X: 179.25, Y: 0.1}, Max: geom.Coord{X: -179.25, Y: 0.4}}, "4001,4002"},
+		// across the antimeridian, between the two squares
+		{geom.Rect{Min: geom.Coord{X: 179.75, Y: 0.1}, Max: geom.Coord{X: -179.75, Y: 0.4}}, ""},
+		// across the antimeridian, west side only
+		{geom.Rect{Min: geom.Coord{X: 179.25, Y: 0.1}, Max: geom.Coord{X: -179.75, Y: 0.4}}, "4001"},
+		// not across the antimeridian, everything but the gap between the two squares
+		{geom.Rect{Min: geom.Coord{X: -179.25, Y: 0.1}, Max: geom.Coord{X: 179.25, Y: 0.4}}, "4001,4002,4003"},
+	}
+
+	f, err := filter.NewSPRFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, newIndex := range testIndexes {
+
+		t.Run(name, func(t *testing.T) {
+
+			idx := newIndex(t)
+			indexFixtures(t, idx, antimeridianFixtures())
+
+			for _, test := range tests {
+
+				rs, err := idx.GetIntersectsByBoundingBox(test.bbox, f)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ids := resultIds(rs)
+				sort.Strings(ids)
+
+				str_ids := strings.Join(ids, ",")
+
+				if str_ids != test.expected {
+					t.Errorf("Expected [%s] for %v, got [%s]", test.expected, test.bbox, str_ids)
+				}
+			}
+		})
+	}
+}
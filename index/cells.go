@@ -0,0 +1,882 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-log"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"math"
+	"sync"
+)
+
+// CellIndex is an in-memory index that stores a "covering" of grid cells for every feature. The
+// grid is a quadtree over longitude and latitude: level 0 is a single cell covering the whole world
+// and every cell at level N is divided in to four cells at level N+1. Each feature is covered by a
+// set of cells, of varying sizes, that are either entirely inside its polygons ("interior" cells)
+// or that contain part of a polygon's boundary ("boundary" cells). A point that falls in an interior
+// cell is known to be inside a feature without testing its polygons at all, only points in boundary
+// cells need a point-in-polygon test.
+
+type CellIndex struct {
+	Index
+	Logger   *log.WOFLogger
+	Options  *CellIndexOptions
+	cache    cache.Cache
+	cells    map[uint64][]*cellEntry // this is a list of cell -> features covered by that cell
+	occupied map[uint64]int          // this is a count of the (covering) cells at or below a given cell
+	features map[string][]uint64     // this is a list of WOF ID -> covering cells
+	mu       *sync.RWMutex
+}
+
+type CellIndexOptions struct {
+	// the level of the smallest cells in a covering
+	MaxLevel int
+	// the (approximate) maximum number of cells in a single feature's covering
+	MaxCells int
+}
+
+type cellEntry struct {
+	Id       string
+	Interior bool
+}
+
+// CELL_MAX_LEVEL is the deepest level a CellIndex supports - cell keys are packed in to
+// a uint64 as level (5 bits) | x (29 bits) | y (29 bits)
+
+const CELL_MAX_LEVEL int = 28
+
+// cell_epsilon is how much (in degrees) cells are grown by when deciding whether they touch
+// a polygon's boundary so that points that sit exactly on a cell's edge are never misclassified
+
+const cell_epsilon float64 = 1e-9
+
+func DefaultCellIndexOptions() (*CellIndexOptions, error) {
+
+	opts := CellIndexOptions{
+		MaxLevel: 16,
+		MaxCells: 256,
+	}
+
+	return &opts, nil
+}
+
+func NewCellIndex(c cache.Cache) (*CellIndex, error) {
+
+	opts, err := DefaultCellIndexOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCellIndexWithOptions(c, opts)
+}
+
+func NewCellIndexWithOptions(c cache.Cache, opts *CellIndexOptions) (*CellIndex, error) {
+
+	if opts.MaxLevel < 0 || opts.MaxLevel > CELL_MAX_LEVEL {
+		return nil, errors.New("Invalid maximum cell level")
+	}
+
+	if opts.MaxCells < 1 {
+		return nil, errors.New("Invalid maximum number of cells")
+	}
+
+	logger := log.SimpleWOFLogger("index")
+
+	mu := new(sync.RWMutex)
+
+	i := CellIndex{
+		Logger:   logger,
+		Options:  opts,
+		cache:    c,
+		cells:    make(map[uint64][]*cellEntry),
+		occupied: make(map[uint64]int),
+		features: make(map[string][]uint64),
+		mu:       mu,
+	}
+
+	return &i, nil
+}
+
+func (i *CellIndex) Cache() cache.Cache {
+	return i.cache
+}
+
+func (i *CellIndex) Close() error {
+	return nil
+}
+
+func (i *CellIndex) IndexFeature(f geojson.Feature) error {
+
+	return i.indexFeature(f, false)
+}
+
+func (i *CellIndex) UpdateFeature(f geojson.Feature) error {
+
+	return i.indexFeature(f, true)
+}
+
+func (i *CellIndex) RemoveFeature(str_id string) error {
+
+	i.mu.Lock()
+	i.removeCells(str_id)
+	i.mu.Unlock()
+
+	return i.cache.Delete(str_id)
+}
+
+func (i *CellIndex) indexFeature(f geojson.Feature, replace bool) error {
+
+	str_id := f.Id()
+
+	fc, err := cache.NewFeatureCache(f)
+
+	if err != nil {
+		return err
+	}
+
+	covering := i.cover(fc.Polygons())
+
+	err = i.cache.Set(str_id, fc)
+
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if replace {
+		i.removeCells(str_id)
+	}
+
+	keys := make([]uint64, 0)
+
+	for key, interior := range covering {
+
+		e := cellEntry{
+			Id:       str_id,
+			Interior: interior,
+		}
+
+		i.cells[key] = append(i.cells[key], &e)
+
+		for _, k := range cellAncestors(key) {
+			i.occupied[k] += 1
+		}
+
+		keys = append(keys, key)
+	}
+
+	i.features[str_id] = append(i.features[str_id], keys...)
+	return nil
+}
+
+// removeCells deletes all the covering cells for 'str_id' - it is assumed that the caller is
+// holding the index lock
+
+func (i *CellIndex) removeCells(str_id string) {
+
+	keys, ok := i.features[str_id]
+
+	if !ok {
+		return
+	}
+
+	for _, key := range keys {
+
+		entries := make([]*cellEntry, 0)
+
+		for _, e := range i.cells[key] {
+
+			if e.Id != str_id {
+				entries = append(entries, e)
+			}
+		}
+
+		if len(entries) == 0 {
+			delete(i.cells, key)
+		} else {
+			i.cells[key] = entries
+		}
+
+		for _, k := range cellAncestors(key) {
+
+			i.occupied[k] -= 1
+
+			if i.occupied[k] <= 0 {
+				delete(i.occupied, k)
+			}
+		}
+	}
+
+	delete(i.features, str_id)
+}
+
+// cover returns the covering for 'polys' as a map of cell key -> whether or not that cell is
+// an interior cell. Starting with the smallest level whose cells are larger than the polygons'
+// bounding box each boundary cell is divided in to four smaller cells until either the cells are
+// at Options.MaxLevel or dividing them again would exceed Options.MaxCells. Only the edges that
+// touch a given cell are passed down to its children so each level gets cheaper to test.
+
+func (i *CellIndex) cover(polys []geojson.Polygon) map[uint64]bool {
+
+	covering := make(map[uint64]bool)
+
+	edges := make([]*geom.Segment, 0)
+	var bounds *geom.Rect
+
+	for _, p := range polys {
+
+		for _, ring := range geo.Rings(p) {
+
+			count := ring.Length()
+
+			for j := 0; j < count; j++ {
+				edges = append(edges, ring.Segment(j))
+			}
+
+			if count == 0 {
+				continue
+			}
+
+			if bounds == nil {
+				b := *ring.Bounds()
+				bounds = &b
+			} else {
+				bounds.ExpandToContainRect(*ring.Bounds())
+			}
+		}
+	}
+
+	if bounds == nil {
+		return covering
+	}
+
+	type pending struct {
+		key   uint64
+		edges []*geom.Segment
+	}
+
+	level := startingLevel(*bounds, i.Options.MaxLevel)
+
+	min_x, min_y := cellXY(bounds.Min, level)
+	max_x, max_y := cellXY(bounds.Max, level)
+
+	current := make([]*pending, 0)
+
+	for x := min_x; x <= max_x; x++ {
+
+		for y := min_y; y <= max_y; y++ {
+
+			key := cellKey(level, x, y)
+
+			p := pending{
+				key:   key,
+				edges: touchingEdges(edges, cellBounds(key)),
+			}
+
+			current = append(current, &p)
+		}
+	}
+
+	for len(current) > 0 {
+
+		boundary := make([]*pending, 0)
+
+		for _, p := range current {
+
+			if len(p.edges) > 0 {
+				boundary = append(boundary, p)
+				continue
+			}
+
+			// a cell that doesn't touch any boundaries is either entirely inside
+			// the polygons or entirely outside so testing its center is enough
+
+			if geo.PolygonsContainsCoord(polys, cellBounds(p.key).Center()) {
+				covering[p.key] = true
+			}
+		}
+
+		if level >= i.Options.MaxLevel || len(covering)+(len(boundary)*4) > i.Options.MaxCells {
+
+			for _, p := range boundary {
+				covering[p.key] = false
+			}
+
+			break
+		}
+
+		next := make([]*pending, 0)
+
+		for _, p := range boundary {
+
+			for _, child := range cellChildren(p.key) {
+
+				c := pending{
+					key:   child,
+					edges: touchingEdges(p.edges, cellBounds(child)),
+				}
+
+				next = append(next, &c)
+			}
+		}
+
+		current = next
+		level += 1
+	}
+
+	return covering
+}
+
+func (i *CellIndex) GetIntersectsByCoord(coord geom.Coord, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return i.GetIntersectsByCoordContext(context.Background(), coord, filters)
+}
+
+func (i *CellIndex) GetIntersectsByCoordContext(ctx context.Context, coord geom.Coord, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	coord = geo.NormalizeCoord(coord)
+
+	possible := i.getEntriesByCoord(coord)
+
+	places := make([]spr.StandardPlacesResult, 0)
+
+	for str_id, interior := range possible {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return nil, err
+		}
+
+		fc, err := i.cache.Get(str_id)
+
+		if err != nil {
+			i.Logger.Error("failed to retrieve cache for %s, because %s", str_id, err)
+			continue
+		}
+
+		s := fc.SPR()
+
+		err = filterSPR(i.cache, filters, s)
+
+		if err != nil {
+			i.Logger.Debug("SKIP %s because filter error %s", str_id, err)
+			continue
+		}
+
+		// this is the whole point of the cell index: there is nothing to test
+		// if the point is in one of the feature's interior cells
+
+		if !interior {
+
+			ok, err := polygonsContainsCoord(fc.Polygons(), coord)
+
+			if err != nil {
+				i.Logger.Error("failed to calculate intersection for %s, because %s", str_id, err)
+				continue
+			}
+
+			if !ok {
+				i.Logger.Debug("SKIP %s because it does not intersect", str_id)
+				continue
+			}
+		}
+
+		places = append(places, s)
+	}
+
+	sortPlacesByPlacetype(places)
+
+	rsp := PlacesResults{
+		Places: places,
+	}
+
+	return &rsp, nil
+}
+
+func (i *CellIndex) GetIntersectsByPath(path geom.Path, filters filter.Filter) ([]spr.StandardPlacesResults, error) {
+
+	return i.GetIntersectsByPathContext(context.Background(), path, filters)
+}
+
+func (i *CellIndex) GetIntersectsByPathContext(ctx context.Context, path geom.Path, filters filter.Filter) ([]spr.StandardPlacesResults, error) {
+
+	return getIntersectsByPathVertices(ctx, path, filters, i.GetIntersectsByCoordContext)
+}
+
+func (i *CellIndex) GetIntersectsByPathAggregate(path geom.Path, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return i.GetIntersectsByPathAggregateContext(context.Background(), path, filters)
+}
+
+func (i *CellIndex) GetIntersectsByPathAggregateContext(ctx context.Context, path geom.Path, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	results := make([]spr.StandardPlacesResults, 0)
+
+	for _, seg := range pathSegments(path) {
+
+		a := seg[0]
+		b := seg[1]
+
+		bbox := geom.Rect{
+			Min: geom.Coord{X: math.Min(a.X, b.X), Y: math.Min(a.Y, b.Y)},
+			Max: geom.Coord{X: math.Max(a.X, b.X), Y: math.Max(a.Y, b.Y)},
+		}
+
+		intersects := func(fc cache.CacheItem) (bool, error) {
+			return geo.PolygonsIntersectsSegment(fc.Polygons(), a, b), nil
+		}
+
+		rsp, err := i.inflateResultsWithFunc(ctx, filters, bbox, intersects)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, rsp)
+	}
+
+	return mergeResults(results), nil
+}
+
+func (i *CellIndex) GetIntersectsByBoundingBox(bbox geom.Rect, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return i.GetIntersectsByBoundingBoxContext(context.Background(), bbox, filters)
+}
+
+// GetIntersectsByBoundingBoxContext returns the places whose polygons intersect 'bbox'. A bounding box
+// whose minimum longitude is greater than its maximum longitude crosses the antimeridian and is queried
+// as two bounding boxes, one on either side of it, whose results are merged.
+
+func (i *CellIndex) GetIntersectsByBoundingBoxContext(ctx context.Context, bbox geom.Rect, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	bounds := geo.SplitRect(bbox)
+	results := make([]spr.StandardPlacesResults, len(bounds))
+
+	for idx, b := range bounds {
+
+		rsp, err := i.getIntersectsByBoundingBox(ctx, b, filters)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results[idx] = rsp
+	}
+
+	if len(results) == 1 {
+		return results[0], nil
+	}
+
+	return mergeResults(results), nil
+}
+
+func (i *CellIndex) getIntersectsByBoundingBox(ctx context.Context, bbox geom.Rect, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	intersects := func(fc cache.CacheItem) (bool, error) {
+		return geo.PolygonsIntersectsRect(fc.Polygons(), bbox)
+	}
+
+	return i.inflateResultsWithFunc(ctx, filters, bbox, intersects)
+}
+
+func (i *CellIndex) GetIntersectsByGeometry(g *geo.Geometry, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return i.GetIntersectsByGeometryContext(context.Background(), g, filters)
+}
+
+func (i *CellIndex) GetIntersectsByGeometryContext(ctx context.Context, g *geo.Geometry, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	intersects := func(fc cache.CacheItem) (bool, error) {
+		return geo.PolygonsIntersectsGeometry(fc.Polygons(), g)
+	}
+
+	return i.inflateResultsWithFunc(ctx, filters, g.Bounds(), intersects)
+}
+
+func (i *CellIndex) GetNearestByCoord(coord geom.Coord, k int, max_distance float64, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return i.GetNearestByCoordContext(context.Background(), coord, k, max_distance, filters)
+}
+
+func (i *CellIndex) GetNearestByCoordContext(ctx context.Context, coord geom.Coord, k int, max_distance float64, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return getNearestByCoord(ctx, i.cache, coord, k, max_distance, filters, i.getIdsByBounds)
+}
+
+func (i *CellIndex) GetIntersectsByRadius(coord geom.Coord, radius float64, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return i.GetIntersectsByRadiusContext(context.Background(), coord, radius, filters)
+}
+
+func (i *CellIndex) GetIntersectsByRadiusContext(ctx context.Context, coord geom.Coord, radius float64, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return getIntersectsByRadius(ctx, i.cache, coord, radius, filters, i.getIdsByBounds)
+}
+
+func (i *CellIndex) GetCandidatesByCoord(coord geom.Coord) (*pip.GeoJSONFeatureCollection, error) {
+
+	return i.GetCandidatesByCoordContext(context.Background(), coord)
+}
+
+// GetCandidatesByCoordContext returns the covering cells that contain 'coord' (rather than
+// bounding boxes) since those are what is used to find candidates
+
+func (i *CellIndex) GetCandidatesByCoordContext(ctx context.Context, coord geom.Coord) (*pip.GeoJSONFeatureCollection, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	coord = geo.NormalizeCoord(coord)
+
+	features := make([]pip.GeoJSONFeature, 0)
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	for level := 0; level <= i.Options.MaxLevel; level++ {
+
+		x, y := cellXY(coord, level)
+		key := cellKey(level, x, y)
+
+		_, ok := i.occupied[key]
+
+		if !ok {
+			break
+		}
+
+		b := cellBounds(key)
+
+		sw := pip.GeoJSONPoint{b.Min.X, b.Min.Y}
+		nw := pip.GeoJSONPoint{b.Min.X, b.Max.Y}
+		ne := pip.GeoJSONPoint{b.Max.X, b.Max.Y}
+		se := pip.GeoJSONPoint{b.Max.X, b.Min.Y}
+
+		ring := pip.GeoJSONRing{sw, nw, ne, se, sw}
+		poly := pip.GeoJSONPolygon{ring}
+		multi := pip.GeoJSONMultiPolygon{poly}
+
+		for _, e := range i.cells[key] {
+
+			props := map[string]interface{}{
+				"id":            e.Id,
+				"cell:level":    level,
+				"cell:interior": e.Interior,
+			}
+
+			geom := pip.GeoJSONGeometry{
+				Type:        "MultiPolygon",
+				Coordinates: multi,
+			}
+
+			feature := pip.GeoJSONFeature{
+				Type:       "Feature",
+				Properties: props,
+				Geometry:   geom,
+			}
+
+			features = append(features, feature)
+		}
+	}
+
+	fc := pip.GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: features,
+	}
+
+	return &fc, nil
+}
+
+// getEntriesByCoord returns the features whose coverings contain 'coord' as a map of WOF ID ->
+// whether or not 'coord' is in one of that feature's interior cells
+
+func (i *CellIndex) getEntriesByCoord(coord geom.Coord) map[string]bool {
+
+	possible := make(map[string]bool)
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	for level := 0; level <= i.Options.MaxLevel; level++ {
+
+		x, y := cellXY(coord, level)
+		key := cellKey(level, x, y)
+
+		_, ok := i.occupied[key]
+
+		if !ok {
+			break
+		}
+
+		for _, e := range i.cells[key] {
+			possible[e.Id] = possible[e.Id] || e.Interior
+		}
+	}
+
+	return possible
+}
+
+// getIdsByBounds returns the IDs of all the features whose coverings intersect 'bbox'
+
+func (i *CellIndex) getIdsByBounds(ctx context.Context, bbox geom.Rect) ([]string, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	ids := make([]string, 0)
+
+	var visit func(uint64)
+
+	visit = func(key uint64) {
+
+		_, ok := i.occupied[key]
+
+		if !ok {
+			return
+		}
+
+		if !geom.RectsIntersect(cellBounds(key), bbox) {
+			return
+		}
+
+		for _, e := range i.cells[key] {
+
+			_, ok := seen[e.Id]
+
+			if ok {
+				continue
+			}
+
+			seen[e.Id] = true
+			ids = append(ids, e.Id)
+		}
+
+		if cellLevel(key) < i.Options.MaxLevel {
+
+			for _, child := range cellChildren(key) {
+				visit(child)
+			}
+		}
+	}
+
+	i.mu.RLock()
+	visit(cellKey(0, 0, 0))
+	i.mu.RUnlock()
+
+	return ids, nil
+}
+
+func (i *CellIndex) inflateResultsWithFunc(ctx context.Context, f filter.Filter, bbox geom.Rect, test func(cache.CacheItem) (bool, error)) (spr.StandardPlacesResults, error) {
+
+	ids, err := i.getIdsByBounds(ctx, bbox)
+
+	if err != nil {
+		return nil, err
+	}
+
+	places := make([]spr.StandardPlacesResult, 0)
+
+	for _, str_id := range ids {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return nil, err
+		}
+
+		fc, err := i.cache.Get(str_id)
+
+		if err != nil {
+			i.Logger.Error("failed to retrieve cache for %s, because %s", str_id, err)
+			continue
+		}
+
+		s := fc.SPR()
+
+		err = filterSPR(i.cache, f, s)
+
+		if err != nil {
+			i.Logger.Debug("SKIP %s because filter error %s", str_id, err)
+			continue
+		}
+
+		ok, err := test(fc)
+
+		if err != nil {
+			i.Logger.Error("failed to calculate intersection for %s, because %s", str_id, err)
+			continue
+		}
+
+		if !ok {
+			i.Logger.Debug("SKIP %s because it does not intersect", str_id)
+			continue
+		}
+
+		places = append(places, s)
+	}
+
+	sortPlacesByPlacetype(places)
+
+	rsp := PlacesResults{
+		Places: places,
+	}
+
+	return &rsp, nil
+}
+
+// polygonsContainsCoord is the same test that the RTreeIndex uses: 'c' needs to be inside the
+// bounding box of at least one of the polygons' exterior rings and inside one of the polygons
+
+func polygonsContainsCoord(polys []geojson.Polygon, c geom.Coord) (bool, error) {
+
+	in_bounds := false
+
+	for _, p := range polys {
+
+		ext := p.ExteriorRing()
+
+		if ext.Bounds().ContainsCoord(c) {
+			in_bounds = true
+			break
+		}
+	}
+
+	if !in_bounds {
+		return false, nil
+	}
+
+	return geo.PolygonsContainsCoord(polys, c), nil
+}
+
+// touchingEdges returns the edges in 'edges' that touch 'bounds' (grown by cell_epsilon)
+
+func touchingEdges(edges []*geom.Segment, bounds geom.Rect) []*geom.Segment {
+
+	r := geom.Rect{
+		Min: geom.Coord{X: bounds.Min.X - cell_epsilon, Y: bounds.Min.Y - cell_epsilon},
+		Max: geom.Coord{X: bounds.Max.X + cell_epsilon, Y: bounds.Max.Y + cell_epsilon},
+	}
+
+	touching := make([]*geom.Segment, 0)
+
+	for _, s := range edges {
+
+		if geo.SegmentIntersectsRect(s.A, s.B, r) {
+			touching = append(touching, s)
+		}
+	}
+
+	return touching
+}
+
+// startingLevel returns the deepest level (no deeper than 'max_level') whose cells are at least
+// as large as 'bounds' so that a covering starts with no more than four cells
+
+func startingLevel(bounds geom.Rect, max_level int) int {
+
+	w := bounds.Max.X - bounds.Min.X
+	h := bounds.Max.Y - bounds.Min.Y
+
+	level := 0
+
+	for level < max_level {
+
+		cell_w := 360.0 / math.Pow(2, float64(level+1))
+		cell_h := 180.0 / math.Pow(2, float64(level+1))
+
+		if cell_w < w || cell_h < h {
+			break
+		}
+
+		level += 1
+	}
+
+	return level
+}
+
+func cellKey(level int, x uint32, y uint32) uint64 {
+	return uint64(level)<<58 | uint64(x)<<29 | uint64(y)
+}
+
+func cellLevel(key uint64) int {
+	return int(key >> 58)
+}
+
+func cellXY(c geom.Coord, level int) (uint32, uint32) {
+
+	n := math.Pow(2, float64(level))
+
+	x := math.Floor((c.X + 180.0) / 360.0 * n)
+	y := math.Floor((c.Y + 90.0) / 180.0 * n)
+
+	x = math.Max(0, math.Min(x, n-1))
+	y = math.Max(0, math.Min(y, n-1))
+
+	return uint32(x), uint32(y)
+}
+
+func cellBounds(key uint64) geom.Rect {
+
+	level := cellLevel(key)
+	x := float64((key >> 29) & (1<<29 - 1))
+	y := float64(key & (1<<29 - 1))
+
+	n := math.Pow(2, float64(level))
+
+	w := 360.0 / n
+	h := 180.0 / n
+
+	return geom.Rect{
+		Min: geom.Coord{X: -180.0 + (x * w), Y: -90.0 + (y * h)},
+		Max: geom.Coord{X: -180.0 + ((x + 1) * w), Y: -90.0 + ((y + 1) * h)},
+	}
+}
+
+func cellChildren(key uint64) []uint64 {
+
+	level := cellLevel(key)
+	x := uint32((key >> 29) & (1<<29 - 1))
+	y := uint32(key & (1<<29 - 1))
+
+	return []uint64{
+		cellKey(level+1, x*2, y*2),
+		cellKey(level+1, x*2+1, y*2),
+		cellKey(level+1, x*2, y*2+1),
+		cellKey(level+1, x*2+1, y*2+1),
+	}
+}
+
+// cellAncestors returns 'key' and all of the cells that contain it, up to level 0
+
+func cellAncestors(key uint64) []uint64 {
+
+	level := cellLevel(key)
+	x := uint32((key >> 29) & (1<<29 - 1))
+	y := uint32(key & (1<<29 - 1))
+
+	ancestors := make([]uint64, 0)
+
+	for l := level; l >= 0; l-- {
+		ancestors = append(ancestors, cellKey(l, x, y))
+		x = x / 2
+		y = y / 2
+	}
+
+	return ancestors
+}
@@ -0,0 +1,393 @@
+package index_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/conformance"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// equivalenceFixtures returns the conformance fixtures, the benchmark grid (with its IDs moved
+// out of the way) and some irregular polygons whose edges cut across lots of cells
+
+func equivalenceFixtures() []*conformance.Fixture {
+
+	fixtures := conformance.Fixtures()
+
+	for _, fx := range benchmarkFixtures() {
+		fx.Id += 100000
+		fixtures = append(fixtures, fx)
+	}
+
+	irregular := []*conformance.Fixture{
+		&conformance.Fixture{Id: 3001, Name: "Triangle", Placetype: "county", Ring: [][]float64{
+			{2.3, 3.1}, {27.9, 11.7}, {8.6, 33.3}, {2.3, 3.1},
+		}},
+		&conformance.Fixture{Id: 3002, Name: "Hook", Placetype: "county", Ring: [][]float64{
+			{12.1, 12.1}, {31.7, 12.1}, {31.7, 14.2}, {14.6, 14.2}, {14.6, 25.3}, {31.7, 25.3}, {31.7, 27.4}, {12.1, 27.4}, {12.1, 12.1},
+		}},
+		&conformance.Fixture{Id: 3003, Name: "Diagonal", Placetype: "neighbourhood", Ring: [][]float64{
+			{0.05, 0.1}, {39.9, 39.85}, {39.95, 39.9}, {0.1, 0.05}, {0.05, 0.1},
+		}},
+		&conformance.Fixture{Id: 3004, Name: "Tiny", Placetype: "microhood", Ring: [][]float64{
+			{20.001, 20.001}, {20.0013, 20.001}, {20.0013, 20.0012}, {20.001, 20.0012}, {20.001, 20.001},
+		}},
+	}
+
+	return append(fixtures, irregular...)
+}
+
+func newEquivalenceIndices(t *testing.T, opts *index.RTreeIndexOptions) (index.Index, index.Index) {
+
+	rtree_idx := newTestRTreeIndex(t, opts)
+	cells_idx := newTestCellIndex(t)
+
+	for _, fx := range equivalenceFixtures() {
+
+		for _, idx := range []index.Index{rtree_idx, cells_idx} {
+
+			f, err := fx.NewFeature()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = idx.IndexFeature(f)
+
+			if err != nil {
+				t.Fatalf("Failed to index %d, %s", fx.Id, err)
+			}
+		}
+	}
+
+	return rtree_idx, cells_idx
+}
+
+func equivalenceFilters(t *testing.T) map[string]filter.Filter {
+
+	queries := map[string]string{
+		"none":      "",
+		"placetype": "placetype=locality",
+	}
+
+	filters := make(map[string]filter.Filter)
+
+	for label, str_query := range queries {
+
+		query, err := url.ParseQuery(str_query)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := filter.NewSPRFilterFromQuery(query)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filters[label] = f
+	}
+
+	return filters
+}
+
+func equivalenceCoords() []geom.Coord {
+
+	coords := make([]geom.Coord, 0)
+
+	for x := -2.0; x <= 42.0; x += 0.53 {
+
+		for y := -2.0; y <= 42.0; y += 0.61 {
+			coords = append(coords, geom.Coord{X: x, Y: y})
+		}
+	}
+
+	for _, c := range conformance.CoordCases() {
+		coords = append(coords, geom.Coord{X: c.Coord[0], Y: c.Coord[1]})
+	}
+
+	// exactly on the edges and corners of some of the fixtures
+
+	edges := []geom.Coord{
+		{X: 10.0, Y: 10.0},
+		{X: 10.0, Y: 5.0},
+		{X: 1.0, Y: 1.0},
+		{X: 20.5, Y: 20.25},
+		{X: 20.0011, Y: 20.0011},
+		{X: 14.6, Y: 20.0},
+	}
+
+	return append(coords, edges...)
+}
+
+func equivalencePaths() []geom.Path {
+
+	paths := []geom.Path{
+		benchmarkPath(50),
+	}
+
+	for _, c := range conformance.PathCases() {
+
+		path := geom.Path{}
+
+		for _, pt := range c.Path {
+			path.AddVertex(geom.Coord{X: pt[0], Y: pt[1]})
+		}
+
+		paths = append(paths, path)
+	}
+
+	diagonal := geom.Path{}
+	diagonal.AddVertex(geom.Coord{X: -1.0, Y: 41.0})
+	diagonal.AddVertex(geom.Coord{X: 41.0, Y: -1.0})
+
+	return append(paths, diagonal)
+}
+
+func equivalenceBounds() []geom.Rect {
+
+	bounds := []geom.Rect{
+		{Min: geom.Coord{X: 0.5, Y: 0.5}, Max: geom.Coord{X: 0.6, Y: 0.6}},
+		{Min: geom.Coord{X: 13.0, Y: 15.0}, Max: geom.Coord{X: 14.0, Y: 24.0}},
+		{Min: geom.Coord{X: 20.0, Y: 20.0}, Max: geom.Coord{X: 20.001, Y: 20.001}},
+		{Min: geom.Coord{X: -10.0, Y: -10.0}, Max: geom.Coord{X: 50.0, Y: 50.0}},
+		{Min: geom.Coord{X: 45.0, Y: 45.0}, Max: geom.Coord{X: 46.0, Y: 46.0}},
+	}
+
+	for _, c := range conformance.BBoxCases() {
+
+		b := geom.Rect{
+			Min: geom.Coord{X: c.BBox[0], Y: c.BBox[1]},
+			Max: geom.Coord{X: c.BBox[2], Y: c.BBox[3]},
+		}
+
+		bounds = append(bounds, b)
+	}
+
+	return bounds
+}
+
+func equivalenceGeometries(t *testing.T) []*geo.Geometry {
+
+	geometries := []string{
+		`{"type":"Polygon","coordinates":[[[13.0,15.0],[14.0,15.0],[14.0,24.0],[13.0,24.0],[13.0,15.0]]]}`,
+		`{"type":"Polygon","coordinates":[[[5.0,5.0],[35.0,5.0],[20.0,35.0],[5.0,5.0]],[[15.0,10.0],[25.0,10.0],[20.0,20.0],[15.0,10.0]]]}`,
+		`{"type":"LineString","coordinates":[[0.5,39.5],[39.5,0.5]]}`,
+		`{"type":"MultiLineString","coordinates":[[[12.5,20.0],[13.5,20.0]],[[45.0,45.0],[46.0,46.0]]]}`,
+	}
+
+	results := make([]*geo.Geometry, len(geometries))
+
+	for i, body := range geometries {
+
+		g, err := geo.NewGeometryFromGeoJSON([]byte(body))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results[i] = g
+	}
+
+	return results
+}
+
+// compareResults fails 't' if the cells and rtree results for the query described by 'label'
+// don't contain the same places, in the same order
+
+func compareResults(t *testing.T, label string, expected spr.StandardPlacesResults, actual spr.StandardPlacesResults) {
+
+	str_expected := strings.Join(resultIds(expected), ",")
+	str_actual := strings.Join(resultIds(actual), ",")
+
+	if str_expected != str_actual {
+		t.Errorf("%s: rtree returned [%s] but cells returned [%s]", label, str_expected, str_actual)
+	}
+}
+
+// TestCellIndexMatchesRTree loads the same fixtures in to a cell index and an rtree index and checks
+// that every query method returns the same results for both. GetCandidatesByCoord isn't compared
+// because the cell index returns covering cells rather than bounding boxes.
+
+func TestCellIndexMatchesRTree(t *testing.T) {
+
+	rtree_idx, cells_idx := newEquivalenceIndices(t, nil)
+	compareIndices(t, rtree_idx, cells_idx)
+}
+
+// the fixtures are all too small to be prepared by default so do it again with every polygon prepared
+
+func TestCellIndexMatchesPreparedRTree(t *testing.T) {
+
+	opts, err := index.DefaultRTreeIndexOptions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.PrepareMinVertices = 1
+
+	rtree_idx, cells_idx := newEquivalenceIndices(t, opts)
+	compareIndices(t, rtree_idx, cells_idx)
+}
+
+func compareIndices(t *testing.T, rtree_idx index.Index, cells_idx index.Index) {
+
+	ctx := context.Background()
+
+	for filter_label, f := range equivalenceFilters(t) {
+
+		for _, c := range equivalenceCoords() {
+
+			label := fmt.Sprintf("coord %v (filter %s)", c, filter_label)
+
+			expected, err := rtree_idx.GetIntersectsByCoordContext(ctx, c, f)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := cells_idx.GetIntersectsByCoordContext(ctx, c, f)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compareResults(t, label, expected, actual)
+		}
+
+		for i, path := range equivalencePaths() {
+
+			label := fmt.Sprintf("path %d (filter %s)", i, filter_label)
+
+			expected, err := rtree_idx.GetIntersectsByPathContext(ctx, path, f)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := cells_idx.GetIntersectsByPathContext(ctx, path, f)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(expected) != len(actual) {
+				t.Errorf("%s: rtree returned %d sets of results but cells returned %d", label, len(expected), len(actual))
+				continue
+			}
+
+			for j := range expected {
+				compareResults(t, fmt.Sprintf("%s vertex %d", label, j), expected[j], actual[j])
+			}
+
+			expected_aggregate, err := rtree_idx.GetIntersectsByPathAggregateContext(ctx, path, f)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual_aggregate, err := cells_idx.GetIntersectsByPathAggregateContext(ctx, path, f)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compareResults(t, label+" aggregate", expected_aggregate, actual_aggregate)
+		}
+
+		for _, bbox := range equivalenceBounds() {
+
+			label := fmt.Sprintf("bbox %v (filter %s)", bbox, filter_label)
+
+			expected, err := rtree_idx.GetIntersectsByBoundingBoxContext(ctx, bbox, f)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := cells_idx.GetIntersectsByBoundingBoxContext(ctx, bbox, f)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compareResults(t, label, expected, actual)
+		}
+
+		for i, g := range equivalenceGeometries(t) {
+
+			label := fmt.Sprintf("geometry %d (filter %s)", i, filter_label)
+
+			expected, err := rtree_idx.GetIntersectsByGeometryContext(ctx, g, f)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := cells_idx.GetIntersectsByGeometryContext(ctx, g, f)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compareResults(t, label, expected, actual)
+		}
+
+		nearby := []geom.Coord{
+			{X: 20.5, Y: 20.5},
+			{X: 45.0, Y: 45.0},
+			{X: 179.9, Y: -10.0},
+			{X: 0.0, Y: 89.0},
+		}
+
+		for _, c := range nearby {
+
+			for _, k := range []int{1, 5, 20} {
+
+				label := fmt.Sprintf("nearest %d to %v (filter %s)", k, c, filter_label)
+
+				expected, err := rtree_idx.GetNearestByCoordContext(ctx, c, k, 0.0, f)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				actual, err := cells_idx.GetNearestByCoordContext(ctx, c, k, 0.0, f)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				compareResults(t, label, expected, actual)
+			}
+
+			for _, radius := range []float64{10.0, 50000.0, 1000000.0} {
+
+				label := fmt.Sprintf("radius %0.0fm around %v (filter %s)", radius, c, filter_label)
+
+				expected, err := rtree_idx.GetIntersectsByRadiusContext(ctx, c, radius, f)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				actual, err := cells_idx.GetIntersectsByRadiusContext(ctx, c, radius, f)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				compareResults(t, label, expected, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package index_test
+
+import (
+	"context"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/conformance"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"github.com/whosonfirst/go-whosonfirst-sqlite/database"
+	"path/filepath"
+	"testing"
+)
+
+func newTestCache(t testing.TB) cache.Cache {
+
+	opts, err := cache.DefaultGoCacheOptions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cache.NewGoCache(opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func newTestRTreeIndex(t testing.TB, opts *index.RTreeIndexOptions) index.Index {
+
+	if opts == nil {
+
+		o, err := index.DefaultRTreeIndexOptions()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts = o
+	}
+
+	idx, err := index.NewRTreeIndexWithOptions(newTestCache(t), opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return idx
+}
+
+func newTestCellIndex(t testing.TB) index.Index {
+
+	idx, err := index.NewCellIndex(newTestCache(t))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return idx
+}
+
+// newTestSpatialiteIndex skips 't' if the spatialite extension can't be loaded
+
+func newTestSpatialiteIndex(t testing.TB) index.Index {
+
+	dsn := filepath.Join(t.TempDir(), "spatialite.db")
+
+	db, err := database.NewDBWithDriver("spatialite", dsn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.LiveHardDieFast()
+
+	if err != nil {
+		db.Close()
+		t.Skipf("Unable to load spatialite, %s", err)
+	}
+
+	idx, err := index.NewSpatialiteIndex(db, newTestCache(t))
+
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		idx.Close()
+	})
+
+	return idx
+}
+
+func resultIds(rs spr.StandardPlacesResults) []string {
+
+	ids := make([]string, 0)
+
+	for _, s := range rs.Results() {
+		ids = append(ids, s.Id())
+	}
+
+	return ids
+}
+
+func runConformance(t *testing.T, idx index.Index, ignore ...string) {
+
+	failures, err := conformance.Run(context.Background(), idx)
+
+	if err != nil {
+		t.Fatalf("Failed to run conformance tests, %s", err)
+	}
+
+	for _, f := range failures {
+
+		ignored := false
+
+		for _, semantics := range ignore {
+
+			if f.Semantics == semantics {
+				ignored = true
+				break
+			}
+		}
+
+		if !ignored {
+			t.Errorf("FAIL %s", f)
+		}
+	}
+}
+
+func TestConformanceRTree(t *testing.T) {
+
+	runConformance(t, newTestRTreeIndex(t, nil))
+}
+
+func TestConformanceRTreeWithoutPreparedGeometries(t *testing.T) {
+
+	opts, err := index.DefaultRTreeIndexOptions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.PrepareGeometries = false
+
+	runConformance(t, newTestRTreeIndex(t, opts))
+}
+
+// the PathSegments option only changes aggregate path queries (to only test the vertices of a path)
+// so everything else should still conform
+
+func TestConformanceRTreeWithoutPathSegments(t *testing.T) {
+
+	opts, err := index.DefaultRTreeIndexOptions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.PathSegments = false
+
+	runConformance(t, newTestRTreeIndex(t, opts), conformance.AGGREGATE)
+}
+
+func TestConformanceCells(t *testing.T) {
+
+	runConformance(t, newTestCellIndex(t))
+}
+
+func TestConformanceSpatialite(t *testing.T) {
+
+	runConformance(t, newTestSpatialiteIndex(t))
+}
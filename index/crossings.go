@@ -0,0 +1,128 @@
+package index
+
+import (
+	"context"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"sort"
+)
+
+// CrossingPoint is the point where a path enters or leaves a place and its distance, in meters,
+// along the path
+
+type CrossingPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Distance  float64 `json:"distance"`
+}
+
+// Crossing is a single visit to a place along a path. Dwell is the distance, in meters, travelled
+// inside the place. A path that passes through the same place more than once will have a Crossing
+// for each visit.
+
+type Crossing struct {
+	Place spr.StandardPlacesResult `json:"place"`
+	Entry *CrossingPoint           `json:"entry"`
+	Exit  *CrossingPoint           `json:"exit"`
+	Dwell float64                  `json:"dwell"`
+}
+
+// Crossings are the places a path enters and leaves grouped by placetype. The crossings for each
+// placetype are ordered by the distance at which they are entered.
+
+type Crossings struct {
+	Distance   float64                `json:"distance"`
+	Placetypes map[string][]*Crossing `json:"placetypes"`
+}
+
+// GetCrossingsByPath returns the ordered sequence of places that 'path' enters and leaves. Candidate
+// places are those returned by the index's GetIntersectsByPathAggregate method and the points where
+// the path crosses each of their boundaries are calculated using the polygons stored in the index's
+// cache. Places that the path only touches, without going inside, are not included.
+
+func GetCrossingsByPath(ctx context.Context, i Index, path geom.Path, filters filter.Filter) (*Crossings, error) {
+
+	results, err := i.GetIntersectsByPathAggregateContext(ctx, path, filters)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := i.Cache()
+
+	placetypes := make(map[string][]*Crossing)
+
+	for _, s := range results.Results() {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return nil, err
+		}
+
+		fc, err := c.Get(alt.KeyForSPR(s))
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, interval := range geo.PolygonsPathIntervals(fc.Polygons(), path) {
+
+			entry := CrossingPoint{
+				Latitude:  interval.Entry.Y,
+				Longitude: interval.Entry.X,
+				Distance:  interval.EntryDistance,
+			}
+
+			exit := CrossingPoint{
+				Latitude:  interval.Exit.Y,
+				Longitude: interval.Exit.X,
+				Distance:  interval.ExitDistance,
+			}
+
+			cr := Crossing{
+				Place: s,
+				Entry: &entry,
+				Exit:  &exit,
+				Dwell: interval.ExitDistance - interval.EntryDistance,
+			}
+
+			pt := s.Placetype()
+			placetypes[pt] = append(placetypes[pt], &cr)
+		}
+	}
+
+	// results are already ordered by ID so a stable sort keeps crossings
+	// at the same distance in that order
+
+	for _, crossings := range placetypes {
+
+		sort.SliceStable(crossings, func(a int, b int) bool {
+			return crossings[a].Entry.Distance < crossings[b].Entry.Distance
+		})
+	}
+
+	rsp := Crossings{
+		Distance:   geo.PathLength(path),
+		Placetypes: placetypes,
+	}
+
+	return &rsp, nil
+}
+
+// Offset adds 'distance' to the distance of every entry and exit point, for example when 'c' was
+// calculated for one page of a longer path.
+
+func (c *Crossings) Offset(distance float64) {
+
+	for _, crossings := range c.Placetypes {
+
+		for _, cr := range crossings {
+			cr.Entry.Distance += distance
+			cr.Exit.Distance += distance
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package index
+
+import (
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+)
+
+// filterSPR returns an error if 's' doesn't pass 'f'. Alternate geometries are tested using the placetype,
+// existential flags and dates of the default geometry for the same ID, read from 'c', so an alternate
+// geometry whose default geometry isn't in the cache can't be tested and fails.
+
+func filterSPR(c cache.Cache, f filter.Filter, s spr.StandardPlacesResult) error {
+
+	source := alt.Source(s)
+
+	// there's no point looking up the default geometry for an alternate
+	// geometry that is going to fail the source test anyway
+
+	if source == alt.DEFAULT_SOURCE || !f.HasSource(source) {
+		return filter.FilterSPR(f, s)
+	}
+
+	fc, err := c.Get(s.Id())
+
+	if err != nil {
+		return fmt.Errorf("Unable to find the default geometry for %s, because %s", alt.KeyForSPR(s), err)
+	}
+
+	return filter.FilterAltSPR(f, s, fc.SPR())
+}
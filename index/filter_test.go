@@ -0,0 +1,126 @@
+package index_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/feature"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func altTestFeatures() []string {
+
+	square := `"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}`
+
+	place := func(id int, placetype string, is_current int) string {
+		return fmt.Sprintf(`{"type":"Feature","properties":{"wof:id":%d,"wof:name":"test","wof:placetype":"%s","wof:repo":"x","mz:is_current":%d,"geom:latitude":0.5,"geom:longitude":0.5,"geom:bbox":"0,0,1,1"},%s}`, id, placetype, is_current, square)
+	}
+
+	alt_place := func(id int) string {
+		return fmt.Sprintf(`{"type":"Feature","properties":{"wof:id":%d,"wof:repo":"x","src:alt_label":"quattroshapes","src:geom":"quattroshapes"},%s}`, id, square)
+	}
+
+	// 3 is an alternate geometry without a default geometry
+
+	return []string{
+		place(1, "locality", 1), alt_place(1),
+		place(2, "region", 0), alt_place(2),
+		alt_place(3),
+	}
+}
+
+func indexAltTestFeatures(t *testing.T, idx index.Index) {
+
+	for _, body := range altTestFeatures() {
+
+		f, err := feature.LoadFeature([]byte(body))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, ok := f.(*feature.WOFAltFeature)
+
+		if ok {
+
+			f, err = alt.NewAltFeature(f)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = idx.IndexFeature(f)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// the placetype and existential filters apply to alternate geometries using their default geometry
+
+func TestGetIntersectsAltFilters(t *testing.T) {
+
+	tests := map[string]string{
+		"":                            "1,2",
+		"alt=true":                    "1,1-alt-quattroshapes,2,2-alt-quattroshapes",
+		"alt=true&placetype=locality": "1,1-alt-quattroshapes",
+		"alt=true&is_current=0":       "2,2-alt-quattroshapes",
+		"source=quattroshapes":        "1-alt-quattroshapes,2-alt-quattroshapes",
+		"source=quattroshapes&placetype=region&is_current=1": "",
+	}
+
+	indices := map[string]index.Index{
+		"rtree": newTestRTreeIndex(t, nil),
+		"cells": newTestCellIndex(t),
+	}
+
+	coord := geom.Coord{X: 0.5, Y: 0.5}
+
+	for label, idx := range indices {
+
+		indexAltTestFeatures(t, idx)
+
+		for str_query, expected := range tests {
+
+			query, err := url.ParseQuery(str_query)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := filter.NewSPRFilterFromQuery(query)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rs, err := idx.GetIntersectsByCoordContext(context.Background(), coord, f)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := make([]string, 0)
+
+			for _, s := range rs.Results() {
+				keys = append(keys, alt.KeyForSPR(s))
+			}
+
+			sort.Strings(keys)
+
+			actual := strings.Join(keys, ",")
+
+			if actual != expected {
+				t.Errorf("%s: expected '%s' to return [%s], got [%s]", label, str_query, expected, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package index_test
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/conformance"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGetIntersectsByGeometry(t *testing.T) {
+
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{`{"type":"Polygon","coordinates":[[[1.5,1.5],[2.5,1.5],[2.5,2.5],[1.5,2.5],[1.5,1.5]]]}`, "1001,1002"},
+		// the same polygon as a feature
+		{`{"type":"Feature","properties":{},"geometry":{"type":"Polygon","coordinates":[[[1.5,1.5],[2.5,1.5],[2.5,2.5],[1.5,2.5],[1.5,1.5]]]}}`, "1001,1002"},
+		// 1002 is entirely inside the hole
+		{`{"type":"Polygon","coordinates":[[[0.5,0.5],[9.5,0.5],[9.5,9.5],[0.5,9.5],[0.5,0.5]],[[0.8,0.8],[3.5,0.8],[3.5,3.5],[0.8,3.5],[0.8,0.8]]]}`, "1001,1003,1004"},
+		{`{"type":"MultiPolygon","coordinates":[[[[1.5,1.5],[2.0,1.5],[2.0,2.0],[1.5,2.0],[1.5,1.5]]],[[[7.5,7.5],[8.0,7.5],[8.0,8.0],[7.5,8.0],[7.5,7.5]]]]}`, "1001,1002,1003"},
+		{`{"type":"LineString","coordinates":[[1.5,1.5],[8.0,8.0]]}`, "1001,1002,1003,1004"},
+		// neither end is inside 1004
+		{`{"type":"LineString","coordinates":[[4.8,5.0],[5.2,5.0]]}`, "1001,1004"},
+		{`{"type":"MultiLineString","coordinates":[[[11.0,11.0],[12.0,12.0]],[[7.5,7.5],[7.6,7.6]]]}`, "1001,1003"},
+		{`{"type":"LineString","coordinates":[[11.0,11.0],[12.0,12.0]]}`, ""},
+	}
+
+	f, err := filter.NewSPRFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, newIndex := range testIndexes {
+
+		t.Run(name, func(t *testing.T) {
+
+			idx := newIndex(t)
+			indexFixtures(t, idx, conformance.Fixtures())
+
+			for _, test := range tests {
+
+				g, err := geo.NewGeometryFromGeoJSON([]byte(test.body))
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				rs, err := idx.GetIntersectsByGeometry(g, f)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ids := resultIds(rs)
+				sort.Strings(ids)
+
+				str_ids := strings.Join(ids, ",")
+
+				if str_ids != test.expected {
+					t.Errorf("Expected [%s] for %s, got [%s]", test.expected, test.body, str_ids)
+				}
+			}
+		})
+	}
+}
+
+func TestGetIntersectsByGeometryInvalid(t *testing.T) {
+
+	tests := []string{
+		`{"type":"Point","coordinates":[1.0,1.0]}`,
+		`{"type":"Polygon","coordinates":[]}`,
+		`{"type":"LineString","coordinates":[[1.0,1.0]]}`,
+		`not json`,
+	}
+
+	for _, body := range tests {
+
+		_, err := geo.NewGeometryFromGeoJSON([]byte(body))
+
+		if err == nil {
+			t.Errorf("Expected %s to be an invalid query geometry", body)
+		}
+	}
+}
+
+func TestAppendOverlaps(t *testing.T) {
+
+	tests := []struct {
+		body     string
+		expected map[string]float64
+	}{
+		// exactly the same as 1002, which is 4% of 1001
+		{`{"type":"Polygon","coordinates":[[[1.0,1.0],[3.0,1.0],[3.0,3.0],[1.0,3.0],[1.0,1.0]]]}`, map[string]float64{"1001": 4.0, "1002": 100.0}},
+		// the west half of 1002, sharing three of its edges
+		{`{"type":"Polygon","coordinates":[[[1.0,1.0],[2.0,1.0],[2.0,3.0],[1.0,3.0],[1.0,1.0]]]}`, map[string]float64{"1001": 2.0, "1002": 50.0}},
+		// next to 1002, sharing its east edge
+		{`{"type":"Polygon","coordinates":[[[3.0,1.0],[4.0,1.0],[4.0,3.0],[3.0,3.0],[3.0,1.0]]]}`, map[string]float64{"1001": 2.0, "1002": 0.0}},
+	}
+
+	idx := newTestRTreeIndex(t, nil)
+	indexFixtures(t, idx, conformance.Fixtures())
+
+	f, err := filter.NewSPRFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+
+		g, err := geo.NewGeometryFromGeoJSON([]byte(test.body))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rs, err := idx.GetIntersectsByGeometry(g, f)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rs, err = index.AppendOverlaps(idx, rs, g)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results := rs.Results()
+
+		if len(results) != len(test.expected) {
+			t.Fatalf("Expected %d results for %s, got %d", len(test.expected), test.body, len(results))
+		}
+
+		for _, s := range results {
+
+			ext := s.(*index.ExtendedPlacesResult)
+
+			percent, ok := ext.Properties["pip:overlap_percent"].(float64)
+
+			if !ok {
+				t.Fatalf("Expected %s to have a 'pip:overlap_percent' property", s.Id())
+			}
+
+			// the squares are slightly different sizes on the ground
+
+			if math.Abs(percent-test.expected[s.Id()]) > 0.1 {
+				t.Errorf("Expected %s to overlap %s by %f percent, got %f", s.Id(), test.body, test.expected[s.Id()], percent)
+			}
+
+			area, ok := ext.Properties["pip:overlap_area"].(float64)
+
+			if !ok || (area > 0.0) != (percent > 0.0) {
+				t.Errorf("Expected %s to have a 'pip:overlap_area' property consistent with %f percent, got %v", s.Id(), percent, ext.Properties["pip:overlap_area"])
+			}
+		}
+	}
+}
@@ -1,21 +1,38 @@
 package index
 
 import (
+	"context"
 	"github.com/skelterjohn/geom"
 	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
 	"github.com/whosonfirst/go-whosonfirst-spr"
 )
 
 type Index interface {
 	IndexFeature(geojson.Feature) error
+	UpdateFeature(geojson.Feature) error
+	RemoveFeature(string) error
 	Cache() cache.Cache
 	Close() error
 	GetIntersectsByCoord(geom.Coord, filter.Filter) (spr.StandardPlacesResults, error)
 	GetCandidatesByCoord(geom.Coord) (*pip.GeoJSONFeatureCollection, error)
 	GetIntersectsByPath(geom.Path, filter.Filter) ([]spr.StandardPlacesResults, error)
+	GetIntersectsByPathAggregate(geom.Path, filter.Filter) (spr.StandardPlacesResults, error)
+	GetIntersectsByBoundingBox(geom.Rect, filter.Filter) (spr.StandardPlacesResults, error)
+	GetIntersectsByGeometry(*geo.Geometry, filter.Filter) (spr.StandardPlacesResults, error)
+	GetNearestByCoord(geom.Coord, int, float64, filter.Filter) (spr.StandardPlacesResults, error)
+	GetIntersectsByRadius(geom.Coord, float64, filter.Filter) (spr.StandardPlacesResults, error)
+	GetIntersectsByCoordContext(context.Context, geom.Coord, filter.Filter) (spr.StandardPlacesResults, error)
+	GetCandidatesByCoordContext(context.Context, geom.Coord) (*pip.GeoJSONFeatureCollection, error)
+	GetIntersectsByPathContext(context.Context, geom.Path, filter.Filter) ([]spr.StandardPlacesResults, error)
+	GetIntersectsByPathAggregateContext(context.Context, geom.Path, filter.Filter) (spr.StandardPlacesResults, error)
+	GetIntersectsByBoundingBoxContext(context.Context, geom.Rect, filter.Filter) (spr.StandardPlacesResults, error)
+	GetIntersectsByGeometryContext(context.Context, *geo.Geometry, filter.Filter) (spr.StandardPlacesResults, error)
+	GetNearestByCoordContext(context.Context, geom.Coord, int, float64, filter.Filter) (spr.StandardPlacesResults, error)
+	GetIntersectsByRadiusContext(context.Context, geom.Coord, float64, filter.Filter) (spr.StandardPlacesResults, error)
 }
 
 type Candidate interface{} // mmmmmaybe?
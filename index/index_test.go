@@ -0,0 +1,292 @@
+package index_test
+
+import (
+	"context"
+	"errors"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/conformance"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"strings"
+	"testing"
+)
+
+var testIndexes = map[string]func(testing.TB) index.Index{
+	"rtree": func(t testing.TB) index.Index {
+		return newTestRTreeIndex(t, nil)
+	},
+	"cells":      newTestCellIndex,
+	"spatialite": newTestSpatialiteIndex,
+}
+
+// indexFixture indexes (or, if 'update' is true, updates) a 2x2 square with the ID 1001 whose
+// south-west corner is at 'min_x', 'min_y'
+
+func indexFixture(t *testing.T, idx index.Index, min_x float64, min_y float64, update bool) {
+
+	fx := conformance.Fixture{
+		Id:        1001,
+		Name:      "Square",
+		Placetype: "locality",
+		MinX:      min_x,
+		MinY:      min_y,
+		Size:      2.0,
+	}
+
+	f, err := fx.NewFeature()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if update {
+		err = idx.UpdateFeature(f)
+	} else {
+		err = idx.IndexFeature(f)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// indexFixtures indexes each of 'fixtures' in 'idx'
+
+func indexFixtures(t *testing.T, idx index.Index, fixtures []*conformance.Fixture) {
+
+	for _, fx := range fixtures {
+
+		f, err := fx.NewFeature()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = idx.IndexFeature(f)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func queryCoord(t *testing.T, idx index.Index, x float64, y float64) string {
+
+	f, err := filter.NewSPRFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := idx.GetIntersectsByCoord(geom.Coord{X: x, Y: y}, f)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Join(resultIds(rs), ",")
+}
+
+func TestUpdateAndRemoveFeature(t *testing.T) {
+
+	for name, newIndex := range testIndexes {
+
+		t.Run(name, func(t *testing.T) {
+
+			idx := newIndex(t)
+
+			indexFixture(t, idx, 1.0, 1.0, false)
+
+			if ids := queryCoord(t, idx, 2.0, 2.0); ids != "1001" {
+				t.Fatalf("Expected 1001 before updating, got '%s'", ids)
+			}
+
+			indexFixture(t, idx, 5.0, 5.0, true)
+
+			if ids := queryCoord(t, idx, 2.0, 2.0); ids != "" {
+				t.Fatalf("Expected the old geometry to be gone after updating, got '%s'", ids)
+			}
+
+			if ids := queryCoord(t, idx, 6.0, 6.0); ids != "1001" {
+				t.Fatalf("Expected 1001 after updating, got '%s'", ids)
+			}
+
+			err := idx.RemoveFeature("1001")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ids := queryCoord(t, idx, 6.0, 6.0); ids != "" {
+				t.Fatalf("Expected nothing after removing, got '%s'", ids)
+			}
+
+			ok, err := idx.Cache().Has("1001")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok {
+				t.Fatal("Expected the removed feature to be deleted from the cache")
+			}
+		})
+	}
+}
+
+// a point inside both the old and the new geometry should be found for the whole of an update
+
+func TestUpdateFeatureIsAtomic(t *testing.T) {
+
+	features := make([]geojson.Feature, 0)
+
+	for _, min_x := range []float64{1.0, 1.5} {
+
+		fx := conformance.Fixture{Id: 1001, Name: "Square", Placetype: "locality", MinX: min_x, MinY: 1.0, Size: 2.0}
+		f, err := fx.NewFeature()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		features = append(features, f)
+	}
+
+	for name, newIndex := range testIndexes {
+
+		t.Run(name, func(t *testing.T) {
+
+			idx := newIndex(t)
+
+			err := idx.IndexFeature(features[0])
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan bool)
+
+			go func() {
+
+				defer close(done)
+
+				for i := 0; i < 100; i++ {
+
+					err := idx.UpdateFeature(features[i%2])
+
+					if err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+
+			misses := 0
+			updating := true
+
+			for updating {
+
+				select {
+				case <-done:
+					updating = false
+				default:
+
+					if ids := queryCoord(t, idx, 2.0, 2.0); ids != "1001" {
+						misses += 1
+					}
+				}
+			}
+
+			if misses > 0 {
+				t.Fatalf("Expected 1001 for every query during updates, missed %d times", misses)
+			}
+		})
+	}
+}
+
+// every query should give up, with the context's error, once its context is cancelled
+
+func TestQueriesCancelled(t *testing.T) {
+
+	f, err := filter.NewSPRFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coord := geom.Coord{X: 2.0, Y: 2.0}
+
+	path := geom.Path{}
+	path.AddVertex(geom.Coord{X: 2.0, Y: 2.0})
+	path.AddVertex(geom.Coord{X: 8.0, Y: 8.0})
+
+	bbox := geom.Rect{Min: geom.Coord{X: 1.5, Y: 1.5}, Max: geom.Coord{X: 2.5, Y: 2.5}}
+
+	g, err := geo.NewGeometryFromGeoJSON([]byte(`{"type":"Polygon","coordinates":[[[1.5,1.5],[2.5,1.5],[2.5,2.5],[1.5,2.5],[1.5,1.5]]]}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, newIndex := range testIndexes {
+
+		t.Run(name, func(t *testing.T) {
+
+			idx := newIndex(t)
+			indexFixtures(t, idx, conformance.Fixtures())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			queries := map[string]func() error{
+				"coord": func() error {
+					_, err := idx.GetIntersectsByCoordContext(ctx, coord, f)
+					return err
+				},
+				"candidates": func() error {
+					_, err := idx.GetCandidatesByCoordContext(ctx, coord)
+					return err
+				},
+				"path": func() error {
+					_, err := idx.GetIntersectsByPathContext(ctx, path, f)
+					return err
+				},
+				"path aggregate": func() error {
+					_, err := idx.GetIntersectsByPathAggregateContext(ctx, path, f)
+					return err
+				},
+				"crossings": func() error {
+					_, err := index.GetCrossingsByPath(ctx, idx, path, f)
+					return err
+				},
+				"bbox": func() error {
+					_, err := idx.GetIntersectsByBoundingBoxContext(ctx, bbox, f)
+					return err
+				},
+				"geometry": func() error {
+					_, err := idx.GetIntersectsByGeometryContext(ctx, g, f)
+					return err
+				},
+				"nearest": func() error {
+					_, err := idx.GetNearestByCoordContext(ctx, coord, 1, 0.0, f)
+					return err
+				},
+				"radius": func() error {
+					_, err := idx.GetIntersectsByRadiusContext(ctx, coord, 1000.0, f)
+					return err
+				},
+			}
+
+			for label, query := range queries {
+
+				err := query()
+
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("Expected %s query to be cancelled, got '%v'", label, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"math"
+	"sort"
+)
+
+// the radius (in meters) of the first search in a nearest neighbour query - each
+// subsequent search doubles the radius until enough results are found
+
+const NEAREST_INITIAL_RADIUS float64 = 1000.0
+
+// candidatesFunc returns the IDs of all the features whose bounding boxes intersect a rect;
+// it is how individual indices plug their own spatial index in to the code below
+
+type candidatesFunc func(context.Context, geom.Rect) ([]string, error)
+
+type distanceResult struct {
+	spr      spr.StandardPlacesResult
+	distance float64
+}
+
+// getNearestByCoord finds the 'k' features closest to 'coord', within 'max_distance' meters, by
+// searching outward in ever larger bounding boxes and measuring the actual distance to the edges
+// of each candidate's polygons. Results are ordered by distance (closest first) and each one has
+// a 'pip:distance' property (in meters).
+
+func getNearestByCoord(ctx context.Context, c cache.Cache, coord geom.Coord, k int, max_distance float64, f filter.Filter, candidates candidatesFunc) (spr.StandardPlacesResults, error) {
+
+	if k < 1 {
+		return nil, errors.New("Invalid number of results")
+	}
+
+	coord = geo.NormalizeCoord(coord)
+
+	if max_distance <= 0.0 || max_distance > geo.MAX_DISTANCE {
+		max_distance = geo.MAX_DISTANCE
+	}
+
+	seen := make(map[string]bool)
+	possible := make([]*distanceResult, 0)
+
+	radius := math.Min(NEAREST_INITIAL_RADIUS, max_distance)
+
+	for {
+
+		ids, err := candidatesForRadius(ctx, coord, radius, candidates)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, str_id := range ids {
+
+			err := ctx.Err()
+
+			if err != nil {
+				return nil, err
+			}
+
+			_, ok := seen[str_id]
+
+			if ok {
+				continue
+			}
+
+			seen[str_id] = true
+
+			fc, err := c.Get(str_id)
+
+			if err != nil {
+				return nil, err
+			}
+
+			s := fc.SPR()
+
+			err = filterSPR(c, f, s)
+
+			if err != nil {
+				continue
+			}
+
+			d := geo.DistanceToPolygons(fc.Polygons(), coord)
+
+			if d > max_distance {
+				continue
+			}
+
+			possible = append(possible, &distanceResult{spr: s, distance: d})
+		}
+
+		// anything we haven't seen yet is necessarily further away than 'radius' so once
+		// there are 'k' results inside of it we know we've got the nearest ones
+
+		found := 0
+
+		for _, r := range possible {
+
+			if r.distance <= radius {
+				found += 1
+			}
+		}
+
+		if found >= k || radius >= max_distance {
+			break
+		}
+
+		radius = math.Min(radius*2.0, max_distance)
+	}
+
+	return distanceResults(possible, k), nil
+}
+
+// distanceResults sorts 'possible' by distance (and then ID) and returns (up to) the first 'k'
+// of them as results with a 'pip:distance' property. A value of 'k' less than 1 means return
+// everything.
+
+func distanceResults(possible []*distanceResult, k int) spr.StandardPlacesResults {
+
+	sort.Slice(possible, func(i, j int) bool {
+
+		if possible[i].distance == possible[j].distance {
+			return possible[i].spr.Id() < possible[j].spr.Id()
+		}
+
+		return possible[i].distance < possible[j].distance
+	})
+
+	if k > 0 && len(possible) > k {
+		possible = possible[0:k]
+	}
+
+	places := make([]spr.StandardPlacesResult, len(possible))
+
+	for i, r := range possible {
+
+		ext := NewExtendedPlacesResult(r.spr)
+		ext.Properties["pip:distance"] = r.distance
+
+		places[i] = ext
+	}
+
+	rsp := PlacesResults{
+		Places: places,
+	}
+
+	return &rsp
+}
+
+// candidatesForRadius returns the IDs of all the features whose bounding boxes intersect the bounding box
+// for a circle of 'radius' meters around 'coord', including the part of it on the other side of the
+// antimeridian if there is one. IDs may be repeated.
+
+func candidatesForRadius(ctx context.Context, coord geom.Coord, radius float64, candidates candidatesFunc) ([]string, error) {
+
+	ids := make([]string, 0)
+
+	for _, bounds := range geo.BoundsListForRadius(coord, radius) {
+
+		bounds_ids, err := candidates(ctx, bounds)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, bounds_ids...)
+	}
+
+	return ids, nil
+}
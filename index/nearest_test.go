@@ -0,0 +1,101 @@
+package index_test
+
+import (
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/conformance"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"strings"
+	"testing"
+)
+
+// distanceFixtures are three squares due east of (0, 0.25) at (roughly) 111, 222 and 333km. The IDs
+// go down as the distance goes up so that results ordered by ID are in the wrong order.
+
+func distanceFixtures() []*conformance.Fixture {
+
+	return []*conformance.Fixture{
+		&conformance.Fixture{Id: 3001, Name: "Far", Placetype: "locality", MinX: 3.0, MinY: 0.0, Size: 0.5},
+		&conformance.Fixture{Id: 3002, Name: "Middle", Placetype: "locality", MinX: 2.0, MinY: 0.0, Size: 0.5},
+		&conformance.Fixture{Id: 3003, Name: "Near", Placetype: "locality", MinX: 1.0, MinY: 0.0, Size: 0.5},
+	}
+}
+
+// distanceIds returns the comma-separated IDs of 'rs' and fails 't' if the results aren't ordered by
+// their 'pip:distance' property
+
+func distanceIds(t *testing.T, rs spr.StandardPlacesResults) string {
+
+	previous := -1.0
+
+	for _, s := range rs.Results() {
+
+		ext, ok := s.(*index.ExtendedPlacesResult)
+
+		if !ok {
+			t.Fatalf("Expected %s to be an extended result", s.Id())
+		}
+
+		d, ok := ext.Properties["pip:distance"].(float64)
+
+		if !ok {
+			t.Fatalf("Expected %s to have a 'pip:distance' property", s.Id())
+		}
+
+		if d < previous {
+			t.Fatalf("Expected results ordered by distance, but %s (%f) comes after %f", s.Id(), d, previous)
+		}
+
+		previous = d
+	}
+
+	return strings.Join(resultIds(rs), ",")
+}
+
+func TestGetNearestByCoord(t *testing.T) {
+
+	tests := []struct {
+		coord        geom.Coord
+		k            int
+		max_distance float64
+		expected     string
+	}{
+		{geom.Coord{X: 0.0, Y: 0.25}, 3, 0.0, "3003,3002,3001"},
+		{geom.Coord{X: 0.0, Y: 0.25}, 2, 0.0, "3003,3002"},
+		{geom.Coord{X: 0.0, Y: 0.25}, 3, 150000.0, "3003"},
+		{geom.Coord{X: 0.0, Y: 0.25}, 3, 50000.0, ""},
+		{geom.Coord{X: 4.0, Y: 0.25}, 3, 0.0, "3001,3002,3003"},
+		{geom.Coord{X: 2.25, Y: 0.25}, 1, 0.0, "3002"},
+	}
+
+	f, err := filter.NewSPRFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, newIndex := range testIndexes {
+
+		t.Run(name, func(t *testing.T) {
+
+			idx := newIndex(t)
+			indexFixtures(t, idx, distanceFixtures())
+
+			for _, test := range tests {
+
+				rs, err := idx.GetNearestByCoord(test.coord, test.k, test.max_distance, f)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ids := distanceIds(t, rs)
+
+				if ids != test.expected {
+					t.Errorf("Expected [%s] for the nearest %d to %v (max %f), got [%s]", test.expected, test.k, test.coord, test.max_distance, ids)
+				}
+			}
+		})
+	}
+}
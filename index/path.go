@@ -0,0 +1,65 @@
+package index
+
+import (
+	"context"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+)
+
+// Every index answers path queries in two ways:
+//
+// GetIntersectsByPath ("per-vertex") returns one set of results for each vertex of the path, in
+// the same order as the vertices, and each set contains the places that contain that vertex.
+//
+// GetIntersectsByPathAggregate ("aggregate") returns a single set of results containing every place
+// that the path touches or crosses, including places that sit between two vertices, ordered by
+// placetype and then ID. The rtree index's PathSegments option is the only exception: if it is false
+// only the places that contain one of the path's vertices are returned.
+
+type coordQueryFunc func(context.Context, geom.Coord, filter.Filter) (spr.StandardPlacesResults, error)
+
+// getIntersectsByPathVertices returns the per-vertex results for 'path' by passing each of its vertices,
+// in turn, to 'query'
+
+func getIntersectsByPathVertices(ctx context.Context, path geom.Path, f filter.Filter, query coordQueryFunc) ([]spr.StandardPlacesResults, error) {
+
+	results := make([]spr.StandardPlacesResults, 0)
+
+	for _, c := range path.Vertices() {
+
+		rsp, err := query(ctx, c, f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, rsp)
+	}
+
+	return results, nil
+}
+
+// pathSegments returns the segments of 'path' as pairs of coordinates. A path with a single vertex
+// is returned as a single (zero-length) segment so that it can be tested the same way. Segments that
+// cross the antimeridian are returned as two segments, one on either side of it.
+
+func pathSegments(path geom.Path) [][2]geom.Coord {
+
+	vertices := path.Vertices()
+	count := len(vertices)
+
+	segments := make([][2]geom.Coord, 0)
+
+	if count == 1 {
+		c := geo.NormalizeCoord(vertices[0])
+		segments = append(segments, [2]geom.Coord{c, c})
+	}
+
+	for i := 0; i < count-1; i++ {
+		segments = append(segments, geo.SplitSegment(vertices[i], vertices[i+1])...)
+	}
+
+	return segments
+}
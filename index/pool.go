@@ -0,0 +1,91 @@
+package index
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// forEach calls 'fn' once for every value of 0 to 'count' - 1 using no more than 'workers'
+// goroutines. It stops handing out work as soon as 'fn' returns an error or 'ctx' is cancelled,
+// waits for any calls already in progress to finish and then returns the first error (or
+// ctx.Err()). The context passed to 'fn' is cancelled when that happens so long-running calls
+// can stop early too.
+
+func forEach(ctx context.Context, count int, workers int, fn func(context.Context, int) error) error {
+
+	if workers > count {
+		workers = count
+	}
+
+	if workers <= 1 {
+
+		for i := 0; i < count; i++ {
+
+			err := ctx.Err()
+
+			if err != nil {
+				return err
+			}
+
+			err = fn(ctx, i)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var next int64
+	var first_err error
+
+	once := new(sync.Once)
+	wg := new(sync.WaitGroup)
+
+	for w := 0; w < workers; w++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for {
+
+				i := int(atomic.AddInt64(&next, 1) - 1)
+
+				if i >= count || ctx.Err() != nil {
+					return
+				}
+
+				err := fn(ctx, i)
+
+				if err != nil {
+
+					once.Do(func() {
+						first_err = err
+						cancel()
+					})
+
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if first_err != nil {
+		return first_err
+	}
+
+	// this is the caller's context being cancelled since ours is only cancelled
+	// above or by the (deferred) call on the way out
+
+	return ctx.Err()
+}
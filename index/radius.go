@@ -0,0 +1,74 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+)
+
+// getIntersectsByRadius returns all the features whose polygons come within 'radius' meters of
+// 'coord'. Like getNearestByCoord results are ordered by distance (closest first) and each one
+// has a 'pip:distance' property (in meters) which is 0 for places that contain 'coord'.
+
+func getIntersectsByRadius(ctx context.Context, c cache.Cache, coord geom.Coord, radius float64, f filter.Filter, candidates candidatesFunc) (spr.StandardPlacesResults, error) {
+
+	if radius <= 0.0 || radius > geo.MAX_DISTANCE {
+		return nil, errors.New("Invalid radius")
+	}
+
+	coord = geo.NormalizeCoord(coord)
+
+	ids, err := candidatesForRadius(ctx, coord, radius, candidates)
+
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	possible := make([]*distanceResult, 0)
+
+	for _, str_id := range ids {
+
+		err := ctx.Err()
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, ok := seen[str_id]
+
+		if ok {
+			continue
+		}
+
+		seen[str_id] = true
+
+		fc, err := c.Get(str_id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		s := fc.SPR()
+
+		err = filterSPR(c, f, s)
+
+		if err != nil {
+			continue
+		}
+
+		d := geo.DistanceToPolygons(fc.Polygons(), coord)
+
+		if d > radius {
+			continue
+		}
+
+		possible = append(possible, &distanceResult{spr: s, distance: d})
+	}
+
+	return distanceResults(possible, 0), nil
+}
@@ -0,0 +1,54 @@
+package index_test
+
+import (
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"testing"
+)
+
+func TestGetIntersectsByRadius(t *testing.T) {
+
+	tests := []struct {
+		coord    geom.Coord
+		radius   float64
+		expected string
+	}{
+		{geom.Coord{X: 0.0, Y: 0.25}, 50000.0, ""},
+		{geom.Coord{X: 0.0, Y: 0.25}, 150000.0, "3003"},
+		{geom.Coord{X: 0.0, Y: 0.25}, 250000.0, "3003,3002"},
+		{geom.Coord{X: 0.0, Y: 0.25}, 400000.0, "3003,3002,3001"},
+		{geom.Coord{X: 4.0, Y: 0.25}, 400000.0, "3001,3002,3003"},
+		// inside 3002, which is 0 meters away
+		{geom.Coord{X: 2.2, Y: 0.25}, 100000.0, "3002,3003,3001"},
+	}
+
+	f, err := filter.NewSPRFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, newIndex := range testIndexes {
+
+		t.Run(name, func(t *testing.T) {
+
+			idx := newIndex(t)
+			indexFixtures(t, idx, distanceFixtures())
+
+			for _, test := range tests {
+
+				rs, err := idx.GetIntersectsByRadius(test.coord, test.radius, f)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ids := distanceIds(t, rs)
+
+				if ids != test.expected {
+					t.Errorf("Expected [%s] within %f meters of %v, got [%s]", test.expected, test.radius, test.coord, ids)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package index
+
+import (
+	"encoding/json"
+	"github.com/tidwall/sjson"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"sort"
+)
+
+// ExtendedPlacesResult is a spr.StandardPlacesResult with additional, query-specific properties
+// (like the area of overlap with a query geometry) that are appended to its JSON encoding
+
+type ExtendedPlacesResult struct {
+	spr.StandardPlacesResult
+	Properties map[string]interface{}
+}
+
+func NewExtendedPlacesResult(s spr.StandardPlacesResult) *ExtendedPlacesResult {
+
+	// don't wrap a wrapper, just add to it
+
+	ext, ok := s.(*ExtendedPlacesResult)
+
+	if ok {
+		return ext
+	}
+
+	props := make(map[string]interface{})
+
+	r := ExtendedPlacesResult{
+		StandardPlacesResult: s,
+		Properties:           props,
+	}
+
+	return &r
+}
+
+func (r *ExtendedPlacesResult) MarshalJSON() ([]byte, error) {
+
+	body, err := json.Marshal(r.StandardPlacesResult)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// sort the keys so that output is stable
+
+	keys := make([]string, 0)
+
+	for k, _ := range r.Properties {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+
+		body, err = sjson.SetBytes(body, k, r.Properties[k])
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return body, nil
+}
+
+// PlacesResults is a generic spr.StandardPlacesResults for results that aren't specific
+// to any one index
+
+type PlacesResults struct {
+	spr.StandardPlacesResults `json:",omitempty"`
+	Places                    []spr.StandardPlacesResult `json:"places"`
+}
+
+func (r *PlacesResults) Results() []spr.StandardPlacesResult {
+	return r.Places
+}
+
+// mergeResults collapses a list of result sets in to a single set of unique places ordered by
+// placetype and then ID
+
+func mergeResults(results []spr.StandardPlacesResults) spr.StandardPlacesResults {
+
+	places := make([]spr.StandardPlacesResult, 0)
+	seen := make(map[string]bool)
+
+	for _, rs := range results {
+
+		for _, s := range rs.Results() {
+
+			key := alt.KeyForSPR(s)
+
+			_, ok := seen[key]
+
+			if ok {
+				continue
+			}
+
+			seen[key] = true
+			places = append(places, s)
+		}
+	}
+
+	sortPlacesByPlacetype(places)
+
+	rsp := PlacesResults{
+		Places: places,
+	}
+
+	return &rsp
+}
+
+// AppendOverlaps decorates each result with the area (in square meters) and the percentage
+// of its polygons that overlap 'g', using the polygons stored in the index's cache
+
+func AppendOverlaps(i Index, results spr.StandardPlacesResults, g *geo.Geometry) (spr.StandardPlacesResults, error) {
+
+	c := i.Cache()
+
+	places := make([]spr.StandardPlacesResult, 0)
+
+	for _, s := range results.Results() {
+
+		fc, err := c.Get(alt.KeyForSPR(s))
+
+		if err != nil {
+			return nil, err
+		}
+
+		o := geo.PolygonsOverlap(fc.Polygons(), g)
+
+		r := NewExtendedPlacesResult(s)
+		r.Properties["pip:overlap_area"] = o.Area
+		r.Properties["pip:overlap_percent"] = o.Percent
+
+		places = append(places, r)
+	}
+
+	rsp := PlacesResults{
+		Places: places,
+	}
+
+	return &rsp, nil
+}
+
+// AppendSources decorates each result with the source of the geometry it was matched
+// against: the label of an alternate geometry or alt.DEFAULT_SOURCE
+
+func AppendSources(results spr.StandardPlacesResults) spr.StandardPlacesResults {
+
+	places := make([]spr.StandardPlacesResult, 0)
+
+	for _, s := range results.Results() {
+
+		r := NewExtendedPlacesResult(s)
+		r.Properties["pip:source"] = alt.Source(s)
+
+		places = append(places, r)
+	}
+
+	rsp := PlacesResults{
+		Places: places,
+	}
+
+	return &rsp
+}
@@ -1,25 +1,65 @@
 package index
 
 import (
+	"context"
+	"errors"
 	"github.com/dhconnelly/rtreego"
 	"github.com/skelterjohn/geom"
 	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
-	"github.com/whosonfirst/go-whosonfirst-geojson-v2/geometry"
 	"github.com/whosonfirst/go-whosonfirst-log"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
 	"github.com/whosonfirst/go-whosonfirst-spr"
 	// golog "log"
+	"math"
+	"runtime"
 	"sync"
 )
 
 type RTreeIndex struct {
 	Index
-	Logger *log.WOFLogger
-	rtree  *rtreego.Rtree
-	cache  cache.Cache
-	mu     *sync.RWMutex
+	Logger   *log.WOFLogger
+	Options  *RTreeIndexOptions
+	rtree    *rtreego.Rtree
+	cache    cache.Cache
+	entries  map[string][]*RTreeSpatialIndex   // this is a list of WOF ID -> rtree entries (one per bounding box)
+	prepared map[string][]*geo.PreparedPolygon // this is a list of WOF ID -> prepared polygons (see Options.PrepareGeometries)
+	mu       *sync.RWMutex
+}
+
+// rtree_epsilon is how much (in degrees) query rectangles are grown by so that features whose
+// bounding boxes only touch them are still candidates
+
+const rtree_epsilon float64 = 1e-9
+
+type RTreeIndexOptions struct {
+	// the maximum number of goroutines a single query will use to test candidates (or, for
+	// path queries, the vertices of a path)
+	Workers int
+	// if true the polygons for each feature are also stored as geo.PreparedPolygon instances which
+	// makes point-in-polygon tests for large polygons much faster at the cost of more memory
+	PrepareGeometries bool
+	// the minimum number of vertices a polygon needs to have before it is prepared
+	PrepareMinVertices int
+	// if true aggregate path queries test each segment of a path against the edges of each candidate
+	// polygon rather than only testing whether the polygon contains the path's vertices. per-vertex
+	// path queries always test the vertices
+	PathSegments bool
+}
+
+func DefaultRTreeIndexOptions() (*RTreeIndexOptions, error) {
+
+	opts := RTreeIndexOptions{
+		Workers:            runtime.NumCPU(),
+		PrepareGeometries:  true,
+		PrepareMinVertices: 1000,
+		PathSegments:       true,
+	}
+
+	return &opts, nil
 }
 
 type RTreeSpatialIndex struct {
@@ -46,35 +86,77 @@ func (r *RTreeResults) Results() []spr.StandardPlacesResult {
 
 func NewRTreeIndex(c cache.Cache) (*RTreeIndex, error) {
 
+	opts, err := DefaultRTreeIndexOptions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRTreeIndexWithOptions(c, opts)
+}
+
+func NewRTreeIndexWithOptions(c cache.Cache, opts *RTreeIndexOptions) (*RTreeIndex, error) {
+
+	if opts.Workers < 1 {
+		return nil, errors.New("Invalid number of workers")
+	}
+
 	logger := log.SimpleWOFLogger("index")
 
 	rtree := rtreego.NewTree(2, 25, 50)
 
 	mu := new(sync.RWMutex)
 
+	entries := make(map[string][]*RTreeSpatialIndex)
+	prepared := make(map[string][]*geo.PreparedPolygon)
+
 	index := RTreeIndex{
-		Logger: logger,
-		rtree:  rtree,
-		cache:  c,
-		mu:     mu,
+		Logger:   logger,
+		Options:  opts,
+		rtree:    rtree,
+		cache:    c,
+		entries:  entries,
+		prepared: prepared,
+		mu:       mu,
 	}
 
 	return &index, nil
 }
 
+func (r *RTreeIndex) Close() error {
+	return nil
+}
+
 func (r *RTreeIndex) Cache() cache.Cache {
 	return r.cache
 }
 
 func (r *RTreeIndex) IndexFeature(f geojson.Feature) error {
 
-	str_id := f.Id()
+	return r.indexFeature(f, false)
+}
 
-	bboxes, err := f.BoundingBoxes()
+// UpdateFeature replaces any existing rtree entries (and cache item) for 'f' with new ones. The old
+// entries are removed and the new ones added while holding the index lock so queries will see either
+// the old version of a feature or the new one but never neither (or both).
 
-	if err != nil {
-		return err
-	}
+func (r *RTreeIndex) UpdateFeature(f geojson.Feature) error {
+
+	return r.indexFeature(f, true)
+}
+
+func (r *RTreeIndex) RemoveFeature(str_id string) error {
+
+	r.mu.Lock()
+	r.removeEntries(str_id)
+	r.mu.Unlock()
+
+	return r.cache.Delete(str_id)
+}
+
+func (r *RTreeIndex) indexFeature(f geojson.Feature, replace bool) error {
+
+	str_id := f.Id()
 
 	fc, err := cache.NewFeatureCache(f)
 
@@ -82,13 +164,17 @@ func (r *RTreeIndex) IndexFeature(f geojson.Feature) error {
 		return err
 	}
 
-	err = r.cache.Set(str_id, fc)
+	// the rtree entries are derived from the cached polygons rather than the feature's own
+	// bounding boxes because polygons that cross the antimeridian have already been split in
+	// two by the cache and the bounding box for the original polygon would span (almost) every
+	// longitude
 
-	if err != nil {
-		return err
-	}
+	entries := make([]*RTreeSpatialIndex, 0)
+
+	for _, poly := range fc.Polygons() {
 
-	for _, bbox := range bboxes.Bounds() {
+		ext := poly.ExteriorRing()
+		bbox := ext.Bounds()
 
 		sw := bbox.Min
 		ne := bbox.Max
@@ -110,72 +196,250 @@ func (r *RTreeIndex) IndexFeature(f geojson.Feature) error {
 			Id:     str_id,
 		}
 
-		r.mu.Lock()
-		r.rtree.Insert(&sp)
-		r.mu.Unlock()
+		entries = append(entries, &sp)
+	}
+
+	prepared := r.preparePolygons(fc)
+
+	// entries are keyed by ID so it's safe to update the cache item before the old
+	// entries are removed
+
+	err = r.cache.Set(str_id, fc)
+
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if replace {
+		r.removeEntries(str_id)
+	}
+
+	for _, sp := range entries {
+		r.rtree.Insert(sp)
+	}
+
+	r.entries[str_id] = append(r.entries[str_id], entries...)
+
+	if prepared != nil {
+		r.prepared[str_id] = prepared
 	}
 
 	return nil
 }
 
+// removeEntries deletes all the rtree entries for 'str_id' - it is assumed that the caller is
+// holding the index lock
+
+func (r *RTreeIndex) removeEntries(str_id string) {
+
+	entries, ok := r.entries[str_id]
+
+	if !ok {
+		return
+	}
+
+	for _, sp := range entries {
+
+		if !r.rtree.Delete(sp) {
+			r.Logger.Warning("failed to remove rtree entry for %s %v", str_id, sp.Bounds())
+		}
+	}
+
+	delete(r.entries, str_id)
+	delete(r.prepared, str_id)
+}
+
+// preparePolygons returns the prepared polygons for 'fc' or nil if prepared geometries are disabled or
+// none of its polygons are large enough to be worth preparing
+
+func (r *RTreeIndex) preparePolygons(fc cache.CacheItem) []*geo.PreparedPolygon {
+
+	if !r.Options.PrepareGeometries {
+		return nil
+	}
+
+	return geo.PreparePolygons(fc.Polygons(), r.Options.PrepareMinVertices)
+}
+
+// containsCoord tests whether the polygons for 'fc' contain 'c' using the prepared
+// polygons for that feature if there are any
+
+func (r *RTreeIndex) containsCoord(fc cache.CacheItem, c geom.Coord) (bool, error) {
+
+	r.mu.RLock()
+	prepared, ok := r.prepared[alt.KeyForSPR(fc.SPR())]
+	r.mu.RUnlock()
+
+	if ok {
+		return geo.PreparedPolygonsContainsCoord(prepared, c), nil
+	}
+
+	return geo.PolygonsContainsCoord(fc.Polygons(), c), nil
+}
+
+// intersectsSegment tests whether the polygons for 'fc' touch or cross the segment a-b using the
+// prepared polygons for that feature if there are any
+
+func (r *RTreeIndex) intersectsSegment(fc cache.CacheItem, a geom.Coord, b geom.Coord) bool {
+
+	r.mu.RLock()
+	prepared, ok := r.prepared[alt.KeyForSPR(fc.SPR())]
+	r.mu.RUnlock()
+
+	if ok {
+		return geo.PreparedPolygonsIntersectsSegment(prepared, a, b)
+	}
+
+	return geo.PolygonsIntersectsSegment(fc.Polygons(), a, b)
+}
+
 func (r *RTreeIndex) GetIntersectsByPath(path geom.Path, filters filter.Filter) ([]spr.StandardPlacesResults, error) {
 
-	type Candidates struct {
-		Index int
-		SPR   spr.StandardPlacesResults
+	return r.GetIntersectsByPathContext(context.Background(), path, filters)
+}
+
+// GetIntersectsByPathContext returns one set of results for each vertex in 'path' and each set contains
+// the places that contain that vertex.
+
+func (r *RTreeIndex) GetIntersectsByPathContext(ctx context.Context, path geom.Path, filters filter.Filter) ([]spr.StandardPlacesResults, error) {
+
+	vertices := path.Vertices()
+	results := make([]spr.StandardPlacesResults, len(vertices))
+
+	// the vertices are shared out between the workers and each vertex is tested
+	// sequentially (rather than using the workers again) so a path query never uses
+	// more than Options.Workers goroutines - results are stored by index so that the
+	// result sets are in the same order as the coords that were passed in
+
+	query := func(ctx context.Context, idx int) error {
+
+		c := geo.NormalizeCoord(vertices[idx])
+
+		rows, err := r.getIntersectsByCoord(c)
+
+		if err != nil {
+			return err
+		}
+
+		contains := func(fc cache.CacheItem) (bool, error) {
+			return r.containsCoord(fc, c)
+		}
+
+		intersects, err := r.inflateResultsWithWorkers(ctx, filters, rows, contains, 1)
+
+		if err != nil {
+			return err
+		}
+
+		results[idx] = intersects
+		return nil
 	}
 
-	candidates_ch := make(chan Candidates)
-	error_ch := make(chan error)
-	done_ch := make(chan bool)
+	err := forEach(ctx, len(vertices), r.Options.Workers, query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *RTreeIndex) GetIntersectsByPathAggregate(path geom.Path, filters filter.Filter) (spr.StandardPlacesResults, error) {
 
-	pending := path.Length()
-	results := make([]spr.StandardPlacesResults, pending)
+	return r.GetIntersectsByPathAggregateContext(context.Background(), path, filters)
+}
 
-	for i, c := range path.Vertices() {
+// GetIntersectsByPathAggregateContext returns all the places that 'path' touches or crosses. If the
+// PathSegments option is false only the places that contain one of the path's vertices are returned,
+// which is faster but misses places that sit between two vertices.
 
-		// see the way we're passing 'i' around as an index - that's so we rebuild
-		// the result sets in the same order that the coords were passed in - that
-		// part is important (20170927/thisisaaronland)
+func (r *RTreeIndex) GetIntersectsByPathAggregateContext(ctx context.Context, path geom.Path, filters filter.Filter) (spr.StandardPlacesResults, error) {
 
-		go func(idx int, c geom.Coord, f filter.Filter, candidates_ch chan Candidates, error_ch chan error, done_ch chan bool) {
+	var results []spr.StandardPlacesResults
+	var err error
 
-			defer func() {
-				done_ch <- true
-			}()
+	if r.Options.PathSegments {
+		results, err = r.getIntersectsBySegments(ctx, path, filters)
+	} else {
+		results, err = r.GetIntersectsByPathContext(ctx, path, filters)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeResults(results), nil
+}
 
-			intersects, err := r.GetIntersectsByCoord(c, f)
+func (r *RTreeIndex) getIntersectsBySegments(ctx context.Context, path geom.Path, filters filter.Filter) ([]spr.StandardPlacesResults, error) {
+
+	vertices := path.Vertices()
+	count := len(vertices)
+
+	results := make([]spr.StandardPlacesResults, count)
+
+	// see notes in GetIntersectsByPathContext inre workers
+
+	query := func(ctx context.Context, idx int) error {
+
+		a := vertices[idx]
+		b := a
+
+		if idx < count-1 {
+			b = vertices[idx+1]
+		}
+
+		// segments that cross the antimeridian are tested as two segments, one on
+		// either side of it, and their results merged
+
+		segments := geo.SplitSegment(a, b)
+		segment_results := make([]spr.StandardPlacesResults, len(segments))
+
+		for i, seg := range segments {
+
+			a := seg[0]
+			b := seg[1]
+
+			bbox := geom.Rect{
+				Min: geom.Coord{X: math.Min(a.X, b.X), Y: math.Min(a.Y, b.Y)},
+				Max: geom.Coord{X: math.Max(a.X, b.X), Y: math.Max(a.Y, b.Y)},
+			}
+
+			rows, err := r.getIntersectsByBounds(bbox)
 
 			if err != nil {
-				error_ch <- err
-				return
+				return err
 			}
 
-			candidates := Candidates{
-				Index: idx,
-				SPR:   intersects,
+			intersects := func(fc cache.CacheItem) (bool, error) {
+				return r.intersectsSegment(fc, a, b), nil
 			}
 
-			candidates_ch <- candidates
+			rsp, err := r.inflateResultsWithWorkers(ctx, filters, rows, intersects, 1)
 
-		}(i, c, filters, candidates_ch, error_ch, done_ch)
+			if err != nil {
+				return err
+			}
 
-	}
+			segment_results[i] = rsp
+		}
 
-	// notes (20170927/thisisaaronland)
-	// 1. kill remaining goroutines if err
+		if len(segment_results) == 1 {
+			results[idx] = segment_results[0]
+		} else {
+			results[idx] = mergeResults(segment_results)
+		}
 
-	for pending > 0 {
+		return nil
+	}
 
-		select {
+	err := forEach(ctx, count, r.Options.Workers, query)
 
-		case err := <-error_ch:
-			return nil, err
-		case candidates := <-candidates_ch:
-			results[candidates.Index] = candidates.SPR
-		case <-done_ch:
-			pending -= 1
-		}
+	if err != nil {
+		return nil, err
 	}
 
 	return results, nil
@@ -183,16 +447,23 @@ func (r *RTreeIndex) GetIntersectsByPath(path geom.Path, filters filter.Filter)
 
 func (r *RTreeIndex) GetIntersectsByCoord(coord geom.Coord, filters filter.Filter) (spr.StandardPlacesResults, error) {
 
+	return r.GetIntersectsByCoordContext(context.Background(), coord, filters)
+}
+
+func (r *RTreeIndex) GetIntersectsByCoordContext(ctx context.Context, coord geom.Coord, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
 	// to do: timings that don't slow everything down the way
 	// go-whosonfirst-timer does now (20170915/thisisaaronland)
 
+	coord = geo.NormalizeCoord(coord)
+
 	rows, err := r.getIntersectsByCoord(coord)
 
 	if err != nil {
 		return nil, err
 	}
 
-	rsp, err := r.inflateResults(coord, filters, rows)
+	rsp, err := r.inflateResults(ctx, coord, filters, rows)
 
 	if err != nil {
 		return nil, err
@@ -201,8 +472,110 @@ func (r *RTreeIndex) GetIntersectsByCoord(coord geom.Coord, filters filter.Filte
 	return rsp, err
 }
 
+func (r *RTreeIndex) GetIntersectsByBoundingBox(bbox geom.Rect, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return r.GetIntersectsByBoundingBoxContext(context.Background(), bbox, filters)
+}
+
+// GetIntersectsByBoundingBoxContext returns the places whose polygons intersect 'bbox'. A bounding box
+// whose minimum longitude is greater than its maximum longitude crosses the antimeridian and is queried
+// as two bounding boxes, one on either side of it, whose results are merged.
+
+func (r *RTreeIndex) GetIntersectsByBoundingBoxContext(ctx context.Context, bbox geom.Rect, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	bounds := geo.SplitRect(bbox)
+	results := make([]spr.StandardPlacesResults, len(bounds))
+
+	for i, b := range bounds {
+
+		rsp, err := r.getIntersectsByBoundingBox(ctx, b, filters)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results[i] = rsp
+	}
+
+	if len(results) == 1 {
+		return results[0], nil
+	}
+
+	return mergeResults(results), nil
+}
+
+func (r *RTreeIndex) getIntersectsByBoundingBox(ctx context.Context, bbox geom.Rect, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	rows, err := r.getIntersectsByBounds(bbox)
+
+	if err != nil {
+		return nil, err
+	}
+
+	intersects := func(fc cache.CacheItem) (bool, error) {
+		return geo.PolygonsIntersectsRect(fc.Polygons(), bbox)
+	}
+
+	return r.inflateResultsWithFunc(ctx, filters, rows, intersects)
+}
+
+func (r *RTreeIndex) GetIntersectsByGeometry(g *geo.Geometry, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return r.GetIntersectsByGeometryContext(context.Background(), g, filters)
+}
+
+func (r *RTreeIndex) GetIntersectsByGeometryContext(ctx context.Context, g *geo.Geometry, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	bbox := g.Bounds()
+
+	rows, err := r.getIntersectsByBounds(bbox)
+
+	if err != nil {
+		return nil, err
+	}
+
+	intersects := func(fc cache.CacheItem) (bool, error) {
+		return geo.PolygonsIntersectsGeometry(fc.Polygons(), g)
+	}
+
+	return r.inflateResultsWithFunc(ctx, filters, rows, intersects)
+}
+
+func (r *RTreeIndex) GetNearestByCoord(coord geom.Coord, k int, max_distance float64, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return r.GetNearestByCoordContext(context.Background(), coord, k, max_distance, filters)
+}
+
+func (r *RTreeIndex) GetNearestByCoordContext(ctx context.Context, coord geom.Coord, k int, max_distance float64, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return getNearestByCoord(ctx, r.cache, coord, k, max_distance, filters, r.getIdsByBounds)
+}
+
+func (r *RTreeIndex) GetIntersectsByRadius(coord geom.Coord, radius float64, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return r.GetIntersectsByRadiusContext(context.Background(), coord, radius, filters)
+}
+
+func (r *RTreeIndex) GetIntersectsByRadiusContext(ctx context.Context, coord geom.Coord, radius float64, filters filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return getIntersectsByRadius(ctx, r.cache, coord, radius, filters, r.getIdsByBounds)
+}
+
 func (r *RTreeIndex) GetCandidatesByCoord(coord geom.Coord) (*pip.GeoJSONFeatureCollection, error) {
 
+	return r.GetCandidatesByCoordContext(context.Background(), coord)
+}
+
+func (r *RTreeIndex) GetCandidatesByCoordContext(ctx context.Context, coord geom.Coord) (*pip.GeoJSONFeatureCollection, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	coord = geo.NormalizeCoord(coord)
+
 	intersects, err := r.getIntersectsByCoord(coord)
 
 	if err != nil {
@@ -261,11 +634,25 @@ func (r *RTreeIndex) GetCandidatesByCoord(coord geom.Coord) (*pip.GeoJSONFeature
 
 func (r *RTreeIndex) getIntersectsByCoord(coord geom.Coord) ([]rtreego.Spatial, error) {
 
-	lat := coord.Y
-	lon := coord.X
+	bbox := geom.Rect{
+		Min: coord,
+		Max: coord,
+	}
+
+	return r.getIntersectsByBounds(bbox)
+}
+
+// getIntersectsByBounds returns the rtree entries whose bounding boxes intersect or touch 'bbox'.
+// rtreego doesn't count rectangles that only share an edge as intersecting so 'bbox' is grown by
+// rtree_epsilon on every side first, otherwise a feature whose edge sits exactly on the edge of
+// 'bbox' (or on a coordinate) would never be tested.
+
+func (r *RTreeIndex) getIntersectsByBounds(bbox geom.Rect) ([]rtreego.Spatial, error) {
 
-	pt := rtreego.Point{lon, lat}
-	rect, err := rtreego.NewRect(pt, []float64{0.0001, 0.0001}) // how small can I make this?
+	sw := rtreego.Point{bbox.Min.X - rtree_epsilon, bbox.Min.Y - rtree_epsilon}
+	ne := rtreego.Point{bbox.Max.X + rtree_epsilon, bbox.Max.Y + rtree_epsilon}
+
+	rect, err := rtreego.NewRectFromPoints(sw, ne)
 
 	if err != nil {
 		return nil, err
@@ -274,89 +661,143 @@ func (r *RTreeIndex) getIntersectsByCoord(coord geom.Coord) ([]rtreego.Spatial,
 	return r.getIntersectsByRect(rect)
 }
 
+// getIdsByBounds returns the IDs of all the features whose bounding boxes intersect 'bbox'
+
+func (r *RTreeIndex) getIdsByBounds(ctx context.Context, bbox geom.Rect) ([]string, error) {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.getIntersectsByBounds(bbox)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(rows))
+
+	for i, row := range rows {
+		sp := row.(*RTreeSpatialIndex)
+		ids[i] = sp.Id
+	}
+
+	return ids, nil
+}
+
 func (r *RTreeIndex) getIntersectsByRect(rect *rtreego.Rect) ([]rtreego.Spatial, error) {
 
 	// to do: timings that don't slow everything down the way
 	// go-whosonfirst-timer does now (20170915/thisisaaronland)
 
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	results := r.rtree.SearchIntersect(rect)
 	return results, nil
 }
 
-func (r *RTreeIndex) inflateResults(c geom.Coord, f filter.Filter, possible []rtreego.Spatial) (spr.StandardPlacesResults, error) {
+func (r *RTreeIndex) inflateResults(ctx context.Context, c geom.Coord, f filter.Filter, possible []rtreego.Spatial) (spr.StandardPlacesResults, error) {
+
+	contains := func(fc cache.CacheItem) (bool, error) {
+		return r.containsCoord(fc, c)
+	}
+
+	return r.inflateResultsWithFunc(ctx, f, possible, contains)
+}
+
+// inflateResultsWithFunc fetches the cached record for each (unique) candidate, applies
+// any filters and then hands the record to 'test' to decide whether it is a match. If 'ctx'
+// is cancelled any candidates that haven't been tested yet are skipped and ctx.Err() is
+// returned. Results are ordered by placetype and then ID.
+
+func (r *RTreeIndex) inflateResultsWithFunc(ctx context.Context, f filter.Filter, possible []rtreego.Spatial, test func(cache.CacheItem) (bool, error)) (spr.StandardPlacesResults, error) {
+
+	return r.inflateResultsWithWorkers(ctx, f, possible, test, r.Options.Workers)
+}
+
+func (r *RTreeIndex) inflateResultsWithWorkers(ctx context.Context, f filter.Filter, possible []rtreego.Spatial, test func(cache.CacheItem) (bool, error), workers int) (spr.StandardPlacesResults, error) {
 
 	// to do: timings that don't slow everything down the way
 	// go-whosonfirst-timer does now (20170915/thisisaaronland)
 
-	rows := make([]spr.StandardPlacesResult, 0)
-	seen := make(map[string]bool)
+	// a feature with multiple polygons will have multiple rtree entries
+	// so only test each feature once
 
-	mu := new(sync.RWMutex)
-	wg := new(sync.WaitGroup)
+	ids := make([]string, 0)
+	seen := make(map[string]bool)
 
 	for _, row := range possible {
 
 		sp := row.(*RTreeSpatialIndex)
-		wg.Add(1)
+		str_id := sp.Id
 
-		go func(sp *RTreeSpatialIndex) {
+		_, ok := seen[str_id]
 
-			defer wg.Done()
+		if ok {
+			continue
+		}
 
-			str_id := sp.Id
+		seen[str_id] = true
+		ids = append(ids, str_id)
+	}
 
-			mu.RLock()
-			_, ok := seen[str_id]
-			mu.RUnlock()
+	matches := make([]spr.StandardPlacesResult, len(ids))
 
-			if ok {
-				return
-			}
+	inflate := func(ctx context.Context, idx int) error {
 
-			mu.Lock()
-			seen[str_id] = true
-			mu.Unlock()
+		str_id := ids[idx]
 
-			fc, err := r.cache.Get(str_id)
+		fc, err := r.cache.Get(str_id)
 
-			if err != nil {
-				r.Logger.Error("failed to retrieve cache for %s, because %s", str_id, err)
-				return
-			}
+		if err != nil {
+			r.Logger.Error("failed to retrieve cache for %s, because %s", str_id, err)
+			return nil
+		}
 
-			s := fc.SPR()
+		s := fc.SPR()
 
-			err = filter.FilterSPR(f, s)
+		err = filterSPR(r.cache, f, s)
 
-			if err != nil {
-				r.Logger.Debug("SKIP %s because filter error %s", str_id, err)
-				return
-			}
+		if err != nil {
+			r.Logger.Debug("SKIP %s because filter error %s", str_id, err)
+			return nil
+		}
 
-			p := fc.Polygons()
+		ok, err := test(fc)
 
-			contains, err := geometry.PolygonsContainsCoord(p, c)
+		if err != nil {
+			r.Logger.Error("failed to calculate intersection for %s, because %s", str_id, err)
+			return nil
+		}
 
-			if err != nil {
-				r.Logger.Error("failed to calculate intersection for %s, because %s", str_id, err)
-				return
-			}
+		if !ok {
+			r.Logger.Debug("SKIP %s because it does not intersect", str_id)
+			return nil
+		}
 
-			if !contains {
-				r.Logger.Debug("SKIP %s because does not contain coord (%v)", str_id, c)
-				return
-			}
+		matches[idx] = s
+		return nil
+	}
 
-			// r.Logger.Status("APPEND %s to result set", str_id)
+	err := forEach(ctx, len(ids), workers, inflate)
 
-			mu.Lock()
-			rows = append(rows, s)
-			mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]spr.StandardPlacesResult, 0)
+
+	for _, s := range matches {
 
-		}(sp)
+		if s != nil {
+			rows = append(rows, s)
+		}
 	}
 
-	wg.Wait()
+	sortPlacesByPlacetype(rows)
 
 	rs := RTreeResults{
 		Places: rows,
@@ -0,0 +1,345 @@
+package index_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/conformance"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"math"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// benchmarkFixtures returns a 40 x 40 degree grid of overlapping places: regions that are 10
+// degrees square, localities that are 1 degree square and neighbourhoods in every other locality
+
+func benchmarkFixtures() []*conformance.Fixture {
+
+	fixtures := make([]*conformance.Fixture, 0)
+	id := int64(1)
+
+	for x := 0; x < 40; x += 10 {
+
+		for y := 0; y < 40; y += 10 {
+
+			fixtures = append(fixtures, &conformance.Fixture{Id: id, Name: "region", Placetype: "region", MinX: float64(x), MinY: float64(y), Size: 10.0})
+			id++
+		}
+	}
+
+	for x := 0; x < 40; x++ {
+
+		for y := 0; y < 40; y++ {
+
+			fixtures = append(fixtures, &conformance.Fixture{Id: id, Name: "locality", Placetype: "locality", MinX: float64(x), MinY: float64(y), Size: 1.0})
+			id++
+
+			if (x+y)%2 == 0 {
+				fixtures = append(fixtures, &conformance.Fixture{Id: id, Name: "neighbourhood", Placetype: "neighbourhood", MinX: float64(x) + 0.25, MinY: float64(y) + 0.25, Size: 0.5})
+				id++
+			}
+		}
+	}
+
+	return fixtures
+}
+
+// benchmarkPath returns a path with 'count' vertices that winds back and forth across the
+// benchmark fixtures
+
+func benchmarkPath(count int) geom.Path {
+
+	path := geom.Path{}
+
+	for i := 0; i < count; i++ {
+
+		t := float64(i) / float64(count)
+
+		x := 0.5 + 39.0*t
+		y := 20.0 + 19.0*math.Sin(t*8.0*math.Pi)
+
+		path.AddVertex(geom.Coord{X: x, Y: y})
+	}
+
+	return path
+}
+
+func newBenchmarkRTreeIndex(t testing.TB, workers int) index.Index {
+
+	opts, err := index.DefaultRTreeIndexOptions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Workers = workers
+
+	idx := newTestRTreeIndex(t, opts)
+
+	for _, fx := range benchmarkFixtures() {
+
+		f, err := fx.NewFeature()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = idx.IndexFeature(f)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return idx
+}
+
+func benchmarkWorkers() []int {
+
+	workers := []int{1, 2, 4}
+
+	if runtime.NumCPU() > 4 {
+		workers = append(workers, runtime.NumCPU())
+	}
+
+	return workers
+}
+
+// the results of a path query shouldn't depend on how many workers were used to find them
+
+func TestGetIntersectsByPathWorkers(t *testing.T) {
+
+	filters, err := filter.NewSPRFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := benchmarkPath(200)
+
+	expected := ""
+	expected_aggregate := ""
+
+	for _, workers := range benchmarkWorkers() {
+
+		idx := newBenchmarkRTreeIndex(t, workers)
+
+		results, err := idx.GetIntersectsByPathContext(context.Background(), path, filters)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		per_vertex := make([]string, len(results))
+
+		for i, rs := range results {
+			per_vertex[i] = strings.Join(resultIds(rs), ",")
+		}
+
+		aggregate, err := idx.GetIntersectsByPathAggregateContext(context.Background(), path, filters)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := strings.Join(per_vertex, " ")
+		actual_aggregate := strings.Join(resultIds(aggregate), ",")
+
+		if expected == "" {
+			expected = actual
+			expected_aggregate = actual_aggregate
+			continue
+		}
+
+		if actual != expected {
+			t.Errorf("Per-vertex results with %d workers are %s, expected %s", workers, actual, expected)
+		}
+
+		if actual_aggregate != expected_aggregate {
+			t.Errorf("Aggregate results with %d workers are %s, expected %s", workers, actual_aggregate, expected_aggregate)
+		}
+	}
+}
+
+func benchmarkGetIntersectsByPath(b *testing.B, aggregate bool) {
+
+	filters, err := filter.NewSPRFilter()
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	path := benchmarkPath(200)
+
+	for _, workers := range benchmarkWorkers() {
+
+		idx := newBenchmarkRTreeIndex(b, workers)
+
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+
+				var err error
+
+				if aggregate {
+					_, err = idx.GetIntersectsByPathAggregateContext(ctx, path, filters)
+				} else {
+					_, err = idx.GetIntersectsByPathContext(ctx, path, filters)
+				}
+
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetIntersectsByPath(b *testing.B) {
+	benchmarkGetIntersectsByPath(b, false)
+}
+
+func BenchmarkGetIntersectsByPathAggregate(b *testing.B) {
+	benchmarkGetIntersectsByPath(b, true)
+}
+
+// preparedFixture is a star-shaped polygon with enough vertices to be prepared with the default
+// options whose points are 5 degrees from (20, 20) and whose notches are 4 degrees from it
+
+func preparedFixture() *conformance.Fixture {
+
+	count := 2000
+	ring := make([][]float64, 0)
+
+	for i := 0; i < count; i++ {
+
+		r := 5.0
+
+		if i%2 == 1 {
+			r = 4.0
+		}
+
+		a := (float64(i) / float64(count)) * 2.0 * math.Pi
+		ring = append(ring, []float64{20.0 + r*math.Cos(a), 20.0 + r*math.Sin(a)})
+	}
+
+	ring = append(ring, ring[0])
+
+	return &conformance.Fixture{Id: 5001, Name: "Star", Placetype: "region", Ring: ring}
+}
+
+// a prepared polygon should give exactly the same results as the plain one, including for points
+// and paths in and around the notches of preparedFixture
+
+func TestPreparedGeometries(t *testing.T) {
+
+	filters, err := filter.NewSPRFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := index.DefaultRTreeIndexOptions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.PrepareGeometries = false
+
+	prepared_idx := newTestRTreeIndex(t, nil)
+	plain_idx := newTestRTreeIndex(t, opts)
+
+	for _, idx := range []index.Index{prepared_idx, plain_idx} {
+		indexFixtures(t, idx, []*conformance.Fixture{preparedFixture()})
+	}
+
+	tests := []struct {
+		coord    geom.Coord
+		expected string
+	}{
+		{geom.Coord{X: 20.0, Y: 20.0}, "5001"},
+		{geom.Coord{X: 23.5, Y: 20.0}, "5001"},
+		{geom.Coord{X: 20.0, Y: 25.5}, ""},
+		{geom.Coord{X: 30.0, Y: 30.0}, ""},
+	}
+
+	for _, test := range tests {
+
+		for label, idx := range map[string]index.Index{"prepared": prepared_idx, "plain": plain_idx} {
+
+			if ids := queryCoord(t, idx, test.coord.X, test.coord.Y); ids != test.expected {
+				t.Errorf("Expected [%s] for %v (%s), got [%s]", test.expected, test.coord, label, ids)
+			}
+		}
+	}
+
+	count_inside := 0
+	count_coords := 0
+
+	// every point on a ring between the points and the notches, which goes in and out of the polygon
+
+	for i := 0; i < 5000; i++ {
+
+		a := (float64(i) / 5000.0) * 2.0 * math.Pi
+		x := 20.0 + 4.5*math.Cos(a)
+		y := 20.0 + 4.5*math.Sin(a)
+
+		expected := queryCoord(t, plain_idx, x, y)
+		actual := queryCoord(t, prepared_idx, x, y)
+
+		if actual != expected {
+			t.Errorf("Expected [%s] for (%f, %f) with prepared geometries, got [%s]", expected, x, y, actual)
+		}
+
+		if expected != "" {
+			count_inside += 1
+		}
+
+		count_coords += 1
+	}
+
+	if count_inside == 0 || count_inside == count_coords {
+		t.Fatalf("Expected some but not all of the points between the points and the notches to be inside, got %d of %d", count_inside, count_coords)
+	}
+
+	// segments that start and end outside the polygon but cut across a single point of the star
+
+	for i := 0; i < 200; i++ {
+
+		a := (float64(i*10) / 2000.0) * 2.0 * math.Pi
+		t1 := a - 0.001
+		t2 := a + 0.001
+
+		path := geom.Path{}
+		path.AddVertex(geom.Coord{X: 20.0 + 4.9*math.Cos(t1), Y: 20.0 + 4.9*math.Sin(t1)})
+		path.AddVertex(geom.Coord{X: 20.0 + 4.9*math.Cos(t2), Y: 20.0 + 4.9*math.Sin(t2)})
+
+		expected, err := plain_idx.GetIntersectsByPathAggregate(path, filters)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := prepared_idx.GetIntersectsByPathAggregate(path, filters)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		str_expected := strings.Join(resultIds(expected), ",")
+		str_actual := strings.Join(resultIds(actual), ",")
+
+		if str_expected != "5001" || str_actual != str_expected {
+			t.Errorf("Expected [5001] for path %v, got [%s] (plain) and [%s] (prepared)", path.Vertices(), str_expected, str_actual)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package index
+
+// snapshots are a gzip-compressed stream of records, one per feature, that look like this:
+//
+// header:   "WOFPIPRT" (magic) | uint32 version | uvarint count
+// record:   string id | uvarint count, (float64 x, float64 y, float64 width, float64 height)... | item
+// item:     string (a cache item encoded by cache.EncodeCacheItem with float64 coordinates)
+//
+// strings are a uvarint length followed by that many bytes and all fixed-width numbers are
+// little-endian
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dhconnelly/rtreego"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+const SNAPSHOT_MAGIC string = "WOFPIPRT"
+
+const SNAPSHOT_VERSION uint32 = 2
+
+// SnapshotIndex is implemented by indices that can be written to, and restored from, a snapshot
+
+type SnapshotIndex interface {
+	WriteSnapshot(io.Writer) error
+	ReadSnapshot(io.Reader) error
+}
+
+// WriteSnapshot writes every feature in the index, along with its cached SPR and polygons, to 'wr'.
+// The IDs and rtree entries are copied while the index is read-locked, so they are a consistent view
+// of the index, but the cache items are read after the lock is released so that a slow cache doesn't
+// hold up any updates to the index. Features that are removed in the meantime are left out.
+
+func (r *RTreeIndex) WriteSnapshot(wr io.Writer) error {
+
+	r.mu.RLock()
+
+	all_entries := make(map[string][]*RTreeSpatialIndex, len(r.entries))
+
+	for str_id, entries := range r.entries {
+		all_entries[str_id] = append([]*RTreeSpatialIndex(nil), entries...)
+	}
+
+	r.mu.RUnlock()
+
+	// sorting the IDs isn't strictly necessary but it means the same index always
+	// produces the same snapshot which makes them easier to compare
+
+	ids := make([]string, 0, len(all_entries))
+
+	for str_id := range all_entries {
+		ids = append(ids, str_id)
+	}
+
+	sort.Strings(ids)
+
+	// the number of features is written before any of them so all the cache items
+	// need to be read first
+
+	items := make(map[string]cache.CacheItem, len(ids))
+	written := make([]string, 0, len(ids))
+
+	for _, str_id := range ids {
+
+		fc, err := r.cache.Get(str_id)
+
+		if err != nil {
+
+			ok, has_err := r.cache.Has(str_id)
+
+			if has_err == nil && !ok {
+				continue
+			}
+
+			return fmt.Errorf("Failed to retrieve cache for %s, %s", str_id, err)
+		}
+
+		items[str_id] = fc
+		written = append(written, str_id)
+	}
+
+	gz := gzip.NewWriter(wr)
+	sw := newSnapshotWriter(gz)
+
+	sw.writeBytes([]byte(SNAPSHOT_MAGIC))
+	sw.writeUint32(SNAPSHOT_VERSION)
+	sw.writeUvarint(uint64(len(written)))
+
+	for _, str_id := range written {
+
+		entries := all_entries[str_id]
+
+		sw.writeString(str_id)
+		sw.writeUvarint(uint64(len(entries)))
+
+		for _, sp := range entries {
+
+			b := sp.Bounds()
+
+			sw.writeFloat64(b.PointCoord(0))
+			sw.writeFloat64(b.PointCoord(1))
+			sw.writeFloat64(b.LengthsCoord(0))
+			sw.writeFloat64(b.LengthsCoord(1))
+		}
+
+		err := sw.writeCacheItem(items[str_id])
+
+		if err != nil {
+			return fmt.Errorf("Failed to write snapshot for %s, %s", str_id, err)
+		}
+
+		if sw.err != nil {
+			return sw.err
+		}
+	}
+
+	err := sw.flush()
+
+	if err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// ReadSnapshot adds all the features in a snapshot written by WriteSnapshot to the index (and its
+// cache). If the index is empty the rtree is bulk-loaded which is considerably faster than adding
+// entries one at a time. Nothing is added to the index, or its cache, unless the whole snapshot can
+// be read.
+
+func (r *RTreeIndex) ReadSnapshot(rd io.Reader) error {
+
+	gz, err := gzip.NewReader(rd)
+
+	if err != nil {
+		return err
+	}
+
+	defer gz.Close()
+
+	sr := newSnapshotReader(gz)
+
+	magic := sr.readBytes(len(SNAPSHOT_MAGIC))
+	version := sr.readUint32()
+
+	if sr.err != nil {
+		return sr.err
+	}
+
+	if string(magic) != SNAPSHOT_MAGIC {
+		return errors.New("Invalid snapshot")
+	}
+
+	if version != SNAPSHOT_VERSION {
+		return fmt.Errorf("Unsupported snapshot version %d", version)
+	}
+
+	count := sr.readUvarint()
+
+	all_entries := make(map[string][]*RTreeSpatialIndex)
+	all_items := make(map[string]cache.CacheItem)
+	all_prepared := make(map[string][]*geo.PreparedPolygon)
+	spatial := make([]rtreego.Spatial, 0)
+
+	for i := uint64(0); i < count; i++ {
+
+		str_id := sr.readString()
+		count_rects := sr.readUvarint()
+
+		if sr.err != nil {
+			return sr.err
+		}
+
+		entries := make([]*RTreeSpatialIndex, 0)
+
+		for j := uint64(0); j < count_rects; j++ {
+
+			x := sr.readFloat64()
+			y := sr.readFloat64()
+			w := sr.readFloat64()
+			h := sr.readFloat64()
+
+			if sr.err != nil {
+				return sr.err
+			}
+
+			rect, err := rtreego.NewRect(rtreego.Point{x, y}, []float64{w, h})
+
+			if err != nil {
+				return fmt.Errorf("Invalid bounds for %s, %s", str_id, err)
+			}
+
+			sp := RTreeSpatialIndex{
+				bounds: rect,
+				Id:     str_id,
+			}
+
+			entries = append(entries, &sp)
+			spatial = append(spatial, &sp)
+		}
+
+		fc, err := sr.readCacheItem()
+
+		if err != nil {
+			return fmt.Errorf("Failed to read snapshot for %s, %s", str_id, err)
+		}
+
+		all_entries[str_id] = entries
+		all_items[str_id] = fc
+
+		prepared := r.preparePolygons(fc)
+
+		if prepared != nil {
+			all_prepared[str_id] = prepared
+		}
+	}
+
+	// gzip only verifies its checksum once it reaches the end of the stream so reading to the
+	// end is the only way to know the snapshot wasn't truncated (or corrupted)
+
+	n, err := io.Copy(ioutil.Discard, sr.rd)
+
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return errors.New("Invalid snapshot, trailing data")
+	}
+
+	// the cache items are only written once the whole snapshot has been read and then, like
+	// indexFeature, before the rtree is updated (and while holding the lock) so that a query
+	// never finds an entry from the snapshot without its cache item
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for str_id, fc := range all_items {
+
+		err := r.cache.Set(str_id, fc)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(r.entries) == 0 {
+
+		r.rtree = rtreego.NewTree(2, 25, 50, spatial...)
+		r.entries = all_entries
+		r.prepared = all_prepared
+
+		return nil
+	}
+
+	for str_id, entries := range all_entries {
+
+		r.removeEntries(str_id)
+
+		for _, sp := range entries {
+			r.rtree.Insert(sp)
+		}
+
+		r.entries[str_id] = entries
+
+		prepared, ok := all_prepared[str_id]
+
+		if ok {
+			r.prepared[str_id] = prepared
+		}
+	}
+
+	return nil
+}
+
+// snapshotWriter wraps a bufio.Writer and remembers the first error it encounters so that
+// callers only need to check for errors once per record
+
+type snapshotWriter struct {
+	wr  *bufio.Writer
+	buf []byte
+	err error
+}
+
+func newSnapshotWriter(wr io.Writer) *snapshotWriter {
+
+	sw := snapshotWriter{
+		wr:  bufio.NewWriter(wr),
+		buf: make([]byte, binary.MaxVarintLen64),
+	}
+
+	return &sw
+}
+
+func (sw *snapshotWriter) writeBytes(b []byte) {
+
+	if sw.err != nil {
+		return
+	}
+
+	_, sw.err = sw.wr.Write(b)
+}
+
+func (sw *snapshotWriter) writeUvarint(v uint64) {
+
+	n := binary.PutUvarint(sw.buf, v)
+	sw.writeBytes(sw.buf[0:n])
+}
+
+func (sw *snapshotWriter) writeUint32(v uint32) {
+
+	binary.LittleEndian.PutUint32(sw.buf, v)
+	sw.writeBytes(sw.buf[0:4])
+}
+
+func (sw *snapshotWriter) writeFloat64(v float64) {
+
+	binary.LittleEndian.PutUint64(sw.buf, math.Float64bits(v))
+	sw.writeBytes(sw.buf[0:8])
+}
+
+func (sw *snapshotWriter) writeString(s string) {
+
+	sw.writeUvarint(uint64(len(s)))
+	sw.writeBytes([]byte(s))
+}
+
+// writeCacheItem writes 'fc' using the cache package's binary encoding. Coordinates are always
+// written as float64s, and never compressed, since snapshots need to be lossless and are already
+// compressed as a whole
+
+func (sw *snapshotWriter) writeCacheItem(fc cache.CacheItem) error {
+
+	opts, err := cache.DefaultItemEncodingOptions()
+
+	if err != nil {
+		return err
+	}
+
+	opts.Compress = false
+	opts.Coordinates = cache.COORDINATES_FLOAT64
+
+	body, err := cache.EncodeCacheItem(fc, opts)
+
+	if err != nil {
+		return err
+	}
+
+	sw.writeUvarint(uint64(len(body)))
+	sw.writeBytes(body)
+
+	return sw.err
+}
+
+func (sw *snapshotWriter) flush() error {
+
+	if sw.err != nil {
+		return sw.err
+	}
+
+	return sw.wr.Flush()
+}
+
+// snapshotReader is the reading equivalent of snapshotWriter - once an error is encountered
+// every subsequent read returns a zero value
+
+type snapshotReader struct {
+	rd  *bufio.Reader
+	buf []byte
+	err error
+}
+
+func newSnapshotReader(rd io.Reader) *snapshotReader {
+
+	sr := snapshotReader{
+		rd:  bufio.NewReader(rd),
+		buf: make([]byte, 8),
+	}
+
+	return &sr
+}
+
+// readBytes reads 'n' bytes. lengths come from the snapshot itself so see cache.ReadBytes for how
+// corrupt (or truncated) snapshots are dealt with
+
+func (sr *snapshotReader) readBytes(n int) []byte {
+
+	if sr.err != nil {
+		return nil
+	}
+
+	var b []byte
+	b, sr.err = cache.ReadBytes(sr.rd, n)
+
+	return b
+}
+
+func (sr *snapshotReader) readUvarint() uint64 {
+
+	if sr.err != nil {
+		return 0
+	}
+
+	var v uint64
+	v, sr.err = binary.ReadUvarint(sr.rd)
+
+	return v
+}
+
+func (sr *snapshotReader) readUint32() uint32 {
+
+	if sr.err != nil {
+		return 0
+	}
+
+	_, sr.err = io.ReadFull(sr.rd, sr.buf[0:4])
+	return binary.LittleEndian.Uint32(sr.buf[0:4])
+}
+
+func (sr *snapshotReader) readFloat64() float64 {
+
+	if sr.err != nil {
+		return 0.0
+	}
+
+	_, sr.err = io.ReadFull(sr.rd, sr.buf[0:8])
+	return math.Float64frombits(binary.LittleEndian.Uint64(sr.buf[0:8]))
+}
+
+func (sr *snapshotReader) readString() string {
+
+	n := sr.readUvarint()
+
+	if n > math.MaxInt32 {
+		sr.err = errors.New("Invalid string length")
+		return ""
+	}
+
+	return string(sr.readBytes(int(n)))
+}
+
+func (sr *snapshotReader) readCacheItem() (cache.CacheItem, error) {
+
+	body := sr.readString()
+
+	if sr.err != nil {
+		return nil, sr.err
+	}
+
+	return cache.DecodeCacheItem([]byte(body))
+}
@@ -0,0 +1,266 @@
+package index_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"github.com/skelterjohn/geom"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/conformance"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newSnapshot(t *testing.T) (index.Index, []byte) {
+
+	idx := newTestRTreeIndex(t, nil)
+
+	for _, fx := range conformance.Fixtures() {
+
+		f, err := fx.NewFeature()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = idx.IndexFeature(f)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	err := idx.(index.SnapshotIndex).WriteSnapshot(&buf)
+
+	if err != nil {
+		t.Fatalf("Failed to write snapshot, %s", err)
+	}
+
+	return idx, buf.Bytes()
+}
+
+func coordResults(t *testing.T, idx index.Index) []string {
+
+	filters, err := filter.NewSPRFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := make([]string, 0)
+
+	for _, c := range conformance.CoordCases() {
+
+		coord := geom.Coord{X: c.Coord[0], Y: c.Coord[1]}
+
+		rs, err := idx.GetIntersectsByCoordContext(context.Background(), coord, filters)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results = append(results, c.Name+": "+strings.Join(resultIds(rs), ","))
+	}
+
+	return results
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+
+	idx, snapshot := newSnapshot(t)
+
+	restored := newTestRTreeIndex(t, nil)
+
+	err := restored.(index.SnapshotIndex).ReadSnapshot(bytes.NewReader(snapshot))
+
+	if err != nil {
+		t.Fatalf("Failed to read snapshot, %s", err)
+	}
+
+	expected := coordResults(t, idx)
+	actual := coordResults(t, restored)
+
+	for i := range expected {
+
+		if expected[i] != actual[i] {
+			t.Errorf("Expected %s, got %s", expected[i], actual[i])
+		}
+	}
+}
+
+func TestSnapshotTruncated(t *testing.T) {
+
+	_, snapshot := newSnapshot(t)
+
+	for n := 0; n < len(snapshot); n++ {
+
+		restored := newTestRTreeIndex(t, nil)
+
+		err := restored.(index.SnapshotIndex).ReadSnapshot(bytes.NewReader(snapshot[0:n]))
+
+		if err == nil {
+			t.Fatalf("Reading the first %d of %d bytes of a snapshot succeeded", n, len(snapshot))
+		}
+
+		// nothing from a snapshot that can't be read should end up in the cache
+
+		if restored.Cache().Size() != 0 {
+			t.Fatalf("Reading the first %d of %d bytes of a snapshot added %d items to the cache", n, len(snapshot), restored.Cache().Size())
+		}
+	}
+}
+
+// a corrupt length shouldn't cause ReadSnapshot to allocate (much) more memory than there is input
+
+func TestSnapshotOversizedLength(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+
+	b := make([]byte, binary.MaxVarintLen64)
+
+	gz.Write([]byte(index.SNAPSHOT_MAGIC))
+
+	binary.LittleEndian.PutUint32(b, index.SNAPSHOT_VERSION)
+	gz.Write(b[0:4])
+
+	n := binary.PutUvarint(b, 1)
+	gz.Write(b[0:n])
+
+	n = binary.PutUvarint(b, 0x7fffffff)
+	gz.Write(b[0:n])
+
+	gz.Write([]byte("1234"))
+	gz.Close()
+
+	var before runtime.MemStats
+	var after runtime.MemStats
+
+	runtime.ReadMemStats(&before)
+
+	err := newTestRTreeIndex(t, nil).(index.SnapshotIndex).ReadSnapshot(&buf)
+
+	runtime.ReadMemStats(&after)
+
+	if err == nil {
+		t.Fatal("Expected an error reading a corrupt snapshot")
+	}
+
+	allocated := after.TotalAlloc - before.TotalAlloc
+
+	if allocated > 16*1024*1024 {
+		t.Fatalf("Reading a corrupt snapshot allocated %d bytes", allocated)
+	}
+}
+
+// blockingCache blocks the first call to Get until 'release' is closed
+
+type blockingCache struct {
+	cache.Cache
+	once    sync.Once
+	blocked chan bool
+	release chan bool
+}
+
+func (c *blockingCache) Get(key string) (cache.CacheItem, error) {
+
+	c.once.Do(func() {
+		close(c.blocked)
+		<-c.release
+	})
+
+	return c.Cache.Get(key)
+}
+
+// writing a snapshot shouldn't stop the index from being updated while it reads the cache
+
+func TestWriteSnapshotDoesNotBlockUpdates(t *testing.T) {
+
+	c := &blockingCache{
+		Cache:   newTestCache(t),
+		blocked: make(chan bool),
+		release: make(chan bool),
+	}
+
+	opts, err := index.DefaultRTreeIndexOptions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := index.NewRTreeIndexWithOptions(c, opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	features := conformance.Fixtures()
+
+	f, err := features[0].NewFeature()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = idx.IndexFeature(f)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write_done := make(chan bool)
+
+	go func() {
+
+		var buf bytes.Buffer
+		err := idx.WriteSnapshot(&buf)
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		close(write_done)
+	}()
+
+	select {
+	case <-c.blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the snapshot to read the cache")
+	}
+
+	update_done := make(chan bool)
+
+	go func() {
+
+		f, err := features[1].NewFeature()
+
+		if err == nil {
+			err = idx.IndexFeature(f)
+		}
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		close(update_done)
+	}()
+
+	select {
+	case <-update_done:
+	case <-time.After(5 * time.Second):
+		t.Error("Timed out indexing a feature while a snapshot was being written")
+	}
+
+	close(c.release)
+	<-write_done
+	<-update_done
+}
@@ -0,0 +1,198 @@
+package index
+
+import (
+	"errors"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	SORT_PLACETYPE    = "placetype"
+	SORT_AREA         = "area"
+	SORT_NAME         = "name"
+	SORT_LASTMODIFIED = "lastmodified"
+)
+
+// placetype_depth is the order in which placetypes are sorted, from the largest to the smallest.
+// It follows the "common" and "optional" placetypes in the go-whosonfirst-placetypes package;
+// anything that isn't listed here is sorted after all of them.
+
+var placetype_depth = map[string]int{
+	"planet":        0,
+	"continent":     1,
+	"ocean":         2,
+	"empire":        3,
+	"country":       4,
+	"dependency":    5,
+	"disputed":      6,
+	"marinearea":    7,
+	"macroregion":   8,
+	"region":        9,
+	"macrocounty":   10,
+	"county":        11,
+	"metroarea":     12,
+	"localadmin":    13,
+	"locality":      14,
+	"postalcode":    15,
+	"borough":       16,
+	"macrohood":     17,
+	"neighbourhood": 18,
+	"microhood":     19,
+	"campus":        20,
+	"building":      21,
+	"address":       22,
+	"venue":         23,
+}
+
+// SortOrders returns the list of valid sort orders for SortResults.
+
+func SortOrders() []string {
+	return []string{SORT_AREA, SORT_LASTMODIFIED, SORT_NAME, SORT_PLACETYPE}
+}
+
+// SortResults returns a copy of 'results' ordered by 'order', which is one of:
+//
+// * placetype - by placetype depth (continent, country, region and so on down to neighbourhood) which is
+// the default order for results returned by the indices
+// * area - by the area of each result's bounding box (on the ground, not in square degrees), smallest first
+// * name - alphabetically by name
+// * lastmodified - by last modified date, most recent first
+//
+// In every case results that are otherwise equal are ordered by ID so the output is always stable.
+
+func SortResults(results spr.StandardPlacesResults, order string) (spr.StandardPlacesResults, error) {
+
+	var cmp func(spr.StandardPlacesResult, spr.StandardPlacesResult) int
+
+	switch order {
+	case SORT_PLACETYPE:
+		cmp = comparePlacetypes
+	case SORT_AREA:
+		cmp = compareAreas
+	case SORT_NAME:
+		cmp = compareNames
+	case SORT_LASTMODIFIED:
+		cmp = compareLastModified
+	default:
+		return nil, errors.New("Invalid sort order")
+	}
+
+	places := make([]spr.StandardPlacesResult, len(results.Results()))
+	copy(places, results.Results())
+
+	sortPlaces(places, cmp)
+
+	rsp := PlacesResults{
+		Places: places,
+	}
+
+	return &rsp, nil
+}
+
+// sortPlaces sorts 'places' in place using 'cmp', then ID and then alternate geometry label
+
+func sortPlaces(places []spr.StandardPlacesResult, cmp func(spr.StandardPlacesResult, spr.StandardPlacesResult) int) {
+
+	sort.SliceStable(places, func(i, j int) bool {
+
+		c := cmp(places[i], places[j])
+
+		if c != 0 {
+			return c < 0
+		}
+
+		c = compareIds(places[i], places[j])
+
+		if c != 0 {
+			return c < 0
+		}
+
+		// default and alternate geometries for the same ID
+
+		return alt.Label(places[i]) < alt.Label(places[j])
+	})
+}
+
+// sortPlacesByPlacetype is the default order for results returned by the indices
+
+func sortPlacesByPlacetype(places []spr.StandardPlacesResult) {
+	sortPlaces(places, comparePlacetypes)
+}
+
+func comparePlacetypes(a spr.StandardPlacesResult, b spr.StandardPlacesResult) int {
+	return compareInts(placetypeDepth(a.Placetype()), placetypeDepth(b.Placetype()))
+}
+
+func compareAreas(a spr.StandardPlacesResult, b spr.StandardPlacesResult) int {
+	return compareFloats(boundingBoxArea(a), boundingBoxArea(b))
+}
+
+func compareNames(a spr.StandardPlacesResult, b spr.StandardPlacesResult) int {
+	return strings.Compare(strings.ToLower(a.Name()), strings.ToLower(b.Name()))
+}
+
+func compareLastModified(a spr.StandardPlacesResult, b spr.StandardPlacesResult) int {
+	return compareInts(b.LastModified(), a.LastModified())
+}
+
+// compareIds compares WOF IDs numerically, falling back to comparing them as strings
+// for non-WOF documents
+
+func compareIds(a spr.StandardPlacesResult, b spr.StandardPlacesResult) int {
+
+	id_a, err_a := strconv.ParseInt(a.Id(), 10, 64)
+	id_b, err_b := strconv.ParseInt(b.Id(), 10, 64)
+
+	if err_a != nil || err_b != nil {
+		return strings.Compare(a.Id(), b.Id())
+	}
+
+	return compareInts(id_a, id_b)
+}
+
+func placetypeDepth(pt string) int64 {
+
+	depth, ok := placetype_depth[pt]
+
+	if !ok {
+		depth = len(placetype_depth)
+	}
+
+	return int64(depth)
+}
+
+// boundingBoxArea returns the area of the bounding box for 's' on the ground, rather than in
+// square degrees, so that places far from the equator aren't sorted as if they were larger
+// than they are
+
+func boundingBoxArea(s spr.StandardPlacesResult) float64 {
+	return geo.BoundingBoxArea(s.MinLongitude(), s.MinLatitude(), s.MaxLongitude(), s.MaxLatitude())
+}
+
+func compareInts(a int64, b int64) int {
+
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareFloats(a float64, b float64) int {
+
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,113 @@
+package index_test
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"github.com/whosonfirst/go-whosonfirst-spr"
+	"strings"
+	"testing"
+)
+
+// testSPR is just enough of a spr.StandardPlacesResult to sort
+
+type testSPR struct {
+	spr.StandardPlacesResult
+	id        string
+	name      string
+	placetype string
+	lastmod   int64
+	bbox      [4]float64
+}
+
+func (s *testSPR) Id() string {
+	return s.id
+}
+
+func (s *testSPR) Name() string {
+	return s.name
+}
+
+func (s *testSPR) Placetype() string {
+	return s.placetype
+}
+
+func (s *testSPR) Path() string {
+	return s.id + ".geojson"
+}
+
+func (s *testSPR) LastModified() int64 {
+	return s.lastmod
+}
+
+func (s *testSPR) MinLongitude() float64 {
+	return s.bbox[0]
+}
+
+func (s *testSPR) MinLatitude() float64 {
+	return s.bbox[1]
+}
+
+func (s *testSPR) MaxLongitude() float64 {
+	return s.bbox[2]
+}
+
+func (s *testSPR) MaxLatitude() float64 {
+	return s.bbox[3]
+}
+
+func TestSortResults(t *testing.T) {
+
+	places := []spr.StandardPlacesResult{
+		// 10x10 degrees at the equator
+		&testSPR{id: "1", name: "b", placetype: "locality", lastmod: 300, bbox: [4]float64{0.0, 0.0, 10.0, 10.0}},
+		// more square degrees but a much smaller area on the ground
+		&testSPR{id: "2", name: "A", placetype: "region", lastmod: 100, bbox: [4]float64{0.0, 70.0, 15.0, 80.0}},
+		// 20x10 degrees across the antimeridian
+		&testSPR{id: "3", name: "c", placetype: "custom", lastmod: 200, bbox: [4]float64{170.0, 0.0, -170.0, 10.0}},
+		&testSPR{id: "4", name: "B", placetype: "country", lastmod: 300, bbox: [4]float64{0.0, 0.0, 10.0, 10.0}},
+	}
+
+	results := &index.PlacesResults{
+		Places: places,
+	}
+
+	tests := map[string]string{
+		index.SORT_PLACETYPE:    "4,2,1,3",
+		index.SORT_AREA:         "2,1,4,3",
+		index.SORT_NAME:         "2,1,4,3",
+		index.SORT_LASTMODIFIED: "1,4,3,2",
+	}
+
+	for _, order := range index.SortOrders() {
+
+		expected, ok := tests[order]
+
+		if !ok {
+			t.Errorf("Missing test for sort order '%s'", order)
+			continue
+		}
+
+		rs, err := index.SortResults(results, order)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := strings.Join(resultIds(rs), ",")
+
+		if ids != expected {
+			t.Errorf("Expected %s for sort order '%s', got %s", expected, order, ids)
+		}
+	}
+
+	// the results that were passed in are left as they were
+
+	if ids := strings.Join(resultIds(results), ","); ids != "1,2,3,4" {
+		t.Errorf("Expected the original results to be left alone, got %s", ids)
+	}
+
+	_, err := index.SortResults(results, "size")
+
+	if err == nil {
+		t.Error("Expected an invalid sort order to fail")
+	}
+}
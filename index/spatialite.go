@@ -5,15 +5,24 @@ package index
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/skelterjohn/geom"
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	gogeom "github.com/twpayne/go-geom"
+	gogeom_geojson "github.com/twpayne/go-geom/encoding/geojson"
+	"github.com/twpayne/go-geom/encoding/wkt"
 	"github.com/whosonfirst/go-whosonfirst-geojson-v2"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/geometry"
+	"github.com/whosonfirst/go-whosonfirst-geojson-v2/properties/whosonfirst"
 	"github.com/whosonfirst/go-whosonfirst-log"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/cache"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2/filter"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/geo"
 	"github.com/whosonfirst/go-whosonfirst-spr"
 	"github.com/whosonfirst/go-whosonfirst-sqlite-features/tables"
 	"github.com/whosonfirst/go-whosonfirst-sqlite/database"
@@ -21,6 +30,11 @@ import (
 	"sync"
 )
 
+// spatialite_key is the key that each row in the geometries table is cached under: its ID for
+// default geometries and {ID}-alt-{LABEL} for alternate geometries (see alt.Key)
+
+const spatialite_key string = `(CASE WHEN alt_label IS NULL OR alt_label = '' THEN id ELSE id || '-alt-' || alt_label END) AS id`
+
 type SpatialiteIndex struct {
 	Index
 	Logger   *log.WOFLogger
@@ -83,7 +97,14 @@ func (i *SpatialiteIndex) Cache() cache.Cache {
 
 func (i *SpatialiteIndex) IndexFeature(f geojson.Feature) error {
 
-	ctx := context.Background()
+	return i.indexFeature(f, false)
+}
+
+// indexFeature caches 'f' and writes its geometry to the geometries table. If 'replace' is true
+// any existing geometry for 'f' is deleted in the same transaction, and while holding the same
+// lock, so that queries see either the old geometry or the new one but never neither.
+
+func (i *SpatialiteIndex) indexFeature(f geojson.Feature, replace bool) error {
 
 	// SEE ABOVE
 
@@ -96,33 +117,226 @@ func (i *SpatialiteIndex) IndexFeature(f geojson.Feature) error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
-	db := i.database
+	fc, err := cache.NewFeatureCache(f)
+
+	if err != nil {
+		return err
+	}
 
-	t, err := tables.NewGeometriesTable(ctx)
+	str_id := f.Id()
+
+	err = i.cache.Set(str_id, fc)
 
 	if err != nil {
 		return err
 	}
 
-	fc, err := cache.NewFeatureCache(f)
+	// the geometries table stores alternate geometries by their ID and
+	// label so make sure it is given the actual feature rather than one
+	// that returns its alternate geometry key as its ID
+
+	alt_f, is_alt := f.(*alt.AltFeature)
+
+	if is_alt {
+		f = alt_f.Feature
+	}
+
+	f, err = normalizeSpatialiteFeature(f, fc)
 
 	if err != nil {
 		return err
 	}
 
-	str_id := f.Id()
+	conn, err := i.database.Conn()
 
-	err = i.cache.Set(str_id, fc)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	if replace {
+
+		err = deleteGeometries(tx, str_id)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = insertGeometry(tx, f)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertGeometry writes the geometry for 'f' to the geometries table the same way that the
+// go-whosonfirst-sqlite-features geometries table does, except as part of 'tx'. Spatialite
+// quietly stores null geometries when they are indexed with GeomFromGeoJSON so the geometry is
+// converted to WKT first.
+
+func insertGeometry(tx *sql.Tx, f geojson.Feature) error {
+
+	str_geom := gjson.GetBytes(f.Bytes(), "geometry").Raw
+
+	var g gogeom.T
+	err := gogeom_geojson.Unmarshal([]byte(str_geom), &g)
+
+	if err != nil {
+		return err
+	}
+
+	str_wkt, err := wkt.Marshal(g)
+
+	if err != nil {
+		return err
+	}
+
+	q := `INSERT OR REPLACE INTO geometries (
+		id, is_alt, alt_label, type, geom, lastmodified
+	) VALUES (
+		?, ?, ?, ?, GeomFromText(?, 4326), ?
+	)`
+
+	_, err = tx.Exec(q, f.Id(), whosonfirst.IsAlt(f), whosonfirst.AltLabel(f), "common", str_wkt, whosonfirst.LastModified(f))
+	return err
+}
+
+// spatialiteFeature is a geojson.Feature whose geometry has been replaced by the (normalized)
+// polygons in its cache item
+
+type spatialiteFeature struct {
+	geojson.Feature
+	body []byte
+}
+
+func (f *spatialiteFeature) String() string {
+	return string(f.body)
+}
+
+func (f *spatialiteFeature) Bytes() []byte {
+	return f.body
+}
+
+// normalizeSpatialiteFeature returns 'f' with its geometry replaced by the polygons in 'fc' if
+// any of its polygons cross the antimeridian, since the geometries table is populated from the
+// feature itself (rather than its cache item) and spatialite would otherwise treat them as
+// spanning (almost) every longitude. Otherwise 'f' is returned as-is.
+
+func normalizeSpatialiteFeature(f geojson.Feature, fc cache.CacheItem) (geojson.Feature, error) {
+
+	polys, err := geometry.PolygonsForFeature(f)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, changed := geo.NormalizePolygons(polys)
+
+	if !changed {
+		return f, nil
+	}
+
+	body, err := sjson.SetBytes(f.Bytes(), "geometry", fc.Geometry())
+
+	if err != nil {
+		return nil, err
+	}
+
+	sp_f := spatialiteFeature{
+		Feature: f,
+		body:    body,
+	}
+
+	return &sp_f, nil
+}
+
+// UpdateFeature replaces the existing geometry for 'f' (see indexFeature)
+
+func (i *SpatialiteIndex) UpdateFeature(f geojson.Feature) error {
+
+	return i.indexFeature(f, true)
+}
+
+func (i *SpatialiteIndex) RemoveFeature(str_id string) error {
+
+	<-i.throttle
+
+	defer func() {
+		i.throttle <- true
+	}()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	conn, err := i.database.Conn()
+
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.Begin()
 
 	if err != nil {
 		return err
 	}
 
-	return t.IndexRecord(ctx, db, f)
+	err = deleteGeometries(tx, str_id)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return err
+	}
+
+	return i.cache.Delete(str_id)
+}
+
+// deleteGeometries removes the row for 'str_id', which may be an alternate geometry key, from
+// the geometries table as part of 'tx'
+
+func deleteGeometries(tx *sql.Tx, str_id string) error {
+
+	// the spatial index (idx_geometries_geom) is kept up to date by the triggers
+	// that CreateSpatialIndex sets up so there's no need to touch it here
+
+	id, label, is_alt := alt.ParseKey(str_id)
+
+	if is_alt {
+		q := "DELETE FROM geometries WHERE id = ? AND alt_label = ?"
+		_, err := tx.Exec(q, id, label)
+		return err
+	}
+
+	q := "DELETE FROM geometries WHERE id = ? AND (alt_label IS NULL OR alt_label = '')"
+
+	_, err := tx.Exec(q, str_id)
+	return err
 }
 
 func (i *SpatialiteIndex) GetIntersectsByCoord(coord geom.Coord, f filter.Filter) (spr.StandardPlacesResults, error) {
 
+	return i.GetIntersectsByCoordContext(context.Background(), coord, f)
+}
+
+func (i *SpatialiteIndex) GetIntersectsByCoordContext(ctx context.Context, coord geom.Coord, f filter.Filter) (spr.StandardPlacesResults, error) {
+
+	coord = geo.NormalizeCoord(coord)
+
 	db := i.database
 
 	conn, err := db.Conn()
@@ -134,19 +348,17 @@ func (i *SpatialiteIndex) GetIntersectsByCoord(coord geom.Coord, f filter.Filter
 	lat := coord.Y
 	lon := coord.X
 
-	places := make([]spr.StandardPlacesResult, 0)
-
 	// for reasons I don't understand this returns empty - I am guessing it has something
 	// to do with internal escaping... (20180220/thisisaaronland)
 	// q := `SELECT id FROM geometries WHERE ST_Within(GeomFromText('POINT(? ?)'), geom) AND rowid IN (SELECT pkid FROM idx_geometries_geom WHERE xmin < ? AND xmax > ? AND ymin < ? AND ymax > ?)`
 	// rows, err := conn.Query(q, lon, lat, lon, lon, lat, lat)
 
-	q := fmt.Sprintf(`SELECT id FROM geometries WHERE ST_Within(GeomFromText('POINT(%0.6f %0.6f)'), geom)
+	q := fmt.Sprintf(`SELECT `+spatialite_key+` FROM geometries WHERE ST_Within(GeomFromText('POINT(%0.6f %0.6f)'), geom)
 		          AND rowid IN (
 			    SELECT pkid FROM idx_geometries_geom WHERE xmin < %0.6f AND xmax > %0.6f AND ymin < %0.6f AND ymax > %0.6f
                           )`, lon, lat, lon, lon, lat, lat)
 
-	rows, err := conn.Query(q)
+	rows, err := conn.QueryContext(ctx, q)
 
 	if err != nil {
 		return nil, err
@@ -154,47 +366,139 @@ func (i *SpatialiteIndex) GetIntersectsByCoord(coord geom.Coord, f filter.Filter
 
 	defer rows.Close()
 
-	for rows.Next() {
+	return i.inflateResults(ctx, rows, f)
+}
 
-		var str_id string
-		err = rows.Scan(&str_id)
+func (i *SpatialiteIndex) GetIntersectsByBoundingBox(bbox geom.Rect, f filter.Filter) (spr.StandardPlacesResults, error) {
 
-		if err != nil {
-			return nil, err
-		}
+	return i.GetIntersectsByBoundingBoxContext(context.Background(), bbox, f)
+}
 
-		fc, err := i.cache.Get(str_id)
+// GetIntersectsByBoundingBoxContext returns the places whose polygons intersect 'bbox'. A bounding box
+// whose minimum longitude is greater than its maximum longitude crosses the antimeridian and is queried
+// as two bounding boxes, one on either side of it, whose results are merged.
+
+func (i *SpatialiteIndex) GetIntersectsByBoundingBoxContext(ctx context.Context, bbox geom.Rect, f filter.Filter) (spr.StandardPlacesResults, error) {
+
+	bounds := geo.SplitRect(bbox)
+	results := make([]spr.StandardPlacesResults, len(bounds))
+
+	for idx, b := range bounds {
+
+		rsp, err := i.getIntersectsByBoundingBox(ctx, b, f)
 
 		if err != nil {
 			return nil, err
 		}
 
-		s := fc.SPR()
+		results[idx] = rsp
+	}
 
-		err = filter.FilterSPR(f, s)
+	if len(results) == 1 {
+		return results[0], nil
+	}
 
-		if err != nil {
-			continue
-		}
+	return mergeResults(results), nil
+}
 
-		places = append(places, fc.SPR())
+func (i *SpatialiteIndex) getIntersectsByBoundingBox(ctx context.Context, bbox geom.Rect, f filter.Filter) (spr.StandardPlacesResults, error) {
+
+	db := i.database
+
+	conn, err := db.Conn()
+
+	if err != nil {
+		return nil, err
 	}
 
-	err = rows.Err()
+	minx := bbox.Min.X
+	miny := bbox.Min.Y
+	maxx := bbox.Max.X
+	maxy := bbox.Max.Y
+
+	q := fmt.Sprintf(`SELECT `+spatialite_key+` FROM geometries WHERE ST_Intersects(BuildMbr(%0.6f, %0.6f, %0.6f, %0.6f), geom)
+		          AND rowid IN (
+			    SELECT pkid FROM idx_geometries_geom WHERE xmin <= %0.6f AND xmax >= %0.6f AND ymin <= %0.6f AND ymax >= %0.6f
+                          )`, minx, miny, maxx, maxy, maxx, minx, maxy, miny)
+
+	rows, err := conn.QueryContext(ctx, q)
 
 	if err != nil {
 		return nil, err
 	}
 
-	r := SpatialiteResults{
-		Places: places,
+	defer rows.Close()
+
+	return i.inflateResults(ctx, rows, f)
+}
+
+func (i *SpatialiteIndex) GetIntersectsByGeometry(g *geo.Geometry, f filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return i.GetIntersectsByGeometryContext(context.Background(), g, f)
+}
+
+func (i *SpatialiteIndex) GetIntersectsByGeometryContext(ctx context.Context, g *geo.Geometry, f filter.Filter) (spr.StandardPlacesResults, error) {
+
+	db := i.database
+
+	conn, err := db.Conn()
+
+	if err != nil {
+		return nil, err
 	}
 
-	return &r, nil
+	bbox := g.Bounds()
+
+	minx := bbox.Min.X
+	miny := bbox.Min.Y
+	maxx := bbox.Max.X
+	maxy := bbox.Max.Y
+
+	q := fmt.Sprintf(`SELECT `+spatialite_key+` FROM geometries WHERE ST_Intersects(GeomFromText('%s'), geom)
+		          AND rowid IN (
+			    SELECT pkid FROM idx_geometries_geom WHERE xmin <= %0.6f AND xmax >= %0.6f AND ymin <= %0.6f AND ymax >= %0.6f
+                          )`, g.WKT(), maxx, minx, maxy, miny)
+
+	rows, err := conn.QueryContext(ctx, q)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return i.inflateResults(ctx, rows, f)
+}
+
+func (i *SpatialiteIndex) GetNearestByCoord(coord geom.Coord, k int, max_distance float64, f filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return i.GetNearestByCoordContext(context.Background(), coord, k, max_distance, f)
+}
+
+func (i *SpatialiteIndex) GetNearestByCoordContext(ctx context.Context, coord geom.Coord, k int, max_distance float64, f filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return getNearestByCoord(ctx, i.cache, coord, k, max_distance, f, i.getIdsByBounds)
+}
+
+func (i *SpatialiteIndex) GetIntersectsByRadius(coord geom.Coord, radius float64, f filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return i.GetIntersectsByRadiusContext(context.Background(), coord, radius, f)
+}
+
+func (i *SpatialiteIndex) GetIntersectsByRadiusContext(ctx context.Context, coord geom.Coord, radius float64, f filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return getIntersectsByRadius(ctx, i.cache, coord, radius, f, i.getIdsByBounds)
 }
 
 func (i *SpatialiteIndex) GetCandidatesByCoord(coord geom.Coord) (*pip.GeoJSONFeatureCollection, error) {
 
+	return i.GetCandidatesByCoordContext(context.Background(), coord)
+}
+
+func (i *SpatialiteIndex) GetCandidatesByCoordContext(ctx context.Context, coord geom.Coord) (*pip.GeoJSONFeatureCollection, error) {
+
+	coord = geo.NormalizeCoord(coord)
+
 	db := i.database
 
 	conn, err := db.Conn()
@@ -206,14 +510,16 @@ func (i *SpatialiteIndex) GetCandidatesByCoord(coord geom.Coord) (*pip.GeoJSONFe
 	lat := coord.Y
 	lon := coord.X
 
-	q := fmt.Sprintf(`SELECT id, AsGeoJSON(ST_Envelope(geom)) AS geom FROM geometries WHERE ST_Within(GeomFromText('POINT(%0.6f %0.6f)'), ST_Envelope(geom))`, lon, lat)
+	q := fmt.Sprintf(`SELECT `+spatialite_key+`, AsGeoJSON(ST_Envelope(geom)) AS geom FROM geometries WHERE ST_Within(GeomFromText('POINT(%0.6f %0.6f)'), ST_Envelope(geom))`, lon, lat)
 
-	rows, err := conn.Query(q)
+	rows, err := conn.QueryContext(ctx, q)
 
 	if err != nil {
 		return nil, err
 	}
 
+	defer rows.Close()
+
 	features := make([]pip.GeoJSONFeature, 0)
 
 	for rows.Next() {
@@ -289,6 +595,34 @@ func (i *SpatialiteIndex) GetCandidatesByCoord(coord geom.Coord) (*pip.GeoJSONFe
 
 func (i *SpatialiteIndex) GetIntersectsByPath(path geom.Path, f filter.Filter) ([]spr.StandardPlacesResults, error) {
 
+	return i.GetIntersectsByPathContext(context.Background(), path, f)
+}
+
+func (i *SpatialiteIndex) GetIntersectsByPathContext(ctx context.Context, path geom.Path, f filter.Filter) ([]spr.StandardPlacesResults, error) {
+
+	return getIntersectsByPathVertices(ctx, path, f, i.GetIntersectsByCoordContext)
+}
+
+func (i *SpatialiteIndex) GetIntersectsByPathAggregate(path geom.Path, f filter.Filter) (spr.StandardPlacesResults, error) {
+
+	return i.GetIntersectsByPathAggregateContext(context.Background(), path, f)
+}
+
+func (i *SpatialiteIndex) GetIntersectsByPathAggregateContext(ctx context.Context, path geom.Path, f filter.Filter) (spr.StandardPlacesResults, error) {
+
+	// a LINESTRING needs at least two points
+
+	if path.Length() < 2 {
+
+		results, err := i.GetIntersectsByPathContext(ctx, path, f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return mergeResults(results), nil
+	}
+
 	db := i.database
 
 	conn, err := db.Conn()
@@ -297,18 +631,56 @@ func (i *SpatialiteIndex) GetIntersectsByPath(path geom.Path, f filter.Filter) (
 		return nil, err
 	}
 
-	pending := path.Length()
-	points := make([]string, pending)
+	// the path is queried as a MULTILINESTRING of its segments so that segments which
+	// cross the antimeridian can be split in two (see pathSegments)
+
+	lines := make([]string, 0)
+
+	for _, seg := range pathSegments(path) {
+
+		a := seg[0]
+		b := seg[1]
+
+		lines = append(lines, fmt.Sprintf("(%0.6f %0.6f,%0.6f %0.6f)", a.X, a.Y, b.X, b.Y))
+	}
+
+	wkt := fmt.Sprintf("MULTILINESTRING(%s)", strings.Join(lines, ","))
+
+	q := fmt.Sprintf("SELECT "+spatialite_key+" FROM geometries WHERE ST_Intersects(GeomFromText('%s'), geom)", wkt)
+
+	rows, err := conn.QueryContext(ctx, q)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return i.inflateResults(ctx, rows, f)
+}
+
+// getIdsByBounds returns the IDs of all the geometries whose bounding boxes intersect 'bbox'
+
+func (i *SpatialiteIndex) getIdsByBounds(ctx context.Context, bbox geom.Rect) ([]string, error) {
 
-	for i, c := range path.Vertices() {
-		points[i] = fmt.Sprintf("%0.6f %0.6f", c.X, c.Y)
+	db := i.database
+
+	conn, err := db.Conn()
+
+	if err != nil {
+		return nil, err
 	}
 
-	wkt := fmt.Sprintf("LINESTRING(%s)", strings.Join(points, ","))
+	minx := bbox.Min.X
+	miny := bbox.Min.Y
+	maxx := bbox.Max.X
+	maxy := bbox.Max.Y
 
-	q := fmt.Sprintf("SELECT id FROM geometries WHERE ST_Intersects(GeomFromText('%s'), geom)", wkt)
+	q := fmt.Sprintf(`SELECT `+spatialite_key+` FROM geometries WHERE rowid IN (
+			    SELECT pkid FROM idx_geometries_geom WHERE xmin <= %0.6f AND xmax >= %0.6f AND ymin <= %0.6f AND ymax >= %0.6f
+                          )`, maxx, minx, maxy, miny)
 
-	rows, err := conn.Query(q)
+	rows, err := conn.QueryContext(ctx, q)
 
 	if err != nil {
 		return nil, err
@@ -316,10 +688,45 @@ func (i *SpatialiteIndex) GetIntersectsByPath(path geom.Path, f filter.Filter) (
 
 	defer rows.Close()
 
+	ids := make([]string, 0)
+
+	for rows.Next() {
+
+		var str_id string
+		err := rows.Scan(&str_id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, str_id)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// inflateResults reads WOF IDs from 'rows', fetches their cached SPR and
+// applies any filters, stopping early if 'ctx' is cancelled. Results are
+// ordered by placetype and then ID.
+
+func (i *SpatialiteIndex) inflateResults(ctx context.Context, rows *sql.Rows, f filter.Filter) (spr.StandardPlacesResults, error) {
+
 	places := make([]spr.StandardPlacesResult, 0)
 
 	for rows.Next() {
 
+		err := ctx.Err()
+
+		if err != nil {
+			return nil, err
+		}
+
 		var str_id string
 		err = rows.Scan(&str_id)
 
@@ -335,7 +742,7 @@ func (i *SpatialiteIndex) GetIntersectsByPath(path geom.Path, f filter.Filter) (
 
 		s := fc.SPR()
 
-		err = filter.FilterSPR(f, s)
+		err = filterSPR(i.cache, f, s)
 
 		if err != nil {
 			continue
@@ -344,16 +751,17 @@ func (i *SpatialiteIndex) GetIntersectsByPath(path geom.Path, f filter.Filter) (
 		places = append(places, fc.SPR())
 	}
 
-	err = rows.Err()
+	err := rows.Err()
 
 	if err != nil {
 		return nil, err
 	}
 
+	sortPlacesByPlacetype(places)
+
 	r := SpatialiteResults{
 		Places: places,
 	}
 
-	results := []spr.StandardPlacesResults{&r}
-	return results, nil
+	return &r, nil
 }
@@ -0,0 +1,209 @@
+// Package polyline encodes and decodes paths in the Google (and Valhalla) encoded polyline format
+// at any precision between MIN_PRECISION and MAX_PRECISION decimal places. Google uses a precision
+// of 5 and Valhalla uses a precision of 6.
+//
+// See also: https://developers.google.com/maps/documentation/utilities/polylinealgorithm
+package polyline
+
+import (
+	"errors"
+	"fmt"
+	"github.com/skelterjohn/geom"
+	"math"
+	"strings"
+)
+
+const (
+	MIN_PRECISION     = 1
+	MAX_PRECISION     = 10
+	DEFAULT_PRECISION = 5
+)
+
+// max_shift is the largest number of bits a single encoded value may use. It is more than enough
+// for a longitude of 180 degrees at MAX_PRECISION and stops malformed input from overflowing.
+
+const max_shift uint = 60
+
+var ErrInvalidPrecision = errors.New("Invalid polyline precision")
+
+var ErrTruncated = errors.New("Truncated polyline")
+
+var ErrOddValues = errors.New("Polyline has an odd number of values")
+
+// Factor returns the number that coordinates are multiplied by, before being rounded, when they
+// are encoded at 'precision' decimal places.
+
+func Factor(precision int) (float64, error) {
+
+	if precision < MIN_PRECISION || precision > MAX_PRECISION {
+		return 0.0, ErrInvalidPrecision
+	}
+
+	return math.Pow10(precision), nil
+}
+
+// Decode returns the path encoded in 'encoded' at 'precision' decimal places. Malformed input (bad
+// characters, truncated values or positions that aren't valid longitudes and latitudes) returns an
+// error rather than a partial path.
+
+func Decode(encoded string, precision int) (*geom.Path, error) {
+
+	factor, err := Factor(precision)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeWithFactor(encoded, factor)
+}
+
+// DecodeWithFactor is the same as Decode but takes the factor (for example 1.0e5) rather than the
+// precision.
+
+func DecodeWithFactor(encoded string, factor float64) (*geom.Path, error) {
+
+	if factor <= 0.0 || math.IsNaN(factor) || math.IsInf(factor, 0) {
+		return nil, ErrInvalidPrecision
+	}
+
+	path := geom.Path{}
+
+	var lat int64
+	var lon int64
+
+	count := 0
+	index := 0
+
+	for index < len(encoded) {
+
+		value, next, err := decodeValue(encoded, index)
+
+		if err != nil {
+			return nil, err
+		}
+
+		index = next
+
+		if count%2 == 0 {
+			lat += value
+		} else {
+
+			lon += value
+
+			c := geom.Coord{
+				X: float64(lon) / factor,
+				Y: float64(lat) / factor,
+			}
+
+			if c.X < -180.0 || c.X > 180.0 || c.Y < -90.0 || c.Y > 90.0 {
+				msg := fmt.Sprintf("Invalid polyline position %f, %f at offset %d", c.Y, c.X, index)
+				return nil, errors.New(msg)
+			}
+
+			path.AddVertex(c)
+		}
+
+		count++
+	}
+
+	if count%2 != 0 {
+		return nil, ErrOddValues
+	}
+
+	return &path, nil
+}
+
+// Encode returns 'path' as an encoded polyline at 'precision' decimal places.
+
+func Encode(path geom.Path, precision int) (string, error) {
+
+	factor, err := Factor(precision)
+
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+
+	var prev_lat int64
+	var prev_lon int64
+
+	for _, c := range path.Vertices() {
+
+		if math.IsNaN(c.X) || math.IsNaN(c.Y) || math.IsInf(c.X, 0) || math.IsInf(c.Y, 0) {
+			return "", errors.New("Invalid polyline position")
+		}
+
+		lat := int64(math.Round(c.Y * factor))
+		lon := int64(math.Round(c.X * factor))
+
+		encodeValue(&b, lat-prev_lat)
+		encodeValue(&b, lon-prev_lon)
+
+		prev_lat = lat
+		prev_lon = lon
+	}
+
+	return b.String(), nil
+}
+
+// decodeValue decodes the value starting at 'index' and returns it along with the index of the
+// next value
+
+func decodeValue(encoded string, index int) (int64, int, error) {
+
+	var result int64
+	var shift uint
+
+	for {
+
+		if index >= len(encoded) {
+			return 0, index, ErrTruncated
+		}
+
+		b := int64(encoded[index]) - 63
+
+		if b < 0 || b > 63 {
+			msg := fmt.Sprintf("Invalid polyline character at offset %d", index)
+			return 0, index, errors.New(msg)
+		}
+
+		index++
+
+		result |= (b & 0x1f) << shift
+		shift += 5
+
+		if b < 0x20 {
+			break
+		}
+
+		if shift > max_shift {
+			msg := fmt.Sprintf("Polyline value too large at offset %d", index)
+			return 0, index, errors.New(msg)
+		}
+	}
+
+	// sign detection
+
+	if result&1 != 0 {
+		return ^(result >> 1), index, nil
+	}
+
+	return result >> 1, index, nil
+}
+
+func encodeValue(b *strings.Builder, value int64) {
+
+	v := value << 1
+
+	if value < 0 {
+		v = ^v
+	}
+
+	for v >= 0x20 {
+		b.WriteByte(byte((0x20 | (v & 0x1f)) + 63))
+		v >>= 5
+	}
+
+	b.WriteByte(byte(v + 63))
+}
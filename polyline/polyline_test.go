@@ -0,0 +1,260 @@
+package polyline
+
+// the module still targets go1.16, which predates native fuzzing, so the fuzz tests are
+// testing/quick property tests seeded with the known edge cases
+
+import (
+	"fmt"
+	"github.com/skelterjohn/geom"
+	"math"
+	"math/rand"
+	"testing"
+	"testing/quick"
+)
+
+// seeds are the edge cases that Decode has to cope with, without panicking
+
+var seeds = []string{
+	"",
+	"_",
+	"?",
+	"~",
+	" ",
+	"\x00",
+	"\xff",
+	"_p~iF",
+	"_p~iF~ps|U",
+	"_p~iF~ps|U_",
+	"_p~iF~ps|U_ulL",
+	"_p~iF~ps|U_ulLnnqC_mqNvxq`@",
+	"_p~iF ps|U",
+	"__________________________",
+	"~~~~~~~~~~~~~~~~~~~~~~~~~~",
+	"~~~~~~~~~~~~~~~~~~~~~~~~~~?",
+	"????????????",
+	"_ibE_ibE",
+	"~hbE~hbE",
+}
+
+func mustNotPanic(t *testing.T, label string, fn func()) {
+
+	defer func() {
+
+		r := recover()
+
+		if r != nil {
+			t.Fatalf("%s panicked: %v", label, r)
+		}
+	}()
+
+	fn()
+}
+
+func TestDecode(t *testing.T) {
+
+	// https://developers.google.com/maps/documentation/utilities/polylinealgorithm
+
+	path, err := Decode("_p~iF~ps|U_ulLnnqC_mqNvxq`@", 5)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []geom.Coord{
+		{X: -120.2, Y: 38.5},
+		{X: -120.95, Y: 40.7},
+		{X: -126.453, Y: 43.252},
+	}
+
+	vertices := path.Vertices()
+
+	if len(vertices) != len(expected) {
+		t.Fatalf("Expected %d vertices, got %d", len(expected), len(vertices))
+	}
+
+	for i, c := range vertices {
+
+		if math.Abs(c.X-expected[i].X) > 1e-9 || math.Abs(c.Y-expected[i].Y) > 1e-9 {
+			t.Fatalf("Vertex %d is %v, expected %v", i, c, expected[i])
+		}
+	}
+
+	encoded, err := Encode(*path, 5)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if encoded != "_p~iF~ps|U_ulLnnqC_mqNvxq`@" {
+		t.Fatalf("Unexpected encoding '%s'", encoded)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+
+	tests := map[string]int{
+		"_p~iF":                       5,  // odd number of values
+		"_p~iF~ps|U_":                 5,  // truncated value
+		"_p~iF ps|U":                  5,  // invalid character
+		"~~~~~~~~~~~~~~~~~~~~~~~~~~?": 5,  // value too large
+		"_ibE_ibE":                    1,  // out of range position
+		"_p~iF~ps|U":                  0,  // precision too small
+		"_p~iF~ps|U ":                 11, // precision too large
+	}
+
+	for encoded, precision := range tests {
+
+		_, err := Decode(encoded, precision)
+
+		if err == nil {
+			t.Errorf("Expected an error decoding '%s' at precision %d", encoded, precision)
+		}
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+
+		path := geom.Path{}
+		path.AddVertex(geom.Coord{X: v, Y: 0.0})
+
+		_, err := Encode(path, 5)
+
+		if err == nil {
+			t.Errorf("Expected an error encoding %f", v)
+		}
+	}
+}
+
+// TestFuzzDecode checks that Decode never panics, whatever it is passed
+
+func TestFuzzDecode(t *testing.T) {
+
+	for _, s := range seeds {
+
+		for precision := MIN_PRECISION - 1; precision <= MAX_PRECISION+1; precision++ {
+
+			label := fmt.Sprintf("Decode('%q', %d)", s, precision)
+
+			mustNotPanic(t, label, func() {
+				Decode(s, precision)
+			})
+
+			for i := 0; i < len(s); i++ {
+				mustNotPanic(t, label, func() {
+					Decode(s[0:i], precision)
+				})
+			}
+		}
+	}
+
+	decode := func(b []byte, precision int8, factor float64) bool {
+
+		// most random bytes aren't valid polyline characters so they are also mapped on
+		// to valid ones to test more than the first character of each input
+
+		alphabet := make([]byte, len(b))
+
+		for i, c := range b {
+			alphabet[i] = (c % 64) + 63
+		}
+
+		mustNotPanic(t, "Decode", func() {
+			Decode(string(b), int(precision))
+			Decode(string(alphabet), int(precision)%(MAX_PRECISION+2))
+			DecodeWithFactor(string(b), factor)
+			DecodeWithFactor(string(alphabet), factor)
+		})
+
+		return true
+	}
+
+	cfg := &quick.Config{
+		MaxCount: 20000,
+		Rand:     rand.New(rand.NewSource(1)),
+	}
+
+	err := quick.Check(decode, cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFuzzEncodeDecode checks that any valid path survives being encoded and decoded, at any
+// precision, give or take rounding
+
+func TestFuzzEncodeDecode(t *testing.T) {
+
+	encode_decode := func(coords [][2]float64, p uint8) bool {
+
+		precision := MIN_PRECISION + int(p)%MAX_PRECISION
+
+		path := geom.Path{}
+
+		for _, pt := range coords {
+
+			// quick generates values across the whole range of float64 so map them on
+			// to valid longitudes and latitudes
+
+			x := math.Mod(pt[0], 180.0)
+			y := math.Mod(pt[1], 90.0)
+
+			path.AddVertex(geom.Coord{X: x, Y: y})
+		}
+
+		encoded, err := Encode(path, precision)
+
+		if err != nil {
+			t.Logf("Failed to encode %v at precision %d, %s", path.Vertices(), precision, err)
+			return false
+		}
+
+		decoded, err := Decode(encoded, precision)
+
+		if err != nil {
+			t.Logf("Failed to decode '%s' at precision %d, %s", encoded, precision, err)
+			return false
+		}
+
+		original := path.Vertices()
+		vertices := decoded.Vertices()
+
+		if len(original) != len(vertices) {
+			t.Logf("Expected %d vertices, got %d", len(original), len(vertices))
+			return false
+		}
+
+		tolerance := 0.5/math.Pow10(precision) + 1e-9
+
+		for i, c := range vertices {
+
+			if math.Abs(c.X-original[i].X) > tolerance || math.Abs(c.Y-original[i].Y) > tolerance {
+				t.Logf("Vertex %d is %v, expected %v at precision %d", i, c, original[i], precision)
+				return false
+			}
+		}
+
+		// and truncating the encoded path doesn't cause a panic
+
+		for i := 0; i < len(encoded); i++ {
+			mustNotPanic(t, "Decode", func() {
+				Decode(encoded[0:i], precision)
+			})
+		}
+
+		return true
+	}
+
+	cfg := &quick.Config{
+		MaxCount: 2000,
+		Rand:     rand.New(rand.NewSource(1)),
+	}
+
+	err := quick.Check(encode_decode, cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,12 +2,13 @@ package utils
 
 import (
 	"context"
-	"errors"
 	"github.com/skelterjohn/geom"
 	geojson_utils "github.com/whosonfirst/go-whosonfirst-geojson-v2/utils"
 	"github.com/whosonfirst/go-whosonfirst-index"
 	"github.com/whosonfirst/go-whosonfirst-pip-v2"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/alt"
 	pip_index "github.com/whosonfirst/go-whosonfirst-pip-v2/index"
+	"github.com/whosonfirst/go-whosonfirst-pip-v2/polyline"
 	"github.com/whosonfirst/go-whosonfirst-spr"
 	"github.com/whosonfirst/go-whosonfirst-uri"
 	"io"
@@ -84,6 +85,34 @@ func IsValidRecord(fh io.Reader, ctx context.Context) (bool, error) {
 	return true, nil
 }
 
+// IsAltRecord returns true if the path for 'ctx' is a WOF alternate geometry file. IsValidRecord
+// returns false for these so they are only ever indexed if they are explicitly asked for.
+
+func IsAltRecord(fh io.Reader, ctx context.Context) (bool, error) {
+
+	path, err := index.PathForContext(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	if path == index.STDIN {
+		return false, nil
+	}
+
+	is_wof, err := uri.IsWOFFile(path)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !is_wof {
+		return false, nil
+	}
+
+	return uri.IsAltFile(path)
+}
+
 // basically we need this in order to roll over all the servers/services
 // without any downtime (20170922/thisisaaronland)
 
@@ -138,7 +167,7 @@ func ResultsToFeatureCollection(results spr.StandardPlacesResults, idx pip_index
 
 	for _, r := range results.Results() {
 
-		fc, err := cache.Get(r.Id())
+		fc, err := cache.Get(alt.KeyForSPR(r))
 
 		if err != nil {
 			return nil, err
@@ -161,86 +190,24 @@ func ResultsToFeatureCollection(results spr.StandardPlacesResults, idx pip_index
 	return &collection, nil
 }
 
+// StringPrecisionToFactor returns the polyline factor for 'str_precision' which may be any number between
+// polyline.MIN_PRECISION and polyline.MAX_PRECISION
+
 func StringPrecisionToFactor(str_precision string) (float64, error) {
 
-	factor := 0.0
 	precision, err := strconv.Atoi(str_precision)
 
 	if err != nil {
-		return factor, err
+		return 0.0, err
 	}
 
-	switch precision {
-	case 5:
-		factor = 1.0e5
-	case 6:
-		factor = 1.0e6
-	default:
-		return factor, errors.New("Invalid string precision")
-	}
-
-	return factor, nil
+	return polyline.Factor(precision)
 }
 
-// the DecodePolyline function is cribbed from Paul Mach's NewPathFromEncoding function here:
-// https://github.com/paulmach/go.geo/blob/master/path.go
-//
-// We don't need to import the rest of the package just the code that can handle decoding
-// plain-vanilla GOOG 5-decimal point polylines as well as Valhalla's 6-decimal point lines
-// defined here: https://mapzen.com/documentation/mobility/decoding/
-//
-// see also: https://developers.google.com/maps/documentation/utilities/polylineutility
-// (20170927/thisisaaronland)
+// DecodePolyline decodes 'encoded' using 'f' as the polyline factor. It is a thin wrapper around
+// polyline.DecodeWithFactor and is kept for backwards compatibility.
 
 func DecodePolyline(encoded string, f float64) (*geom.Path, error) {
 
-	var count, index int
-
-	tempLatLng := [2]int{0, 0}
-
-	path := geom.Path{}
-
-	for index < len(encoded) {
-		var result int
-		var b = 0x20
-		var shift uint
-
-		for b >= 0x20 {
-			b = int(encoded[index]) - 63
-			index++
-
-			result |= (b & 0x1f) << shift
-			shift += 5
-		}
-
-		// sign dection
-		if result&1 != 0 {
-			result = ^(result >> 1)
-		} else {
-			result = result >> 1
-		}
-
-		if count%2 == 0 {
-			result += tempLatLng[0]
-			tempLatLng[0] = result
-		} else {
-			result += tempLatLng[1]
-			tempLatLng[1] = result
-
-			lon := float64(tempLatLng[1]) / f
-			lat := float64(tempLatLng[0]) / f
-
-			coord, err := geojson_utils.NewCoordinateFromLatLons(lat, lon)
-
-			if err != nil {
-				return nil, err
-			}
-
-			path.AddVertex(coord)
-		}
-
-		count++
-	}
-
-	return &path, nil
+	return polyline.DecodeWithFactor(encoded, f)
 }